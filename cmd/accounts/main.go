@@ -0,0 +1,243 @@
+package main
+
+import (
+	"common/config"
+	"common/dao"
+	"common/services"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+import _ "github.com/lib/pq"
+
+var (
+	pDatabaseURL = flag.String("database-url", "", "Database to use")
+)
+
+func usage() {
+	_, _ = fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] <command> [command flags] [arguments]
+
+Accounts are given by their email or account number.
+
+Commands:
+  create -email <email> [-first-name <name>] [-last-name <name>] [-locale <locale>]
+                create an account
+  show <account>
+                show an account and its balance
+  list [-after <account number>] [-limit <n>] [-all]
+                list accounts by account number, -all includes deactivated ones
+  update <account> [-first-name <name>] [-last-name <name>] [-email <email>] [-locale <locale>]
+                change the name, email or locale of an account, only the given flags are changed
+  deactivate <account>
+                deactivate an account, its transactions are kept but nothing can be imported into it
+  merge <source> <target>
+                move the transactions and imports of source into target and deactivate source
+
+Supported locales: %s
+
+Flags:
+`, os.Args[0], strings.Join(services.SupportedLocales(), ", "))
+	flag.PrintDefaults()
+}
+
+// parseCommand parses the flags of a command, which come before its arguments, and checks how many arguments it got.
+func parseCommand(fs *flag.FlagSet, args []string, nArgs int, usage string) {
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s %s\n", os.Args[0], usage)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != nArgs {
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+func nullTime(value sql.NullTime) string {
+	if !value.Valid {
+		return "-"
+	}
+
+	return value.Time.Format(time.RFC3339)
+}
+
+func accountStatus(account dao.Account) string {
+	if account.MergedInto.Valid {
+		return fmt.Sprintf("merged into %d", account.MergedInto.Int64)
+	} else if account.DeactivatedAt.Valid {
+		return "deactivated"
+	}
+
+	return "active"
+}
+
+func printAccounts(accounts []dao.Account) error {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "ACCOUNT\tEMAIL\tNAME\tLOCALE\tCURRENCY\tSTATUS")
+	for _, account := range accounts {
+		name := strings.TrimSpace(account.FirstName + " " + account.LastName)
+		currency := services.DefaultCurrency
+		if account.ReportingCurrency.Valid {
+			currency = account.ReportingCurrency.String
+		}
+		_, _ = fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\n", account.AccountID, account.Email, name, account.Locale, currency, accountStatus(account))
+	}
+	return writer.Flush()
+}
+
+func printAccount(ctx context.Context, accountService *services.AccountService, account dao.Account) error {
+	balance, err := accountService.GetBalance(ctx, account.AccountID)
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(writer, "Account:\t%d\n", account.AccountID)
+	_, _ = fmt.Fprintf(writer, "Email:\t%s\n", account.Email)
+	_, _ = fmt.Fprintf(writer, "First name:\t%s\n", account.FirstName)
+	_, _ = fmt.Fprintf(writer, "Last name:\t%s\n", account.LastName)
+	_, _ = fmt.Fprintf(writer, "Locale:\t%s\n", account.Locale)
+	_, _ = fmt.Fprintf(writer, "Currency:\t%s\n", balance.Currency)
+	_, _ = fmt.Fprintf(writer, "Status:\t%s\n", accountStatus(account))
+	_, _ = fmt.Fprintf(writer, "Created at:\t%s\n", nullTime(account.CreatedAt))
+	_, _ = fmt.Fprintf(writer, "Updated at:\t%s\n", nullTime(account.UpdatedAt))
+	_, _ = fmt.Fprintf(writer, "Deactivated at:\t%s\n", nullTime(account.DeactivatedAt))
+	_, _ = fmt.Fprintf(writer, "Last balance at:\t%s\n", nullTime(account.LastBalanceAt))
+	_, _ = fmt.Fprintf(writer, "Total balance:\t%s\n", balance.TotalBalance.Format(account.Locale))
+	_, _ = fmt.Fprintf(writer, "Average debit:\t%s\n", balance.AvgDebitAmount.Format(account.Locale))
+	_, _ = fmt.Fprintf(writer, "Average credit:\t%s\n", balance.AvgCreditAmount.Format(account.Locale))
+	return writer.Flush()
+}
+
+func findAccount(ctx context.Context, accountService *services.AccountService, key string) dao.Account {
+	account, err := accountService.FindAccount(ctx, key)
+	if err != nil {
+		log.Fatal("Could not find account: ", err)
+	}
+	return account
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	url, err := config.DatabaseURL(*pDatabaseURL)
+	if err != nil {
+		log.Fatal("Could not configure database:", err)
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		log.Fatal("Could not open database:", err)
+	}
+	defer func(db *sql.DB) {
+		if err := db.Close(); err != nil {
+			log.Println("Could not close database:", err)
+		}
+	}(db)
+
+	accountService := &services.AccountService{Database: db}
+	command, args := flag.Arg(0), flag.Args()[1:]
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	switch command {
+	case "create":
+		email := fs.String("email", "", "Email of the account")
+		firstName := fs.String("first-name", "", "First name of the account holder")
+		lastName := fs.String("last-name", "", "Last name of the account holder")
+		locale := fs.String("locale", services.DefaultLocale, "Locale of the emails and balance page of the account")
+		parseCommand(fs, args, 0, "create -email <email> [flags]")
+
+		var account dao.Account
+		account, err = accountService.CreateAccount(ctx, *email, *firstName, *lastName, *locale)
+		if err == nil {
+			log.Printf("Created account %d for %s", account.AccountID, account.Email)
+			err = printAccounts([]dao.Account{account})
+		}
+	case "show":
+		parseCommand(fs, args, 1, "show <account>")
+		err = printAccount(ctx, accountService, findAccount(ctx, accountService, fs.Arg(0)))
+	case "list":
+		after := fs.Int64("after", 0, "List accounts after this account number")
+		limit := fs.Int("limit", 50, "Number of accounts to list")
+		all := fs.Bool("all", false, "Include deactivated accounts")
+		parseCommand(fs, args, 0, "list [flags]")
+
+		var accounts []dao.Account
+		accounts, err = accountService.ListAccounts(ctx, *after, int32(*limit), *all)
+		if err == nil {
+			err = printAccounts(accounts)
+		}
+	case "update":
+		firstName := fs.String("first-name", "", "New first name of the account holder")
+		lastName := fs.String("last-name", "", "New last name of the account holder")
+		email := fs.String("email", "", "New email of the account")
+		locale := fs.String("locale", "", "New locale of the account")
+		if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			// the account may come before the flags too
+			args = append(args[1:], args[0])
+		}
+		parseCommand(fs, args, 1, "update <account> [flags]")
+
+		var changes services.AccountChanges
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "first-name":
+				changes.FirstName = firstName
+			case "last-name":
+				changes.LastName = lastName
+			case "email":
+				changes.Email = email
+			case "locale":
+				changes.Locale = locale
+			}
+		})
+
+		var account dao.Account
+		account, err = accountService.UpdateAccount(ctx, findAccount(ctx, accountService, fs.Arg(0)), changes)
+		if err == nil {
+			log.Printf("Updated account %d", account.AccountID)
+			err = printAccounts([]dao.Account{account})
+		}
+	case "deactivate":
+		parseCommand(fs, args, 1, "deactivate <account>")
+
+		var account dao.Account
+		account, err = accountService.DeactivateAccount(ctx, findAccount(ctx, accountService, fs.Arg(0)))
+		if err == nil {
+			log.Printf("Deactivated account %d", account.AccountID)
+		}
+	case "merge":
+		parseCommand(fs, args, 2, "merge <source> <target>")
+		source := findAccount(ctx, accountService, fs.Arg(0))
+		target := findAccount(ctx, accountService, fs.Arg(1))
+
+		var report services.BalanceReport
+		target, report, err = accountService.MergeAccounts(ctx, source, target)
+		if err == nil {
+			log.Printf("Merged account %d into %d, it now has %d credits and %d debits", source.AccountID, target.AccountID, report.CountCredit, report.CountDebit)
+			err = printAccount(ctx, accountService, target)
+		}
+	default:
+		log.Printf("Unknown command: %s", command)
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal("Could not ", command, " account: ", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"common/config"
+	"common/services"
+	"common/web"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+import _ "github.com/lib/pq"
+
+// ShutdownTimeout is how long in-flight requests get to finish once the server is asked to stop.
+const ShutdownTimeout = 10 * time.Second
+
+var (
+	pAddr        = flag.String("addr", ":3000", "Address to listen on")
+	pDatabaseURL = flag.String("database-url", "", "Database to use")
+	pTokenSecret = flag.String("token-secret", "", "Secret to verify balance links with (leave blank to use BALANCE_TOKEN_SECRET)")
+)
+
+func flagTokenSecret() []byte {
+	secret := *pTokenSecret
+	if secret == "" {
+		secret = os.Getenv("BALANCE_TOKEN_SECRET")
+	}
+	if secret == "" {
+		log.Fatal("Token secret not set, use -token-secret or BALANCE_TOKEN_SECRET")
+	}
+
+	return []byte(secret)
+}
+
+func main() {
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	url, err := config.DatabaseURL(*pDatabaseURL)
+	if err != nil {
+		log.Fatal("Could not configure database:", err)
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		log.Fatal("Could not open database:", err)
+	}
+	defer func(db *sql.DB) {
+		if err := db.Close(); err != nil {
+			log.Println("Could not close database:", err)
+		}
+	}(db)
+
+	page := &services.BalancePage{}
+	err = page.LoadMessages()
+	if err != nil {
+		log.Fatal("Could not load messages:", err)
+	}
+
+	handler := &web.BalanceHandler{
+		Accounts: &services.AccountService{Database: db},
+		Tokens:   &services.BalanceTokens{Secret: flagTokenSecret()},
+		Page:     page,
+	}
+	server := &http.Server{
+		Addr:              *pAddr,
+		Handler:           handler.Routes(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Println("Could not shut down server:", err)
+		}
+	}()
+
+	log.Printf("Listening on %s", *pAddr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal("Could not serve:", err)
+	}
+	log.Println("Server stopped")
+}
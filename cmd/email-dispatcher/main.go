@@ -0,0 +1,145 @@
+package main
+
+import (
+	"common/config"
+	"common/dao"
+	"common/services"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+import _ "github.com/lib/pq"
+
+var (
+	pDatabaseURL   = flag.String("database-url", "", "Database to use")
+	pInterval      = flag.Duration("interval", 10*time.Second, "How often to look for emails due when running")
+	pMaxAttempts   = flag.Int("max-attempts", services.DefaultEmailMaxAttempts, "Attempts before an email is dead-lettered")
+	pRetryDelay    = flag.Duration("retry-delay", services.DefaultEmailRetryDelay, "Wait after the first failed attempt, doubled on every attempt after that")
+	pMaxRetryDelay = flag.Duration("max-retry-delay", services.DefaultEmailMaxRetryDelay, "Longest wait between attempts")
+)
+
+func usage() {
+	_, _ = fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] <command>
+
+Commands:
+  run           send the emails of the outbox as they are due, until stopped
+  once          send the emails due now and exit
+  status        count the emails of the outbox by status
+  retry [id]    queue dead emails again, all of them without id
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+// emailService sends reports with the same environment variables as proc-txns-csv.
+func emailService() *services.EmailService {
+	emailConfig, err := services.EmailConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid email configuration:", err)
+	}
+	sender, err := emailConfig.NewSender()
+	if err != nil {
+		log.Fatal("Could not configure email sender:", err)
+	}
+
+	service := &services.EmailService{
+		PublicURL: os.Getenv("PUBLIC_URL"),
+		Sender:    sender,
+	}
+	if secret := os.Getenv("BALANCE_TOKEN_SECRET"); secret != "" {
+		service.Tokens = &services.BalanceTokens{Secret: []byte(secret)}
+	}
+
+	if err := service.LoadMessages(); err != nil {
+		log.Fatal("Could not load email messages:", err)
+	}
+	return service
+}
+
+func printStatus(ctx context.Context, dispatcher *services.EmailDispatcher) error {
+	counts, err := dispatcher.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "STATUS\tEMAILS")
+	for _, status := range []dao.EmailStatus{dao.EmailStatusPending, dao.EmailStatusSent, dao.EmailStatusDead} {
+		_, _ = fmt.Fprintf(writer, "%s\t%d\n", status, counts[status])
+	}
+	return writer.Flush()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	url, err := config.DatabaseURL(*pDatabaseURL)
+	if err != nil {
+		log.Fatal("Could not configure database:", err)
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		log.Fatal("Could not open database:", err)
+	}
+	defer func(db *sql.DB) {
+		if err := db.Close(); err != nil {
+			log.Println("Could not close database:", err)
+		}
+	}(db)
+
+	dispatcher := &services.EmailDispatcher{
+		Database:      db,
+		MaxAttempts:   *pMaxAttempts,
+		RetryDelay:    *pRetryDelay,
+		MaxRetryDelay: *pMaxRetryDelay,
+	}
+	switch command := flag.Arg(0); command {
+	case "run":
+		dispatcher.Email = emailService()
+		log.Printf("Sending emails every %s", *pInterval)
+		dispatcher.Run(ctx, *pInterval)
+	case "once":
+		dispatcher.Email = emailService()
+		var result services.DispatchResult
+		result, err = dispatcher.DispatchPending(ctx)
+		log.Printf("Sent %d emails, %d failed and %d dead", result.Sent, result.Failed, result.Dead)
+	case "status":
+		err = printStatus(ctx, dispatcher)
+	case "retry":
+		var emailID int64
+		if flag.NArg() == 2 {
+			if emailID, err = strconv.ParseInt(flag.Arg(1), 10, 64); err != nil || emailID <= 0 {
+				log.Fatal("Usage: email-dispatcher retry [id]")
+			}
+		}
+
+		var retried int64
+		retried, err = dispatcher.Retry(ctx, emailID)
+		log.Printf("Queued %d dead emails again", retried)
+	default:
+		log.Printf("Unknown command: %s", command)
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal("Could not dispatch emails:", err)
+	}
+}
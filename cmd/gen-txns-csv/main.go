@@ -11,7 +11,6 @@ import (
 )
 
 const (
-	dayMonthLayout      = "01/02"
 	maxRandomGeneration = 10000
 )
 
@@ -21,6 +20,7 @@ var (
 	pGenerate  = flag.Uint("gen", 1000, "Number of transactions to generate (leave empty for random)")
 	pDateMin   = flag.String("date-min", "", "Minimum date to randomly pick from, YYYY-MM-DD format (leave empty for last year)")
 	pDateMax   = flag.String("date-max", "", "Maximum date to randomly pick from, YYYY-MM-DD format (leave empty for today)")
+	pLayout    = flag.String("date-layout", time.DateOnly, "Go layout of the generated dates (use 01/02 for legacy MM/DD files)")
 	pAmountMin = flag.Float64("amount-min", 0.0, "Minimum amount to randomly pick from (fix to two decimals, MXN)")
 	pAmountMax = flag.Float64("amount-max", 10000.0, "Maximum amount to randomly pick from (fix to two decimals, MXN)")
 	rng        *rand.Rand
@@ -110,7 +110,7 @@ func main() {
 		txnId := fmt.Sprintf("%d", i)
 		txnDate := randomDateBetween(minDate, maxDate)
 		txnAmount := randomAmountBetween(minAmount, maxAmount)
-		err = writer.Write([]string{txnId, txnDate.Format(dayMonthLayout), fmt.Sprintf("%s%.2f", randomDebitOrCredit(), txnAmount)})
+		err = writer.Write([]string{txnId, txnDate.Format(*pLayout), fmt.Sprintf("%s%.2f", randomDebitOrCredit(), txnAmount)})
 		if err != nil {
 			log.Fatalf("Error writing to file: %v", err)
 		}
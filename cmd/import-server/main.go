@@ -0,0 +1,141 @@
+package main
+
+import (
+	"common/config"
+	"common/services"
+	"common/web"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+import _ "github.com/lib/pq"
+
+// ShutdownTimeout is how long in-flight requests and imports get to finish once the server is asked to stop,
+// imports still running afterward are interrupted and finish as incomplete.
+const ShutdownTimeout = 30 * time.Second
+
+var (
+	pAddr           = flag.String("addr", ":3001", "Address to listen on")
+	pDatabaseURL    = flag.String("database-url", "", "Database to use")
+	pWorkers        = flag.Int("workers", 5, "Number of workers to use when processing transactions")
+	pBatchSize      = flag.Int("batch-size", 100, "Number of transactions to process at a time")
+	pInsertStrategy = flag.String("insert-strategy", "row", "How to insert transactions: row, batch (multi-row INSERT) or copy (COPY FROM STDIN)")
+	pConcurrency    = flag.Int("concurrency", 2, "Number of imports to run at the same time")
+	pMaxUploadSize  = flag.Int64("max-upload-size", web.DefaultMaxUploadSize, "Largest statement to accept, in bytes")
+	pRetryInterval  = flag.Duration("email-retry-interval", time.Minute, "How often to retry reports that failed to send")
+)
+
+func flagInsertStrategy() services.InsertStrategy {
+	strategy, err := services.ParseInsertStrategy(*pInsertStrategy)
+	if err != nil {
+		log.Fatal("Invalid insert strategy:", err)
+	}
+
+	return strategy
+}
+
+// emailService sends reports with the same environment variables as proc-txns-csv.
+func emailService() *services.EmailService {
+	emailConfig, err := services.EmailConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid email configuration:", err)
+	}
+	sender, err := emailConfig.NewSender()
+	if err != nil {
+		log.Fatal("Could not configure email sender:", err)
+	}
+
+	service := &services.EmailService{
+		PublicURL: os.Getenv("PUBLIC_URL"),
+		Sender:    sender,
+	}
+	if secret := os.Getenv("BALANCE_TOKEN_SECRET"); secret != "" {
+		service.Tokens = &services.BalanceTokens{Secret: []byte(secret)}
+	}
+
+	if err := service.LoadMessages(); err != nil {
+		log.Fatal("Could not load email messages:", err)
+	}
+	return service
+}
+
+func main() {
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	url, err := config.DatabaseURL(*pDatabaseURL)
+	if err != nil {
+		log.Fatal("Could not configure database:", err)
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		log.Fatal("Could not open database:", err)
+	}
+	defer func(db *sql.DB) {
+		if err := db.Close(); err != nil {
+			log.Println("Could not close database:", err)
+		}
+	}(db)
+
+	// imports outlive the requests that upload them, they are only interrupted when shutting down takes too long
+	importCtx, cancelImports := context.WithCancel(context.Background())
+	defer cancelImports()
+
+	// reports that fail to send right after their import are retried in the background
+	dispatcher := &services.EmailDispatcher{Database: db, Email: emailService()}
+	go dispatcher.Run(ctx, *pRetryInterval)
+
+	rates := &services.DatabaseRates{Database: db}
+	handler := &web.ImportHandler{
+		Accounts: &services.AccountService{Database: db, Rates: rates},
+		Imports:  &services.ImportService{Database: db},
+		Jobs:     &web.ImportJobs{},
+		Transactions: services.TransactionService{
+			Database:  db,
+			Rates:     rates,
+			Workers:   *pWorkers,
+			BatchSize: *pBatchSize,
+			Strategy:  flagInsertStrategy(),
+		},
+		Dispatcher:    dispatcher,
+		MaxUploadSize: *pMaxUploadSize,
+		Concurrency:   *pConcurrency,
+		Context:       importCtx,
+	}
+	server := &http.Server{
+		Addr:              *pAddr,
+		Handler:           handler.Routes(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		context.AfterFunc(shutdownCtx, cancelImports)
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Println("Could not shut down server:", err)
+		}
+		handler.Wait()
+	}()
+
+	log.Printf("Listening on %s", *pAddr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal("Could not serve:", err)
+	}
+	<-done
+	log.Println("Server stopped")
+}
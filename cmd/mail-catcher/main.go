@@ -0,0 +1,84 @@
+package main
+
+import (
+	"common/mailcatcher"
+	"common/web"
+	"context"
+	"errors"
+	"flag"
+	"github.com/joho/godotenv"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ShutdownTimeout is how long in-flight requests get to finish once the server is asked to stop.
+const ShutdownTimeout = 10 * time.Second
+
+var (
+	pSMTPAddr = flag.String("smtp-addr", ":1025", "Address to accept SMTP on")
+	pAddr     = flag.String("addr", ":8025", "Address to serve the messages on")
+	pHostname = flag.String("hostname", mailcatcher.DefaultHostname, "Name to greet SMTP clients with and to issue the TLS certificate for")
+	pUsername = flag.String("username", "", "Only accept messages authenticated as this user (leave blank to use SMTP_USERNAME, any user when neither is set)")
+	pPassword = flag.String("password", "", "Password of the user (leave blank to use SMTP_PASSWORD)")
+)
+
+// flagCredentials returns the credentials to accept, the ones the services send with from .env unless given.
+func flagCredentials() (string, string) {
+	if *pUsername != "" {
+		return *pUsername, *pPassword
+	}
+
+	// .env is optional, the catcher accepts any credentials without it
+	_ = godotenv.Load()
+	return os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD")
+}
+
+func main() {
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store := &mailcatcher.Store{}
+	smtpServer := &mailcatcher.Server{Store: store, Hostname: *pHostname}
+	smtpServer.Username, smtpServer.Password = flagCredentials()
+	if smtpServer.Username != "" {
+		log.Printf("Accepting messages from user %s", smtpServer.Username)
+	}
+
+	handler := &web.MailHandler{Store: store}
+	server := &http.Server{
+		Addr:              *pAddr,
+		Handler:           handler.Routes(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := smtpServer.Close(); err != nil {
+			log.Println("Could not close SMTP server:", err)
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Println("Could not shut down server:", err)
+		}
+	}()
+
+	go func() {
+		log.Printf("Accepting SMTP on %s", *pSMTPAddr)
+		if err := smtpServer.ListenAndServe(*pSMTPAddr); !errors.Is(err, mailcatcher.ErrServerClosed) {
+			log.Fatal("Could not serve SMTP:", err)
+		}
+	}()
+
+	log.Printf("Listening on %s", *pAddr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal("Could not serve:", err)
+	}
+	log.Println("Server stopped")
+}
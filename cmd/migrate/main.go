@@ -0,0 +1,138 @@
+package main
+
+import (
+	"common/config"
+	"common/migrations"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+import _ "github.com/lib/pq"
+
+var (
+	pDatabaseURL = flag.String("database-url", "", "Database to use")
+)
+
+func usage() {
+	_, _ = fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] <command>
+
+Commands:
+  up            apply every pending migration
+  down          roll back the last applied migration
+  status        list migrations and whether they are applied
+  to <version>  migrate up or down to version, 0 rolls back every migration
+  baseline <version>
+                mark migrations up to version as applied without running them, for databases
+                created before migrations
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func printMigrations(verb string, migrated []migrations.Migration) {
+	if len(migrated) == 0 {
+		log.Println("Nothing to migrate")
+	}
+	for _, migration := range migrated {
+		log.Printf("%s %d_%s", verb, migration.Version, migration.Name)
+	}
+}
+
+func printStatus(ctx context.Context, migrator *migrations.Migrator) error {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "VERSION\tNAME\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.Applied {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		_, _ = fmt.Fprintf(writer, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+	}
+	return writer.Flush()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	url, err := config.DatabaseURL(*pDatabaseURL)
+	if err != nil {
+		log.Fatal("Could not configure database:", err)
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		log.Fatal("Could not open database:", err)
+	}
+	defer func(db *sql.DB) {
+		if err := db.Close(); err != nil {
+			log.Println("Could not close database:", err)
+		}
+	}(db)
+
+	migrator := &migrations.Migrator{Database: db}
+	var migrated []migrations.Migration
+	switch command := flag.Arg(0); command {
+	case "up":
+		migrated, err = migrator.Up(ctx)
+		printMigrations("Applied", migrated)
+	case "down":
+		migrated, err = migrator.Down(ctx)
+		printMigrations("Rolled back", migrated)
+	case "to":
+		version, parseErr := strconv.ParseInt(flag.Arg(1), 10, 64)
+		if flag.NArg() != 2 || parseErr != nil {
+			log.Fatal("Usage: migrate to <version>")
+		}
+
+		var current int64
+		current, err = migrator.Version(ctx)
+		if err != nil {
+			break
+		}
+		migrated, err = migrator.To(ctx, version)
+		if version < current {
+			printMigrations("Rolled back", migrated)
+		} else {
+			printMigrations("Applied", migrated)
+		}
+	case "baseline":
+		version, parseErr := strconv.ParseInt(flag.Arg(1), 10, 64)
+		if flag.NArg() != 2 || parseErr != nil {
+			log.Fatal("Usage: migrate baseline <version>")
+		}
+
+		migrated, err = migrator.Baseline(ctx, version)
+		printMigrations("Marked as applied", migrated)
+	case "status":
+		err = printStatus(ctx, migrator)
+	default:
+		log.Printf("Unknown command: %s", command)
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal("Could not migrate:", err)
+	}
+}
@@ -1,18 +1,23 @@
 package main
 
 import (
+	"common/config"
+	"common/dao"
 	"common/services"
 	"context"
 	"database/sql"
-	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/jaswdr/faker/v2"
-	"github.com/joho/godotenv"
 	"log"
 	"math/rand"
 	"os"
-	"strconv"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -20,10 +25,27 @@ import _ "github.com/lib/pq"
 
 var (
 	pFile             = flag.String("file", "", "File to read transactions from")
+	pFormat           = flag.String("format", "auto", "Statement format: auto, csv, ofx (qfx), qif, camt053 or mt940")
+	pProfile          = flag.String("profile", "", "CSV column mapping profile, by name or path to a JSON profile (leave blank for id,date,amount)")
+	pTolerant         = flag.Bool("tolerant", false, "Accept rows with trailing empty columns, loose quotes and amounts with thousands separators")
+	pFailFast         = flag.Bool("fail-fast", false, "Abort the import on the first rejected row")
+	pMaxErrors        = flag.Int64("max-errors", 0, "Abort the import when more rows than this are rejected (leave 0 to never abort)")
+	pMaxErrorRatio    = flag.Float64("max-error-ratio", 0, "Abort the import when the ratio of rejected rows is above this, between 0 and 1 (leave 0 to never abort)")
+	pRejects          = flag.String("rejects", "", "File to write rejected rows to, as CSV (leave blank to skip)")
+	pSummary          = flag.String("summary", "", "File to write the summary of multi-account statements to, as JSON (leave blank to skip)")
+	pPDF              = flag.String("pdf", "", "File to write the PDF statement to, multi-account statements get one per account with the account number before the extension (leave blank to skip)")
+	pSource           = flag.String("source", "", "Source of the transactions, ids are unique per source (leave blank to use file name)")
 	pSeed             = flag.Int64("seed", 0, "Seed to use for random number generation")
+	pDateLayouts      = flag.String("date-layouts", "", "Comma separated Go layouts to parse dates with (leave blank for YYYY-MM-DD and MM/DD)")
+	pStatementDate    = flag.String("statement-date", "", "Closing date of the statement, YYYY-MM-DD format, used to guess the year of MM/DD dates (leave blank for today)")
+	pStatementYear    = flag.Int("statement-year", 0, "Year of the statement, same as -statement-date with December 31st of that year")
 	pWorkers          = flag.Int("workers", 5, "Number of workers to use when processing transactions")
 	pBatchSize        = flag.Int("batch-size", 100, "Number of transactions to process at a time")
-	pAccountEmail     = flag.String("account-email", "", "Account Email to use when creating accounts (leave blank to random)")
+	pInsertStrategy   = flag.String("insert-strategy", "row", "How to insert transactions: row, batch (multi-row INSERT) or copy (COPY FROM STDIN)")
+	pRates            = flag.String("rates", "", "CSV file with base,quote,rate,effective_at exchange rates (leave blank to use the fx_rates table)")
+	pCurrency         = flag.String("reporting-currency", "", "Currency to report the balance of the account in, converting other currencies (leave blank to keep the one of the account)")
+	pAtomic           = flag.Bool("atomic", false, "Import the whole file and update the balance in a single database transaction")
+	pAccountEmail     = flag.String("account-email", "", "Account Email to use when creating accounts (leave blank to random @example.com)")
 	pAccountFirstName = flag.String("account-first-name", "", "Account First name to use when creating accounts (leave blank to random)")
 	pAccountLastName  = flag.String("account-last-name", "", "Account Last name to use when creating accounts (leave blank to random)")
 	pDatabaseURL      = flag.String("database-url", "", "Database to use")
@@ -32,6 +54,10 @@ var (
 	pSMTPUsername     = flag.String("smtp-username", "", "SMTP Username to use")
 	pSMTPPassword     = flag.String("smtp-password", "", "SMTP Password to use")
 	pSMTPFromEmail    = flag.String("smtp-from-email", "", "SMTP From Email to use")
+	pEmailSender      = flag.String("email-sender", "", "Where to send report emails: smtp, dir (write them to -email-dir) or log (leave blank to use EMAIL_SENDER, smtp by default)")
+	pEmailDir         = flag.String("email-dir", "", "Directory to write report emails to with -email-sender dir (leave blank to use EMAIL_DIR)")
+	pPublicURL        = flag.String("public-url", "", "Public URL of the balance server, for the link in the email (leave blank to use PUBLIC_URL)")
+	pTokenSecret      = flag.String("token-secret", "", "Secret to sign balance links with (leave blank to use BALANCE_TOKEN_SECRET)")
 	fake              faker.Faker
 )
 
@@ -56,9 +82,119 @@ func flagFile() *os.File {
 	return file
 }
 
+func flagSource() string {
+	if *pSource == "" {
+		return filepath.Base(*pFile)
+	}
+
+	return *pSource
+}
+
+func flagDateParser() services.DateParser {
+	parser := services.DateParser{}
+	if *pDateLayouts != "" {
+		parser.Layouts = strings.Split(*pDateLayouts, ",")
+	}
+
+	if *pStatementDate != "" {
+		date, err := time.Parse(time.DateOnly, *pStatementDate)
+		if err != nil {
+			log.Fatal("Could not parse statement date:", err)
+		}
+		parser.ReferenceDate = date
+	} else if *pStatementYear != 0 {
+		parser.ReferenceDate = time.Date(*pStatementYear, time.December, 31, 0, 0, 0, 0, time.UTC)
+	}
+
+	return parser
+}
+
+func flagProfile() *services.CSVProfile {
+	if *pProfile == "" {
+		return nil
+	}
+
+	var profile services.CSVProfile
+	var err error
+	if strings.HasSuffix(*pProfile, ".json") {
+		var jsonData []byte
+		jsonData, err = os.ReadFile(*pProfile)
+		if err == nil {
+			profile, err = services.ParseCSVProfile(jsonData)
+		}
+	} else {
+		profile, err = services.LoadCSVProfile(*pProfile)
+	}
+	if err != nil {
+		log.Fatal("Could not load profile:", err)
+	}
+
+	return &profile
+}
+
+func flagStatementParser(file *os.File, profile *services.CSVProfile) (services.StatementParser, services.StatementFormat) {
+	format, err := services.ParseFormat(*pFormat)
+	if err != nil {
+		log.Fatal("Invalid statement format:", err)
+	}
+
+	parser, format, err := services.NewStatementParser(format, *pFile, file, services.ParserOptions{
+		Dates:    flagDateParser(),
+		Tolerant: *pTolerant,
+		Profile:  profile,
+	})
+	if err != nil {
+		log.Fatal("Could not read statement:", err)
+	}
+
+	log.Printf("Reading %s statement", format)
+	return parser, format
+}
+
+func flagRates(db *sql.DB) services.FXProvider {
+	if *pRates == "" {
+		return &services.DatabaseRates{Database: db}
+	}
+
+	file, err := os.Open(*pRates)
+	if err != nil {
+		log.Fatal("Could not open rates file:", err)
+	}
+	defer func(file *os.File) {
+		if err := file.Close(); err != nil {
+			log.Fatal("Could not close rates file:", err)
+		}
+	}(file)
+
+	rates, err := services.LoadFileRates(file)
+	if err != nil {
+		log.Fatal("Could not load rates:", err)
+	}
+	return rates
+}
+
+func flagImportMode() services.ImportMode {
+	if *pAtomic {
+		return services.ImportModeAtomic
+	}
+
+	return services.ImportModeBestEffort
+}
+
+func flagInsertStrategy() services.InsertStrategy {
+	strategy, err := services.ParseInsertStrategy(*pInsertStrategy)
+	if err != nil {
+		log.Fatal("Invalid insert strategy:", err)
+	}
+
+	return strategy
+}
+
+// flagAccountEmail returns the email of the account, a random one on the reserved example.com domain when not given
+// so reports of accounts made up for a run never reach a real mailbox.
 func flagAccountEmail() string {
 	if *pAccountEmail == "" {
-		return "fake+" + fake.Internet().Email()
+		return strings.ToLower(fake.Internet().User()) + "@example.com"
 	}
 
 	return *pAccountEmail
@@ -80,78 +216,180 @@ func flagAccountLastName() string {
 	return *pAccountLastName
 }
 
-func flagDatabase() string {
-	if *pDatabaseURL == "" {
-		err := godotenv.Load()
+func flagEmailSender() services.EmailSender {
+	emailConfig, err := services.EmailConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid email configuration:", err)
+	}
+
+	if *pEmailSender != "" {
+		emailConfig.Sender, err = services.ParseEmailSenderKind(*pEmailSender)
 		if err != nil {
-			log.Fatal("Error loading .env file")
+			log.Fatal("Invalid email sender:", err)
 		}
+	}
+	if *pEmailDir != "" {
+		emailConfig.Dir = *pEmailDir
+	}
+	if *pSMTPHost != "" {
+		emailConfig.SMTPHost = *pSMTPHost
+	}
+	if *pSMTPPort != 0 {
+		emailConfig.SMTPPort = *pSMTPPort
+	}
+	if *pSMTPUsername != "" {
+		emailConfig.SMTPUser = *pSMTPUsername
+	}
+	if *pSMTPPassword != "" {
+		emailConfig.SMTPPass = *pSMTPPassword
+	}
+	if *pSMTPFromEmail != "" {
+		emailConfig.FromEmail = *pSMTPFromEmail
+	}
 
-		host := os.Getenv("POSTGRES_HOST")
-		if host == "" {
-			host = "database" // from docker-compose
-		}
+	sender, err := emailConfig.NewSender()
+	if err != nil {
+		log.Fatal("Could not configure email sender:", err)
+	}
+	log.Printf("Sending report emails with the %s sender", emailConfig.Sender)
+	return sender
+}
 
-		port := os.Getenv("POSTGRES_PORT")
-		if port == "" {
-			port = "5432"
-		}
+func flagBalanceLinks() (string, *services.BalanceTokens) {
+	publicURL := *pPublicURL
+	if publicURL == "" {
+		publicURL = os.Getenv("PUBLIC_URL")
+	}
 
-		user := os.Getenv("POSTGRES_USER")
-		if user == "" {
-			log.Fatal("POSTGRES_USER not set")
-		}
+	secret := *pTokenSecret
+	if secret == "" {
+		secret = os.Getenv("BALANCE_TOKEN_SECRET")
+	}
+	if publicURL == "" || secret == "" {
+		log.Println("Public URL or token secret not set, sending the report without balance link")
+		return publicURL, nil
+	}
 
-		pass := os.Getenv("POSTGRES_PASSWORD")
-		if pass == "" {
-			log.Fatal("POSTGRES_PASSWORD not set")
-		}
+	return publicURL, &services.BalanceTokens{Secret: []byte(secret)}
+}
 
-		db := os.Getenv("POSTGRES_DB")
-		if db == "" {
-			log.Fatal("POSTGRES_DB not set")
-		}
+func writeRejections(rejections []services.Rejection) {
+	log.Printf("Rejected %d rows", len(rejections))
+	if *pRejects == "" {
+		return
+	}
 
-		opts := os.Getenv("POSTGRES_OPTS")
-		if opts == "" {
-			opts = "?sslmode=disable" // from docker-compose
+	file, err := os.Create(*pRejects)
+	if err != nil {
+		log.Fatal("Could not create rejects file:", err)
+	}
+	defer func(file *os.File) {
+		if err := file.Close(); err != nil {
+			log.Fatal("Could not close rejects file:", err)
 		}
+	}(file)
+
+	if err := services.WriteRejections(file, rejections); err != nil {
+		log.Fatal("Could not write rejects file:", err)
+	}
+}
 
-		url := fmt.Sprintf("postgresql://%s:%s@%s:%s/%s%s", user, pass, host, port, db, opts)
-		log.Printf("Using database host: %s", host)
-		return url
+func writeSummary(batch services.BatchReport) {
+	summary := batch.Summary
+	log.Printf("Imported %d credits and %d debits of %d accounts with %d duplicates and %d rejections",
+		summary.CountCredit, summary.CountDebit, len(batch.Accounts), summary.CountDuplicate, summary.CountRejected)
+	for _, account := range batch.Accounts {
+		log.Printf("Account %d (%s): %d credits, %d debits, balance %s", account.Report.AccountID, account.Key,
+			account.Report.CountCredit, account.Report.CountDebit, account.Report.TotalBalance)
+	}
+	if *pSummary == "" {
+		return
 	}
 
-	return *pDatabaseURL
+	jsonData, err := json.MarshalIndent(batch, "", "  ")
+	if err != nil {
+		log.Fatal("Could not encode summary:", err)
+	}
+	if err := os.WriteFile(*pSummary, jsonData, 0644); err != nil {
+		log.Fatal("Could not write summary file:", err)
+	}
 }
 
-func flagSMTPConfig() (string, int, string, string, string) {
-	smtpHost := *pSMTPHost
-	if *pSMTPHost == "" {
-		smtpHost = os.Getenv("SMTP_HOST")
+// writeStatement writes the PDF statement of an import into the account to path.
+func writeStatement(ctx context.Context, accountService *services.AccountService, account dao.Account, importID int64, report services.BalanceReport, path string) {
+	statement, err := accountService.Statement(ctx, account, importID, report)
+	if err != nil {
+		log.Fatal("Could not load statement:", err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		log.Fatal("Could not create PDF file:", err)
 	}
+	defer func(file *os.File) {
+		if err := file.Close(); err != nil {
+			log.Fatal("Could not close PDF file:", err)
+		}
+	}(file)
 
-	smtpPort := *pSMTPPort
-	if *pSMTPPort == 0 {
-		smtpPort, _ = strconv.Atoi(os.Getenv("SMTP_PORT"))
+	statements := &services.StatementPDF{}
+	if err := statements.LoadMessages(); err != nil {
+		log.Fatal("Could not load statement messages:", err)
+	}
+	if err := statements.Render(file, statement); err != nil {
+		log.Fatal("Could not write PDF file:", err)
 	}
+	log.Printf("Wrote statement of account %d to %s", account.AccountID, path)
+}
 
-	smtpUsername := *pSMTPUsername
-	if *pSMTPUsername == "" {
-		smtpUsername = os.Getenv("SMTP_USERNAME")
+// importBatch imports a statement of many accounts, each account gets its own report and import.
+func importBatch(ctx context.Context, accountService *services.AccountService, importService *services.ImportService, transactionService services.TransactionService, dispatcher *services.EmailDispatcher, file *os.File, profile *services.CSVProfile) {
+	checksum, err := services.Checksum(file)
+	if err != nil {
+		log.Fatal("Could not read file:", err)
 	}
 
-	smtpPassword := *pSMTPPassword
-	if *pSMTPPassword == "" {
-		smtpPassword = os.Getenv("SMTP_PASSWORD")
+	parser, format := flagStatementParser(file, profile)
+	batch, rejections, err := importService.ImportBatch(ctx, transactionService, accountService, parser, format, checksum)
+	writeRejections(rejections)
+	writeSummary(batch)
+	if err != nil {
+		log.Fatal("Could not import transactions:", err)
 	}
 
-	smtpFromEmail := *pSMTPFromEmail
-	if *pSMTPFromEmail == "" {
-		smtpFromEmail = os.Getenv("SMTP_FROM_EMAIL")
+	// the accounts of the file are only known once imported, so earlier imports of it are reported afterward
+	for _, account := range batch.Accounts {
+		previous, err := importService.PreviousImports(ctx, account.Report.AccountID, checksum)
+		if err != nil {
+			log.Fatal("Could not look up previous imports:", err)
+		}
+		for _, imp := range previous {
+			if imp.ImportID != account.ImportID {
+				log.Printf("File already imported for account %d as import %d on %s (%s)", account.Report.AccountID, imp.ImportID, imp.StartedAt.Format(time.RFC3339), imp.Status)
+			}
+		}
 	}
+	if *pPDF != "" {
+		ext := filepath.Ext(*pPDF)
+		for _, account := range batch.Accounts {
+			path := fmt.Sprintf("%s-%d%s", strings.TrimSuffix(*pPDF, ext), account.Account.AccountID, ext)
+			writeStatement(ctx, accountService, account.Account, account.ImportID, account.Report, path)
+		}
+	}
+
+	dispatchReports(ctx, dispatcher, batch.ReportKeys()...)
+}
 
-	return smtpHost, smtpPort, smtpUsername, smtpPassword, smtpFromEmail
+// dispatchReports sends the reports queued by the import, by key, the ones that fail and the rest of the outbox are
+// left for the email-dispatcher command.
+func dispatchReports(ctx context.Context, dispatcher *services.EmailDispatcher, keys ...string) {
+	result, err := dispatcher.DispatchKeys(ctx, keys...)
+	if err != nil {
+		log.Println("Could not send reports:", err)
+	}
+	if result.Failed > 0 || result.Dead > 0 {
+		log.Printf("%d reports failed to send, %d of them for good, see the email-dispatcher command", result.Failed+result.Dead, result.Dead)
+	}
 }
 
 func main() {
@@ -165,51 +403,97 @@ func main() {
 		}
 	}(file)
 
-	backgroundContext := context.Background()
-	db, err := sql.Open("postgres", flagDatabase())
+	// SIGINT and SIGTERM stop the import, a second one kills the command right away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	url, err := config.DatabaseURL(*pDatabaseURL)
+	if err != nil {
+		log.Fatal("Could not configure database:", err)
+	}
+	db, err := sql.Open("postgres", url)
 	if err != nil {
 		log.Fatal("Could not open database:", err)
 	}
 
-	// Configure and load accounts and transactions services
-	accountService := services.AccountService{Database: db}
-	transactionService := services.TransactionService{Database: db, Workers: *pWorkers, BatchSize: *pBatchSize}
+	// Configure and load accounts, imports and transactions services
+	rates := flagRates(db)
+	accountService := services.AccountService{Database: db, Rates: rates}
+	importService := services.ImportService{Database: db}
+	transactionService := services.TransactionService{
+		Database:  db,
+		Rates:     rates,
+		Workers:   *pWorkers,
+		BatchSize: *pBatchSize,
+		Source:    flagSource(),
+		Mode:      flagImportMode(),
+		Strategy:  flagInsertStrategy(),
+		Threshold: services.ErrorThreshold{
+			FailFast:      *pFailFast,
+			MaxErrors:     *pMaxErrors,
+			MaxErrorRatio: *pMaxErrorRatio,
+		},
+		Notify: true,
+	}
 
 	// Configure and load email service
-	smtpHost, smtpPort, smtpUsername, smtpPassword, smtpFromEmail := flagSMTPConfig()
+	publicURL, tokens := flagBalanceLinks()
 	emailService := services.EmailService{
-		PublicURL: "",
-		Sender: &services.SMTPSender{
-			FromEmail: smtpFromEmail,
-			SMTPHost:  smtpHost,
-			SMTPPort:  smtpPort,
-			SMTPUser:  smtpUsername,
-			SMTPPass:  smtpPassword,
-		},
+		PublicURL: publicURL,
+		Tokens:    tokens,
+		Sender:    flagEmailSender(),
 	}
 	err = emailService.LoadMessages()
 	if err != nil {
 		log.Fatal("Could not load email messages:", err)
 	}
+	dispatcher := &services.EmailDispatcher{Database: db, Email: &emailService}
+
+	// Statements with an account column are routed to the account of each row instead
+	profile := flagProfile()
+	if profile != nil && profile.MultiAccount() {
+		importBatch(ctx, &accountService, &importService, transactionService, dispatcher, file, profile)
+		return
+	}
 
-	account, err := accountService.FetchOrCreateAccount(backgroundContext, flagAccountEmail(), flagAccountFirstName(), flagAccountLastName())
+	account, err := accountService.FetchOrCreateAccount(ctx, flagAccountEmail(), flagAccountFirstName(), flagAccountLastName())
 	if err != nil {
 		log.Fatal("Could not fetch or create account:", err)
 	}
+	if *pCurrency != "" {
+		account, err = accountService.SetReportingCurrency(ctx, account, *pCurrency)
+		if err != nil {
+			log.Fatal("Could not set reporting currency:", err)
+		}
+	}
 
-	reader := csv.NewReader(file)
-	report, err := transactionService.ProcessFile(backgroundContext, account.AccountID, reader)
+	checksum, err := services.Checksum(file)
 	if err != nil {
-		log.Fatal("Could not process transactions:", err)
+		log.Fatal("Could not read file:", err)
 	}
-
-	_, err = accountService.UpdateAccountBalance(backgroundContext, account, report)
+	previous, err := importService.PreviousImports(ctx, account.AccountID, checksum)
 	if err != nil {
-		log.Fatal("Could not update balance data:", err)
+		log.Fatal("Could not look up previous imports:", err)
+	}
+	for _, imp := range previous {
+		log.Printf("File already imported for this account as import %d on %s (%s)", imp.ImportID, imp.StartedAt.Format(time.RFC3339), imp.Status)
 	}
 
-	err = emailService.SendReport(account, report)
-	if err != nil {
-		log.Fatal("Could not send report:", err)
+	parser, format := flagStatementParser(file, profile)
+	imp, account, report, rejections, err := importService.ImportFile(ctx, transactionService, account, parser, format, checksum)
+	writeRejections(rejections)
+	if errors.Is(err, services.ErrImportIncomplete) {
+		log.Printf("Import %d interrupted after storing %d credits and %d debits, run it again to import the rest",
+			imp.ImportID, report.CountCredit, report.CountDebit)
+		log.Fatal("Could not import transactions:", err)
+	} else if err != nil {
+		log.Fatal("Could not import transactions:", err)
+	}
+	log.Printf("Recorded import %d", imp.ImportID)
+	if *pPDF != "" {
+		writeStatement(ctx, &accountService, account, imp.ImportID, report, *pPDF)
 	}
+
+	dispatchReports(ctx, dispatcher, services.ImportReportKey(imp.ImportID, account.AccountID))
 }
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"github.com/joho/godotenv"
+	"log"
+	"os"
+)
+
+// DatabaseURL returns the database url given to a command, or builds it from the POSTGRES_* variables of .env when
+// none is given. The host defaults to the database service of docker-compose.
+func DatabaseURL(url string) (string, error) {
+	if url != "" {
+		return url, nil
+	}
+
+	if err := godotenv.Load(); err != nil {
+		return "", fmt.Errorf("error loading .env file: %w", err)
+	}
+	return DatabaseURLFromEnv()
+}
+
+// DatabaseURLFromEnv builds the database url from POSTGRES_HOST, POSTGRES_PORT, POSTGRES_USER, POSTGRES_PASSWORD,
+// POSTGRES_DB and POSTGRES_OPTS.
+func DatabaseURLFromEnv() (string, error) {
+	host := os.Getenv("POSTGRES_HOST")
+	if host == "" {
+		host = "database" // from docker-compose
+	}
+
+	port := os.Getenv("POSTGRES_PORT")
+	if port == "" {
+		port = "5432"
+	}
+
+	user := os.Getenv("POSTGRES_USER")
+	if user == "" {
+		return "", fmt.Errorf("POSTGRES_USER not set")
+	}
+
+	pass := os.Getenv("POSTGRES_PASSWORD")
+	if pass == "" {
+		return "", fmt.Errorf("POSTGRES_PASSWORD not set")
+	}
+
+	db := os.Getenv("POSTGRES_DB")
+	if db == "" {
+		return "", fmt.Errorf("POSTGRES_DB not set")
+	}
+
+	opts := os.Getenv("POSTGRES_OPTS")
+	if opts == "" {
+		opts = "?sslmode=disable" // from docker-compose
+	}
+
+	log.Printf("Using database host: %s", host)
+	return fmt.Sprintf("postgresql://%s:%s@%s:%s/%s%s", user, pass, host, port, db, opts), nil
+}
@@ -7,12 +7,100 @@ package dao
 import (
 	"database/sql"
 	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/shopspring/decimal"
 )
 
+type EmailStatus string
+
+const (
+	EmailStatusPending EmailStatus = "pending"
+	EmailStatusSent    EmailStatus = "sent"
+	EmailStatusDead    EmailStatus = "dead"
+)
+
+func (e *EmailStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = EmailStatus(s)
+	case string:
+		*e = EmailStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for EmailStatus: %T", src)
+	}
+	return nil
+}
+
+type NullEmailStatus struct {
+	EmailStatus EmailStatus
+	Valid       bool // Valid is true if EmailStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullEmailStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.EmailStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.EmailStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullEmailStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.EmailStatus), nil
+}
+
+type ImportStatus string
+
+const (
+	ImportStatusRunning    ImportStatus = "running"
+	ImportStatusCompleted  ImportStatus = "completed"
+	ImportStatusIncomplete ImportStatus = "incomplete"
+	ImportStatusFailed     ImportStatus = "failed"
+)
+
+func (e *ImportStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = ImportStatus(s)
+	case string:
+		*e = ImportStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for ImportStatus: %T", src)
+	}
+	return nil
+}
+
+type NullImportStatus struct {
+	ImportStatus ImportStatus
+	Valid        bool // Valid is true if ImportStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullImportStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.ImportStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.ImportStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullImportStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.ImportStatus), nil
+}
+
 type TxOperationType string
 
 const (
@@ -56,17 +144,69 @@ func (ns NullTxOperationType) Value() (driver.Value, error) {
 }
 
 type Account struct {
-	AccountID       int64
-	FirstName       string
-	LastName        string
-	Email           string
-	Locale          string
-	TotalBalance    sql.NullString
-	AvgDebitAmount  sql.NullString
-	AvgCreditAmount sql.NullString
-	LastBalanceAt   sql.NullTime
-	CreatedAt       sql.NullTime
-	UpdatedAt       sql.NullTime
+	AccountID         int64
+	FirstName         string
+	LastName          string
+	Email             string
+	Locale            string
+	TotalBalance      decimal.NullDecimal
+	AvgDebitAmount    decimal.NullDecimal
+	AvgCreditAmount   decimal.NullDecimal
+	LastBalanceAt     sql.NullTime
+	CreatedAt         sql.NullTime
+	UpdatedAt         sql.NullTime
+	ReportingCurrency sql.NullString
+	DeactivatedAt     sql.NullTime
+	MergedInto        sql.NullInt64
+}
+
+type BalanceSnapshot struct {
+	SnapshotID   int64
+	AccountID    int64
+	TotalBalance decimal.Decimal
+	Report       json.RawMessage
+	CreatedAt    time.Time
+}
+
+type EmailOutbox struct {
+	EmailID        int64
+	IdempotencyKey string
+	AccountID      int64
+	Kind           string
+	Payload        json.RawMessage
+	Status         EmailStatus
+	Attempts       int32
+	NextAttemptAt  time.Time
+	LastError      string
+	SentAt         sql.NullTime
+	CreatedAt      sql.NullTime
+	UpdatedAt      sql.NullTime
+}
+
+type FxRate struct {
+	Base        string
+	Quote       string
+	Rate        decimal.Decimal
+	EffectiveAt time.Time
+	CreatedAt   sql.NullTime
+}
+
+type Import struct {
+	ImportID       int64
+	AccountID      int64
+	Source         string
+	Checksum       string
+	Format         string
+	Status         ImportStatus
+	CountAccepted  int64
+	CountRejected  int64
+	CountDuplicate int64
+	Error          string
+	Report         json.RawMessage
+	StartedAt      time.Time
+	FinishedAt     sql.NullTime
+	CreatedAt      sql.NullTime
+	UpdatedAt      sql.NullTime
 }
 
 type Transaction struct {
@@ -78,4 +218,7 @@ type Transaction struct {
 	PerformedAt   time.Time
 	CreatedAt     sql.NullTime
 	UpdatedAt     sql.NullTime
+	ImportID      sql.NullInt64
+	ExternalID    string
+	Source        string
 }
@@ -8,23 +8,153 @@ package dao
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/shopspring/decimal"
 )
 
+const claimEmail = `-- name: ClaimEmail :one
+SELECT email_id, idempotency_key, account_id, kind, payload, status, attempts, next_attempt_at, last_error, sent_at, created_at, updated_at FROM email_outbox
+WHERE status = 'pending' AND next_attempt_at <= $1
+ORDER BY next_attempt_at, email_id
+LIMIT 1
+FOR UPDATE SKIP LOCKED
+`
+
+func (q *Queries) ClaimEmail(ctx context.Context, nextAttemptAt time.Time) (EmailOutbox, error) {
+	row := q.db.QueryRowContext(ctx, claimEmail, nextAttemptAt)
+	var i EmailOutbox
+	err := row.Scan(
+		&i.EmailID,
+		&i.IdempotencyKey,
+		&i.AccountID,
+		&i.Kind,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.LastError,
+		&i.SentAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const claimEmailByKey = `-- name: ClaimEmailByKey :one
+SELECT email_id, idempotency_key, account_id, kind, payload, status, attempts, next_attempt_at, last_error, sent_at, created_at, updated_at FROM email_outbox
+WHERE idempotency_key = $1 AND status = 'pending' AND next_attempt_at <= $2
+FOR UPDATE SKIP LOCKED
+`
+
+type ClaimEmailByKeyParams struct {
+	IdempotencyKey string
+	NextAttemptAt  time.Time
+}
+
+func (q *Queries) ClaimEmailByKey(ctx context.Context, arg ClaimEmailByKeyParams) (EmailOutbox, error) {
+	row := q.db.QueryRowContext(ctx, claimEmailByKey, arg.IdempotencyKey, arg.NextAttemptAt)
+	var i EmailOutbox
+	err := row.Scan(
+		&i.EmailID,
+		&i.IdempotencyKey,
+		&i.AccountID,
+		&i.Kind,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.LastError,
+		&i.SentAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const countEmailsByStatus = `-- name: CountEmailsByStatus :many
+SELECT status, COUNT(*) AS count FROM email_outbox GROUP BY status ORDER BY status
+`
+
+type CountEmailsByStatusRow struct {
+	Status EmailStatus
+	Count  int64
+}
+
+func (q *Queries) CountEmailsByStatus(ctx context.Context) ([]CountEmailsByStatusRow, error) {
+	rows, err := q.db.QueryContext(ctx, countEmailsByStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountEmailsByStatusRow
+	for rows.Next() {
+		var i CountEmailsByStatusRow
+		if err := rows.Scan(&i.Status, &i.Count); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const countTransactionsByMonth = `-- name: CountTransactionsByMonth :many
+SELECT to_char(performed_at, 'YYYY-MM')::TEXT AS year_month, count(*) AS count
+FROM transactions
+WHERE account_id = $1
+GROUP BY year_month
+ORDER BY year_month
+`
+
+type CountTransactionsByMonthRow struct {
+	YearMonth string
+	Count     int64
+}
+
+func (q *Queries) CountTransactionsByMonth(ctx context.Context, accountID int64) ([]CountTransactionsByMonthRow, error) {
+	rows, err := q.db.QueryContext(ctx, countTransactionsByMonth, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountTransactionsByMonthRow
+	for rows.Next() {
+		var i CountTransactionsByMonthRow
+		if err := rows.Scan(&i.YearMonth, &i.Count); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const createAccount = `-- name: CreateAccount :one
 INSERT INTO accounts
-    (first_name, last_name, email, created_at, updated_at)
+    (first_name, last_name, email, locale, created_at, updated_at)
 VALUES
-    ($1, $2, $3, $4, $5)
-RETURNING account_id, first_name, last_name, email, locale, total_balance, avg_debit_amount, avg_credit_amount, last_balance_at, created_at, updated_at
+    ($1, $2, $3, $4, $5, $6)
+RETURNING account_id, first_name, last_name, email, locale, total_balance, avg_debit_amount, avg_credit_amount, last_balance_at, created_at, updated_at, reporting_currency, deactivated_at, merged_into
 `
 
 type CreateAccountParams struct {
 	FirstName string
 	LastName  string
 	Email     string
+	Locale    string
 	CreatedAt sql.NullTime
 	UpdatedAt sql.NullTime
 }
@@ -34,9 +164,252 @@ func (q *Queries) CreateAccount(ctx context.Context, arg CreateAccountParams) (A
 		arg.FirstName,
 		arg.LastName,
 		arg.Email,
+		arg.Locale,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+	)
+	var i Account
+	err := row.Scan(
+		&i.AccountID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Locale,
+		&i.TotalBalance,
+		&i.AvgDebitAmount,
+		&i.AvgCreditAmount,
+		&i.LastBalanceAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ReportingCurrency,
+		&i.DeactivatedAt,
+		&i.MergedInto,
+	)
+	return i, err
+}
+
+const createBalanceSnapshot = `-- name: CreateBalanceSnapshot :one
+INSERT INTO balance_snapshots
+    (account_id, total_balance, report, created_at)
+VALUES
+    ($1, $2, $3, $4)
+RETURNING snapshot_id, account_id, total_balance, report, created_at
+`
+
+type CreateBalanceSnapshotParams struct {
+	AccountID    int64
+	TotalBalance decimal.Decimal
+	Report       json.RawMessage
+	CreatedAt    time.Time
+}
+
+func (q *Queries) CreateBalanceSnapshot(ctx context.Context, arg CreateBalanceSnapshotParams) (BalanceSnapshot, error) {
+	row := q.db.QueryRowContext(ctx, createBalanceSnapshot,
+		arg.AccountID,
+		arg.TotalBalance,
+		arg.Report,
+		arg.CreatedAt,
+	)
+	var i BalanceSnapshot
+	err := row.Scan(
+		&i.SnapshotID,
+		&i.AccountID,
+		&i.TotalBalance,
+		&i.Report,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createImport = `-- name: CreateImport :one
+INSERT INTO imports
+    (account_id, source, checksum, format, status, started_at, created_at, updated_at)
+VALUES
+    ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING import_id, account_id, source, checksum, format, status, count_accepted, count_rejected, count_duplicate, error, report, started_at, finished_at, created_at, updated_at
+`
+
+type CreateImportParams struct {
+	AccountID int64
+	Source    string
+	Checksum  string
+	Format    string
+	Status    ImportStatus
+	StartedAt time.Time
+	CreatedAt sql.NullTime
+	UpdatedAt sql.NullTime
+}
+
+func (q *Queries) CreateImport(ctx context.Context, arg CreateImportParams) (Import, error) {
+	row := q.db.QueryRowContext(ctx, createImport,
+		arg.AccountID,
+		arg.Source,
+		arg.Checksum,
+		arg.Format,
+		arg.Status,
+		arg.StartedAt,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+	)
+	var i Import
+	err := row.Scan(
+		&i.ImportID,
+		&i.AccountID,
+		&i.Source,
+		&i.Checksum,
+		&i.Format,
+		&i.Status,
+		&i.CountAccepted,
+		&i.CountRejected,
+		&i.CountDuplicate,
+		&i.Error,
+		&i.Report,
+		&i.StartedAt,
+		&i.FinishedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const deactivateAccount = `-- name: DeactivateAccount :one
+UPDATE accounts
+    SET deactivated_at = $1, merged_into = $2, updated_at = $1
+    WHERE account_id = $3
+RETURNING account_id, first_name, last_name, email, locale, total_balance, avg_debit_amount, avg_credit_amount, last_balance_at, created_at, updated_at, reporting_currency, deactivated_at, merged_into
+`
+
+type DeactivateAccountParams struct {
+	DeactivatedAt sql.NullTime
+	MergedInto    sql.NullInt64
+	AccountID     int64
+}
+
+func (q *Queries) DeactivateAccount(ctx context.Context, arg DeactivateAccountParams) (Account, error) {
+	row := q.db.QueryRowContext(ctx, deactivateAccount, arg.DeactivatedAt, arg.MergedInto, arg.AccountID)
+	var i Account
+	err := row.Scan(
+		&i.AccountID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Locale,
+		&i.TotalBalance,
+		&i.AvgDebitAmount,
+		&i.AvgCreditAmount,
+		&i.LastBalanceAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ReportingCurrency,
+		&i.DeactivatedAt,
+		&i.MergedInto,
+	)
+	return i, err
+}
+
+const deleteTransactionsByAccount = `-- name: DeleteTransactionsByAccount :execrows
+DELETE FROM transactions WHERE account_id = $1
+`
+
+func (q *Queries) DeleteTransactionsByAccount(ctx context.Context, accountID int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteTransactionsByAccount, accountID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const enqueueEmail = `-- name: EnqueueEmail :execrows
+INSERT INTO email_outbox
+    (idempotency_key, account_id, kind, payload, status, next_attempt_at, created_at, updated_at)
+VALUES
+    ($1, $2, $3, $4, 'pending', $5, $6, $6)
+ON CONFLICT (idempotency_key) DO NOTHING
+`
+
+type EnqueueEmailParams struct {
+	IdempotencyKey string
+	AccountID      int64
+	Kind           string
+	Payload        json.RawMessage
+	NextAttemptAt  time.Time
+	CreatedAt      sql.NullTime
+}
+
+func (q *Queries) EnqueueEmail(ctx context.Context, arg EnqueueEmailParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, enqueueEmail,
+		arg.IdempotencyKey,
+		arg.AccountID,
+		arg.Kind,
+		arg.Payload,
+		arg.NextAttemptAt,
 		arg.CreatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const finishImport = `-- name: FinishImport :one
+UPDATE imports
+    SET status = $1, count_accepted = $2, count_rejected = $3, count_duplicate = $4, error = $5, report = $6,
+        finished_at = $7, updated_at = $8
+    WHERE import_id = $9
+RETURNING import_id, account_id, source, checksum, format, status, count_accepted, count_rejected, count_duplicate, error, report, started_at, finished_at, created_at, updated_at
+`
+
+type FinishImportParams struct {
+	Status         ImportStatus
+	CountAccepted  int64
+	CountRejected  int64
+	CountDuplicate int64
+	Error          string
+	Report         json.RawMessage
+	FinishedAt     sql.NullTime
+	UpdatedAt      sql.NullTime
+	ImportID       int64
+}
+
+func (q *Queries) FinishImport(ctx context.Context, arg FinishImportParams) (Import, error) {
+	row := q.db.QueryRowContext(ctx, finishImport,
+		arg.Status,
+		arg.CountAccepted,
+		arg.CountRejected,
+		arg.CountDuplicate,
+		arg.Error,
+		arg.Report,
+		arg.FinishedAt,
 		arg.UpdatedAt,
+		arg.ImportID,
+	)
+	var i Import
+	err := row.Scan(
+		&i.ImportID,
+		&i.AccountID,
+		&i.Source,
+		&i.Checksum,
+		&i.Format,
+		&i.Status,
+		&i.CountAccepted,
+		&i.CountRejected,
+		&i.CountDuplicate,
+		&i.Error,
+		&i.Report,
+		&i.StartedAt,
+		&i.FinishedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
 	)
+	return i, err
+}
+
+const getAccount = `-- name: GetAccount :one
+SELECT account_id, first_name, last_name, email, locale, total_balance, avg_debit_amount, avg_credit_amount, last_balance_at, created_at, updated_at, reporting_currency, deactivated_at, merged_into FROM accounts WHERE account_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetAccount(ctx context.Context, accountID int64) (Account, error) {
+	row := q.db.QueryRowContext(ctx, getAccount, accountID)
 	var i Account
 	err := row.Scan(
 		&i.AccountID,
@@ -50,12 +423,15 @@ func (q *Queries) CreateAccount(ctx context.Context, arg CreateAccountParams) (A
 		&i.LastBalanceAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.ReportingCurrency,
+		&i.DeactivatedAt,
+		&i.MergedInto,
 	)
 	return i, err
 }
 
 const getAccountByEmail = `-- name: GetAccountByEmail :one
-SELECT account_id, first_name, last_name, email, locale, total_balance, avg_debit_amount, avg_credit_amount, last_balance_at, created_at, updated_at FROM accounts WHERE email = $1 LIMIT 1
+SELECT account_id, first_name, last_name, email, locale, total_balance, avg_debit_amount, avg_credit_amount, last_balance_at, created_at, updated_at, reporting_currency, deactivated_at, merged_into FROM accounts WHERE email = $1 LIMIT 1
 `
 
 func (q *Queries) GetAccountByEmail(ctx context.Context, email string) (Account, error) {
@@ -73,53 +449,678 @@ func (q *Queries) GetAccountByEmail(ctx context.Context, email string) (Account,
 		&i.LastBalanceAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.ReportingCurrency,
+		&i.DeactivatedAt,
+		&i.MergedInto,
+	)
+	return i, err
+}
+
+const getAccountTotals = `-- name: GetAccountTotals :many
+SELECT
+    currency::TEXT AS currency,
+    COALESCE(SUM(CASE WHEN operation = 'credit' THEN amount ELSE 0 END), 0)::DECIMAL AS total_credit,
+    COUNT(*) FILTER (WHERE operation = 'credit') AS count_credit,
+    COALESCE(SUM(CASE WHEN operation = 'debit' THEN amount ELSE 0 END), 0)::DECIMAL AS total_debit,
+    COUNT(*) FILTER (WHERE operation = 'debit') AS count_debit
+FROM transactions
+WHERE account_id = $1
+GROUP BY currency
+ORDER BY currency
+`
+
+type GetAccountTotalsRow struct {
+	Currency    string
+	TotalCredit decimal.Decimal
+	CountCredit int64
+	TotalDebit  decimal.Decimal
+	CountDebit  int64
+}
+
+func (q *Queries) GetAccountTotals(ctx context.Context, accountID int64) ([]GetAccountTotalsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getAccountTotals, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetAccountTotalsRow
+	for rows.Next() {
+		var i GetAccountTotalsRow
+		if err := rows.Scan(
+			&i.Currency,
+			&i.TotalCredit,
+			&i.CountCredit,
+			&i.TotalDebit,
+			&i.CountDebit,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFXRate = `-- name: GetFXRate :one
+SELECT rate FROM fx_rates
+WHERE base = $1 AND quote = $2 AND effective_at <= $3
+ORDER BY effective_at DESC
+LIMIT 1
+`
+
+type GetFXRateParams struct {
+	Base        string
+	Quote       string
+	EffectiveAt time.Time
+}
+
+func (q *Queries) GetFXRate(ctx context.Context, arg GetFXRateParams) (decimal.Decimal, error) {
+	row := q.db.QueryRowContext(ctx, getFXRate, arg.Base, arg.Quote, arg.EffectiveAt)
+	var rate decimal.Decimal
+	err := row.Scan(&rate)
+	return rate, err
+}
+
+const getImport = `-- name: GetImport :one
+SELECT import_id, account_id, source, checksum, format, status, count_accepted, count_rejected, count_duplicate, error, report, started_at, finished_at, created_at, updated_at FROM imports WHERE import_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetImport(ctx context.Context, importID int64) (Import, error) {
+	row := q.db.QueryRowContext(ctx, getImport, importID)
+	var i Import
+	err := row.Scan(
+		&i.ImportID,
+		&i.AccountID,
+		&i.Source,
+		&i.Checksum,
+		&i.Format,
+		&i.Status,
+		&i.CountAccepted,
+		&i.CountRejected,
+		&i.CountDuplicate,
+		&i.Error,
+		&i.Report,
+		&i.StartedAt,
+		&i.FinishedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
 
 const insertTransaction = `-- name: InsertTransaction :one
 INSERT INTO transactions
-    (account_id, operation, amount, performed_at, created_at, updated_at)
+    (account_id, external_id, source, operation, amount, currency, performed_at, created_at, updated_at, import_id)
 VALUES
-    ($1, $2, $3, $4, $5, $6)
+    ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+ON CONFLICT (account_id, source, external_id) DO NOTHING
 RETURNING transaction_id
 `
 
 type InsertTransactionParams struct {
 	AccountID   int64
+	ExternalID  string
+	Source      string
 	Operation   TxOperationType
 	Amount      decimal.Decimal
+	Currency    string
 	PerformedAt time.Time
 	CreatedAt   sql.NullTime
 	UpdatedAt   sql.NullTime
+	ImportID    sql.NullInt64
 }
 
 func (q *Queries) InsertTransaction(ctx context.Context, arg InsertTransactionParams) (int64, error) {
 	row := q.db.QueryRowContext(ctx, insertTransaction,
 		arg.AccountID,
+		arg.ExternalID,
+		arg.Source,
 		arg.Operation,
 		arg.Amount,
+		arg.Currency,
 		arg.PerformedAt,
 		arg.CreatedAt,
 		arg.UpdatedAt,
+		arg.ImportID,
 	)
 	var transaction_id int64
 	err := row.Scan(&transaction_id)
 	return transaction_id, err
 }
 
+const insertTransactions = `-- name: InsertTransactions :execrows
+INSERT INTO transactions
+    (account_id, external_id, source, operation, amount, currency, performed_at, created_at, updated_at, import_id)
+SELECT
+    $1::BIGINT,
+    unnest($2::TEXT[]),
+    $3::TEXT,
+    unnest($4::TX_OPERATION_TYPE[]),
+    unnest($5::DECIMAL[]),
+    unnest($6::TEXT[]),
+    unnest($7::DATE[]),
+    $8::TIMESTAMPTZ,
+    $9::TIMESTAMPTZ,
+    $10::BIGINT
+ON CONFLICT (account_id, source, external_id) DO NOTHING
+`
+
+type InsertTransactionsParams struct {
+	AccountID    int64
+	ExternalIds  []string
+	Source       string
+	Operations   []TxOperationType
+	Amounts      []decimal.Decimal
+	Currencies   []string
+	PerformedAts []time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	ImportID     sql.NullInt64
+}
+
+func (q *Queries) InsertTransactions(ctx context.Context, arg InsertTransactionsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, insertTransactions,
+		arg.AccountID,
+		pq.Array(arg.ExternalIds),
+		arg.Source,
+		pq.Array(arg.Operations),
+		pq.Array(arg.Amounts),
+		pq.Array(arg.Currencies),
+		pq.Array(arg.PerformedAts),
+		arg.CreatedAt,
+		arg.UpdatedAt,
+		arg.ImportID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const listAccounts = `-- name: ListAccounts :many
+SELECT account_id, first_name, last_name, email, locale, total_balance, avg_debit_amount, avg_credit_amount, last_balance_at, created_at, updated_at, reporting_currency, deactivated_at, merged_into FROM accounts
+WHERE account_id > $1 AND ($2::BOOLEAN OR deactivated_at IS NULL)
+ORDER BY account_id
+LIMIT $3
+`
+
+type ListAccountsParams struct {
+	AfterID            int64
+	IncludeDeactivated bool
+	RowLimit           int32
+}
+
+func (q *Queries) ListAccounts(ctx context.Context, arg ListAccountsParams) ([]Account, error) {
+	rows, err := q.db.QueryContext(ctx, listAccounts, arg.AfterID, arg.IncludeDeactivated, arg.RowLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Account
+	for rows.Next() {
+		var i Account
+		if err := rows.Scan(
+			&i.AccountID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Locale,
+			&i.TotalBalance,
+			&i.AvgDebitAmount,
+			&i.AvgCreditAmount,
+			&i.LastBalanceAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.ReportingCurrency,
+			&i.DeactivatedAt,
+			&i.MergedInto,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listBalanceSnapshots = `-- name: ListBalanceSnapshots :many
+SELECT snapshot_id, account_id, total_balance, report, created_at FROM balance_snapshots
+WHERE account_id = $1 AND created_at >= $2 AND created_at < $3
+ORDER BY created_at, snapshot_id
+`
+
+type ListBalanceSnapshotsParams struct {
+	AccountID    int64
+	CreatedFrom  time.Time
+	CreatedUntil time.Time
+}
+
+func (q *Queries) ListBalanceSnapshots(ctx context.Context, arg ListBalanceSnapshotsParams) ([]BalanceSnapshot, error) {
+	rows, err := q.db.QueryContext(ctx, listBalanceSnapshots, arg.AccountID, arg.CreatedFrom, arg.CreatedUntil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []BalanceSnapshot
+	for rows.Next() {
+		var i BalanceSnapshot
+		if err := rows.Scan(
+			&i.SnapshotID,
+			&i.AccountID,
+			&i.TotalBalance,
+			&i.Report,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listImportsByAccount = `-- name: ListImportsByAccount :many
+SELECT import_id, account_id, source, checksum, format, status, count_accepted, count_rejected, count_duplicate, error, report, started_at, finished_at, created_at, updated_at FROM imports
+WHERE account_id = $1
+ORDER BY started_at DESC, import_id DESC
+LIMIT $2
+`
+
+type ListImportsByAccountParams struct {
+	AccountID int64
+	Limit     int32
+}
+
+func (q *Queries) ListImportsByAccount(ctx context.Context, arg ListImportsByAccountParams) ([]Import, error) {
+	rows, err := q.db.QueryContext(ctx, listImportsByAccount, arg.AccountID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Import
+	for rows.Next() {
+		var i Import
+		if err := rows.Scan(
+			&i.ImportID,
+			&i.AccountID,
+			&i.Source,
+			&i.Checksum,
+			&i.Format,
+			&i.Status,
+			&i.CountAccepted,
+			&i.CountRejected,
+			&i.CountDuplicate,
+			&i.Error,
+			&i.Report,
+			&i.StartedAt,
+			&i.FinishedAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listImportsByChecksum = `-- name: ListImportsByChecksum :many
+SELECT import_id, account_id, source, checksum, format, status, count_accepted, count_rejected, count_duplicate, error, report, started_at, finished_at, created_at, updated_at FROM imports
+WHERE account_id = $1 AND checksum = $2
+ORDER BY started_at DESC, import_id DESC
+`
+
+type ListImportsByChecksumParams struct {
+	AccountID int64
+	Checksum  string
+}
+
+func (q *Queries) ListImportsByChecksum(ctx context.Context, arg ListImportsByChecksumParams) ([]Import, error) {
+	rows, err := q.db.QueryContext(ctx, listImportsByChecksum, arg.AccountID, arg.Checksum)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Import
+	for rows.Next() {
+		var i Import
+		if err := rows.Scan(
+			&i.ImportID,
+			&i.AccountID,
+			&i.Source,
+			&i.Checksum,
+			&i.Format,
+			&i.Status,
+			&i.CountAccepted,
+			&i.CountRejected,
+			&i.CountDuplicate,
+			&i.Error,
+			&i.Report,
+			&i.StartedAt,
+			&i.FinishedAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRecentTransactions = `-- name: ListRecentTransactions :many
+SELECT transaction_id, account_id, operation, amount, currency, performed_at, created_at, updated_at, import_id, external_id, source FROM transactions
+WHERE account_id = $1
+ORDER BY performed_at DESC, transaction_id DESC
+LIMIT $2
+`
+
+type ListRecentTransactionsParams struct {
+	AccountID int64
+	Limit     int32
+}
+
+func (q *Queries) ListRecentTransactions(ctx context.Context, arg ListRecentTransactionsParams) ([]Transaction, error) {
+	rows, err := q.db.QueryContext(ctx, listRecentTransactions, arg.AccountID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Transaction
+	for rows.Next() {
+		var i Transaction
+		if err := rows.Scan(
+			&i.TransactionID,
+			&i.AccountID,
+			&i.Operation,
+			&i.Amount,
+			&i.Currency,
+			&i.PerformedAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.ImportID,
+			&i.ExternalID,
+			&i.Source,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTransactionsByImport = `-- name: ListTransactionsByImport :many
+SELECT transaction_id, account_id, operation, amount, currency, performed_at, created_at, updated_at, import_id, external_id, source FROM transactions
+WHERE import_id = $1 AND account_id = $2
+ORDER BY performed_at, transaction_id
+`
+
+type ListTransactionsByImportParams struct {
+	ImportID  sql.NullInt64
+	AccountID int64
+}
+
+func (q *Queries) ListTransactionsByImport(ctx context.Context, arg ListTransactionsByImportParams) ([]Transaction, error) {
+	rows, err := q.db.QueryContext(ctx, listTransactionsByImport, arg.ImportID, arg.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Transaction
+	for rows.Next() {
+		var i Transaction
+		if err := rows.Scan(
+			&i.TransactionID,
+			&i.AccountID,
+			&i.Operation,
+			&i.Amount,
+			&i.Currency,
+			&i.PerformedAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.ImportID,
+			&i.ExternalID,
+			&i.Source,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markEmailFailed = `-- name: MarkEmailFailed :one
+UPDATE email_outbox
+    SET status = $1, attempts = attempts + 1, next_attempt_at = $2, last_error = $3, updated_at = $4
+    WHERE email_id = $5
+RETURNING email_id, idempotency_key, account_id, kind, payload, status, attempts, next_attempt_at, last_error, sent_at, created_at, updated_at
+`
+
+type MarkEmailFailedParams struct {
+	Status        EmailStatus
+	NextAttemptAt time.Time
+	LastError     string
+	UpdatedAt     sql.NullTime
+	EmailID       int64
+}
+
+func (q *Queries) MarkEmailFailed(ctx context.Context, arg MarkEmailFailedParams) (EmailOutbox, error) {
+	row := q.db.QueryRowContext(ctx, markEmailFailed,
+		arg.Status,
+		arg.NextAttemptAt,
+		arg.LastError,
+		arg.UpdatedAt,
+		arg.EmailID,
+	)
+	var i EmailOutbox
+	err := row.Scan(
+		&i.EmailID,
+		&i.IdempotencyKey,
+		&i.AccountID,
+		&i.Kind,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.LastError,
+		&i.SentAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const markEmailSent = `-- name: MarkEmailSent :one
+UPDATE email_outbox
+    SET status = 'sent', attempts = attempts + 1, last_error = '', sent_at = $1, updated_at = $1
+    WHERE email_id = $2
+RETURNING email_id, idempotency_key, account_id, kind, payload, status, attempts, next_attempt_at, last_error, sent_at, created_at, updated_at
+`
+
+type MarkEmailSentParams struct {
+	SentAt  sql.NullTime
+	EmailID int64
+}
+
+func (q *Queries) MarkEmailSent(ctx context.Context, arg MarkEmailSentParams) (EmailOutbox, error) {
+	row := q.db.QueryRowContext(ctx, markEmailSent, arg.SentAt, arg.EmailID)
+	var i EmailOutbox
+	err := row.Scan(
+		&i.EmailID,
+		&i.IdempotencyKey,
+		&i.AccountID,
+		&i.Kind,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.LastError,
+		&i.SentAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const moveImports = `-- name: MoveImports :execrows
+UPDATE imports
+    SET account_id = $1, updated_at = $2
+    WHERE account_id = $3
+`
+
+type MoveImportsParams struct {
+	TargetID  int64
+	UpdatedAt sql.NullTime
+	SourceID  int64
+}
+
+func (q *Queries) MoveImports(ctx context.Context, arg MoveImportsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, moveImports, arg.TargetID, arg.UpdatedAt, arg.SourceID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const moveTransactions = `-- name: MoveTransactions :execrows
+UPDATE transactions
+    SET account_id = $1, updated_at = $2
+    WHERE account_id = $3 AND NOT EXISTS (
+        SELECT 1 FROM transactions t
+        WHERE t.account_id = $1 AND t.source = transactions.source AND t.external_id = transactions.external_id
+    )
+`
+
+type MoveTransactionsParams struct {
+	TargetID  int64
+	UpdatedAt sql.NullTime
+	SourceID  int64
+}
+
+func (q *Queries) MoveTransactions(ctx context.Context, arg MoveTransactionsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, moveTransactions, arg.TargetID, arg.UpdatedAt, arg.SourceID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const retryDeadEmails = `-- name: RetryDeadEmails :execrows
+UPDATE email_outbox
+    SET status = 'pending', attempts = 0, next_attempt_at = $1, updated_at = $2
+    WHERE status = 'dead' AND ($3::BIGINT = 0 OR email_id = $3)
+`
+
+type RetryDeadEmailsParams struct {
+	RetryAt   time.Time
+	UpdatedAt sql.NullTime
+	EmailID   int64
+}
+
+func (q *Queries) RetryDeadEmails(ctx context.Context, arg RetryDeadEmailsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, retryDeadEmails, arg.RetryAt, arg.UpdatedAt, arg.EmailID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateAccount = `-- name: UpdateAccount :one
+UPDATE accounts
+    SET first_name = $1, last_name = $2, email = $3, locale = $4, updated_at = $5
+    WHERE account_id = $6
+RETURNING account_id, first_name, last_name, email, locale, total_balance, avg_debit_amount, avg_credit_amount, last_balance_at, created_at, updated_at, reporting_currency, deactivated_at, merged_into
+`
+
+type UpdateAccountParams struct {
+	FirstName string
+	LastName  string
+	Email     string
+	Locale    string
+	UpdatedAt sql.NullTime
+	AccountID int64
+}
+
+func (q *Queries) UpdateAccount(ctx context.Context, arg UpdateAccountParams) (Account, error) {
+	row := q.db.QueryRowContext(ctx, updateAccount,
+		arg.FirstName,
+		arg.LastName,
+		arg.Email,
+		arg.Locale,
+		arg.UpdatedAt,
+		arg.AccountID,
+	)
+	var i Account
+	err := row.Scan(
+		&i.AccountID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Locale,
+		&i.TotalBalance,
+		&i.AvgDebitAmount,
+		&i.AvgCreditAmount,
+		&i.LastBalanceAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ReportingCurrency,
+		&i.DeactivatedAt,
+		&i.MergedInto,
+	)
+	return i, err
+}
+
 const updateAccountBalance = `-- name: UpdateAccountBalance :one
 UPDATE accounts
     SET last_balance_at = $1, total_balance = $2, avg_debit_amount = $3, avg_credit_amount = $4
     WHERE account_id = $5
-RETURNING account_id, first_name, last_name, email, locale, total_balance, avg_debit_amount, avg_credit_amount, last_balance_at, created_at, updated_at
+RETURNING account_id, first_name, last_name, email, locale, total_balance, avg_debit_amount, avg_credit_amount, last_balance_at, created_at, updated_at, reporting_currency, deactivated_at, merged_into
 `
 
 type UpdateAccountBalanceParams struct {
 	LastBalanceAt   sql.NullTime
-	TotalBalance    sql.NullString
-	AvgDebitAmount  sql.NullString
-	AvgCreditAmount sql.NullString
+	TotalBalance    decimal.NullDecimal
+	AvgDebitAmount  decimal.NullDecimal
+	AvgCreditAmount decimal.NullDecimal
 	AccountID       int64
 }
 
@@ -144,6 +1145,44 @@ func (q *Queries) UpdateAccountBalance(ctx context.Context, arg UpdateAccountBal
 		&i.LastBalanceAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.ReportingCurrency,
+		&i.DeactivatedAt,
+		&i.MergedInto,
+	)
+	return i, err
+}
+
+const updateAccountReportingCurrency = `-- name: UpdateAccountReportingCurrency :one
+UPDATE accounts
+    SET reporting_currency = $1, updated_at = $2
+    WHERE account_id = $3
+RETURNING account_id, first_name, last_name, email, locale, total_balance, avg_debit_amount, avg_credit_amount, last_balance_at, created_at, updated_at, reporting_currency, deactivated_at, merged_into
+`
+
+type UpdateAccountReportingCurrencyParams struct {
+	ReportingCurrency sql.NullString
+	UpdatedAt         sql.NullTime
+	AccountID         int64
+}
+
+func (q *Queries) UpdateAccountReportingCurrency(ctx context.Context, arg UpdateAccountReportingCurrencyParams) (Account, error) {
+	row := q.db.QueryRowContext(ctx, updateAccountReportingCurrency, arg.ReportingCurrency, arg.UpdatedAt, arg.AccountID)
+	var i Account
+	err := row.Scan(
+		&i.AccountID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Locale,
+		&i.TotalBalance,
+		&i.AvgDebitAmount,
+		&i.AvgCreditAmount,
+		&i.LastBalanceAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ReportingCurrency,
+		&i.DeactivatedAt,
+		&i.MergedInto,
 	)
 	return i, err
 }
@@ -2,7 +2,11 @@ module common
 
 go 1.23
 
-require github.com/shopspring/decimal v1.4.0
+require (
+	github.com/joho/godotenv v1.5.1
+	github.com/lib/pq v1.10.9
+	github.com/shopspring/decimal v1.4.0
+)
 
 require (
 	github.com/DATA-DOG/go-sqlmock v1.5.2 // indirect
@@ -0,0 +1,408 @@
+package mailcatcher
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHostname is the name the server greets with when it has no Hostname.
+	DefaultHostname = "localhost"
+
+	// DefaultMaxSize is the largest message accepted when the server has no MaxSize.
+	DefaultMaxSize = 32 << 20
+
+	// commandTimeout is how long a client may take to send each command or message.
+	commandTimeout = 5 * time.Minute
+)
+
+// ErrServerClosed is returned by Serve once the server is closed.
+var ErrServerClosed = errors.New("mailcatcher: server closed")
+
+// Server is an SMTP server that accepts every message and keeps it in Store instead of delivering it, for development
+// and tests. It offers STARTTLS, with a self-signed certificate unless TLSConfig is given, and AUTH PLAIN and LOGIN,
+// which only check the credentials when Username is set.
+type Server struct {
+	Store     *Store
+	Hostname  string
+	TLSConfig *tls.Config
+	MaxSize   int
+
+	// Username and Password are the only credentials accepted when Username is set, messages are accepted only after
+	// authenticating with them.
+	Username string
+	Password string
+
+	lock     sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wait     sync.WaitGroup
+}
+
+// ListenAndServe listens on the TCP address and serves SMTP sessions until the server is closed.
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(listener)
+}
+
+// Serve accepts SMTP sessions on the listener until the server is closed, ErrServerClosed is returned then.
+func (s *Server) Serve(listener net.Listener) error {
+	if s.TLSConfig == nil {
+		certificate, err := SelfSignedCertificate(s.hostname())
+		if err != nil {
+			return err
+		}
+		s.TLSConfig = &tls.Config{Certificates: []tls.Certificate{certificate}}
+	}
+
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		_ = listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.conns = make(map[net.Conn]struct{})
+	s.lock.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			s.lock.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		// Close may have run since Accept returned, it already closed the tracked conns and waits for their
+		// goroutines, so this one is closed instead of leaking
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			_ = conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wait.Add(1)
+		s.lock.Unlock()
+		go func() {
+			defer s.wait.Done()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// Addr returns the address the server listens on, nil before Serve.
+func (s *Server) Addr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+
+	return s.listener.Addr()
+}
+
+// Close stops listening, closes the open sessions and waits for them to end.
+func (s *Server) Close() error {
+	s.lock.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.lock.Unlock()
+
+	s.wait.Wait()
+	return err
+}
+
+func (s *Server) hostname() string {
+	if s.Hostname == "" {
+		return DefaultHostname
+	}
+
+	return s.Hostname
+}
+
+func (s *Server) maxSize() int {
+	if s.MaxSize <= 0 {
+		return DefaultMaxSize
+	}
+
+	return s.MaxSize
+}
+
+// session is the state of an SMTP connection.
+type session struct {
+	server   *Server
+	conn     net.Conn
+	text     *textproto.Conn
+	tls      bool
+	greeted  bool
+	username string
+	from     string
+	to       []string
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	sess := &session{server: s, conn: conn, text: textproto.NewConn(conn)}
+	defer func() {
+		_ = sess.text.Close()
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+	}()
+
+	sess.reply(220, "%s ESMTP mail-catcher", s.hostname())
+	for {
+		_ = sess.conn.SetDeadline(time.Now().Add(commandTimeout))
+		line, err := sess.text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, args, _ := strings.Cut(line, " ")
+		if !sess.handle(strings.ToUpper(verb), strings.TrimSpace(args)) {
+			return
+		}
+	}
+}
+
+func (sess *session) reply(code int, format string, args ...any) {
+	_ = sess.text.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+}
+
+// handle runs a command, reporting whether the session goes on.
+func (sess *session) handle(verb, args string) bool {
+	switch verb {
+	case "HELO":
+		sess.greeted = true
+		sess.reset()
+		sess.reply(250, "%s", sess.server.hostname())
+	case "EHLO":
+		sess.greeted = true
+		sess.reset()
+		extensions := []string{sess.server.hostname(), "8BITMIME", fmt.Sprintf("SIZE %d", sess.server.maxSize())}
+		if !sess.tls {
+			extensions = append(extensions, "STARTTLS")
+		}
+		extensions = append(extensions, "AUTH PLAIN LOGIN")
+		for _, extension := range extensions[:len(extensions)-1] {
+			_ = sess.text.PrintfLine("250-%s", extension)
+		}
+		sess.reply(250, "%s", extensions[len(extensions)-1])
+	case "STARTTLS":
+		if sess.tls {
+			sess.reply(503, "5.5.1 TLS already active")
+			return true
+		}
+		sess.reply(220, "2.0.0 Ready to start TLS")
+		conn := tls.Server(sess.conn, sess.server.TLSConfig)
+		if err := conn.Handshake(); err != nil {
+			log.Printf("TLS handshake failed: %v", err)
+			return false
+		}
+		// the client starts over after the handshake
+		sess.conn, sess.text, sess.tls = conn, textproto.NewConn(conn), true
+		sess.greeted, sess.username = false, ""
+		sess.reset()
+	case "AUTH":
+		sess.auth(args)
+	case "MAIL":
+		from, ok := pathArg(args, "FROM:")
+		switch {
+		case !sess.greeted:
+			sess.reply(503, "5.5.1 Send EHLO first")
+		case sess.server.Username != "" && sess.username == "":
+			sess.reply(530, "5.7.0 Authentication required")
+		case !ok:
+			sess.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+		default:
+			sess.reset()
+			sess.from = from
+			sess.reply(250, "2.1.0 Ok")
+		}
+	case "RCPT":
+		to, ok := pathArg(args, "TO:")
+		switch {
+		case sess.from == "":
+			sess.reply(503, "5.5.1 Send MAIL first")
+		case !ok || to == "":
+			sess.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+		default:
+			sess.to = append(sess.to, to)
+			sess.reply(250, "2.1.5 Ok")
+		}
+	case "DATA":
+		if len(sess.to) == 0 {
+			sess.reply(503, "5.5.1 Send RCPT first")
+			return true
+		}
+		sess.data()
+	case "RSET":
+		sess.reset()
+		sess.reply(250, "2.0.0 Ok")
+	case "NOOP":
+		sess.reply(250, "2.0.0 Ok")
+	case "VRFY":
+		sess.reply(252, "2.5.0 Cannot verify, send some mail")
+	case "QUIT":
+		sess.reply(221, "2.0.0 Bye")
+		return false
+	default:
+		sess.reply(502, "5.5.2 Command not recognized")
+	}
+	return true
+}
+
+// reset forgets the message in progress.
+func (sess *session) reset() {
+	sess.from, sess.to = "", nil
+}
+
+// auth runs the AUTH PLAIN and LOGIN exchanges.
+func (sess *session) auth(args string) {
+	if sess.username != "" {
+		sess.reply(503, "5.5.1 Already authenticated")
+		return
+	}
+
+	mechanism, initial, _ := strings.Cut(args, " ")
+	var username, password string
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		response, ok := sess.challenge(initial, "")
+		if !ok {
+			return
+		}
+		// authorization identity, authentication identity and password
+		fields := strings.Split(string(response), "\x00")
+		if len(fields) != 3 {
+			sess.reply(501, "5.5.2 Invalid PLAIN response")
+			return
+		}
+		username, password = fields[1], fields[2]
+	case "LOGIN":
+		user, ok := sess.challenge(initial, "Username:")
+		if !ok {
+			return
+		}
+		pass, ok := sess.challenge("", "Password:")
+		if !ok {
+			return
+		}
+		username, password = string(user), string(pass)
+	default:
+		sess.reply(504, "5.5.4 Unrecognized authentication mechanism")
+		return
+	}
+
+	server := sess.server
+	if username == "" || server.Username != "" && (username != server.Username || password != server.Password) {
+		sess.reply(535, "5.7.8 Authentication credentials invalid")
+		return
+	}
+	sess.username = username
+	sess.reply(235, "2.7.0 Authentication successful")
+}
+
+// challenge decodes the initial response of an AUTH command, or asks for it with the prompt when there is none.
+func (sess *session) challenge(initial, prompt string) ([]byte, bool) {
+	response := initial
+	if response == "" {
+		sess.reply(334, "%s", base64.StdEncoding.EncodeToString([]byte(prompt)))
+		line, err := sess.text.ReadLine()
+		if err != nil {
+			return nil, false
+		}
+		response = line
+	}
+	if response == "*" {
+		sess.reply(501, "5.0.0 Authentication cancelled")
+		return nil, false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(response)
+	if err != nil {
+		sess.reply(501, "5.5.2 Invalid base64")
+		return nil, false
+	}
+	return decoded, true
+}
+
+// data reads and stores the message of the session.
+func (sess *session) data() {
+	sess.reply(354, "End data with <CR><LF>.<CR><LF>")
+	var data bytes.Buffer
+	reader := sess.text.DotReader()
+	n, err := data.ReadFrom(io.LimitReader(reader, int64(sess.server.maxSize())+1))
+	if err == nil {
+		// the rest of a message that is too big still has to be read up to the final dot
+		_, err = io.Copy(io.Discard, reader)
+	}
+	if err != nil {
+		return
+	}
+	if n > int64(sess.server.maxSize()) {
+		sess.reply(552, "5.3.4 Message too big")
+		sess.reset()
+		return
+	}
+
+	message := Message{
+		From:       sess.from,
+		To:         sess.to,
+		Username:   sess.username,
+		TLS:        sess.tls,
+		ReceivedAt: time.Now(),
+		Data:       data.Bytes(),
+	}
+	if parsed, err := mail.ReadMessage(bytes.NewReader(message.Data)); err == nil {
+		subject := parsed.Header.Get("Subject")
+		if decoded, err := new(mime.WordDecoder).DecodeHeader(subject); err == nil {
+			subject = decoded
+		}
+		message.Subject = subject
+	}
+
+	message = sess.server.Store.Add(message)
+	sess.reset()
+	sess.reply(250, "2.0.0 Ok: queued as %d", message.ID)
+}
+
+// pathArg returns the address of MAIL FROM:<address> and RCPT TO:<address> arguments, ignoring their parameters.
+func pathArg(args, prefix string) (string, bool) {
+	if len(args) < len(prefix) || !strings.EqualFold(args[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	path, _, _ := strings.Cut(strings.TrimSpace(args[len(prefix):]), " ")
+	if !strings.HasPrefix(path, "<") || !strings.HasSuffix(path, ">") {
+		return "", false
+	}
+	return path[1 : len(path)-1], true
+}
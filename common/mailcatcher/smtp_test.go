@@ -0,0 +1,220 @@
+package mailcatcher
+
+import (
+	"crypto/tls"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/smtp"
+	"strings"
+	"testing"
+)
+
+const testMessage = "From: reports@example.com\r\n" +
+	"To: john.doe@example.com\r\n" +
+	"Subject: =?UTF-8?q?Balance_Report_=E2=9C=93?=\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=UTF-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Total balance: =2410.00\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=UTF-8\r\n" +
+	"\r\n" +
+	"<p>Total balance: $10.00</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf; name=\"statement-1.pdf\"\r\n" +
+	"Content-Disposition: attachment; filename=\"statement-1.pdf\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0x\r\n" +
+	"LjQ=\r\n" +
+	"--outer--\r\n" +
+	".leading dot\r\n"
+
+func startServer(t *testing.T, server *Server) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() { done <- server.Serve(listener) }()
+	t.Cleanup(func() {
+		require.NoError(t, server.Close())
+		require.ErrorIs(t, <-done, ErrServerClosed)
+	})
+	return listener.Addr().String()
+}
+
+// send delivers the test message with STARTTLS, authenticating when auth is given.
+func send(addr string, auth smtp.Auth) error {
+	client, err := smtp.Dial(addr)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.StartTLS(&tls.Config{InsecureSkipVerify: true}); err != nil {
+		return err
+	}
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail("reports@example.com"); err != nil {
+		return err
+	}
+	if err := client.Rcpt("john.doe@example.com"); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(testMessage)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func TestServer(t *testing.T) {
+	store := &Store{}
+	addr := startServer(t, &Server{Store: store, Username: "user", Password: "secret"})
+
+	t.Run("Plain", func(t *testing.T) {
+		store.Clear()
+		require.NoError(t, send(addr, smtp.PlainAuth("", "user", "secret", "127.0.0.1")))
+
+		messages := store.Messages()
+		require.Len(t, messages, 1)
+		message := messages[0]
+		require.Equal(t, "reports@example.com", message.From)
+		require.Equal(t, []string{"john.doe@example.com"}, message.To)
+		require.Equal(t, "Balance Report ✓", message.Subject)
+		require.Equal(t, "user", message.Username)
+		require.True(t, message.TLS)
+		require.Equal(t, strings.ReplaceAll(testMessage, "\r\n", "\n"), string(message.Data))
+		require.Equal(t, len(message.Data), message.Size)
+
+		parts, err := message.Parts()
+		require.NoError(t, err)
+		require.Equal(t, []Part{
+			{ContentType: "text/plain", Size: 21, Body: []byte("Total balance: $10.00")},
+			{ContentType: "text/html", Size: 28, Body: []byte("<p>Total balance: $10.00</p>")},
+			{ContentType: "application/pdf", Filename: "statement-1.pdf", Size: 8, Body: []byte("%PDF-1.4")},
+		}, parts)
+
+		got, ok := store.Get(message.ID)
+		require.True(t, ok)
+		require.Equal(t, message, got)
+	})
+
+	t.Run("Login", func(t *testing.T) {
+		store.Clear()
+		require.NoError(t, send(addr, &loginAuth{username: "user", password: "secret"}))
+		require.Len(t, store.Messages(), 1)
+	})
+
+	t.Run("InvalidCredentials", func(t *testing.T) {
+		err := send(addr, smtp.PlainAuth("", "user", "wrong", "127.0.0.1"))
+		require.ErrorContains(t, err, "535")
+	})
+
+	t.Run("AuthenticationRequired", func(t *testing.T) {
+		err := send(addr, nil)
+		require.ErrorContains(t, err, "530")
+	})
+}
+
+func TestServer_WithoutCredentials(t *testing.T) {
+	store := &Store{}
+	addr := startServer(t, &Server{Store: store})
+
+	// any credentials are accepted, or none at all
+	require.NoError(t, send(addr, smtp.PlainAuth("", "anyone", "anything", "127.0.0.1")))
+	require.NoError(t, send(addr, nil))
+
+	messages := store.Messages()
+	require.Len(t, messages, 2)
+	require.Equal(t, "anyone", messages[0].Username)
+	require.Empty(t, messages[1].Username)
+	require.Equal(t, int64(2), messages[1].ID)
+
+	store.Clear()
+	require.Empty(t, store.Messages())
+	_, ok := store.Get(1)
+	require.False(t, ok)
+}
+
+func TestServer_MaxSize(t *testing.T) {
+	store := &Store{}
+	addr := startServer(t, &Server{Store: store, MaxSize: 100})
+
+	err := send(addr, nil)
+	require.ErrorContains(t, err, "552")
+	require.Empty(t, store.Messages())
+}
+
+func TestServer_CloseWhileAccepting(t *testing.T) {
+	listener := &pendingListener{accepting: make(chan struct{}), conns: make(chan net.Conn)}
+	server := &Server{Store: &Store{}}
+	served := make(chan error)
+	go func() { served <- server.Serve(listener) }()
+
+	// the conn is accepted after Close went over the tracked ones, Serve closes it instead of serving it
+	<-listener.accepting
+	require.NoError(t, server.Close())
+	client, conn := net.Pipe()
+	listener.conns <- conn
+	require.ErrorIs(t, <-served, ErrServerClosed)
+
+	_, err := client.Read(make([]byte, 1))
+	require.Error(t, err)
+}
+
+// pendingListener hands out the conns sent to it, Close doesn't stop a pending Accept.
+type pendingListener struct {
+	accepting chan struct{}
+	conns     chan net.Conn
+}
+
+func (l *pendingListener) Accept() (net.Conn, error) {
+	close(l.accepting)
+	return <-l.conns, nil
+}
+
+func (l *pendingListener) Close() error {
+	return nil
+}
+
+func (l *pendingListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+// loginAuth is the AUTH LOGIN mechanism, which net/smtp doesn't implement.
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	if string(fromServer) == "Username:" {
+		return []byte(a.username), nil
+	}
+	return []byte(a.password), nil
+}
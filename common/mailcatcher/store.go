@@ -0,0 +1,164 @@
+package mailcatcher
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is an email received by the server, Data is the message as it was sent, headers included, with its lines
+// ending in "\n".
+type Message struct {
+	ID         int64     `json:"id"`
+	From       string    `json:"from"`
+	To         []string  `json:"to"`
+	Subject    string    `json:"subject"`
+	Username   string    `json:"username,omitempty"`
+	TLS        bool      `json:"tls"`
+	Size       int       `json:"size"`
+	ReceivedAt time.Time `json:"received_at"`
+	Data       []byte    `json:"-"`
+}
+
+// Part is a leaf of the MIME tree of a message, its body decoded from its transfer encoding.
+type Part struct {
+	ContentType string `json:"content_type"`
+	Filename    string `json:"filename,omitempty"`
+	Size        int    `json:"size"`
+	Body        []byte `json:"-"`
+}
+
+// Header parses the headers of the message.
+func (m Message) Header() (mail.Header, error) {
+	parsed, err := mail.ReadMessage(bytes.NewReader(m.Data))
+	if err != nil {
+		return nil, err
+	}
+
+	return parsed.Header, nil
+}
+
+// Parts walks the MIME tree of the message and returns its leaves in order: the bodies and the attachments.
+func (m Message) Parts() ([]Part, error) {
+	parsed, err := mail.ReadMessage(bytes.NewReader(m.Data))
+	if err != nil {
+		return nil, err
+	}
+
+	return readParts(parsed.Header.Get("Content-Type"), parsed.Header.Get("Content-Transfer-Encoding"), parsed.Header.Get("Content-Disposition"), parsed.Body)
+}
+
+// readParts reads the parts of a body with the given headers, recursing into multipart bodies.
+func readParts(contentType, encoding, disposition string, body io.Reader) ([]Part, error) {
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		var parts []Part
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return parts, nil
+			} else if err != nil {
+				return nil, err
+			}
+
+			children, err := readParts(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part.Header.Get("Content-Disposition"), part)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, children...)
+		}
+	}
+
+	switch strings.ToLower(encoding) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, &newlineSkipper{reader: body})
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	part := Part{ContentType: mediaType, Size: len(data), Body: data}
+	if _, params, err := mime.ParseMediaType(disposition); err == nil {
+		part.Filename = params["filename"]
+	}
+	return []Part{part}, nil
+}
+
+// newlineSkipper drops the line breaks of base64 bodies, which the base64 decoder doesn't skip on its own.
+type newlineSkipper struct {
+	reader io.Reader
+}
+
+func (r *newlineSkipper) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	kept := 0
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' {
+			p[kept] = b
+			kept++
+		}
+	}
+	return kept, err
+}
+
+// Store keeps the received messages in memory, it is safe for concurrent use.
+type Store struct {
+	lock     sync.Mutex
+	lastID   int64
+	messages []Message
+}
+
+// Add stores a message with the next id and returns it.
+func (s *Store) Add(message Message) Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastID += 1
+	message.ID = s.lastID
+	message.Size = len(message.Data)
+	s.messages = append(s.messages, message)
+	return message
+}
+
+// Messages returns the stored messages, oldest first.
+func (s *Store) Messages() []Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Get returns the message with the id, reporting whether there is one.
+func (s *Store) Get(id int64) (Message, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, message := range s.messages {
+		if message.ID == id {
+			return message, true
+		}
+	}
+	return Message{}, false
+}
+
+// Clear deletes every stored message, ids keep counting.
+func (s *Store) Clear() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.messages = nil
+}
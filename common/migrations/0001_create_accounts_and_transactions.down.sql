@@ -0,0 +1,5 @@
+DROP TABLE transactions;
+
+DROP TYPE TX_OPERATION_TYPE;
+
+DROP TABLE accounts;
@@ -0,0 +1,5 @@
+ALTER TABLE transactions DROP COLUMN import_id;
+
+DROP TABLE imports;
+
+DROP TYPE IMPORT_STATUS;
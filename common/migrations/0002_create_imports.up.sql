@@ -0,0 +1,26 @@
+CREATE TYPE IMPORT_STATUS AS ENUM('running', 'completed', 'incomplete', 'failed');
+
+CREATE TABLE imports (
+    import_id BIGSERIAL PRIMARY KEY,
+    account_id BIGINT NOT NULL REFERENCES accounts(account_id),
+    source TEXT NOT NULL,
+    checksum TEXT NOT NULL,
+    format TEXT NOT NULL DEFAULT '',
+    status IMPORT_STATUS NOT NULL,
+    count_accepted BIGINT NOT NULL DEFAULT 0,
+    count_rejected BIGINT NOT NULL DEFAULT 0,
+    count_duplicate BIGINT NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    report JSONB NOT NULL DEFAULT '{}',
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    finished_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX imports_account_id_idx ON imports(account_id, started_at);
+CREATE INDEX imports_checksum_idx ON imports(account_id, checksum);
+
+ALTER TABLE transactions ADD COLUMN import_id BIGINT REFERENCES imports(import_id);
+
+CREATE INDEX transactions_import_id_idx ON transactions(import_id);
@@ -0,0 +1,9 @@
+CREATE TABLE balance_snapshots (
+    snapshot_id BIGSERIAL PRIMARY KEY,
+    account_id BIGINT NOT NULL REFERENCES accounts(account_id),
+    total_balance DECIMAL(16, 2) NOT NULL,
+    report JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX balance_snapshots_account_id_idx ON balance_snapshots(account_id, created_at);
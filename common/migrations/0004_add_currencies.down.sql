@@ -0,0 +1,3 @@
+DROP TABLE fx_rates;
+
+ALTER TABLE accounts DROP COLUMN reporting_currency;
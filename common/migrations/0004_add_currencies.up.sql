@@ -0,0 +1,10 @@
+ALTER TABLE accounts ADD COLUMN reporting_currency CHAR(3);
+
+CREATE TABLE fx_rates (
+    base CHAR(3) NOT NULL,
+    quote CHAR(3) NOT NULL,
+    rate DECIMAL(20, 10) NOT NULL,
+    effective_at DATE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (base, quote, effective_at)
+);
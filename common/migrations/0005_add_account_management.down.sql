@@ -0,0 +1,2 @@
+ALTER TABLE accounts DROP COLUMN merged_into;
+ALTER TABLE accounts DROP COLUMN deactivated_at;
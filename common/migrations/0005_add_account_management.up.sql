@@ -0,0 +1,2 @@
+ALTER TABLE accounts ADD COLUMN deactivated_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE accounts ADD COLUMN merged_into BIGINT REFERENCES accounts(account_id);
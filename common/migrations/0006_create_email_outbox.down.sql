@@ -0,0 +1,3 @@
+DROP TABLE email_outbox;
+
+DROP TYPE EMAIL_STATUS;
@@ -0,0 +1,19 @@
+CREATE TYPE EMAIL_STATUS AS ENUM('pending', 'sent', 'dead');
+
+CREATE TABLE email_outbox (
+    email_id BIGSERIAL PRIMARY KEY,
+    idempotency_key TEXT NOT NULL,
+    account_id BIGINT NOT NULL REFERENCES accounts(account_id),
+    kind TEXT NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    status EMAIL_STATUS NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    sent_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX email_outbox_idempotency_key_idx ON email_outbox(idempotency_key);
+CREATE INDEX email_outbox_pending_idx ON email_outbox(next_attempt_at) WHERE status = 'pending';
@@ -0,0 +1,5 @@
+DROP INDEX transactions_external_id_idx;
+
+ALTER TABLE transactions DROP COLUMN source;
+
+ALTER TABLE transactions DROP COLUMN external_id;
@@ -0,0 +1,10 @@
+-- Databases created before migrations lack the ids of the rows in their statements, rows stored then keep their
+-- transaction id as external id so they stay unique. Databases that already have them are left as they are.
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS external_id TEXT;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT '';
+
+UPDATE transactions SET external_id = transaction_id::TEXT WHERE external_id IS NULL;
+
+ALTER TABLE transactions ALTER COLUMN external_id SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS transactions_external_id_idx ON transactions(account_id, source, external_id);
@@ -0,0 +1,346 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// ErrOutdatedSchema is returned by Check when the database misses migrations known to the binary.
+var ErrOutdatedSchema = errors.New("database schema is outdated")
+
+// ErrUnknownVersion is returned when migrating to a version that has no migration.
+var ErrUnknownVersion = errors.New("unknown migration version")
+
+const (
+	createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMP WITH TIME ZONE NOT NULL
+)`
+	listAppliedMigrations = `SELECT version, applied_at FROM schema_migrations ORDER BY version`
+	insertMigration       = `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`
+	deleteMigration       = `DELETE FROM schema_migrations WHERE version = $1`
+)
+
+// fileName matches migration files: <version>_<name>.(up|down).sql, sqlc reads the up files as the schema.
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration changes the schema from the previous version to Version with Up, and back with Down.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus tells whether a migration is applied to the database, and when.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Load reads the migrations embedded in the binary, ordered by version.
+func Load() ([]Migration, error) {
+	return load(files)
+}
+
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration %s: %w", entry.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both up and down files", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Migrator applies and rolls back migrations, keeping track of the applied ones in the schema_migrations table.
+// Each migration runs in its own database transaction along with its row in schema_migrations, so concurrent runs
+// fail on its primary key instead of applying a migration twice.
+type Migrator struct {
+	Database *sql.DB
+
+	// Migrations defaults to the ones embedded in the binary.
+	Migrations []Migration
+}
+
+func (m *Migrator) migrations() ([]Migration, error) {
+	if m.Migrations != nil {
+		return m.Migrations, nil
+	}
+	return Load()
+}
+
+// Latest returns the version of the last known migration, 0 without migrations.
+func (m *Migrator) Latest() (int64, error) {
+	migrations, err := m.migrations()
+	if err != nil || len(migrations) == 0 {
+		return 0, err
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// Version returns the version of the last migration applied to the database, 0 when none is.
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var version int64
+	for v := range applied {
+		version = max(version, v)
+	}
+	return version, nil
+}
+
+// Status lists every known migration and whether it is applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := m.migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, migration := range migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses[i] = MigrationStatus{Migration: migration, Applied: ok, AppliedAt: appliedAt}
+	}
+	return statuses, nil
+}
+
+// Check returns ErrOutdatedSchema unless every known migration is applied to the database.
+func (m *Migrator) Check(ctx context.Context) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		if !status.Applied {
+			return fmt.Errorf("%w: migration %d_%s is not applied, run migrate up", ErrOutdatedSchema, status.Version, status.Name)
+		}
+	}
+	return nil
+}
+
+// Up applies every pending migration, returning the ones applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	latest, err := m.Latest()
+	if err != nil {
+		return nil, err
+	}
+	return m.To(ctx, latest)
+}
+
+// Down rolls back the last applied migration, returning it. Nothing is rolled back without applied migrations.
+func (m *Migrator) Down(ctx context.Context) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(statuses) - 1; i >= 0; i-- {
+		if statuses[i].Applied {
+			return m.rollback(ctx, []Migration{statuses[i].Migration})
+		}
+	}
+	return nil, nil
+}
+
+// To applies the pending migrations up to version and rolls back the applied ones after it, returning the
+// migrations run in order. Version 0 rolls back every migration.
+func (m *Migrator) To(ctx context.Context, version int64) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	known := version == 0
+	var pending, applied []Migration
+	for _, status := range statuses {
+		known = known || status.Version == version
+		if status.Version <= version && !status.Applied {
+			pending = append(pending, status.Migration)
+		} else if status.Version > version && status.Applied {
+			applied = append([]Migration{status.Migration}, applied...)
+		}
+	}
+	if !known {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	if len(applied) > 0 {
+		return m.rollback(ctx, applied)
+	}
+	return m.apply(ctx, pending)
+}
+
+// Baseline records the migrations up to version as applied without running them, for databases whose schema was
+// created before migrations. It refuses databases that already have applied migrations.
+func (m *Migrator) Baseline(ctx context.Context, version int64) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	known := false
+	var baseline []Migration
+	for _, status := range statuses {
+		if status.Applied {
+			return nil, fmt.Errorf("migration %d_%s is already applied, baseline is only for databases without migrations", status.Version, status.Name)
+		}
+		known = known || status.Version == version
+		if status.Version <= version {
+			baseline = append(baseline, status.Migration)
+		}
+	}
+	if !known {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	err = m.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now()
+		for _, migration := range baseline {
+			if _, err := tx.ExecContext(ctx, insertMigration, migration.Version, migration.Name, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error recording baseline: %w", err)
+	}
+	return baseline, nil
+}
+
+// apply runs the up migrations in order, stopping at the first that fails.
+func (m *Migrator) apply(ctx context.Context, migrations []Migration) ([]Migration, error) {
+	for i, migration := range migrations {
+		err := m.withTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, insertMigration, migration.Version, migration.Name, time.Now())
+			return err
+		})
+		if err != nil {
+			return migrations[:i], fmt.Errorf("error applying migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+	return migrations, nil
+}
+
+// rollback runs the down migrations in order, stopping at the first that fails.
+func (m *Migrator) rollback(ctx context.Context, migrations []Migration) ([]Migration, error) {
+	for i, migration := range migrations {
+		err := m.withTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, deleteMigration, migration.Version)
+			return err
+		})
+		if err != nil {
+			return migrations[:i], fmt.Errorf("error rolling back migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+	return migrations, nil
+}
+
+// applied returns when each applied migration was applied, by version.
+func (m *Migrator) applied(ctx context.Context) (map[int64]time.Time, error) {
+	if _, err := m.Database.ExecContext(ctx, createMigrationsTable); err != nil {
+		return nil, fmt.Errorf("error creating schema_migrations: %w", err)
+	}
+
+	rows, err := m.Database.QueryContext(ctx, listAppliedMigrations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// withTx runs fn inside a database transaction, it is committed only when fn succeeds.
+func (m *Migrator) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := m.Database.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			log.Printf("error rolling back transaction: %s", rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,205 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var testMigrations = []Migration{
+	{Version: 1, Name: "create_accounts", Up: "CREATE TABLE accounts ()", Down: "DROP TABLE accounts"},
+	{Version: 2, Name: "create_imports", Up: "CREATE TABLE imports ()", Down: "DROP TABLE imports"},
+	{Version: 3, Name: "create_snapshots", Up: "CREATE TABLE snapshots ()", Down: "DROP TABLE snapshots"},
+}
+
+func expectApplied(mock sqlmock.Sqlmock, versions ...int64) {
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, version := range versions {
+		rows.AddRow(version, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	}
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT version, applied_at FROM schema_migrations`).WillReturnRows(rows)
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, migration := range migrations {
+		require.Equal(t, int64(i+1), migration.Version)
+		require.NotEmpty(t, migration.Up)
+		require.NotEmpty(t, migration.Down)
+	}
+
+	t.Run("MissingDown", func(t *testing.T) {
+		_, err := load(fstest.MapFS{"0001_init.up.sql": {Data: []byte("CREATE TABLE a ()")}})
+		require.ErrorContains(t, err, "needs both up and down files")
+	})
+
+	t.Run("Ordered", func(t *testing.T) {
+		migrations, err := load(fstest.MapFS{
+			"0010_later.up.sql":   {Data: []byte("up 10")},
+			"0010_later.down.sql": {Data: []byte("down 10")},
+			"0002_first.up.sql":   {Data: []byte("up 2")},
+			"0002_first.down.sql": {Data: []byte("down 2")},
+			"README.md":           {Data: []byte("ignored")},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []Migration{
+			{Version: 2, Name: "first", Up: "up 2", Down: "down 2"},
+			{Version: 10, Name: "later", Up: "up 10", Down: "down 10"},
+		}, migrations)
+	})
+}
+
+func TestMigrator_Up(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	migrator := &Migrator{Database: db, Migrations: testMigrations}
+
+	t.Run("Pending", func(t *testing.T) {
+		expectApplied(mock, 1)
+		for _, migration := range testMigrations[1:] {
+			mock.ExpectBegin()
+			mock.ExpectExec(migration.Up).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`INSERT INTO schema_migrations`).WithArgs(migration.Version, migration.Name, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}
+
+		applied, err := migrator.Up(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, testMigrations[1:], applied)
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		expectApplied(mock)
+		mock.ExpectBegin()
+		mock.ExpectExec(testMigrations[0].Up).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(testMigrations[1].Up).WillReturnError(errors.New("syntax error"))
+		mock.ExpectRollback()
+
+		applied, err := migrator.Up(context.Background())
+		require.ErrorContains(t, err, "error applying migration 2_create_imports: syntax error")
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, testMigrations[:1], applied)
+	})
+
+	t.Run("UpToDate", func(t *testing.T) {
+		expectApplied(mock, 1, 2, 3)
+
+		applied, err := migrator.Up(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Empty(t, applied)
+	})
+}
+
+func TestMigrator_Down(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	migrator := &Migrator{Database: db, Migrations: testMigrations}
+
+	t.Run("Last", func(t *testing.T) {
+		expectApplied(mock, 1, 2)
+		mock.ExpectBegin()
+		mock.ExpectExec(testMigrations[1].Down).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`DELETE FROM schema_migrations`).WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		rolledBack, err := migrator.Down(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, testMigrations[1:2], rolledBack)
+	})
+
+	t.Run("Nothing", func(t *testing.T) {
+		expectApplied(mock)
+
+		rolledBack, err := migrator.Down(context.Background())
+		require.NoError(t, err)
+		require.Empty(t, rolledBack)
+	})
+}
+
+func TestMigrator_To(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	migrator := &Migrator{Database: db, Migrations: testMigrations}
+
+	t.Run("Down", func(t *testing.T) {
+		expectApplied(mock, 1, 2, 3)
+		for _, version := range []int64{3, 2} {
+			migration := testMigrations[version-1]
+			mock.ExpectBegin()
+			mock.ExpectExec(migration.Down).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`DELETE FROM schema_migrations`).WithArgs(version).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}
+
+		rolledBack, err := migrator.To(context.Background(), 1)
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, []Migration{testMigrations[2], testMigrations[1]}, rolledBack)
+	})
+
+	t.Run("UnknownVersion", func(t *testing.T) {
+		expectApplied(mock, 1)
+
+		_, err := migrator.To(context.Background(), 7)
+		require.ErrorIs(t, err, ErrUnknownVersion)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestMigrator_Check(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	migrator := &Migrator{Database: db, Migrations: testMigrations}
+
+	t.Run("UpToDate", func(t *testing.T) {
+		expectApplied(mock, 1, 2, 3)
+		require.NoError(t, migrator.Check(context.Background()))
+	})
+
+	t.Run("Outdated", func(t *testing.T) {
+		expectApplied(mock, 1, 2)
+		err := migrator.Check(context.Background())
+		require.ErrorIs(t, err, ErrOutdatedSchema)
+		require.ErrorContains(t, err, "3_create_snapshots")
+	})
+}
+
+func TestMigrator_Baseline(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	migrator := &Migrator{Database: db, Migrations: testMigrations}
+
+	t.Run("Baseline", func(t *testing.T) {
+		expectApplied(mock)
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO schema_migrations`).WithArgs(int64(1), "create_accounts", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO schema_migrations`).WithArgs(int64(2), "create_imports", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		baseline, err := migrator.Baseline(context.Background(), 2)
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, testMigrations[:2], baseline)
+	})
+
+	t.Run("AlreadyMigrated", func(t *testing.T) {
+		expectApplied(mock, 1)
+
+		_, err := migrator.Baseline(context.Background(), 3)
+		require.ErrorContains(t, err, "already applied")
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,70 @@
+package money
+
+import (
+	"strings"
+)
+
+// localeFormat holds the separators and currency symbols of a locale, currencies without a symbol are shown by their
+// code.
+type localeFormat struct {
+	decimal     string
+	group       string
+	symbolAfter bool
+	symbols     map[string]string
+}
+
+// neutralFormat writes amounts of unknown locales with their currency code, callers resolve the locale of an account
+// (services.DefaultLocale when it has none) before formatting.
+var neutralFormat = localeFormat{decimal: ".", group: ","}
+
+var localeFormats = map[string]localeFormat{
+	"en-US": {decimal: ".", group: ",", symbols: map[string]string{"USD": "$", "MXN": "MX$", "CAD": "CA$", "EUR": "€", "GBP": "£", "JPY": "¥"}},
+	"es-MX": {decimal: ".", group: ",", symbols: map[string]string{"MXN": "$"}},
+	"es-ES": {decimal: ",", group: ".", symbolAfter: true, symbols: map[string]string{"EUR": "€", "USD": "US$"}},
+}
+
+// Format returns the amount rounded and written like the locale does, for example $1,234.50 in es-MX or
+// 1.234,50 € in es-ES. Unknown locales get the currency code, like MXN 1,234.50.
+func (m Money) Format(locale string) string {
+	format, ok := localeFormats[locale]
+	if !ok {
+		format = neutralFormat
+	}
+
+	rounded := m.Round()
+	digits := rounded.amount.Abs().StringFixed(MinorUnits(m.currency))
+	integer, fraction, _ := strings.Cut(digits, ".")
+
+	var builder strings.Builder
+	if rounded.IsNegative() {
+		builder.WriteString("-")
+	}
+
+	symbol, ok := format.symbols[m.currency]
+	if !ok {
+		symbol = m.currency
+	}
+	if !format.symbolAfter && symbol != "" {
+		builder.WriteString(symbol)
+		if !ok {
+			builder.WriteString(" ")
+		}
+	}
+
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			builder.WriteString(format.group)
+		}
+		builder.WriteRune(digit)
+	}
+	if fraction != "" {
+		builder.WriteString(format.decimal)
+		builder.WriteString(fraction)
+	}
+
+	if format.symbolAfter && symbol != "" {
+		builder.WriteString(" ")
+		builder.WriteString(symbol)
+	}
+	return builder.String()
+}
@@ -0,0 +1,32 @@
+package money
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMoney_Format(t *testing.T) {
+	tests := []struct {
+		amount    string
+		currency  string
+		locale    string
+		formatted string
+	}{
+		{"1234.5", "MXN", "es-MX", "$1,234.50"},
+		{"1234.5", "MXN", "en-US", "MX$1,234.50"},
+		{"1234.5", "USD", "en-US", "$1,234.50"},
+		{"1234.5", "USD", "es-MX", "USD 1,234.50"},
+		{"-1234567.891", "EUR", "es-ES", "-1.234.567,89 €"},
+		{"0.5", "MXN", "es-ES", "0,50 MXN"},
+		{"-0.004", "MXN", "es-MX", "$0.00"},
+		{"1500", "JPY", "en-US", "¥1,500"},
+		{"999", "MXN", "unknown", "MXN 999.00"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.formatted, func(t *testing.T) {
+			require.Equal(t, tc.formatted, New(decimal.RequireFromString(tc.amount), tc.currency).Format(tc.locale))
+		})
+	}
+}
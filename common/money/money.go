@@ -0,0 +1,180 @@
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/shopspring/decimal"
+)
+
+// Rounding is the policy used to round amounts to the minor units of their currency.
+type Rounding int
+
+const (
+	// RoundHalfEven rounds halves to the nearest even digit (banker's rounding), so rounding many amounts doesn't
+	// drift the totals in one direction.
+	RoundHalfEven Rounding = iota
+
+	// RoundHalfUp rounds halves away from zero.
+	RoundHalfUp
+)
+
+// DefaultRounding is the policy of Round and of formatted amounts.
+var DefaultRounding = RoundHalfEven
+
+// minorUnits are the ISO 4217 currencies whose minor unit isn't the cent, every other one has 2 decimals.
+var minorUnits = map[string]int32{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0,
+	"UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+// MinorUnits returns the number of decimals of a currency.
+func MinorUnits(currency string) int32 {
+	if units, ok := minorUnits[currency]; ok {
+		return units
+	}
+
+	return 2
+}
+
+// Money is an amount in a currency. The zero Money has no currency, it takes the one of the amounts it's added to.
+type Money struct {
+	amount   decimal.Decimal
+	currency string
+}
+
+// New returns an amount of a currency, as is, round it with Round to store or show it.
+func New(amount decimal.Decimal, currency string) Money {
+	return Money{amount: amount, currency: currency}
+}
+
+// Zero returns no money of a currency.
+func Zero(currency string) Money {
+	return Money{amount: decimal.Zero, currency: currency}
+}
+
+// Amount returns the amount without its currency.
+func (m Money) Amount() decimal.Decimal {
+	return m.amount
+}
+
+// Currency returns the ISO 4217 code of the currency, blank for the zero Money.
+func (m Money) Currency() string {
+	return m.currency
+}
+
+func (m Money) IsZero() bool {
+	return m.amount.IsZero()
+}
+
+func (m Money) IsNegative() bool {
+	return m.amount.IsNegative()
+}
+
+func (m Money) Equal(other Money) bool {
+	return m.currency == other.currency && m.amount.Equal(other.amount)
+}
+
+// Add returns the sum of both amounts, which must be of the same currency.
+func (m Money) Add(other Money) Money {
+	return Money{amount: m.amount.Add(other.amount), currency: m.sameCurrency(other)}
+}
+
+// Sub returns the difference of both amounts, which must be of the same currency.
+func (m Money) Sub(other Money) Money {
+	return Money{amount: m.amount.Sub(other.amount), currency: m.sameCurrency(other)}
+}
+
+// Mul returns the amount times a factor, without rounding.
+func (m Money) Mul(factor decimal.Decimal) Money {
+	return Money{amount: m.amount.Mul(factor), currency: m.currency}
+}
+
+// Div returns the amount split in count parts, rounded to the minor units of its currency.
+func (m Money) Div(count int64) Money {
+	return Money{amount: m.amount.Div(decimal.NewFromInt(count)), currency: m.currency}.Round()
+}
+
+// Neg returns the amount with the opposite sign.
+func (m Money) Neg() Money {
+	return Money{amount: m.amount.Neg(), currency: m.currency}
+}
+
+// Abs returns the amount without sign.
+func (m Money) Abs() Money {
+	return Money{amount: m.amount.Abs(), currency: m.currency}
+}
+
+// Convert returns the amount in another currency, given how many units of it one unit of this one is worth, rounded
+// to the minor units of that currency.
+func (m Money) Convert(rate decimal.Decimal, currency string) Money {
+	return Money{amount: m.amount.Mul(rate), currency: currency}.Round()
+}
+
+// Round rounds the amount to the minor units of its currency with the DefaultRounding.
+func (m Money) Round() Money {
+	return m.RoundWith(DefaultRounding)
+}
+
+// RoundWith rounds the amount to the minor units of its currency with a rounding policy.
+func (m Money) RoundWith(rounding Rounding) Money {
+	places := MinorUnits(m.currency)
+	if rounding == RoundHalfUp {
+		return Money{amount: m.amount.Round(places), currency: m.currency}
+	}
+
+	return Money{amount: m.amount.RoundBank(places), currency: m.currency}
+}
+
+// String returns the rounded amount followed by its currency, like 1234.50 MXN.
+func (m Money) String() string {
+	amount := m.Round().amount.StringFixed(MinorUnits(m.currency))
+	if m.currency == "" {
+		return amount
+	}
+
+	return amount + " " + m.currency
+}
+
+// sameCurrency returns the currency of both amounts, it panics when they differ since adding them up is a bug.
+func (m Money) sameCurrency(other Money) string {
+	switch {
+	case m.currency == other.currency || other.currency == "":
+		return m.currency
+	case m.currency == "":
+		return other.currency
+	}
+	panic(fmt.Sprintf("money: mismatched currencies %s and %s", m.currency, other.currency))
+}
+
+// moneyJSON is how Money is stored in reports and snapshots.
+type moneyJSON struct {
+	Amount   decimal.Decimal `json:"amount"`
+	Currency string          `json:"currency"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.amount, Currency: m.currency})
+}
+
+// UnmarshalJSON reads amounts with their currency, or bare amounts like the ones of reports stored before Money, which
+// are left without currency.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var amount decimal.Decimal
+		if err := amount.UnmarshalJSON(data); err != nil {
+			return err
+		}
+		*m = Money{amount: amount}
+		return nil
+	}
+
+	var value moneyJSON
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*m = Money{amount: value.Amount, currency: value.Currency}
+	return nil
+}
@@ -0,0 +1,85 @@
+package money
+
+import (
+	"encoding/json"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMinorUnits(t *testing.T) {
+	require.Equal(t, int32(2), MinorUnits("MXN"))
+	require.Equal(t, int32(0), MinorUnits("JPY"))
+	require.Equal(t, int32(3), MinorUnits("KWD"))
+	require.Equal(t, int32(2), MinorUnits("XYZ"))
+}
+
+func TestMoney_Arithmetic(t *testing.T) {
+	a := New(decimal.RequireFromString("10.50"), "MXN")
+	b := New(decimal.RequireFromString("4.25"), "MXN")
+
+	require.Equal(t, "14.75 MXN", a.Add(b).String())
+	require.Equal(t, "6.25 MXN", a.Sub(b).String())
+	require.Equal(t, "-10.50 MXN", a.Neg().String())
+	require.Equal(t, "10.50 MXN", a.Neg().Abs().String())
+	require.Equal(t, "3.50 MXN", a.Div(3).String())
+	require.True(t, a.Sub(a).IsZero())
+	require.True(t, b.Sub(a).IsNegative())
+
+	// the zero Money takes the currency of what it's added to
+	var total Money
+	total = total.Add(a)
+	require.Equal(t, "MXN", total.Currency())
+	require.True(t, total.Equal(a))
+
+	require.Panics(t, func() { a.Add(New(decimal.NewFromInt(1), "USD")) })
+}
+
+func TestMoney_Round(t *testing.T) {
+	tests := []struct {
+		amount   string
+		currency string
+		rounding Rounding
+		rounded  string
+	}{
+		{"2.345", "MXN", RoundHalfEven, "2.34"},
+		{"2.355", "MXN", RoundHalfEven, "2.36"},
+		{"2.345", "MXN", RoundHalfUp, "2.35"},
+		{"-2.345", "MXN", RoundHalfUp, "-2.35"},
+		{"1234.5", "JPY", RoundHalfEven, "1234"},
+		{"1.2345", "KWD", RoundHalfEven, "1.234"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.amount+" "+tc.currency, func(t *testing.T) {
+			rounded := New(decimal.RequireFromString(tc.amount), tc.currency).RoundWith(tc.rounding)
+			require.Equal(t, tc.rounded, rounded.Amount().String())
+		})
+	}
+}
+
+func TestMoney_Convert(t *testing.T) {
+	usd := New(decimal.RequireFromString("10.01"), "USD")
+	converted := usd.Convert(decimal.RequireFromString("17.0512"), "MXN")
+	require.Equal(t, "MXN", converted.Currency())
+	require.Equal(t, "170.68", converted.Amount().String())
+
+	require.Equal(t, "1707", usd.Convert(decimal.RequireFromString("170.5"), "JPY").Amount().String())
+}
+
+func TestMoney_JSON(t *testing.T) {
+	jsonData, err := json.Marshal(New(decimal.RequireFromString("12.5"), "MXN"))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"amount":"12.5","currency":"MXN"}`, string(jsonData))
+
+	var money Money
+	require.NoError(t, json.Unmarshal(jsonData, &money))
+	require.True(t, money.Equal(New(decimal.RequireFromString("12.5"), "MXN")))
+
+	// amounts stored before Money have no currency
+	require.NoError(t, json.Unmarshal([]byte(`"100.25"`), &money))
+	require.Equal(t, "", money.Currency())
+	require.Equal(t, "100.25", money.Amount().String())
+
+	require.Error(t, json.Unmarshal([]byte(`"abc"`), &money))
+}
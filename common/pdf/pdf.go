@@ -0,0 +1,167 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Font is one of the standard fonts every PDF reader has, so nothing needs to be embedded in the document.
+type Font int
+
+const (
+	Helvetica Font = iota
+	HelveticaBold
+)
+
+var fontNames = map[Font]string{
+	Helvetica:     "Helvetica",
+	HelveticaBold: "Helvetica-Bold",
+}
+
+const (
+	// PageWidth and PageHeight are the size of letter pages, in points.
+	PageWidth  = 612.0
+	PageHeight = 792.0
+)
+
+// Document is a PDF 1.4 document of letter pages with text, lines and boxes. Text is written in the WinAnsi
+// encoding of the standard fonts, characters outside it are replaced by '?'.
+type Document struct {
+	Title string
+	pages []*Page
+}
+
+// Page is a page of a document. Coordinates are in points from the top left corner, y grows downwards.
+type Page struct {
+	content bytes.Buffer
+}
+
+// New creates an empty document.
+func New(title string) *Document {
+	return &Document{Title: title}
+}
+
+// AddPage adds a blank page at the end of the document.
+func (d *Document) AddPage() *Page {
+	page := &Page{}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+// Pages returns the pages of the document in order.
+func (d *Document) Pages() []*Page {
+	return d.pages
+}
+
+// Text writes text with its baseline at y.
+func (p *Page) Text(x, y float64, font Font, size float64, text string) {
+	_, _ = fmt.Fprintf(&p.content, "BT /F%d %s Tf %s %s Td (%s) Tj ET\n", font+1, num(size), num(x), num(PageHeight-y), escape(text))
+}
+
+// TextRight writes text with its baseline at y and its end at x.
+func (p *Page) TextRight(x, y float64, font Font, size float64, text string) {
+	p.Text(x-TextWidth(font, size, text), y, font, size, text)
+}
+
+// Line draws a black line of the given width.
+func (p *Page) Line(x1, y1, x2, y2, width float64) {
+	_, _ = fmt.Fprintf(&p.content, "%s w %s %s m %s %s l S\n", num(width), num(x1), num(PageHeight-y1), num(x2), num(PageHeight-y2))
+}
+
+// Box fills a rectangle with its top left corner at x, y in a shade of gray, 0 is black and 1 white.
+func (p *Page) Box(x, y, width, height, gray float64) {
+	_, _ = fmt.Fprintf(&p.content, "q %s g %s %s %s %s re f Q\n", num(gray), num(x), num(PageHeight-y-height), num(width), num(height))
+}
+
+// WriteTo writes the document, a document without pages gets a blank one.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	pages := d.pages
+	if len(pages) == 0 {
+		pages = []*Page{{}}
+	}
+
+	// objects 1 to 5 are the catalog, the page tree, the info and the two fonts, then each page and its content
+	var buf bytes.Buffer
+	offsets := make([]int, 0, 5+2*len(pages))
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		_, _ = fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object(fmt.Sprintf("<< /Title (%s) /Producer (account-balance) >>", escape(d.Title)))
+	for _, font := range []Font{Helvetica, HelveticaBold} {
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", fontNames[font]))
+	}
+	for i, page := range pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents %d 0 R >>",
+			num(PageWidth), num(PageHeight), 7+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.content.Len(), page.content.String()))
+	}
+
+	xref := buf.Len()
+	_, _ = fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		_, _ = fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	_, _ = fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// num formats a coordinate or size with up to two decimals.
+func num(value float64) string {
+	return strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64)
+}
+
+// escape encodes text as the contents of a PDF string in WinAnsi.
+func escape(text string) string {
+	var buf strings.Builder
+	for _, r := range text {
+		b := winAnsi(r)
+		switch b {
+		case '\\', '(', ')':
+			buf.WriteByte('\\')
+			buf.WriteByte(b)
+		default:
+			if b < 0x20 || b > 0x7e {
+				_, _ = fmt.Fprintf(&buf, "\\%03o", b)
+			} else {
+				buf.WriteByte(b)
+			}
+		}
+	}
+	return buf.String()
+}
+
+// winAnsiExtra are the characters of WinAnsi between 0x80 and 0x9f, the rest above 0xa0 match Latin-1.
+var winAnsiExtra = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88, '‰': 0x89, 'Š': 0x8a,
+	'‹': 0x8b, 'Œ': 0x8c, 'Ž': 0x8e, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'˜': 0x98, '™': 0x99, 'š': 0x9a, '›': 0x9b, 'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+	// the narrow no-break space some locales group digits with
+	'\u202f': 0xa0,
+}
+
+// winAnsi returns the WinAnsi code of a character, '?' when it has none.
+func winAnsi(r rune) byte {
+	switch {
+	case r >= 0x20 && r <= 0x7e, r >= 0xa0 && r <= 0xff:
+		return byte(r)
+	case winAnsiExtra[r] != 0:
+		return winAnsiExtra[r]
+	default:
+		return '?'
+	}
+}
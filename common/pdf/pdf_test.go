@@ -0,0 +1,61 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestDocument_WriteTo(t *testing.T) {
+	doc := New("Estado de cuenta")
+	page := doc.AddPage()
+	page.Text(50, 50, HelveticaBold, 18, "Depósito (1/2) \\ 5 €")
+	page.TextRight(562, 80, Helvetica, 10, "$1,234.50")
+	page.Line(50, 90, 562, 90, 0.5)
+	page.Box(50, 100, 512, 20, 0.9)
+	doc.AddPage().Text(50, 50, Helvetica, 10, "second page")
+
+	var buf bytes.Buffer
+	_, err := doc.WriteTo(&buf)
+	require.NoError(t, err)
+	out := buf.String()
+
+	require.Regexp(t, `^%PDF-1\.4\n`, out)
+	require.Contains(t, out, "/Kids [6 0 R 8 0 R] /Count 2")
+	require.Contains(t, out, "BT /F2 18 Tf 50 742 Td (Dep\\363sito \\(1/2\\) \\\\ 5 \\200) Tj ET")
+	require.Contains(t, out, "0.5 w 50 702 m 562 702 l S")
+	require.Contains(t, out, "q 0.9 g 50 672 512 20 re f Q")
+	require.Contains(t, out, "/BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding")
+
+	// every object is where the cross-reference table says
+	xref := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindStringSubmatch(out)
+	require.NotNil(t, xref)
+	start, err := strconv.Atoi(xref[1])
+	require.NoError(t, err)
+	require.Equal(t, "xref\n0 10\n", out[start:start+10])
+	offsets := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllStringSubmatch(out[start:], -1)
+	require.Len(t, offsets, 9)
+	for i, offset := range offsets {
+		at, err := strconv.Atoi(offset[1])
+		require.NoError(t, err)
+		object := fmt.Sprintf("%d 0 obj\n", i+1)
+		require.Equal(t, object, out[at:at+len(object)])
+	}
+}
+
+func TestDocument_WriteToEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := New("").WriteTo(&buf)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "/Count 1")
+}
+
+func TestTextWidth(t *testing.T) {
+	require.Equal(t, 5.56, TextWidth(Helvetica, 10, "0"))
+	require.InDelta(t, 22.78, TextWidth(Helvetica, 10, "Hello"), 0.001)
+	require.Equal(t, TextWidth(HelveticaBold, 12, "Deposito"), TextWidth(HelveticaBold, 12, "Depósito"))
+	require.Equal(t, TextWidth(Helvetica, 10, "?"), TextWidth(Helvetica, 10, "日"))
+}
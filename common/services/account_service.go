@@ -2,18 +2,101 @@ package services
 
 import (
 	"common/dao"
+	"common/money"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// RecentTransactionsLimit is how many transactions are listed along with the balance of an account.
+const RecentTransactionsLimit = 50
+
+// maxMergeHops bounds how many merged accounts are followed to find the one that took them over.
+const maxMergeHops = 10
+
+var (
+	// ErrUnknownAccount is returned when resolving an account number that doesn't exist.
+	ErrUnknownAccount = errors.New("unknown account")
+
+	// ErrInvalidEmail is returned for malformed email addresses.
+	ErrInvalidEmail = errors.New("invalid email")
+
+	// ErrUnsupportedLocale is returned for locales without messages for emails and the balance page.
+	ErrUnsupportedLocale = errors.New("unsupported locale")
+
+	// ErrAccountExists is returned when creating an account, or changing its email, to an email already in use.
+	ErrAccountExists = errors.New("account already exists")
+
+	// ErrAccountDeactivated is returned when importing into or changing an account that was deactivated.
+	ErrAccountDeactivated = errors.New("account deactivated")
+)
+
+// AccountChanges are the fields of an account to update, nil fields are left as they are.
+type AccountChanges struct {
+	FirstName *string
+	LastName  *string
+	Email     *string
+	Locale    *string
+}
+
+// AccountBalance is the balance of an account, as of its last import, along with its latest transactions.
+type AccountBalance struct {
+	AccountID        int64                `json:"account_id"`
+	FirstName        string               `json:"first_name"`
+	LastName         string               `json:"last_name"`
+	Locale           string               `json:"locale"`
+	Currency         string               `json:"currency"`
+	TotalBalance     money.Money          `json:"total_balance"`
+	AvgDebitAmount   money.Money          `json:"avg_debit_amount"`
+	AvgCreditAmount  money.Money          `json:"avg_credit_amount"`
+	LastBalanceAt    *time.Time           `json:"last_balance_at"`
+	TransactionCount map[string]int       `json:"transaction_count"`
+	Transactions     []BalanceTransaction `json:"transactions"`
+}
+
+// BalanceTransaction is a transaction as listed with the balance of an account.
+type BalanceTransaction struct {
+	ExternalID  string              `json:"external_id"`
+	Source      string              `json:"source"`
+	Operation   dao.TxOperationType `json:"operation"`
+	Amount      decimal.Decimal     `json:"amount"`
+	Currency    string              `json:"currency"`
+	PerformedAt string              `json:"performed_at"`
+}
+
+// Money returns the amount of the transaction in its currency.
+func (t BalanceTransaction) Money() money.Money {
+	return money.New(t.Amount, t.Currency)
+}
+
+// BalanceSnapshot is the balance of an account as it was stored at some point, one is taken on every update.
+type BalanceSnapshot struct {
+	SnapshotID int64         `json:"snapshot_id"`
+	AccountID  int64         `json:"account_id"`
+	CreatedAt  time.Time     `json:"created_at"`
+	Report     BalanceReport `json:"report"`
+}
+
 // AccountService abstracts business logic over DAO.
 type AccountService struct {
 	Database *sql.DB
+
+	// Rates converts the totals of other currencies into the reporting currency of the account, without it only
+	// the transactions in that currency are totaled.
+	Rates FXProvider
 }
 
-// FetchOrCreateAccount fetches an account by email, or creates a new one if none exists.
+// FetchOrCreateAccount fetches an account by email, or creates a new one if none exists. Accounts merged into
+// another one resolve to the account they were merged into, ErrAccountDeactivated is returned for other deactivated
+// accounts.
 func (s *AccountService) FetchOrCreateAccount(ctx context.Context, email, firstName, lastName string) (dao.Account, error) {
 	queries := dao.New(s.Database)
 	account, err := queries.GetAccountByEmail(ctx, email)
@@ -23,6 +106,7 @@ func (s *AccountService) FetchOrCreateAccount(ctx context.Context, email, firstN
 			FirstName: firstName,
 			LastName:  lastName,
 			Email:     email,
+			Locale:    DefaultLocale,
 			CreatedAt: sql.NullTime{Valid: true, Time: now},
 			UpdatedAt: sql.NullTime{Valid: true, Time: now},
 		}
@@ -31,6 +115,7 @@ func (s *AccountService) FetchOrCreateAccount(ctx context.Context, email, firstN
 			FirstName: account.FirstName,
 			LastName:  account.LastName,
 			Email:     account.Email,
+			Locale:    account.Locale,
 			CreatedAt: account.CreatedAt,
 			UpdatedAt: account.UpdatedAt,
 		})
@@ -38,16 +123,484 @@ func (s *AccountService) FetchOrCreateAccount(ctx context.Context, email, firstN
 		return account, err
 	}
 
+	return activeAccount(ctx, queries, account)
+}
+
+// ResolveAccount returns the account of a multi-account statement row by its email, creating it (without name) when
+// there is none yet, or by its account number, which must exist.
+func (s *AccountService) ResolveAccount(ctx context.Context, key string) (dao.Account, error) {
+	key = strings.TrimSpace(key)
+	if strings.Contains(key, "@") {
+		email, err := ParseEmail(key)
+		if err != nil {
+			return dao.Account{}, err
+		}
+		return s.FetchOrCreateAccount(ctx, email, "", "")
+	}
+
+	account, err := s.FindAccount(ctx, key)
+	if err != nil {
+		return account, err
+	}
+	return activeAccount(ctx, dao.New(s.Database), account)
+}
+
+// FindAccount returns an account by its email or account number, deactivated ones included. ErrUnknownAccount is
+// returned when there is none.
+func (s *AccountService) FindAccount(ctx context.Context, key string) (dao.Account, error) {
+	key = strings.TrimSpace(key)
+	queries := dao.New(s.Database)
+
+	var account dao.Account
+	var err error
+	if strings.Contains(key, "@") {
+		email, parseErr := ParseEmail(key)
+		if parseErr != nil {
+			return dao.Account{}, parseErr
+		}
+		account, err = queries.GetAccountByEmail(ctx, email)
+	} else {
+		accountID, parseErr := strconv.ParseInt(key, 10, 64)
+		if parseErr != nil || accountID <= 0 {
+			return dao.Account{}, fmt.Errorf("%w: %q", ErrUnknownAccount, key)
+		}
+		account, err = queries.GetAccount(ctx, accountID)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return dao.Account{}, fmt.Errorf("%w: %q", ErrUnknownAccount, key)
+	}
+	return account, err
+}
+
+// activeAccount follows merged accounts to the account that took them over.
+func activeAccount(ctx context.Context, queries *dao.Queries, account dao.Account) (dao.Account, error) {
+	for hops := 0; account.DeactivatedAt.Valid; hops++ {
+		if !account.MergedInto.Valid || hops == maxMergeHops {
+			return account, fmt.Errorf("%w: %d", ErrAccountDeactivated, account.AccountID)
+		}
+
+		var err error
+		if account, err = queries.GetAccount(ctx, account.MergedInto.Int64); err != nil {
+			return account, err
+		}
+	}
 	return account, nil
 }
 
-func (s *AccountService) UpdateAccountBalance(ctx context.Context, account dao.Account, report BalanceReport) (dao.Account, error) {
+// ParseEmail validates a bare email address, without display name.
+func ParseEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "", fmt.Errorf("%w: %q", ErrInvalidEmail, email)
+	}
+
+	return address.Address, nil
+}
+
+// SupportedLocales returns the locales emails and the balance page have messages for, sorted.
+func SupportedLocales() []string {
+	messages, err := loadMessages()
+	if err != nil {
+		return []string{DefaultLocale}
+	}
+
+	locales := make([]string, 0, len(messages))
+	for locale := range messages {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// ParseLocale validates a locale against the SupportedLocales.
+func ParseLocale(locale string) (string, error) {
+	locale = strings.TrimSpace(locale)
+	for _, supported := range SupportedLocales() {
+		if locale == supported {
+			return locale, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %q, use one of %s", ErrUnsupportedLocale, locale, strings.Join(SupportedLocales(), ", "))
+}
+
+// CreateAccount creates an account with a validated email and locale, a blank locale is the DefaultLocale.
+// ErrAccountExists is returned when the email is taken.
+func (s *AccountService) CreateAccount(ctx context.Context, email, firstName, lastName, locale string) (dao.Account, error) {
+	email, err := ParseEmail(email)
+	if err != nil {
+		return dao.Account{}, err
+	}
+	if locale == "" {
+		locale = DefaultLocale
+	} else if locale, err = ParseLocale(locale); err != nil {
+		return dao.Account{}, err
+	}
+
+	queries := dao.New(s.Database)
+	if err := checkEmailAvailable(ctx, queries, email); err != nil {
+		return dao.Account{}, err
+	}
+
+	now := sql.NullTime{Valid: true, Time: time.Now()}
+	return queries.CreateAccount(ctx, dao.CreateAccountParams{
+		FirstName: strings.TrimSpace(firstName),
+		LastName:  strings.TrimSpace(lastName),
+		Email:     email,
+		Locale:    locale,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+}
+
+// checkEmailAvailable returns ErrAccountExists when an account has the email.
+func checkEmailAvailable(ctx context.Context, queries *dao.Queries, email string) error {
+	existing, err := queries.GetAccountByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%w: %s is account %d", ErrAccountExists, email, existing.AccountID)
+}
+
+// ListAccounts returns up to limit accounts after the account afterID, ordered by account id, so the last one of a
+// page is where the next starts.
+func (s *AccountService) ListAccounts(ctx context.Context, afterID int64, limit int32, includeDeactivated bool) ([]dao.Account, error) {
+	return dao.New(s.Database).ListAccounts(ctx, dao.ListAccountsParams{
+		AfterID:            afterID,
+		IncludeDeactivated: includeDeactivated,
+		RowLimit:           limit,
+	})
+}
+
+// UpdateAccount changes the name, email or locale of an active account, validating them like CreateAccount.
+func (s *AccountService) UpdateAccount(ctx context.Context, account dao.Account, changes AccountChanges) (dao.Account, error) {
+	if account.DeactivatedAt.Valid {
+		return account, fmt.Errorf("%w: %d", ErrAccountDeactivated, account.AccountID)
+	}
+
+	queries := dao.New(s.Database)
+	params := dao.UpdateAccountParams{
+		FirstName: account.FirstName,
+		LastName:  account.LastName,
+		Email:     account.Email,
+		Locale:    account.Locale,
+		UpdatedAt: sql.NullTime{Valid: true, Time: time.Now()},
+		AccountID: account.AccountID,
+	}
+	if changes.FirstName != nil {
+		params.FirstName = strings.TrimSpace(*changes.FirstName)
+	}
+	if changes.LastName != nil {
+		params.LastName = strings.TrimSpace(*changes.LastName)
+	}
+	if changes.Email != nil {
+		email, err := ParseEmail(*changes.Email)
+		if err != nil {
+			return account, err
+		}
+		if email != account.Email {
+			if err := checkEmailAvailable(ctx, queries, email); err != nil {
+				return account, err
+			}
+		}
+		params.Email = email
+	}
+	if changes.Locale != nil {
+		locale, err := ParseLocale(*changes.Locale)
+		if err != nil {
+			return account, err
+		}
+		params.Locale = locale
+	}
+
+	return queries.UpdateAccount(ctx, params)
+}
+
+// DeactivateAccount deactivates an account, its transactions and balance are kept but nothing can be imported into
+// it anymore.
+func (s *AccountService) DeactivateAccount(ctx context.Context, account dao.Account) (dao.Account, error) {
+	if account.DeactivatedAt.Valid {
+		return account, fmt.Errorf("%w: %d", ErrAccountDeactivated, account.AccountID)
+	}
+
+	return dao.New(s.Database).DeactivateAccount(ctx, dao.DeactivateAccountParams{
+		DeactivatedAt: sql.NullTime{Valid: true, Time: time.Now()},
+		AccountID:     account.AccountID,
+	})
+}
+
+// MergeAccounts moves the transactions and imports of source into target, deactivates source as merged into target
+// and recalculates the balance of both, all in one transaction. Transactions target already has, by source and
+// external id, are dropped from source instead of moved. Imports into the email of source go to target afterwards.
+func (s *AccountService) MergeAccounts(ctx context.Context, source, target dao.Account) (dao.Account, BalanceReport, error) {
+	if source.AccountID == target.AccountID {
+		return target, BalanceReport{}, fmt.Errorf("can't merge account %d into itself", source.AccountID)
+	}
+	for _, account := range []dao.Account{source, target} {
+		if account.DeactivatedAt.Valid {
+			return target, BalanceReport{}, fmt.Errorf("%w: %d", ErrAccountDeactivated, account.AccountID)
+		}
+	}
+
+	var report BalanceReport
+	err := withTx(ctx, s.Database, func(tx *sql.Tx) error {
+		queries := dao.New(s.Database).WithTx(tx)
+		now := sql.NullTime{Valid: true, Time: time.Now()}
+
+		_, err := queries.MoveTransactions(ctx, dao.MoveTransactionsParams{TargetID: target.AccountID, UpdatedAt: now, SourceID: source.AccountID})
+		if err != nil {
+			return fmt.Errorf("error moving transactions: %w", err)
+		}
+		if _, err := queries.DeleteTransactionsByAccount(ctx, source.AccountID); err != nil {
+			return fmt.Errorf("error deleting duplicated transactions: %w", err)
+		}
+		_, err = queries.MoveImports(ctx, dao.MoveImportsParams{TargetID: target.AccountID, UpdatedAt: now, SourceID: source.AccountID})
+		if err != nil {
+			return fmt.Errorf("error moving imports: %w", err)
+		}
+
+		merged, err := queries.DeactivateAccount(ctx, dao.DeactivateAccountParams{
+			DeactivatedAt: now,
+			MergedInto:    sql.NullInt64{Valid: true, Int64: target.AccountID},
+			AccountID:     source.AccountID,
+		})
+		if err != nil {
+			return fmt.Errorf("error deactivating account %d: %w", source.AccountID, err)
+		}
+
+		// source is left without transactions, its balance goes to zero
+		if _, _, err := recalculateBalance(ctx, queries, s.Rates, merged); err != nil {
+			return err
+		}
+		target, report, err = recalculateBalance(ctx, queries, s.Rates, target)
+		return err
+	})
+	return target, report, err
+}
+
+// SetReportingCurrency changes the currency the balance of the account is reported in, a blank currency goes back to
+// the DefaultCurrency. The balance columns hold bare amounts in the reporting currency, so a stored balance is
+// recalculated in the new currency within the same transaction, it is never read in a currency it wasn't stored in.
+func (s *AccountService) SetReportingCurrency(ctx context.Context, account dao.Account, currency string) (dao.Account, error) {
+	reporting := sql.NullString{}
+	if currency != "" {
+		var err error
+		if reporting.String, err = ParseCurrency(currency); err != nil {
+			return account, err
+		}
+		reporting.Valid = true
+	}
+
+	err := withTx(ctx, s.Database, func(tx *sql.Tx) error {
+		queries := dao.New(s.Database).WithTx(tx)
+		updated, err := queries.UpdateAccountReportingCurrency(ctx, dao.UpdateAccountReportingCurrencyParams{
+			ReportingCurrency: reporting,
+			UpdatedAt:         sql.NullTime{Valid: true, Time: time.Now()},
+			AccountID:         account.AccountID,
+		})
+		if err != nil {
+			return err
+		}
+
+		if updated.TotalBalance.Valid && reportingCurrency(updated) != reportingCurrency(account) {
+			if updated, _, err = recalculateBalance(ctx, queries, s.Rates, updated); err != nil {
+				return err
+			}
+		}
+		account = updated
+		return nil
+	})
+	return account, err
+}
+
+// GetBalance loads the balance of an account from the accounts and transactions tables, sql.ErrNoRows is returned
+// for unknown accounts.
+func (s *AccountService) GetBalance(ctx context.Context, accountID int64) (AccountBalance, error) {
 	queries := dao.New(s.Database)
-	return queries.UpdateAccountBalance(ctx, dao.UpdateAccountBalanceParams{
-		LastBalanceAt:   sql.NullTime{Valid: true, Time: time.Now()},
-		TotalBalance:    sql.NullString{Valid: true, String: report.TotalBalance.String()},
-		AvgDebitAmount:  sql.NullString{Valid: true, String: report.AvgDebitAmount.String()},
-		AvgCreditAmount: sql.NullString{Valid: true, String: report.AvgCreditAmount.String()},
+	account, err := queries.GetAccount(ctx, accountID)
+	if err != nil {
+		return AccountBalance{}, err
+	}
+
+	currency := reportingCurrency(account)
+	balance := AccountBalance{
+		AccountID:        account.AccountID,
+		FirstName:        account.FirstName,
+		LastName:         account.LastName,
+		Locale:           account.Locale,
+		Currency:         currency,
+		TotalBalance:     nullMoney(account.TotalBalance, currency),
+		AvgDebitAmount:   nullMoney(account.AvgDebitAmount, currency),
+		AvgCreditAmount:  nullMoney(account.AvgCreditAmount, currency),
+		TransactionCount: make(map[string]int),
+		Transactions:     []BalanceTransaction{},
+	}
+	if account.LastBalanceAt.Valid {
+		balance.LastBalanceAt = &account.LastBalanceAt.Time
+	}
+
+	counts, err := queries.CountTransactionsByMonth(ctx, accountID)
+	if err != nil {
+		return AccountBalance{}, err
+	}
+	for _, count := range counts {
+		balance.TransactionCount[count.YearMonth] = int(count.Count)
+	}
+
+	transactions, err := queries.ListRecentTransactions(ctx, dao.ListRecentTransactionsParams{AccountID: accountID, Limit: RecentTransactionsLimit})
+	if err != nil {
+		return AccountBalance{}, err
+	}
+	balance.Transactions = append(balance.Transactions, balanceTransactions(transactions)...)
+
+	return balance, nil
+}
+
+// balanceTransactions converts stored transactions to the ones listed with balances and statements.
+func balanceTransactions(transactions []dao.Transaction) []BalanceTransaction {
+	items := make([]BalanceTransaction, 0, len(transactions))
+	for _, transaction := range transactions {
+		items = append(items, BalanceTransaction{
+			ExternalID:  transaction.ExternalID,
+			Source:      transaction.Source,
+			Operation:   transaction.Operation,
+			Amount:      transaction.Amount,
+			Currency:    transaction.Currency,
+			PerformedAt: transaction.PerformedAt.Format(time.DateOnly),
+		})
+	}
+	return items
+}
+
+// nullMoney reads a nullable balance column in the reporting currency, which is the currency updateAccountBalance
+// stores them in. Accounts without imports have no balance yet.
+func nullMoney(value decimal.NullDecimal, currency string) money.Money {
+	if !value.Valid {
+		return money.Zero(currency)
+	}
+
+	return money.New(value.Decimal, currency)
+}
+
+// RecalculateBalance aggregates every stored transaction of the account into a report and stores it as the
+// current balance of the account. Unlike the report of an import, which only covers the rows of one statement, it
+// covers the whole history of the account.
+func (s *AccountService) RecalculateBalance(ctx context.Context, account dao.Account) (dao.Account, BalanceReport, error) {
+	var report BalanceReport
+	err := withTx(ctx, s.Database, func(tx *sql.Tx) error {
+		var err error
+		account, report, err = recalculateBalance(ctx, dao.New(s.Database).WithTx(tx), s.Rates, account)
+		return err
+	})
+	return account, report, err
+}
+
+// recalculateBalance is shared with imports, atomic ones recalculate the balance within their own transaction so
+// it includes the rows just stored. Totals are converted into the reporting currency of the account with the rates
+// of today.
+func recalculateBalance(ctx context.Context, queries *dao.Queries, rates FXProvider, account dao.Account) (dao.Account, BalanceReport, error) {
+	totals, err := queries.GetAccountTotals(ctx, account.AccountID)
+	if err != nil {
+		return account, BalanceReport{}, fmt.Errorf("error aggregating transactions: %w", err)
+	}
+
+	report := BalanceReport{
+		AccountID:        account.AccountID,
+		Currency:         reportingCurrency(account),
+		Currencies:       make(map[string]CurrencyTotals),
+		TransactionCount: make(map[string]int),
+	}
+	for _, row := range totals {
+		report.Currencies[row.Currency] = CurrencyTotals{
+			TotalCredit: money.New(row.TotalCredit, row.Currency),
+			CountCredit: row.CountCredit,
+			TotalDebit:  money.New(row.TotalDebit, row.Currency),
+			CountDebit:  row.CountDebit,
+		}
+	}
+	report.summarize(ctx, rates, time.Now())
+
+	counts, err := queries.CountTransactionsByMonth(ctx, account.AccountID)
+	if err != nil {
+		return account, BalanceReport{}, fmt.Errorf("error counting transactions: %w", err)
+	}
+	for _, count := range counts {
+		report.TransactionCount[count.YearMonth] = int(count.Count)
+	}
+
+	account, err = updateAccountBalance(ctx, queries, account, report)
+	return account, report, err
+}
+
+// UpdateAccountBalance stores the totals and averages of a report as the current balance of the account, along with
+// a snapshot of the whole report.
+func (s *AccountService) UpdateAccountBalance(ctx context.Context, account dao.Account, report BalanceReport) (dao.Account, error) {
+	err := withTx(ctx, s.Database, func(tx *sql.Tx) error {
+		var err error
+		account, err = updateAccountBalance(ctx, dao.New(s.Database).WithTx(tx), account, report)
+		return err
+	})
+	return account, err
+}
+
+// updateAccountBalance is shared with imports, which update the balance within their own transaction. The snapshot
+// is taken in the same transaction so there is one for every balance the account had.
+func updateAccountBalance(ctx context.Context, queries *dao.Queries, account dao.Account, report BalanceReport) (dao.Account, error) {
+	// the columns only keep the amounts, which are read back in the reporting currency of the account
+	if currency := report.TotalBalance.Currency(); currency != "" && currency != reportingCurrency(account) {
+		return account, fmt.Errorf("balance in %s, account %d reports in %s", currency, account.AccountID, reportingCurrency(account))
+	}
+
+	now := time.Now()
+	account, err := queries.UpdateAccountBalance(ctx, dao.UpdateAccountBalanceParams{
+		LastBalanceAt:   sql.NullTime{Valid: true, Time: now},
+		TotalBalance:    decimal.NullDecimal{Valid: true, Decimal: report.TotalBalance.Amount()},
+		AvgDebitAmount:  decimal.NullDecimal{Valid: true, Decimal: report.AvgDebitAmount.Amount()},
+		AvgCreditAmount: decimal.NullDecimal{Valid: true, Decimal: report.AvgCreditAmount.Amount()},
 		AccountID:       account.AccountID,
 	})
+	if err != nil {
+		return account, err
+	}
+
+	jsonData, err := json.Marshal(report)
+	if err != nil {
+		return account, err
+	}
+	_, err = queries.CreateBalanceSnapshot(ctx, dao.CreateBalanceSnapshotParams{
+		AccountID:    account.AccountID,
+		TotalBalance: report.TotalBalance.Amount(),
+		Report:       jsonData,
+		CreatedAt:    now,
+	})
+	if err != nil {
+		return account, fmt.Errorf("error storing balance snapshot: %w", err)
+	}
+	return account, nil
+}
+
+// ListBalanceSnapshots returns the snapshots of an account taken from (inclusive) until (exclusive), oldest first.
+func (s *AccountService) ListBalanceSnapshots(ctx context.Context, accountID int64, from, until time.Time) ([]BalanceSnapshot, error) {
+	rows, err := dao.New(s.Database).ListBalanceSnapshots(ctx, dao.ListBalanceSnapshotsParams{
+		AccountID:    accountID,
+		CreatedFrom:  from,
+		CreatedUntil: until,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]BalanceSnapshot, 0, len(rows))
+	for _, row := range rows {
+		snapshot := BalanceSnapshot{SnapshotID: row.SnapshotID, AccountID: row.AccountID, CreatedAt: row.CreatedAt}
+		if err := json.Unmarshal(row.Report, &snapshot.Report); err != nil {
+			return nil, fmt.Errorf("error reading balance snapshot %d: %w", row.SnapshotID, err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
 }
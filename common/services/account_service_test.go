@@ -2,6 +2,7 @@ package services
 
 import (
 	"common/dao"
+	"common/money"
 	"context"
 	"database/sql"
 	"database/sql/driver"
@@ -29,9 +30,9 @@ func TestAccountService_FetchOrCreateAccount(t *testing.T) {
 		LastName:        "Doe",
 		Email:           "john.doe@example.com",
 		Locale:          "es-MX",
-		TotalBalance:    sql.NullString{},
-		AvgDebitAmount:  sql.NullString{},
-		AvgCreditAmount: sql.NullString{},
+		TotalBalance:    decimal.NullDecimal{},
+		AvgDebitAmount:  decimal.NullDecimal{},
+		AvgCreditAmount: decimal.NullDecimal{},
 		LastBalanceAt:   sql.NullTime{},
 		CreatedAt:       sql.NullTime{Valid: true, Time: now},
 		UpdatedAt:       sql.NullTime{Valid: true, Time: now},
@@ -49,6 +50,9 @@ func TestAccountService_FetchOrCreateAccount(t *testing.T) {
 		"last_balance_at",
 		"created_at",
 		"updated_at",
+		"reporting_currency",
+		"deactivated_at",
+		"merged_into",
 	}
 	accountRow := []driver.Value{
 		acc.AccountID,
@@ -62,6 +66,9 @@ func TestAccountService_FetchOrCreateAccount(t *testing.T) {
 		acc.LastBalanceAt,
 		acc.CreatedAt,
 		acc.UpdatedAt,
+		acc.ReportingCurrency,
+		acc.DeactivatedAt,
+		acc.MergedInto,
 	}
 
 	fetchAccountQuery := `SELECT 
@@ -75,7 +82,10 @@ func TestAccountService_FetchOrCreateAccount(t *testing.T) {
     	avg_credit_amount, 
     	last_balance_at, 
     	created_at, 
-    	updated_at 
+    	updated_at, 
+    	reporting_currency, 
+    	deactivated_at, 
+    	merged_into 
 	FROM accounts WHERE email = \$1 LIMIT 1`
 	insertAccountQuery := `INSERT INTO accounts`
 
@@ -90,7 +100,7 @@ func TestAccountService_FetchOrCreateAccount(t *testing.T) {
 
 	t.Run("NewAccount", func(t *testing.T) {
 		mock.ExpectQuery(fetchAccountQuery).WithArgs("john.doe@example.com").WillReturnRows(sqlmock.NewRows(accountColumns)) // Simulating account creation
-		mock.ExpectQuery(insertAccountQuery).WithArgs("John", "Doe", "john.doe@example.com", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		mock.ExpectQuery(insertAccountQuery).WithArgs("John", "Doe", "john.doe@example.com", "es-MX", sqlmock.AnyArg(), sqlmock.AnyArg()).
 			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(accountRow...))
 
 		res, err := accSrv.FetchOrCreateAccount(context.Background(), "john.doe@example.com", "John", "Doe")
@@ -110,6 +120,194 @@ func TestAccountService_FetchOrCreateAccount(t *testing.T) {
 	})
 }
 
+func TestAccountService_ResolveAccount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	accSrv := &AccountService{Database: db}
+	accountColumns := []string{"account_id", "first_name", "last_name", "email", "locale", "total_balance", "avg_debit_amount", "avg_credit_amount", "last_balance_at", "created_at", "updated_at", "reporting_currency", "deactivated_at", "merged_into"}
+
+	t.Run("Email", func(t *testing.T) {
+		mock.ExpectQuery(`FROM accounts WHERE email = \$1`).WithArgs("ana@example.com").
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(3, "Ana", "", "ana@example.com", "es-MX", nil, nil, nil, nil, nil, nil, nil, nil, nil))
+
+		account, err := accSrv.ResolveAccount(context.Background(), " ana@example.com ")
+		require.NoError(t, err)
+		require.Equal(t, int64(3), account.AccountID)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("AccountNumber", func(t *testing.T) {
+		mock.ExpectQuery(`FROM accounts WHERE account_id = \$1`).WithArgs(int64(3)).
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(3, "Ana", "", "ana@example.com", "es-MX", nil, nil, nil, nil, nil, nil, nil, nil, nil))
+		mock.ExpectQuery(`FROM accounts WHERE account_id = \$1`).WithArgs(int64(4)).WillReturnRows(sqlmock.NewRows(accountColumns))
+
+		account, err := accSrv.ResolveAccount(context.Background(), "3")
+		require.NoError(t, err)
+		require.Equal(t, "ana@example.com", account.Email)
+
+		_, err = accSrv.ResolveAccount(context.Background(), "4")
+		require.ErrorIs(t, err, ErrUnknownAccount)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := accSrv.ResolveAccount(context.Background(), "ana@")
+		require.ErrorIs(t, err, ErrInvalidEmail)
+
+		_, err = accSrv.ResolveAccount(context.Background(), "ACC-1")
+		require.ErrorIs(t, err, ErrUnknownAccount)
+	})
+}
+
+func TestAccountService_ResolveMergedAccount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	accSrv := &AccountService{Database: db}
+	accountColumns := []string{"account_id", "first_name", "last_name", "email", "locale", "total_balance", "avg_debit_amount", "avg_credit_amount", "last_balance_at", "created_at", "updated_at", "reporting_currency", "deactivated_at", "merged_into"}
+
+	t.Run("Merged", func(t *testing.T) {
+		mock.ExpectQuery(`FROM accounts WHERE email = \$1`).WithArgs("ana@example.com").
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(3, "Ana", "", "ana@example.com", "es-MX", nil, nil, nil, nil, nil, nil, nil, time.Now(), 5))
+		mock.ExpectQuery(`FROM accounts WHERE account_id = \$1`).WithArgs(int64(5)).
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(5, "Ana", "Díaz", "ana.diaz@example.com", "es-MX", nil, nil, nil, nil, nil, nil, nil, nil, nil))
+
+		account, err := accSrv.ResolveAccount(context.Background(), "ana@example.com")
+		require.NoError(t, err)
+		require.Equal(t, int64(5), account.AccountID)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Deactivated", func(t *testing.T) {
+		mock.ExpectQuery(`FROM accounts WHERE account_id = \$1`).WithArgs(int64(3)).
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(3, "Ana", "", "ana@example.com", "es-MX", nil, nil, nil, nil, nil, nil, nil, time.Now(), nil))
+
+		_, err := accSrv.ResolveAccount(context.Background(), "3")
+		require.ErrorIs(t, err, ErrAccountDeactivated)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestParseLocale(t *testing.T) {
+	require.Equal(t, []string{"en-US", "es-MX"}, SupportedLocales())
+
+	locale, err := ParseLocale(" en-US ")
+	require.NoError(t, err)
+	require.Equal(t, "en-US", locale)
+
+	_, err = ParseLocale("fr-FR")
+	require.ErrorIs(t, err, ErrUnsupportedLocale)
+	require.ErrorContains(t, err, "use one of en-US, es-MX")
+}
+
+func TestAccountService_CreateAccount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	accSrv := &AccountService{Database: db}
+
+	t.Run("Create", func(t *testing.T) {
+		mock.ExpectQuery(`FROM accounts WHERE email = \$1`).WithArgs("ana@example.com").WillReturnRows(sqlmock.NewRows(accountColumns))
+		mock.ExpectQuery(`INSERT INTO accounts`).WithArgs("Ana", "Díaz", "ana@example.com", "en-US", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(7, "Ana", "Díaz", "ana@example.com", "en-US", nil, nil, nil, nil, time.Now(), time.Now(), nil, nil, nil))
+
+		account, err := accSrv.CreateAccount(context.Background(), " ana@example.com", " Ana", "Díaz", "en-US")
+		require.NoError(t, err)
+		require.Equal(t, int64(7), account.AccountID)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Exists", func(t *testing.T) {
+		mock.ExpectQuery(`FROM accounts WHERE email = \$1`).WithArgs("ana@example.com").
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(7, "Ana", "Díaz", "ana@example.com", "en-US", nil, nil, nil, nil, nil, nil, nil, nil, nil))
+
+		_, err := accSrv.CreateAccount(context.Background(), "ana@example.com", "Ana", "Díaz", "")
+		require.ErrorIs(t, err, ErrAccountExists)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := accSrv.CreateAccount(context.Background(), "Ana <ana@example.com>", "Ana", "Díaz", "")
+		require.ErrorIs(t, err, ErrInvalidEmail)
+
+		_, err = accSrv.CreateAccount(context.Background(), "ana@example.com", "Ana", "Díaz", "pt-BR")
+		require.ErrorIs(t, err, ErrUnsupportedLocale)
+	})
+}
+
+func TestAccountService_UpdateAccount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	accSrv := &AccountService{Database: db}
+	account := dao.Account{AccountID: 7, FirstName: "Ana", LastName: "Díaz", Email: "ana@example.com", Locale: "es-MX"}
+
+	t.Run("Partial", func(t *testing.T) {
+		email, locale := "ana.diaz@example.com", "en-US"
+		mock.ExpectQuery(`FROM accounts WHERE email = \$1`).WithArgs(email).WillReturnRows(sqlmock.NewRows(accountColumns))
+		mock.ExpectQuery(`UPDATE accounts\s+SET first_name`).WithArgs("Ana", "Díaz", email, locale, sqlmock.AnyArg(), int64(7)).
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(7, "Ana", "Díaz", email, locale, nil, nil, nil, nil, nil, time.Now(), nil, nil, nil))
+
+		updated, err := accSrv.UpdateAccount(context.Background(), account, AccountChanges{Email: &email, Locale: &locale})
+		require.NoError(t, err)
+		require.Equal(t, "en-US", updated.Locale)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		locale := "es"
+		_, err := accSrv.UpdateAccount(context.Background(), account, AccountChanges{Locale: &locale})
+		require.ErrorIs(t, err, ErrUnsupportedLocale)
+
+		deactivated := account
+		deactivated.DeactivatedAt = sql.NullTime{Valid: true, Time: time.Now()}
+		_, err = accSrv.UpdateAccount(context.Background(), deactivated, AccountChanges{})
+		require.ErrorIs(t, err, ErrAccountDeactivated)
+	})
+}
+
+func TestAccountService_MergeAccounts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	accSrv := &AccountService{Database: db}
+	source := dao.Account{AccountID: 3, Email: "ana@example.com"}
+	target := dao.Account{AccountID: 5, Email: "ana.diaz@example.com"}
+
+	t.Run("Merge", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE transactions`).WithArgs(int64(5), sqlmock.AnyArg(), int64(3)).WillReturnResult(sqlmock.NewResult(0, 4))
+		mock.ExpectExec(`DELETE FROM transactions`).WithArgs(int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE imports`).WithArgs(int64(5), sqlmock.AnyArg(), int64(3)).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectQuery(`UPDATE accounts\s+SET deactivated_at`).WithArgs(sqlmock.AnyArg(), int64(5), int64(3)).
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(3, "", "", "ana@example.com", "es-MX", nil, nil, nil, nil, nil, nil, nil, time.Now(), 5))
+		for _, accountID := range []int64{3, 5} {
+			totals := sqlmock.NewRows(totalsColumns)
+			if accountID == 5 {
+				totals.AddRow("MXN", "100.00", 1, "40.00", 2)
+			}
+			mock.ExpectQuery(`FROM transactions\s+WHERE account_id = \$1`).WithArgs(accountID).WillReturnRows(totals)
+			mock.ExpectQuery(`SELECT to_char`).WithArgs(accountID).WillReturnRows(sqlmock.NewRows([]string{"year_month", "count"}))
+			mock.ExpectQuery(`UPDATE accounts\s+SET last_balance_at`).
+				WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(accountID, "", "", "", "es-MX", "0", "0", "0", time.Now(), nil, nil, nil, nil, nil))
+			mock.ExpectQuery(`INSERT INTO balance_snapshots`).WillReturnRows(sqlmock.NewRows(snapshotColumns).AddRow(accountID, accountID, "0", []byte("{}"), time.Now()))
+		}
+		mock.ExpectCommit()
+
+		merged, report, err := accSrv.MergeAccounts(context.Background(), source, target)
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, int64(5), merged.AccountID)
+		require.Equal(t, "60.00 MXN", report.TotalBalance.String())
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, _, err := accSrv.MergeAccounts(context.Background(), source, source)
+		require.ErrorContains(t, err, "can't merge account 3 into itself")
+
+		deactivated := target
+		deactivated.DeactivatedAt = sql.NullTime{Valid: true, Time: time.Now()}
+		_, _, err = accSrv.MergeAccounts(context.Background(), source, deactivated)
+		require.ErrorIs(t, err, ErrAccountDeactivated)
+	})
+}
+
 func TestAccountService_UpdateAccountBalance(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
@@ -126,9 +324,9 @@ func TestAccountService_UpdateAccountBalance(t *testing.T) {
 		LastName:        "Doe",
 		Email:           "john.doe@example.com",
 		Locale:          "es-MX",
-		TotalBalance:    sql.NullString{},
-		AvgDebitAmount:  sql.NullString{},
-		AvgCreditAmount: sql.NullString{},
+		TotalBalance:    decimal.NullDecimal{},
+		AvgDebitAmount:  decimal.NullDecimal{},
+		AvgCreditAmount: decimal.NullDecimal{},
 		LastBalanceAt:   sql.NullTime{},
 		CreatedAt:       sql.NullTime{Valid: true, Time: now},
 		UpdatedAt:       sql.NullTime{Valid: true, Time: now},
@@ -136,14 +334,14 @@ func TestAccountService_UpdateAccountBalance(t *testing.T) {
 
 	report := BalanceReport{
 		AccountID:        1,
-		TotalCredit:      decimal.Decimal{},
+		TotalCredit:      money.Zero("MXN"),
 		CountCredit:      1,
-		TotalDebit:       decimal.Decimal{},
+		TotalDebit:       money.Zero("MXN"),
 		CountDebit:       1,
-		TotalBalance:     decimal.NewFromFloat32(10.0),
-		AvgDebitAmount:   decimal.NewFromFloat32(11.1),
-		AvgCreditAmount:  decimal.NewFromFloat32(12.2),
-		TransactionCount: map[int]int{1: 1},
+		TotalBalance:     money.New(decimal.RequireFromString("10"), "MXN"),
+		AvgDebitAmount:   money.New(decimal.RequireFromString("11.1"), "MXN"),
+		AvgCreditAmount:  money.New(decimal.RequireFromString("12.2"), "MXN"),
+		TransactionCount: map[string]int{"2024-01": 1},
 	}
 
 	accountRow := []driver.Value{
@@ -158,6 +356,9 @@ func TestAccountService_UpdateAccountBalance(t *testing.T) {
 		acc.LastBalanceAt,
 		acc.CreatedAt,
 		acc.UpdatedAt,
+		acc.ReportingCurrency,
+		acc.DeactivatedAt,
+		acc.MergedInto,
 	}
 
 	updateAccountBalanceQuery := `
@@ -189,17 +390,24 @@ func TestAccountService_UpdateAccountBalance(t *testing.T) {
 		"last_balance_at",
 		"created_at",
 		"updated_at",
+		"reporting_currency",
+		"deactivated_at",
+		"merged_into",
 	}
 
 	t.Run("UpdateBalance", func(t *testing.T) {
 		args := []driver.Value{
 			sqlmock.AnyArg(),
-			report.TotalBalance,
-			report.AvgDebitAmount,
-			report.AvgCreditAmount,
+			report.TotalBalance.Amount(),
+			report.AvgDebitAmount.Amount(),
+			report.AvgCreditAmount.Amount(),
 			report.AccountID,
 		}
+		mock.ExpectBegin()
 		mock.ExpectQuery(updateAccountBalanceQuery).WithArgs(args...).WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(accountRow...))
+		mock.ExpectQuery(`INSERT INTO balance_snapshots`).WithArgs(report.AccountID, report.TotalBalance.Amount(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(snapshotColumns).AddRow(1, 1, "10", []byte("{}"), now))
+		mock.ExpectCommit()
 		res, err := accSrv.UpdateAccountBalance(context.Background(), acc, report)
 
 		require.NoError(t, err)
@@ -210,12 +418,14 @@ func TestAccountService_UpdateAccountBalance(t *testing.T) {
 	t.Run("DBError", func(t *testing.T) {
 		args := []driver.Value{
 			sqlmock.AnyArg(),
-			report.TotalBalance,
-			report.AvgDebitAmount,
-			report.AvgCreditAmount,
+			report.TotalBalance.Amount(),
+			report.AvgDebitAmount.Amount(),
+			report.AvgCreditAmount.Amount(),
 			report.AccountID,
 		}
+		mock.ExpectBegin()
 		mock.ExpectQuery(updateAccountBalanceQuery).WithArgs(args...).WillReturnError(errors.New("db_error"))
+		mock.ExpectRollback()
 
 		_, err := accSrv.UpdateAccountBalance(context.Background(), acc, report)
 		require.Error(t, err)
@@ -223,3 +433,229 @@ func TestAccountService_UpdateAccountBalance(t *testing.T) {
 		require.NoError(t, mock.ExpectationsWereMet())
 	})
 }
+
+func TestAccountService_RecalculateBalance(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	accSrv := &AccountService{
+		Database: db,
+	}
+
+	account := dao.Account{AccountID: 1}
+	accountColumns := []string{"account_id", "first_name", "last_name", "email", "locale", "total_balance", "avg_debit_amount", "avg_credit_amount", "last_balance_at", "created_at", "updated_at", "reporting_currency", "deactivated_at", "merged_into"}
+
+	t.Run("Recalculate", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM transactions\s+WHERE account_id = \$1`).WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows(totalsColumns).AddRow("MXN", "300.00", 3, "50.00", 2))
+		mock.ExpectQuery(`SELECT to_char`).WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"year_month", "count"}).AddRow("2024-12", 2).AddRow("2025-01", 3))
+		mock.ExpectQuery(`UPDATE accounts`).WithArgs(sqlmock.AnyArg(), "250", "25", "100", int64(1)).
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(1, "John", "Doe", "john.doe@example.com", "es-MX", "250.00", "25.00", "100.00", time.Now(), nil, nil, nil, nil, nil))
+		mock.ExpectQuery(`INSERT INTO balance_snapshots`).WithArgs(int64(1), decimal.RequireFromString("250"), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(snapshotColumns).AddRow(1, 1, "250.00", []byte("{}"), time.Now()))
+		mock.ExpectCommit()
+
+		updated, report, err := accSrv.RecalculateBalance(context.Background(), account)
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, "250.00", updated.TotalBalance.Decimal.StringFixed(2))
+		require.Equal(t, int64(3), report.CountCredit)
+		require.Equal(t, int64(2), report.CountDebit)
+		require.Equal(t, "250.00 MXN", report.TotalBalance.String())
+		require.Equal(t, map[string]int{"2024-12": 2, "2025-01": 3}, report.TransactionCount)
+	})
+
+	t.Run("Converted", func(t *testing.T) {
+		// the rate the dollars were converted with is kept in the report stored with the snapshot
+		at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		converting := &AccountService{Database: db, Rates: &FileRates{Rates: []FXRate{
+			{Base: "USD", Quote: "MXN", Rate: decimal.RequireFromString("17.5"), EffectiveAt: at},
+		}}}
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM transactions\s+WHERE account_id = \$1`).WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows(totalsColumns).AddRow("MXN", "100.00", 1, "0", 0).AddRow("USD", "10.00", 1, "0", 0))
+		mock.ExpectQuery(`SELECT to_char`).WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"year_month", "count"}))
+		mock.ExpectQuery(`UPDATE accounts`).WithArgs(sqlmock.AnyArg(), "275", "0", "137.5", int64(1)).
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(1, "John", "Doe", "john.doe@example.com", "es-MX", "275.00", "0", "137.50", time.Now(), nil, nil, nil, nil, nil))
+		mock.ExpectQuery(`INSERT INTO balance_snapshots`).WithArgs(int64(1), decimal.RequireFromString("275"), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(snapshotColumns).AddRow(3, 1, "275.00", []byte("{}"), time.Now()))
+		mock.ExpectCommit()
+
+		_, report, err := converting.RecalculateBalance(context.Background(), account)
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, "275.00 MXN", report.TotalBalance.String())
+		require.Len(t, report.Rates, 1)
+		require.Equal(t, "17.5", report.Rates["USD"].Rate.String())
+		require.False(t, report.Rates["USD"].At.Before(at))
+	})
+
+	t.Run("NoTransactions", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM transactions\s+WHERE account_id = \$1`).WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows(totalsColumns).AddRow("MXN", "0", 0, "0", 0))
+		mock.ExpectQuery(`SELECT to_char`).WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"year_month", "count"}))
+		mock.ExpectQuery(`UPDATE accounts`).WithArgs(sqlmock.AnyArg(), "0", "0", "0", int64(1)).
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(1, "John", "Doe", "john.doe@example.com", "es-MX", "0.00", "0.00", "0.00", time.Now(), nil, nil, nil, nil, nil))
+		mock.ExpectQuery(`INSERT INTO balance_snapshots`).WillReturnRows(sqlmock.NewRows(snapshotColumns).AddRow(2, 1, "0.00", []byte("{}"), time.Now()))
+		mock.ExpectCommit()
+
+		_, report, err := accSrv.RecalculateBalance(context.Background(), account)
+		require.NoError(t, err)
+		require.True(t, report.AvgCreditAmount.IsZero())
+		require.True(t, report.AvgDebitAmount.IsZero())
+	})
+
+	t.Run("DBError", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM transactions`).WithArgs(int64(1)).WillReturnError(errors.New("db_error"))
+		mock.ExpectRollback()
+
+		_, _, err := accSrv.RecalculateBalance(context.Background(), account)
+		require.ErrorContains(t, err, "db_error")
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestAccountService_SetReportingCurrency(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	service := &AccountService{Database: db}
+	account := dao.Account{AccountID: 1, TotalBalance: decimal.NullDecimal{Valid: true, Decimal: decimal.RequireFromString("250")}}
+
+	t.Run("Recalculated", func(t *testing.T) {
+		// the stored balance is in MXN, it is recalculated in USD along with the change
+		mock.ExpectBegin()
+		mock.ExpectQuery(`UPDATE accounts\s+SET reporting_currency = \$1`).WithArgs(sql.NullString{Valid: true, String: "USD"}, sqlmock.AnyArg(), int64(1)).
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(1, "John", "Doe", "john.doe@example.com", "es-MX", "250.00", "0", "250.00", time.Now(), nil, nil, "USD", nil, nil))
+		mock.ExpectQuery(`FROM transactions\s+WHERE account_id = \$1`).WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows(totalsColumns).AddRow("USD", "20.00", 1, "0", 0))
+		mock.ExpectQuery(`SELECT to_char`).WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"year_month", "count"}))
+		mock.ExpectQuery(`UPDATE accounts\s+SET last_balance_at`).WithArgs(sqlmock.AnyArg(), "20", "0", "20", int64(1)).
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(1, "John", "Doe", "john.doe@example.com", "es-MX", "20.00", "0", "20.00", time.Now(), nil, nil, "USD", nil, nil))
+		mock.ExpectQuery(`INSERT INTO balance_snapshots`).WillReturnRows(sqlmock.NewRows(snapshotColumns).AddRow(1, 1, "20.00", []byte("{}"), time.Now()))
+		mock.ExpectCommit()
+
+		updated, err := service.SetReportingCurrency(context.Background(), account, "usd")
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, "20.00", updated.TotalBalance.Decimal.StringFixed(2))
+	})
+
+	t.Run("NoBalance", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`UPDATE accounts\s+SET reporting_currency = \$1`).
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(2, "Jane", "Doe", "jane.doe@example.com", "es-MX", nil, nil, nil, nil, nil, nil, "USD", nil, nil))
+		mock.ExpectCommit()
+
+		updated, err := service.SetReportingCurrency(context.Background(), dao.Account{AccountID: 2}, "USD")
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, "USD", updated.ReportingCurrency.String)
+	})
+
+	t.Run("OtherCurrency", func(t *testing.T) {
+		// balances are only stored in the reporting currency of the account
+		report := BalanceReport{TotalBalance: money.New(decimal.RequireFromString("10"), "USD")}
+		_, err := updateAccountBalance(context.Background(), dao.New(db), account, report)
+		require.EqualError(t, err, "balance in USD, account 1 reports in MXN")
+	})
+}
+
+func TestAccountService_ListBalanceSnapshots(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	accSrv := &AccountService{
+		Database: db,
+	}
+
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Snapshots", func(t *testing.T) {
+		mock.ExpectQuery(`FROM balance_snapshots`).WithArgs(int64(1), from, until).
+			WillReturnRows(sqlmock.NewRows(snapshotColumns).
+				AddRow(1, 1, "100.00", []byte(`{"account_id":1,"total_balance":"100","count_credit":1,"transaction_count":{"2024-12":1}}`), from.Add(time.Hour)).
+				AddRow(2, 1, "150.00", []byte(`{"account_id":1,"total_balance":"150","count_credit":2,"transaction_count":{"2024-12":1,"2025-01":1}}`), from.Add(48*time.Hour)))
+
+		snapshots, err := accSrv.ListBalanceSnapshots(context.Background(), 1, from, until)
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Len(t, snapshots, 2)
+		require.Equal(t, int64(2), snapshots[1].SnapshotID)
+		require.Equal(t, from.Add(48*time.Hour), snapshots[1].CreatedAt)
+		require.Equal(t, "150.00", snapshots[1].Report.TotalBalance.String())
+		require.Equal(t, map[string]int{"2024-12": 1, "2025-01": 1}, snapshots[1].Report.TransactionCount)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		mock.ExpectQuery(`FROM balance_snapshots`).WithArgs(int64(2), from, until).WillReturnRows(sqlmock.NewRows(snapshotColumns))
+
+		snapshots, err := accSrv.ListBalanceSnapshots(context.Background(), 2, from, until)
+		require.NoError(t, err)
+		require.Empty(t, snapshots)
+	})
+
+	t.Run("InvalidReport", func(t *testing.T) {
+		mock.ExpectQuery(`FROM balance_snapshots`).WithArgs(int64(1), from, until).
+			WillReturnRows(sqlmock.NewRows(snapshotColumns).AddRow(3, 1, "100.00", []byte(`not json`), from))
+
+		_, err := accSrv.ListBalanceSnapshots(context.Background(), 1, from, until)
+		require.ErrorContains(t, err, "error reading balance snapshot 3")
+	})
+}
+
+func TestAccountService_GetBalance(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	accSrv := &AccountService{
+		Database: db,
+	}
+
+	lastBalanceAt := time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)
+	accountColumns := []string{"account_id", "first_name", "last_name", "email", "locale", "total_balance", "avg_debit_amount", "avg_credit_amount", "last_balance_at", "created_at", "updated_at", "reporting_currency", "deactivated_at", "merged_into"}
+
+	t.Run("Balance", func(t *testing.T) {
+		mock.ExpectQuery(`FROM accounts WHERE account_id = \$1`).WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(1, "John", "Doe", "john.doe@example.com", "en-US", "10.50", "2.00", "12.50", lastBalanceAt, nil, nil, nil, nil, nil))
+		mock.ExpectQuery(`SELECT to_char\(performed_at, 'YYYY-MM'\)`).WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"year_month", "count"}).AddRow("2024-12", 1).AddRow("2025-01", 1))
+		mock.ExpectQuery(`FROM transactions`).WithArgs(int64(1), RecentTransactionsLimit).
+			WillReturnRows(sqlmock.NewRows(transactionColumns).
+				AddRow(2, 1, "debit", "2.00", "MXN", time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), nil, nil, nil, "2", "statement.csv").
+				AddRow(1, 1, "credit", "12.50", "MXN", time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC), nil, nil, nil, "1", "statement.csv"))
+
+		balance, err := accSrv.GetBalance(context.Background(), 1)
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, "10.50 MXN", balance.TotalBalance.String())
+		require.Equal(t, lastBalanceAt, *balance.LastBalanceAt)
+		require.Equal(t, map[string]int{"2024-12": 1, "2025-01": 1}, balance.TransactionCount)
+		require.Len(t, balance.Transactions, 2)
+		require.Equal(t, BalanceTransaction{ExternalID: "2", Source: "statement.csv", Operation: dao.TxOperationTypeDebit, Amount: decimal.RequireFromString("2.00"), Currency: "MXN", PerformedAt: "2025-01-02"}, balance.Transactions[0])
+	})
+
+	t.Run("NoImportsYet", func(t *testing.T) {
+		mock.ExpectQuery(`FROM accounts WHERE account_id = \$1`).WithArgs(int64(2)).
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(2, "Jane", "Doe", "jane.doe@example.com", "es-MX", nil, nil, nil, nil, nil, nil, nil, nil, nil))
+		mock.ExpectQuery(`SELECT to_char`).WithArgs(int64(2)).WillReturnRows(sqlmock.NewRows([]string{"year_month", "count"}))
+		mock.ExpectQuery(`FROM transactions`).WithArgs(int64(2), RecentTransactionsLimit).WillReturnRows(sqlmock.NewRows(transactionColumns))
+
+		balance, err := accSrv.GetBalance(context.Background(), 2)
+		require.NoError(t, err)
+		require.True(t, balance.TotalBalance.IsZero())
+		require.Nil(t, balance.LastBalanceAt)
+		require.Empty(t, balance.Transactions)
+	})
+
+	t.Run("UnknownAccount", func(t *testing.T) {
+		mock.ExpectQuery(`FROM accounts WHERE account_id = \$1`).WithArgs(int64(3)).WillReturnRows(sqlmock.NewRows(accountColumns))
+
+		_, err := accSrv.GetBalance(context.Background(), 3)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+}
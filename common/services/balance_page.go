@@ -0,0 +1,86 @@
+package services
+
+import (
+	"html/template"
+	"io"
+)
+
+// DefaultLocale is the locale of pages rendered without an account, like invalid links.
+const DefaultLocale = "es-MX"
+
+var balanceTemplate = template.Must(template.New("balance.html").Funcs(templateFuncs).ParseFS(content, "static/balance/balance.html"))
+
+// BalancePageData represents the data required to render the balance page of an account.
+type BalancePageData struct {
+	Lang                   string
+	InvalidLink            bool
+	TitleMsg               string
+	LastBalanceAtMsg       string
+	TotalBalanceMsg        string
+	AvgCreditAmountMsg     string
+	AvgDebitAmountMsg      string
+	TransactionsInMonthMsg string
+	RecentTransactionsMsg  string
+	DateMsg                string
+	OperationMsg           string
+	AmountMsg              string
+	CreditMsg              string
+	DebitMsg               string
+	NoTransactionsMsg      string
+	InvalidLinkMsg         string
+	Locale                 map[string]string
+	Balance                AccountBalance
+}
+
+// BalancePage renders the balance page with the same messages as the balance email.
+type BalancePage struct {
+	Messages map[string]map[string]string
+}
+
+// LoadMessages loads localization messages
+func (p *BalancePage) LoadMessages() error {
+	messages, err := loadMessages()
+	p.Messages = messages
+	return err
+}
+
+// Render writes the balance page of an account, in the locale of the account.
+func (p *BalancePage) Render(w io.Writer, balance AccountBalance) error {
+	data := p.data(balance.Locale)
+	data.Balance = balance
+	return balanceTemplate.Execute(w, data)
+}
+
+// RenderInvalidLink writes the page shown for invalid or expired links.
+func (p *BalancePage) RenderInvalidLink(w io.Writer) error {
+	data := p.data(DefaultLocale)
+	data.InvalidLink = true
+	return balanceTemplate.Execute(w, data)
+}
+
+func (p *BalancePage) data(locale string) BalancePageData {
+	loc, ok := p.Messages[locale]
+	if !ok {
+		locale = DefaultLocale
+		loc = p.Messages[locale]
+	}
+
+	return BalancePageData{
+		Lang:                   locale,
+		Locale:                 loc,
+		TitleMsg:               loc["balance_page.title"],
+		LastBalanceAtMsg:       loc["balance_page.last_balance_at"],
+		TotalBalanceMsg:        loc["balance_email.total_balance"],
+		AvgCreditAmountMsg:     loc["balance_email.avg_credit_amount"],
+		AvgDebitAmountMsg:      loc["balance_email.avg_debit_amount"],
+		TransactionsInMonthMsg: loc["balance_email.transactions_in_month"],
+		RecentTransactionsMsg:  loc["balance_page.recent_transactions"],
+		DateMsg:                loc["balance_page.date"],
+		OperationMsg:           loc["balance_page.operation"],
+		AmountMsg:              loc["balance_page.amount"],
+		CreditMsg:              loc["balance_page.credit"],
+		DebitMsg:               loc["balance_page.debit"],
+		NoTransactionsMsg:      loc["balance_page.no_transactions"],
+		InvalidLinkMsg:         loc["balance_page.invalid_link"],
+	}
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"bytes"
+	"common/dao"
+	"common/money"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBalancePage_Render(t *testing.T) {
+	page := &BalancePage{}
+	require.NoError(t, page.LoadMessages())
+
+	var buffer bytes.Buffer
+	err := page.Render(&buffer, AccountBalance{
+		AccountID:        1,
+		FirstName:        "Ada",
+		LastName:         "Lovelace",
+		Locale:           "en-US",
+		Currency:         "USD",
+		TotalBalance:     money.New(decimal.RequireFromString("39.74"), "USD"),
+		TransactionCount: map[string]int{"2024-07": 2},
+		Transactions: []BalanceTransaction{
+			{Operation: dao.TxOperationTypeCredit, Amount: decimal.RequireFromString("60.5"), Currency: "USD", PerformedAt: "2024-07-15"},
+			{Operation: dao.TxOperationTypeDebit, Amount: decimal.RequireFromString("10.3"), Currency: "USD", PerformedAt: "2024-07-28"},
+		},
+	})
+	require.NoError(t, err)
+	html := buffer.String()
+	require.Contains(t, html, `<html lang="en-US">`)
+	require.Contains(t, html, "Ada Lovelace")
+	require.Contains(t, html, "$39.74")
+	require.Contains(t, html, "Number of transactions in July 2024: 2")
+	require.Contains(t, html, "<td>Credit</td>")
+	require.Contains(t, html, "-$10.30")
+
+	buffer.Reset()
+	require.NoError(t, page.Render(&buffer, AccountBalance{Locale: "es-MX"}))
+	require.Contains(t, buffer.String(), "Aún no hay transacciones.")
+}
+
+func TestBalancePage_RenderInvalidLink(t *testing.T) {
+	page := &BalancePage{}
+	require.NoError(t, page.LoadMessages())
+
+	var buffer bytes.Buffer
+	require.NoError(t, page.RenderInvalidLink(&buffer))
+	require.Contains(t, buffer.String(), "Este enlace no es válido")
+	require.NotContains(t, buffer.String(), "<table>")
+}
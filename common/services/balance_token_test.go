@@ -0,0 +1,40 @@
+package services
+
+import (
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBalanceTokens(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tokens := &BalanceTokens{Secret: []byte("secret"), TTL: time.Hour, Now: func() time.Time { return now }}
+
+	token, err := tokens.Sign(42)
+	require.NoError(t, err)
+	require.NotContains(t, token, "42")
+
+	accountID, err := tokens.Verify(token)
+	require.NoError(t, err)
+	require.Equal(t, int64(42), accountID)
+
+	// another secret, a tampered payload and garbage are all invalid
+	_, err = (&BalanceTokens{Secret: []byte("other")}).Verify(token)
+	require.ErrorIs(t, err, ErrInvalidToken)
+	forged, err := (&BalanceTokens{Secret: []byte("other"), Now: tokens.Now}).Sign(43)
+	require.NoError(t, err)
+	payload, _, _ := strings.Cut(forged, ".")
+	_, signature, _ := strings.Cut(token, ".")
+	_, err = tokens.Verify(payload + "." + signature)
+	require.ErrorIs(t, err, ErrInvalidToken)
+	_, err = tokens.Verify("42")
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	now = now.Add(2 * time.Hour)
+	_, err = tokens.Verify(token)
+	require.ErrorIs(t, err, ErrExpiredToken)
+
+	_, err = (&BalanceTokens{}).Sign(42)
+	require.Error(t, err)
+}
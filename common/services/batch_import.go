@@ -0,0 +1,188 @@
+package services
+
+import (
+	"common/dao"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AccountResolver finds the account of each row of multi-account statements, AccountService resolves them by email
+// or account number.
+type AccountResolver interface {
+	ResolveAccount(ctx context.Context, key string) (dao.Account, error)
+}
+
+// AccountReport is the statement of one account within a multi-account file.
+type AccountReport struct {
+	// Key is the email or account number of the account as it is in the file.
+	Key     string      `json:"key"`
+	Account dao.Account `json:"-"`
+
+	// ImportID is the import recorded for the account by ImportService.ImportBatch, its rows are linked to it.
+	ImportID int64         `json:"import_id,omitempty"`
+	Report   BalanceReport `json:"report"`
+}
+
+// BatchReport is the outcome of importing a multi-account statement: the statement of each account with stored
+// rows, ordered by account id, and a summary of the whole file with its totals in the DefaultCurrency. Duplicates and
+// rejections are only counted in the summary.
+type BatchReport struct {
+	Accounts []AccountReport `json:"accounts"`
+	Summary  BalanceReport   `json:"summary"`
+}
+
+// ReportKeys returns the idempotency keys of the report emails queued for the accounts of the batch, for those with
+// a recorded import.
+func (b BatchReport) ReportKeys() []string {
+	keys := make([]string, 0, len(b.Accounts))
+	for _, account := range b.Accounts {
+		if account.ImportID != 0 {
+			keys = append(keys, ImportReportKey(account.ImportID, account.Report.AccountID))
+		}
+	}
+	return keys
+}
+
+// ImportBatch imports a statement whose rows belong to different accounts, routing each row to the account of its
+// account column and recalculating the balance of every account. In atomic mode the rows and the balances of all
+// accounts are committed together, or not at all. Accounts are resolved (and created) as rows are read, outside the
+// import, so they are kept even when an atomic import rolls back.
+func (s *TransactionService) ImportBatch(ctx context.Context, resolver AccountResolver, parser StatementParser) (BatchReport, []Rejection, error) {
+	router := &routingParser{ctx: ctx, parser: parser, resolver: resolver, imports: s.imports, accounts: make(map[string]dao.Account), failed: make(map[string]error)}
+	if s.Mode != ImportModeAtomic {
+		summary, rejections, err := s.processFile(ctx, s.Database, nil, 0, DefaultCurrency, router)
+		batch := s.splitBatch(ctx, summary, router)
+		if err != nil {
+			return batch, rejections, err
+		}
+
+		for i := range batch.Accounts {
+			err := withTx(ctx, s.Database, func(tx *sql.Tx) error {
+				return s.recalculateBatchAccount(ctx, dao.New(s.Database).WithTx(tx), &batch.Accounts[i])
+			})
+			if err != nil {
+				return batch, rejections, err
+			}
+		}
+		return batch, rejections, nil
+	}
+
+	var batch BatchReport
+	var rejections []Rejection
+	err := withTx(ctx, s.Database, func(tx *sql.Tx) error {
+		summary, rowRejections, err := s.processFile(ctx, tx, &sync.Mutex{}, 0, DefaultCurrency, router)
+		batch, rejections = s.splitBatch(ctx, summary, router), rowRejections
+		if err != nil {
+			return err
+		}
+
+		queries := dao.New(s.Database).WithTx(tx)
+		for i := range batch.Accounts {
+			if err := s.recalculateBatchAccount(ctx, queries, &batch.Accounts[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return batch, rejections, err
+}
+
+// recalculateBatchAccount recalculates the balance of an account of a batch, keeping the updated account, and queues
+// its report email when notifying.
+func (s *TransactionService) recalculateBatchAccount(ctx context.Context, queries *dao.Queries, accountReport *AccountReport) error {
+	account, _, err := recalculateBalance(ctx, queries, s.Rates, accountReport.Account)
+	if err != nil {
+		return fmt.Errorf("error updating balance of account %d: %w", accountReport.Account.AccountID, err)
+	}
+
+	accountReport.Account = account
+	return s.notify(ctx, queries, account, accountReport.ImportID, accountReport.Report)
+}
+
+// splitBatch builds the report of each account from the totals of the summary, in the reporting currency of the
+// account.
+func (s *TransactionService) splitBatch(ctx context.Context, summary BalanceReport, router *routingParser) BatchReport {
+	// the same account may be keyed by its email and its number, the first key in order names it
+	keys := make(map[int64]string)
+	accounts := make(map[int64]dao.Account)
+	for key, account := range router.accounts {
+		if current, ok := keys[account.AccountID]; !ok || key < current {
+			keys[account.AccountID] = key
+			accounts[account.AccountID] = account
+		}
+	}
+
+	batch := BatchReport{Accounts: make([]AccountReport, 0, len(summary.accounts)), Summary: summary}
+	for accountID, totals := range summary.accounts {
+		account := accounts[accountID]
+		report := BalanceReport{
+			AccountID:        accountID,
+			Currency:         reportingCurrency(account),
+			Currencies:       totals.Currencies,
+			TransactionCount: totals.TransactionCount,
+			Incomplete:       summary.Incomplete,
+		}
+
+		// the import may be interrupted, converting what was stored still needs the rates
+		report.summarize(context.WithoutCancel(ctx), s.Rates, time.Now())
+		batch.Accounts = append(batch.Accounts, AccountReport{Key: keys[accountID], Account: account, ImportID: router.imports.importID(accountID), Report: report})
+	}
+
+	sort.Slice(batch.Accounts, func(i, j int) bool {
+		return batch.Accounts[i].Report.AccountID < batch.Accounts[j].Report.AccountID
+	})
+	return batch
+}
+
+// routingParser resolves the account of each transaction of a multi-account statement, rows without an account or
+// whose account can't be resolved are rejected. Each account is resolved once, and its import recorded when the
+// import is.
+type routingParser struct {
+	ctx      context.Context
+	parser   StatementParser
+	resolver AccountResolver
+	imports  *batchImports
+	accounts map[string]dao.Account
+	failed   map[string]error
+}
+
+func (p *routingParser) Next() (StatementTransaction, error) {
+	transaction, err := p.parser.Next()
+	if err != nil {
+		return transaction, err
+	}
+
+	reject := func(reason string) (StatementTransaction, error) {
+		return StatementTransaction{}, &RecordError{Line: transaction.Line, Record: transaction.Record, Field: "account", Reason: reason}
+	}
+	if transaction.Account == "" {
+		return reject("missing account")
+	}
+	if err, ok := p.failed[transaction.Account]; ok {
+		return reject(err.Error())
+	}
+
+	account, ok := p.accounts[transaction.Account]
+	if !ok {
+		account, err = p.resolver.ResolveAccount(p.ctx, transaction.Account)
+		if errors.Is(err, ErrUnknownAccount) || errors.Is(err, ErrInvalidEmail) || errors.Is(err, ErrAccountDeactivated) {
+			p.failed[transaction.Account] = err
+			return reject(err.Error())
+		} else if err != nil {
+			return StatementTransaction{}, fmt.Errorf("error resolving account %s: %w", transaction.Account, err)
+		}
+		if err := p.imports.start(p.ctx, account); err != nil {
+			return StatementTransaction{}, fmt.Errorf("error recording import of account %d: %w", account.AccountID, err)
+		}
+		p.accounts[transaction.Account] = account
+	}
+
+	transaction.AccountID = account.AccountID
+	transaction.ImportID = p.imports.importID(account.AccountID)
+	return transaction, nil
+}
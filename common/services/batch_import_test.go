@@ -0,0 +1,122 @@
+package services
+
+import (
+	"bytes"
+	"common/dao"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// mapResolver resolves accounts from a map by key, counting how many times each key is resolved.
+type mapResolver struct {
+	accounts map[string]dao.Account
+	resolved map[string]int
+	err      error
+}
+
+func (r *mapResolver) ResolveAccount(_ context.Context, key string) (dao.Account, error) {
+	r.resolved[key] += 1
+	if r.err != nil {
+		return dao.Account{}, r.err
+	}
+
+	account, ok := r.accounts[key]
+	if !ok {
+		return dao.Account{}, fmt.Errorf("%w: %q", ErrUnknownAccount, key)
+	}
+	return account, nil
+}
+
+func TestTransactionService_ImportBatch(t *testing.T) {
+	profile, err := LoadCSVProfile("aggregator")
+	require.NoError(t, err)
+	content := "Reference,Date,Amount,Currency,Description,Account\n" +
+		"1,2024-01-10,100.00,MXN,Payroll,ana@example.com\n" +
+		"2,2024-01-11,-10.00,MXN,Coffee,2\n" +
+		"3,2024-01-12,-5.00,MXN,Rent,nobody@example.com\n" +
+		"4,2024-02-01,-20.00,MXN,Groceries,ana@example.com\n"
+
+	t.Run("Routed", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		service := TransactionService{Database: db, Workers: 1, BatchSize: 1, Source: "feed.csv"}
+		resolver := &mapResolver{
+			accounts: map[string]dao.Account{
+				"ana@example.com": {AccountID: 1, Email: "ana@example.com"},
+				"2":               {AccountID: 2, Email: "bob@example.com", ReportingCurrency: sql.NullString{Valid: true, String: "USD"}},
+			},
+			resolved: make(map[string]int),
+		}
+
+		for _, args := range [][]any{{1, "1", "credit", "100"}, {2, "2", "debit", "10"}, {1, "4", "debit", "20"}} {
+			mock.ExpectQuery(`INSERT INTO transactions`).
+				WithArgs(args[0], args[1], "feed.csv", args[2], decimal.RequireFromString(args[3].(string)), "MXN", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(1))
+		}
+		for _, accountID := range []int64{1, 2} {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`FROM transactions`).WithArgs(accountID).WillReturnRows(sqlmock.NewRows(totalsColumns))
+			mock.ExpectQuery(`SELECT to_char`).WithArgs(accountID).WillReturnRows(sqlmock.NewRows([]string{"year_month", "count"}))
+			mock.ExpectQuery(`UPDATE accounts`).WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(accountID, "", "", "", "es-MX", "0", "0", "0", time.Now(), nil, nil, nil, nil, nil))
+			mock.ExpectQuery(`INSERT INTO balance_snapshots`).WillReturnRows(sqlmock.NewRows(snapshotColumns).AddRow(accountID, accountID, "0", []byte("{}"), time.Now()))
+			mock.ExpectCommit()
+		}
+
+		parser := &CSVParser{Reader: csv.NewReader(bytes.NewBufferString(content)), Profile: &profile}
+		batch, rejections, err := service.ImportBatch(context.Background(), resolver, parser)
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+
+		require.Len(t, rejections, 1)
+		require.Equal(t, 4, rejections[0].Line)
+		require.Equal(t, "account", rejections[0].Field)
+
+		// accounts are resolved once
+		require.Equal(t, 1, resolver.resolved["ana@example.com"])
+		require.Equal(t, 1, resolver.resolved["nobody@example.com"])
+
+		require.Len(t, batch.Accounts, 2)
+		ana := batch.Accounts[0]
+		require.Equal(t, "ana@example.com", ana.Key)
+		require.Equal(t, int64(1), ana.Report.AccountID)
+		require.Equal(t, int64(1), ana.Report.CountCredit)
+		require.Equal(t, int64(1), ana.Report.CountDebit)
+		require.Equal(t, "80.00 MXN", ana.Report.TotalBalance.String())
+		require.Equal(t, map[string]int{"2024-01": 1, "2024-02": 1}, ana.Report.TransactionCount)
+
+		// reported in the currency of the account, without rates nothing is converted
+		bob := batch.Accounts[1]
+		require.Equal(t, "USD", bob.Report.Currency)
+		require.Equal(t, []string{"MXN"}, bob.Report.Unconverted)
+
+		require.Equal(t, int64(0), batch.Summary.AccountID)
+		require.Equal(t, int64(1), batch.Summary.CountCredit)
+		require.Equal(t, int64(2), batch.Summary.CountDebit)
+		require.Equal(t, int64(1), batch.Summary.CountRejected)
+		require.Equal(t, "70.00 MXN", batch.Summary.TotalBalance.String())
+	})
+
+	t.Run("ResolverError", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		service := TransactionService{Database: db, Workers: 1, BatchSize: 1, Mode: ImportModeAtomic}
+		resolver := &mapResolver{resolved: make(map[string]int), err: errors.New("connection refused")}
+
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		parser := &CSVParser{Reader: csv.NewReader(bytes.NewBufferString(content)), Profile: &profile}
+		batch, _, err := service.ImportBatch(context.Background(), resolver, parser)
+		require.ErrorContains(t, err, "error resolving account ana@example.com: connection refused")
+		require.Empty(t, batch.Accounts)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
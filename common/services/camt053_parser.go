@@ -0,0 +1,173 @@
+package services
+
+import (
+	"common/dao"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"io"
+	"strings"
+	"time"
+)
+
+// camtEntry is the part of an ISO 20022 camt.053 Ntry element used to build a transaction.
+type camtEntry struct {
+	Amt          camtAmount `xml:"Amt"`
+	CdtDbtInd    string     `xml:"CdtDbtInd"`
+	RvslInd      bool       `xml:"RvslInd"`
+	BookgDt      camtDate
+	ValDt        camtDate
+	NtryRef      string `xml:"NtryRef"`
+	AcctSvcrRef  string `xml:"AcctSvcrRef"`
+	AddtlNtryInf string `xml:"AddtlNtryInf"`
+	TxDtls       []struct {
+		AcctSvcrRef string   `xml:"Refs>AcctSvcrRef"`
+		EndToEndID  string   `xml:"Refs>EndToEndId"`
+		Ustrd       []string `xml:"RmtInf>Ustrd"`
+	} `xml:"NtryDtls>TxDtls"`
+}
+
+type camtAmount struct {
+	Value string `xml:",chardata"`
+	Ccy   string `xml:"Ccy,attr"`
+}
+
+type camtDate struct {
+	Dt   string `xml:"Dt"`
+	DtTm string `xml:"DtTm"`
+}
+
+// CAMT053Parser reads the entries of ISO 20022 camt.053 bank to customer statements. Amounts are unsigned, the
+// CdtDbtInd element tells credits (CRDT) from debits (DBIT) and the Ccy attribute of Amt their currency.
+type CAMT053Parser struct {
+	Reader io.Reader
+
+	decoder *xml.Decoder
+	ids     syntheticIDs
+}
+
+// Next returns the next Ntry element of the statement, of any Stmt in the document.
+func (p *CAMT053Parser) Next() (StatementTransaction, error) {
+	if p.decoder == nil {
+		p.decoder = xml.NewDecoder(p.Reader)
+		p.ids = make(syntheticIDs)
+	}
+
+	for {
+		token, err := p.decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return StatementTransaction{}, io.EOF
+		} else if err != nil {
+			return StatementTransaction{}, fmt.Errorf("error reading statement: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "Ntry" {
+			continue
+		}
+
+		line, _ := p.decoder.InputPos()
+		var entry camtEntry
+		if err := p.decoder.DecodeElement(&entry, &start); err != nil {
+			return StatementTransaction{}, fmt.Errorf("error reading statement: %w", err)
+		}
+
+		record := CSVRecord{entry.AcctSvcrRef, entry.BookgDt.value(), entry.CdtDbtInd, entry.Amt.Value}
+		transaction, err := p.parseEntry(entry)
+		transaction.Line = line
+		transaction.Record = record
+		var recordErr *RecordError
+		if errors.As(err, &recordErr) {
+			recordErr.Line = line
+			recordErr.Record = record
+		}
+		return transaction, err
+	}
+}
+
+func (p *CAMT053Parser) parseEntry(entry camtEntry) (StatementTransaction, error) {
+	date := entry.BookgDt.value()
+	if date == "" {
+		date = entry.ValDt.value()
+	}
+	performedAt, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return StatementTransaction{}, &RecordError{Field: "date", Reason: fmt.Sprintf("invalid date: %q", date)}
+	}
+
+	amount, err := decimal.NewFromString(strings.TrimSpace(entry.Amt.Value))
+	if err != nil || amount.IsNegative() {
+		return StatementTransaction{}, &RecordError{Field: "amount", Reason: fmt.Sprintf("invalid transaction amount: %s", entry.Amt.Value)}
+	}
+
+	currency := DefaultCurrency
+	if entry.Amt.Ccy != "" {
+		if currency, err = ParseCurrency(entry.Amt.Ccy); err != nil {
+			return StatementTransaction{}, &RecordError{Field: "currency", Reason: err.Error()}
+		}
+	}
+
+	var operation dao.TxOperationType
+	switch entry.CdtDbtInd {
+	case "CRDT":
+		operation = dao.TxOperationTypeCredit
+	case "DBIT":
+		operation = dao.TxOperationTypeDebit
+	default:
+		return StatementTransaction{}, &RecordError{Field: "amount", Reason: fmt.Sprintf("invalid credit debit indicator: %s", entry.CdtDbtInd)}
+	}
+
+	// a reversal undoes an entry, so it goes the opposite way
+	if entry.RvslInd {
+		if operation == dao.TxOperationTypeCredit {
+			operation = dao.TxOperationTypeDebit
+		} else {
+			operation = dao.TxOperationTypeCredit
+		}
+	}
+
+	description := entry.AddtlNtryInf
+	id := firstNonEmpty(entry.AcctSvcrRef, entry.NtryRef)
+	for _, details := range entry.TxDtls {
+		if id == "" {
+			id = firstNonEmpty(details.AcctSvcrRef, strings.TrimPrefix(details.EndToEndID, "NOTPROVIDED"))
+		}
+		if description == "" {
+			description = strings.Join(details.Ustrd, " ")
+		}
+	}
+	if id == "" {
+		id = p.ids.next(date, entry.CdtDbtInd, entry.Amt.Value, description)
+	}
+
+	return StatementTransaction{
+		ExternalID:  id,
+		PerformedAt: performedAt,
+		Operation:   operation,
+		Amount:      amount,
+		Currency:    currency,
+		Description: description,
+	}, nil
+}
+
+// value returns the date of a Dt or DtTm element.
+func (d camtDate) value() string {
+	if d.Dt != "" {
+		return strings.TrimSpace(d.Dt)
+	}
+	if len(d.DtTm) >= 10 {
+		return d.DtTm[:10]
+	}
+	return d.DtTm
+}
+
+// firstNonEmpty returns the first of values that isn't blank.
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
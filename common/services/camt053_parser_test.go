@@ -0,0 +1,81 @@
+package services
+
+import (
+	"bytes"
+	"common/dao"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestCAMT053Parser_Next(t *testing.T) {
+	content := `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02">
+  <BkToCstmrStmt>
+    <Stmt>
+      <Ntry>
+        <Amt Ccy="EUR">1500.25</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <BookgDt><Dt>2024-01-31</Dt></BookgDt>
+        <AcctSvcrRef>REF1</AcctSvcrRef>
+        <AddtlNtryInf>Payroll</AddtlNtryInf>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="EUR">20.50</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <BookgDt><DtTm>2024-02-01T10:00:00</DtTm></BookgDt>
+        <NtryDtls><TxDtls>
+          <Refs><EndToEndId>E2E2</EndToEndId></Refs>
+          <RmtInf><Ustrd>Coffee</Ustrd></RmtInf>
+        </TxDtls></NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="EUR">5</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <RvslInd>true</RvslInd>
+        <BookgDt><Dt>2024-02-02</Dt></BookgDt>
+        <AcctSvcrRef>REF3</AcctSvcrRef>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="EUR">5</Amt>
+        <CdtDbtInd>XXXX</CdtDbtInd>
+        <BookgDt><Dt>2024-02-03</Dt></BookgDt>
+      </Ntry>
+    </Stmt>
+  </BkToCstmrStmt>
+</Document>
+`
+	parser := CAMT053Parser{Reader: bytes.NewBufferString(content)}
+
+	transaction, err := parser.Next()
+	require.NoError(t, err)
+	require.Equal(t, 5, transaction.Line)
+	require.Equal(t, "REF1", transaction.ExternalID)
+	require.Equal(t, time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC), transaction.PerformedAt)
+	require.Equal(t, dao.TxOperationTypeCredit, transaction.Operation)
+	require.Equal(t, "1500.25", transaction.Amount.String())
+	require.Equal(t, "EUR", transaction.Currency)
+	require.Equal(t, "Payroll", transaction.Description)
+
+	transaction, err = parser.Next()
+	require.NoError(t, err)
+	require.Equal(t, "E2E2", transaction.ExternalID)
+	require.Equal(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), transaction.PerformedAt)
+	require.Equal(t, dao.TxOperationTypeDebit, transaction.Operation)
+	require.Equal(t, "Coffee", transaction.Description)
+
+	// reversal of a debit
+	transaction, err = parser.Next()
+	require.NoError(t, err)
+	require.Equal(t, dao.TxOperationTypeCredit, transaction.Operation)
+
+	_, err = parser.Next()
+	var recordErr *RecordError
+	require.ErrorAs(t, err, &recordErr)
+	require.Equal(t, 28, recordErr.Line)
+	require.Equal(t, "invalid credit debit indicator: XXXX", recordErr.Reason)
+
+	_, err = parser.Next()
+	require.ErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"common/dao"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	rTxID                 = regexp.MustCompile(`^\d+$`)
+	rTxOperationAndAmount = regexp.MustCompile(`^[+|-]\d+(\.\d+)?$`)
+	rLooseAmount          = regexp.MustCompile(`^\s*([+-])\s*(\d{1,3}(?:,\d{3})+|\d+)(\.\d+)?\s*$`)
+)
+
+// CSVParser reads statements in the id,date,amount layout, where amounts are signed: + for credit and - for debit. An
+// optional fourth column holds the currency of the amount, the DefaultCurrency when absent.
+type CSVParser struct {
+	Reader *csv.Reader
+
+	// Dates parses the date column, by default ISO and MM/DD dates relative to today.
+	Dates DateParser
+
+	// Tolerant accepts rows with trailing empty columns, loose quotes and amounts with thousands separators
+	// or spaces, instead of rejecting them.
+	Tolerant bool
+
+	// Profile maps the columns of bank specific layouts, by default records are id,date,amount with signed amounts.
+	Profile *CSVProfile
+
+	readHeader bool
+	indexes    csvColumnIndexes
+	ids        syntheticIDs
+}
+
+// Next reads the next row, skipping the header.
+func (p *CSVParser) Next() (StatementTransaction, error) {
+	if !p.readHeader {
+		if p.Tolerant {
+			p.Reader.FieldsPerRecord = -1
+			p.Reader.LazyQuotes = true
+		}
+		if p.Profile != nil {
+			p.Reader.Comma = p.Profile.comma()
+			p.Reader.FieldsPerRecord = -1
+		}
+
+		// an empty file is an error, not the end of the statement
+		header, err := p.Reader.Read()
+		if errors.Is(err, io.EOF) {
+			return StatementTransaction{}, errors.New("error reading header: empty file")
+		} else if err != nil {
+			return StatementTransaction{}, fmt.Errorf("error reading header: %w", err)
+		}
+
+		if p.Profile != nil {
+			if p.indexes, err = p.Profile.columnIndexes(header); err != nil {
+				return StatementTransaction{}, fmt.Errorf("error reading header: %w", err)
+			}
+			p.ids = make(syntheticIDs)
+		}
+		p.readHeader = true
+	}
+
+	record, err := p.Reader.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return StatementTransaction{}, &RecordError{Line: parseErr.StartLine, Record: record, Reason: parseErr.Err.Error()}
+	} else if err != nil {
+		return StatementTransaction{}, err
+	}
+
+	line, _ := p.Reader.FieldPos(0)
+	var transaction StatementTransaction
+	if p.Profile != nil {
+		transaction, err = p.parseProfileRecord(record)
+	} else {
+		transaction, err = p.ParseRecord(record)
+	}
+	transaction.Line = line
+	transaction.Record = record
+
+	var recordErr *RecordError
+	if errors.As(err, &recordErr) {
+		recordErr.Line = line
+		recordErr.Record = record
+	}
+	return transaction, err
+}
+
+// ParseRecord validates a CSV record and extracts transaction id, date, operation type, amount and currency.
+// Returns a RecordError if any of the fields are invalid.
+func (p *CSVParser) ParseRecord(record CSVRecord) (StatementTransaction, error) {
+	if p.Tolerant {
+		record = trimTrailingEmpty(record)
+	}
+	if len(record) != 3 && len(record) != 4 {
+		return StatementTransaction{}, &RecordError{Reason: fmt.Sprintf("wrong number of fields: expected 3 or 4, got %d", len(record))}
+	}
+
+	if !rTxID.MatchString(record[0]) {
+		return StatementTransaction{}, &RecordError{Field: "id", Reason: fmt.Sprintf("invalid transaction id: %s", record[0])}
+	}
+
+	txDate, err := p.Dates.Parse(record[1])
+	if err != nil {
+		return StatementTransaction{}, &RecordError{Field: "date", Reason: err.Error()}
+	}
+
+	operationAndAmount := record[2]
+	if p.Tolerant {
+		operationAndAmount = normalizeLooseAmount(operationAndAmount)
+	}
+	if !rTxOperationAndAmount.MatchString(operationAndAmount) {
+		return StatementTransaction{}, &RecordError{Field: "amount", Reason: fmt.Sprintf("invalid transaction operation and amount: %s", record[2])}
+	}
+
+	txOperation := dao.TxOperationTypeCredit
+	if operationAndAmount[0:1] == "-" {
+		txOperation = dao.TxOperationTypeDebit
+	}
+
+	txAmount, err := decimal.NewFromString(operationAndAmount[1:])
+	if err != nil {
+		return StatementTransaction{}, &RecordError{Field: "amount", Reason: err.Error()}
+	}
+
+	txCurrency := DefaultCurrency
+	if len(record) == 4 {
+		if txCurrency, err = ParseCurrency(record[3]); err != nil {
+			return StatementTransaction{}, &RecordError{Field: "currency", Reason: err.Error()}
+		}
+	}
+
+	return StatementTransaction{
+		ExternalID:  record[0],
+		PerformedAt: txDate,
+		Operation:   txOperation,
+		Amount:      txAmount,
+		Currency:    txCurrency,
+	}, nil
+}
+
+// parseProfileRecord extracts a transaction from the columns of the profile, records without an id column get a
+// synthetic one.
+func (p *CSVParser) parseProfileRecord(record CSVRecord) (StatementTransaction, error) {
+	id := strings.TrimSpace(column(record, p.indexes.id))
+	if p.indexes.id >= 0 && id == "" {
+		return StatementTransaction{}, &RecordError{Field: "id", Reason: "missing transaction id"}
+	}
+
+	dates := p.Dates
+	if len(dates.Layouts) == 0 {
+		dates.Layouts = p.Profile.DateLayouts
+	}
+	txDate, err := dates.Parse(strings.TrimSpace(column(record, p.indexes.date)))
+	if err != nil {
+		return StatementTransaction{}, &RecordError{Field: "date", Reason: err.Error()}
+	}
+
+	txOperation, txAmount, err := p.Profile.parseOperation(record, p.indexes)
+	if err != nil {
+		return StatementTransaction{}, &RecordError{Field: "amount", Reason: err.Error()}
+	}
+
+	txCurrency, err := p.Profile.parseCurrency(record, p.indexes)
+	if err != nil {
+		return StatementTransaction{}, &RecordError{Field: "currency", Reason: err.Error()}
+	}
+
+	account := strings.TrimSpace(column(record, p.indexes.account))
+	if p.indexes.account >= 0 && account == "" {
+		return StatementTransaction{}, &RecordError{Field: "account", Reason: "missing account"}
+	}
+
+	description := strings.TrimSpace(column(record, p.indexes.description))
+	if id == "" && account != "" {
+		// identical rows of different accounts are different transactions
+		id = p.ids.next(account, txDate.Format(time.DateOnly), string(txOperation), txAmount.String(), description)
+	} else if id == "" {
+		id = p.ids.next(txDate.Format(time.DateOnly), string(txOperation), txAmount.String(), description)
+	}
+
+	return StatementTransaction{
+		ExternalID:  id,
+		PerformedAt: txDate,
+		Operation:   txOperation,
+		Amount:      txAmount,
+		Currency:    txCurrency,
+		Description: description,
+		Account:     account,
+	}, nil
+}
+
+// trimTrailingEmpty removes empty columns at the end of a record, like the ones left by trailing commas.
+func trimTrailingEmpty(record CSVRecord) CSVRecord {
+	for len(record) > 0 && strings.TrimSpace(record[len(record)-1]) == "" {
+		record = record[:len(record)-1]
+	}
+	return record
+}
+
+// normalizeLooseAmount removes spaces and thousands separators from an amount like "+ 1,234.50", amounts that don't
+// look like that are returned as is.
+func normalizeLooseAmount(amount string) string {
+	match := rLooseAmount.FindStringSubmatch(amount)
+	if match == nil {
+		return amount
+	}
+
+	return match[1] + strings.ReplaceAll(match[2], ",", "") + match[3]
+}
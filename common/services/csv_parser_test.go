@@ -0,0 +1,147 @@
+package services
+
+import (
+	"bytes"
+	"common/dao"
+	"encoding/csv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestCSVParser_ParseRecord(t *testing.T) {
+	tests := []struct {
+		name      string
+		record    CSVRecord
+		expectErr bool
+		field     string
+		currency  string
+	}{
+		{
+			name:      "Valid Record",
+			record:    []string{"422202", "01/31", "+10.50"},
+			expectErr: false,
+			currency:  "MXN",
+		},
+		{
+			name:      "Valid ISO Record",
+			record:    []string{"422202", "2024-01-31", "+10.50"},
+			expectErr: false,
+			currency:  "MXN",
+		},
+		{
+			name:      "Valid Currency",
+			record:    []string{"422202", "2024-01-31", "+10.50", "usd"},
+			expectErr: false,
+			currency:  "USD",
+		},
+		{
+			name:      "Invalid Currency",
+			record:    []string{"422202", "2024-01-31", "+10.50", "US$"},
+			expectErr: true,
+			field:     "currency",
+		},
+		{
+			name:      "Invalid ID",
+			record:    []string{"422AA2", "29/10", "+10.50"},
+			expectErr: true,
+			field:     "id",
+		},
+		{
+			name:      "Invalid Date",
+			record:    []string{"422202", "13-20", "+10.50"},
+			expectErr: true,
+			field:     "date",
+		},
+		{
+			name:      "Invalid Operation type",
+			record:    []string{"422202", "10/29", "~10.50"},
+			expectErr: true,
+			field:     "amount",
+		},
+		{
+			name:      "Invalid Amount",
+			record:    []string{"422202", "10/29", "+1A.50"},
+			expectErr: true,
+			field:     "amount",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			parser := CSVParser{}
+			transaction, err := parser.ParseRecord(tc.record)
+
+			if tc.expectErr {
+				var recordErr *RecordError
+				assert.ErrorAs(t, err, &recordErr)
+				assert.Equal(t, tc.field, recordErr.Field)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.record[0], transaction.ExternalID)
+				assert.False(t, transaction.PerformedAt.IsZero())
+				assert.Equal(t, dao.TxOperationTypeCredit, transaction.Operation)
+				assert.Equal(t, "10.5", transaction.Amount.String())
+				assert.Equal(t, tc.currency, transaction.Currency)
+			}
+		})
+	}
+}
+
+func TestCSVParser_ParseTolerantRecord(t *testing.T) {
+	tests := []struct {
+		name      string
+		record    CSVRecord
+		tolerant  bool
+		amount    string
+		expectErr bool
+	}{
+		{"Strict trailing column", []string{"1", "2024-01-31", "+10.50", ""}, false, "", true},
+		{"Tolerant trailing columns", []string{"1", "2024-01-31", "+10.50", "", " "}, true, "10.5", false},
+		{"Strict thousands separator", []string{"1", "2024-01-31", "-1,234.50"}, false, "", true},
+		{"Tolerant thousands separator", []string{"1", "2024-01-31", "-1,234.50"}, true, "1234.5", false},
+		{"Tolerant spaces", []string{"1", "2024-01-31", " + 1,234,567 "}, true, "1234567", false},
+		{"Tolerant decimal comma", []string{"1", "2024-01-31", "+1,5"}, true, "", true},
+		{"Tolerant missing column", []string{"1", "2024-01-31"}, true, "", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			parser := CSVParser{Tolerant: tc.tolerant}
+			transaction, err := parser.ParseRecord(tc.record)
+			if tc.expectErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.amount, transaction.Amount.String())
+		})
+	}
+}
+
+func TestCSVParser_Next(t *testing.T) {
+	content := "id,date,amount\n1,2024-01-31,+10.50\n2,2024-02-01,-abc\n3,\"2024-02-02,+1\n"
+	parser := CSVParser{Reader: csv.NewReader(bytes.NewBufferString(content))}
+
+	transaction, err := parser.Next()
+	require.NoError(t, err)
+	require.Equal(t, 2, transaction.Line)
+	require.Equal(t, "1", transaction.ExternalID)
+	require.Equal(t, time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC), transaction.PerformedAt)
+	require.Equal(t, CSVRecord{"1", "2024-01-31", "+10.50"}, transaction.Record)
+
+	_, err = parser.Next()
+	var recordErr *RecordError
+	require.ErrorAs(t, err, &recordErr)
+	require.Equal(t, Rejection{Line: 3, Record: CSVRecord{"2", "2024-02-01", "-abc"}, Field: "amount", Reason: "invalid transaction operation and amount: -abc"}, recordErr.Rejection())
+
+	_, err = parser.Next()
+	require.ErrorAs(t, err, &recordErr)
+	require.Equal(t, 4, recordErr.Line)
+
+	_, err = parser.Next()
+	require.ErrorIs(t, err, io.EOF)
+}
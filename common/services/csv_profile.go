@@ -0,0 +1,285 @@
+package services
+
+import (
+	"common/dao"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+var rProfileAmount = regexp.MustCompile(`^[+-]?\d+(\.\d+)?$`)
+
+// SignConvention tells how a CSV profile marks debits and credits.
+type SignConvention string
+
+const (
+	// SignSigned amounts are positive for credits and negative for debits.
+	SignSigned SignConvention = "signed"
+
+	// SignInverted amounts are positive for debits and negative for credits, like credit card statements.
+	SignInverted SignConvention = "inverted"
+
+	// SignColumns amounts go in a debit or a credit column, without sign.
+	SignColumns SignConvention = "columns"
+)
+
+// CSVColumns names the header of each column, ID, Description, Currency and Account are optional. Profiles with an
+// Account column are multi-account statements, the column holds the email or the account number of each row.
+type CSVColumns struct {
+	ID          string `json:"id"`
+	Date        string `json:"date"`
+	Amount      string `json:"amount"`
+	Debit       string `json:"debit"`
+	Credit      string `json:"credit"`
+	Description string `json:"description"`
+	Currency    string `json:"currency"`
+	Account     string `json:"account"`
+}
+
+// CSVProfile declares the layout of a bank specific CSV export. Columns are found by their header name, so their
+// order doesn't matter and extra columns are ignored. Currency is the currency of the amounts of exports without a
+// currency column, the DefaultCurrency when blank.
+type CSVProfile struct {
+	Delimiter          string         `json:"delimiter"`
+	Columns            CSVColumns     `json:"columns"`
+	DateLayouts        []string       `json:"date_layouts"`
+	DecimalSeparator   string         `json:"decimal_separator"`
+	ThousandsSeparator string         `json:"thousands_separator"`
+	Sign               SignConvention `json:"sign"`
+	Currency           string         `json:"currency"`
+}
+
+// csvColumnIndexes are the positions of the profile columns within a record, -1 when absent.
+type csvColumnIndexes struct {
+	id, date, amount, debit, credit, description, currency, account int
+}
+
+// LoadCSVProfiles loads the profiles embedded in static/profiles/csv_profiles.json, by name.
+func LoadCSVProfiles() (map[string]CSVProfile, error) {
+	jsonData, err := content.ReadFile("static/profiles/csv_profiles.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles map[string]CSVProfile
+	if err := json.Unmarshal(jsonData, &profiles); err != nil {
+		return nil, err
+	}
+
+	for name, profile := range profiles {
+		if err := profile.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid profile %s: %w", name, err)
+		}
+	}
+	return profiles, nil
+}
+
+// LoadCSVProfile returns an embedded profile by name.
+func LoadCSVProfile(name string) (CSVProfile, error) {
+	profiles, err := LoadCSVProfiles()
+	if err != nil {
+		return CSVProfile{}, err
+	}
+
+	profile, ok := profiles[name]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return CSVProfile{}, fmt.Errorf("unknown profile %s, available profiles: %s", name, strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+// ParseCSVProfile reads a profile from JSON, for profiles that are not embedded.
+func ParseCSVProfile(jsonData []byte) (CSVProfile, error) {
+	var profile CSVProfile
+	if err := json.Unmarshal(jsonData, &profile); err != nil {
+		return CSVProfile{}, err
+	}
+
+	return profile, profile.Validate()
+}
+
+// Validate checks the profile has the columns its sign convention needs.
+func (p CSVProfile) Validate() error {
+	if p.Delimiter != "" && utf8.RuneCountInString(p.Delimiter) != 1 {
+		return fmt.Errorf("delimiter must be a single character: %q", p.Delimiter)
+	}
+	if p.Columns.Date == "" {
+		return errors.New("missing date column")
+	}
+	if p.decimalSeparator() == p.ThousandsSeparator {
+		return errors.New("decimal and thousands separators must differ")
+	}
+	if p.Currency != "" {
+		if _, err := ParseCurrency(p.Currency); err != nil {
+			return err
+		}
+	}
+
+	switch p.Sign {
+	case "", SignSigned, SignInverted:
+		if p.Columns.Amount == "" {
+			return errors.New("missing amount column")
+		}
+	case SignColumns:
+		if p.Columns.Debit == "" || p.Columns.Credit == "" {
+			return errors.New("missing debit or credit column")
+		}
+	default:
+		return fmt.Errorf("unknown sign convention: %s", p.Sign)
+	}
+	return nil
+}
+
+// MultiAccount tells whether the rows of the statements belong to different accounts.
+func (p CSVProfile) MultiAccount() bool {
+	return p.Columns.Account != ""
+}
+
+// decimalSeparator returns the decimal separator, a dot by default.
+func (p CSVProfile) decimalSeparator() string {
+	if p.DecimalSeparator == "" {
+		return "."
+	}
+
+	return p.DecimalSeparator
+}
+
+// comma returns the delimiter as the csv.Reader expects it.
+func (p CSVProfile) comma() rune {
+	if p.Delimiter == "" {
+		return ','
+	}
+
+	delimiter, _ := utf8.DecodeRuneInString(p.Delimiter)
+	return delimiter
+}
+
+// columnIndexes finds the columns of the profile in the header, names are compared ignoring case and spaces.
+func (p CSVProfile) columnIndexes(header CSVRecord) (csvColumnIndexes, error) {
+	positions := make(map[string]int)
+	for i, name := range header {
+		// exports from spreadsheets often start with a byte order mark
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, ok := positions[name]; !ok {
+			positions[name] = i
+		}
+	}
+
+	var missing []string
+	find := func(name string, required bool) int {
+		if name == "" {
+			return -1
+		}
+		position, ok := positions[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			if required {
+				missing = append(missing, name)
+			}
+			return -1
+		}
+		return position
+	}
+
+	indexes := csvColumnIndexes{
+		id:          find(p.Columns.ID, true),
+		date:        find(p.Columns.Date, true),
+		amount:      find(p.Columns.Amount, p.Sign != SignColumns),
+		debit:       find(p.Columns.Debit, p.Sign == SignColumns),
+		credit:      find(p.Columns.Credit, p.Sign == SignColumns),
+		description: find(p.Columns.Description, false),
+		currency:    find(p.Columns.Currency, true),
+		account:     find(p.Columns.Account, true),
+	}
+	if len(missing) > 0 {
+		return indexes, fmt.Errorf("missing columns: %s", strings.Join(missing, ", "))
+	}
+	return indexes, nil
+}
+
+// parseAmount parses an amount with the separators of the profile, like "-1.234,50" or "(12.00)".
+func (p CSVProfile) parseAmount(value string) (decimal.Decimal, error) {
+	amount := strings.ReplaceAll(strings.TrimSpace(value), " ", "")
+
+	// accounting notation for negative amounts
+	if strings.HasPrefix(amount, "(") && strings.HasSuffix(amount, ")") {
+		amount = "-" + amount[1:len(amount)-1]
+	}
+	if p.ThousandsSeparator != "" {
+		amount = strings.ReplaceAll(amount, p.ThousandsSeparator, "")
+	}
+	amount = strings.Replace(amount, p.decimalSeparator(), ".", 1)
+
+	if !rProfileAmount.MatchString(amount) {
+		return decimal.Zero, fmt.Errorf("invalid transaction amount: %s", value)
+	}
+	return decimal.NewFromString(amount)
+}
+
+// parseOperation returns the operation and absolute amount of a record following the sign convention.
+func (p CSVProfile) parseOperation(record CSVRecord, indexes csvColumnIndexes) (dao.TxOperationType, decimal.Decimal, error) {
+	if p.Sign != SignColumns {
+		amount, err := p.parseAmount(column(record, indexes.amount))
+		if err != nil {
+			return "", decimal.Zero, err
+		}
+		if p.Sign == SignInverted {
+			amount = amount.Neg()
+		}
+
+		operation, amount := operationOf(amount)
+		return operation, amount, nil
+	}
+
+	// the column left blank (or zero) tells the operation
+	var debit, credit decimal.Decimal
+	var err error
+	if value := column(record, indexes.debit); strings.TrimSpace(value) != "" {
+		if debit, err = p.parseAmount(value); err != nil {
+			return "", decimal.Zero, err
+		}
+	}
+	if value := column(record, indexes.credit); strings.TrimSpace(value) != "" {
+		if credit, err = p.parseAmount(value); err != nil {
+			return "", decimal.Zero, err
+		}
+	}
+
+	switch {
+	case !debit.IsZero() && credit.IsZero():
+		return dao.TxOperationTypeDebit, debit.Abs(), nil
+	case debit.IsZero() && !credit.IsZero():
+		return dao.TxOperationTypeCredit, credit.Abs(), nil
+	}
+	return "", decimal.Zero, fmt.Errorf("expected either a debit or a credit amount, got %q and %q", column(record, indexes.debit), column(record, indexes.credit))
+}
+
+// parseCurrency returns the currency of a record, from the currency column or the one of the profile.
+func (p CSVProfile) parseCurrency(record CSVRecord, indexes csvColumnIndexes) (string, error) {
+	if indexes.currency >= 0 {
+		return ParseCurrency(column(record, indexes.currency))
+	}
+	if p.Currency != "" {
+		return ParseCurrency(p.Currency)
+	}
+
+	return DefaultCurrency, nil
+}
+
+// column returns the value of a column, or blank when the column is absent or the record too short.
+func column(record CSVRecord, index int) string {
+	if index < 0 || index >= len(record) {
+		return ""
+	}
+
+	return record[index]
+}
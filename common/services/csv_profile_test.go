@@ -0,0 +1,186 @@
+package services
+
+import (
+	"bytes"
+	"common/dao"
+	"encoding/csv"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestLoadCSVProfiles(t *testing.T) {
+	profiles, err := LoadCSVProfiles()
+	require.NoError(t, err)
+	require.Contains(t, profiles, "es-mx")
+
+	_, err = LoadCSVProfile("unknown")
+	require.ErrorContains(t, err, "available profiles: aggregator, credit-card, debit-credit, es-mx, semicolon-eu")
+}
+
+func TestCSVProfile_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		err     string
+	}{
+		{"Signed", `{"columns": {"date": "Date", "amount": "Amount"}}`, ""},
+		{"Missing date", `{"columns": {"amount": "Amount"}}`, "missing date column"},
+		{"Missing amount", `{"columns": {"date": "Date"}, "sign": "inverted"}`, "missing amount column"},
+		{"Missing credit", `{"columns": {"date": "Date", "debit": "Debit"}, "sign": "columns"}`, "missing debit or credit column"},
+		{"Unknown sign", `{"columns": {"date": "Date", "amount": "Amount"}, "sign": "both"}`, "unknown sign convention: both"},
+		{"Long delimiter", `{"delimiter": ";;", "columns": {"date": "Date", "amount": "Amount"}}`, "delimiter must be a single character"},
+		{"Same separators", `{"thousands_separator": ".", "columns": {"date": "Date", "amount": "Amount"}}`, "decimal and thousands separators must differ"},
+		{"Invalid currency", `{"currency": "pesos", "columns": {"date": "Date", "amount": "Amount"}}`, `invalid currency: "pesos"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseCSVProfile([]byte(tc.profile))
+			if tc.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestCSVProfile_ParseAmount(t *testing.T) {
+	european := CSVProfile{DecimalSeparator: ",", ThousandsSeparator: "."}
+	american := CSVProfile{ThousandsSeparator: ","}
+
+	tests := []struct {
+		profile CSVProfile
+		value   string
+		amount  string
+	}{
+		{european, "-1.234,50", "-1234.5"},
+		{european, "12,5", "12.5"},
+		{european, "1.234.567", "1234567"},
+		{american, "1,234.50", "1234.5"},
+		{american, "(12.00)", "-12"},
+		{american, " + 3 ", "3"},
+		{american, "12.3.4", ""},
+		{american, "abc", ""},
+	}
+
+	for _, tc := range tests {
+		amount, err := tc.profile.parseAmount(tc.value)
+		if tc.amount == "" {
+			require.Error(t, err, tc.value)
+			continue
+		}
+
+		require.NoError(t, err, tc.value)
+		require.Equal(t, tc.amount, amount.String(), tc.value)
+	}
+}
+
+func TestCSVParser_NextWithProfile(t *testing.T) {
+	profile, err := LoadCSVProfile("es-mx")
+	require.NoError(t, err)
+
+	content := "\ufeffFecha,Referencia,Concepto,Cargo,Abono,Saldo\n" +
+		"31/01/2024,1001,Nomina,,\"15,000.00\",15000.00\n" +
+		"01/02/2024,1002,Cafe,45.50,0.00,14954.50\n" +
+		"02/02/2024,1003,Nada,,,14954.50\n" +
+		"30/02/2024,1004,Fecha invalida,1.00,,14953.50\n"
+	parser := CSVParser{Reader: csv.NewReader(bytes.NewBufferString(content)), Profile: &profile}
+
+	transaction, err := parser.Next()
+	require.NoError(t, err)
+	require.Equal(t, 2, transaction.Line)
+	require.Equal(t, "1001", transaction.ExternalID)
+	require.Equal(t, time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC), transaction.PerformedAt)
+	require.Equal(t, dao.TxOperationTypeCredit, transaction.Operation)
+	require.Equal(t, "15000", transaction.Amount.String())
+	require.Equal(t, "MXN", transaction.Currency)
+	require.Equal(t, "Nomina", transaction.Description)
+
+	transaction, err = parser.Next()
+	require.NoError(t, err)
+	require.Equal(t, dao.TxOperationTypeDebit, transaction.Operation)
+	require.Equal(t, "45.5", transaction.Amount.String())
+
+	_, err = parser.Next()
+	var recordErr *RecordError
+	require.ErrorAs(t, err, &recordErr)
+	require.Equal(t, 4, recordErr.Line)
+	require.Equal(t, "amount", recordErr.Field)
+
+	_, err = parser.Next()
+	require.ErrorAs(t, err, &recordErr)
+	require.Equal(t, "date", recordErr.Field)
+
+	_, err = parser.Next()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestCSVParser_NextWithProfileWithoutIDs(t *testing.T) {
+	profile, err := LoadCSVProfile("semicolon-eu")
+	require.NoError(t, err)
+	profile.Columns.ID = ""
+
+	content := "Date;Amount;Description\n31/01/2024;-1.234,50;Rent\n31/01/2024;-1.234,50;Rent\n"
+	parser, format, err := NewStatementParser(FormatAuto, "export.txt", bytes.NewBufferString(content), ParserOptions{Profile: &profile})
+	require.NoError(t, err)
+	require.Equal(t, FormatCSV, format)
+
+	first, err := parser.Next()
+	require.NoError(t, err)
+	require.Equal(t, dao.TxOperationTypeDebit, first.Operation)
+	require.Equal(t, "1234.5", first.Amount.String())
+
+	second, err := parser.Next()
+	require.NoError(t, err)
+	require.NotEqual(t, first.ExternalID, second.ExternalID)
+
+	// the header must have the columns of the profile
+	parser = &CSVParser{Reader: csv.NewReader(bytes.NewBufferString("Fecha;Monto\n")), Profile: &profile}
+	_, err = parser.Next()
+	require.ErrorContains(t, err, "missing columns: Date, Amount")
+}
+
+func TestCSVParser_NextWithProfileCurrency(t *testing.T) {
+	profile := CSVProfile{Columns: CSVColumns{Date: "Date", Amount: "Amount", Currency: "Currency"}, DateLayouts: []string{time.DateOnly}}
+	content := "Date,Amount,Currency\n2024-01-31,-12.50,usd\n2024-01-31,-1.00,\n"
+	parser := CSVParser{Reader: csv.NewReader(bytes.NewBufferString(content)), Profile: &profile}
+
+	transaction, err := parser.Next()
+	require.NoError(t, err)
+	require.Equal(t, "USD", transaction.Currency)
+
+	_, err = parser.Next()
+	var recordErr *RecordError
+	require.ErrorAs(t, err, &recordErr)
+	require.Equal(t, "currency", recordErr.Field)
+
+	// without a currency column every amount is in the currency of the profile
+	profile = CSVProfile{Columns: CSVColumns{Date: "Date", Amount: "Amount"}, DateLayouts: []string{time.DateOnly}, Currency: "EUR"}
+	parser = CSVParser{Reader: csv.NewReader(bytes.NewBufferString("Date,Amount\n2024-01-31,-12.50\n")), Profile: &profile}
+	transaction, err = parser.Next()
+	require.NoError(t, err)
+	require.Equal(t, "EUR", transaction.Currency)
+}
+
+func TestCSVParser_NextWithProfileAccount(t *testing.T) {
+	profile, err := LoadCSVProfile("aggregator")
+	require.NoError(t, err)
+	require.True(t, profile.MultiAccount())
+
+	content := "Reference,Date,Amount,Currency,Description,Account\n" +
+		"1,2024-01-31,-12.50,MXN,Rent,ana@example.com\n" +
+		"2,2024-01-31,100.00,MXN,Payroll,\n"
+	parser := CSVParser{Reader: csv.NewReader(bytes.NewBufferString(content)), Profile: &profile}
+
+	transaction, err := parser.Next()
+	require.NoError(t, err)
+	require.Equal(t, "ana@example.com", transaction.Account)
+
+	_, err = parser.Next()
+	var recordErr *RecordError
+	require.ErrorAs(t, err, &recordErr)
+	require.Equal(t, "account", recordErr.Field)
+}
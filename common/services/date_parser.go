@@ -0,0 +1,70 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	dayMonthLayout  = "01/02"
+	yearMonthLayout = "2006-01"
+)
+
+// DefaultDateLayouts are used when a DateParser has no layouts, ISO dates first and then legacy MM/DD dates.
+var DefaultDateLayouts = []string{time.DateOnly, dayMonthLayout}
+
+// DateParser parses transaction dates trying each layout in order. Layouts without a year (like the legacy MM/DD)
+// are resolved against ReferenceDate, usually the statement closing date: dates that would fall after it are
+// rolled back to the previous year, so a December row in a statement processed in January keeps its year.
+type DateParser struct {
+	Layouts       []string
+	ReferenceDate time.Time
+}
+
+// Parse parses a date with the first layout that matches.
+func (p DateParser) Parse(value string) (time.Time, error) {
+	layouts := p.Layouts
+	if len(layouts) == 0 {
+		layouts = DefaultDateLayouts
+	}
+
+	for _, layout := range layouts {
+		date, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+
+		if hasYear(layout) {
+			return date, nil
+		}
+		return p.resolveYear(date)
+	}
+
+	return time.Time{}, fmt.Errorf("invalid transaction date: %s", value)
+}
+
+// resolveYear places a date without year in the year of the reference date, or the one before it.
+func (p DateParser) resolveYear(date time.Time) (time.Time, error) {
+	reference := p.ReferenceDate
+	if reference.IsZero() {
+		reference = time.Now()
+	}
+
+	year := reference.Year()
+	resolved := time.Date(year, date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	if resolved.After(reference) {
+		year -= 1
+		resolved = time.Date(year, date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	}
+
+	// February 29th normalizes into March on non leap years
+	if resolved.Day() != date.Day() {
+		return time.Time{}, fmt.Errorf("invalid transaction date: %s does not exist in %d", date.Format(dayMonthLayout), year)
+	}
+	return resolved, nil
+}
+
+func hasYear(layout string) bool {
+	return strings.Contains(layout, "2006") || strings.Contains(layout, "06")
+}
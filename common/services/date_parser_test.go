@@ -0,0 +1,41 @@
+package services
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestDateParser_Parse(t *testing.T) {
+	reference := time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		parser    DateParser
+		value     string
+		expected  time.Time
+		expectErr bool
+	}{
+		{"ISO date", DateParser{ReferenceDate: reference}, "2023-06-30", time.Date(2023, 6, 30, 0, 0, 0, 0, time.UTC), false},
+		{"Legacy date in reference year", DateParser{ReferenceDate: reference}, "01/10", time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC), false},
+		{"Legacy date on reference date", DateParser{ReferenceDate: reference}, "01/15", time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC), false},
+		{"Legacy date rolls over to previous year", DateParser{ReferenceDate: reference}, "12/20", time.Date(2024, 12, 20, 0, 0, 0, 0, time.UTC), false},
+		{"Leap day in leap year", DateParser{ReferenceDate: time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)}, "02/29", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), false},
+		{"Leap day in non leap year", DateParser{ReferenceDate: time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC)}, "02/29", time.Time{}, true},
+		{"Custom layout", DateParser{Layouts: []string{"02/01/2006"}}, "31/12/2024", time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC), false},
+		{"Custom layout rejects default", DateParser{Layouts: []string{"02/01/2006"}}, "2024-12-31", time.Time{}, true},
+		{"Invalid date", DateParser{ReferenceDate: reference}, "13-20", time.Time{}, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			date, err := tc.parser.Parse(tc.value)
+			if tc.expectErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, date)
+		})
+	}
+}
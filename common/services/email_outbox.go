@@ -0,0 +1,297 @@
+package services
+
+import (
+	"common/dao"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// EmailKindBalanceReport is the kind of outbox emails with the balance report of an import.
+const EmailKindBalanceReport = "balance_report"
+
+const (
+	// DefaultEmailMaxAttempts is how many times an email is tried before it is dead-lettered.
+	DefaultEmailMaxAttempts = 8
+
+	// DefaultEmailRetryDelay is the wait after the first failed attempt, it doubles on every attempt after that.
+	DefaultEmailRetryDelay = time.Minute
+
+	// DefaultEmailMaxRetryDelay caps the wait between attempts.
+	DefaultEmailMaxRetryDelay = 6 * time.Hour
+)
+
+// errUndeliverable marks emails that would fail the same way on every attempt, they are dead-lettered right away.
+var errUndeliverable = errors.New("undeliverable email")
+
+// BalanceReportEmail is the payload of balance report emails in the outbox.
+type BalanceReportEmail struct {
+	// Balance is the balance of the account right after the import, Report the statement of the file.
+	Balance BalanceReport `json:"balance"`
+	Report  BalanceReport `json:"report"`
+
+	// ImportID lists the transactions of the import in the PDF statement, the recent ones of the account without it.
+	ImportID int64 `json:"import_id,omitempty"`
+}
+
+// enqueueReport queues the balance report email of an account in the outbox, within the database transaction of
+// queries. Emails with the key of one already queued are skipped, so retrying an import doesn't send it twice.
+func enqueueReport(ctx context.Context, queries *dao.Queries, key string, account dao.Account, report BalanceReport, importID int64) error {
+	jsonData, err := json.Marshal(BalanceReportEmail{Balance: storedBalance(account), Report: report, ImportID: importID})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = queries.EnqueueEmail(ctx, dao.EnqueueEmailParams{
+		IdempotencyKey: key,
+		AccountID:      account.AccountID,
+		Kind:           EmailKindBalanceReport,
+		Payload:        jsonData,
+		NextAttemptAt:  now,
+		CreatedAt:      sql.NullTime{Valid: true, Time: now},
+	})
+	if err != nil {
+		return fmt.Errorf("error queueing report email: %w", err)
+	}
+	return nil
+}
+
+// ImportReportKey returns the idempotency key of the report email of an import recorded by ImportService into the
+// account, to dispatch it with EmailDispatcher.DispatchKeys.
+func ImportReportKey(importID int64, accountID int64) string {
+	return fmt.Sprintf("%s/import/%d/account/%d", EmailKindBalanceReport, importID, accountID)
+}
+
+// reportKey returns the idempotency key of the report email of an import into the account. Imports recorded by
+// ImportService are keyed by their id, others by their source and statement.
+func (s *TransactionService) reportKey(accountID int64, importID int64, report BalanceReport) (string, error) {
+	if importID != 0 {
+		return ImportReportKey(importID, accountID), nil
+	}
+
+	jsonData, err := json.Marshal(report)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	hash.Write([]byte(s.Source))
+	hash.Write(jsonData)
+	return fmt.Sprintf("%s/account/%d/%s", EmailKindBalanceReport, accountID, hex.EncodeToString(hash.Sum(nil))), nil
+}
+
+// notify queues the report email of the account when the service notifies, within the transaction of queries. The
+// import id, when recorded, keys the email and lists its transactions in the PDF statement.
+func (s *TransactionService) notify(ctx context.Context, queries *dao.Queries, account dao.Account, importID int64, report BalanceReport) error {
+	if !s.Notify {
+		return nil
+	}
+
+	key, err := s.reportKey(account.AccountID, importID, report)
+	if err != nil {
+		return err
+	}
+	return enqueueReport(ctx, queries, key, account, report, importID)
+}
+
+// DispatchResult counts what a dispatch did with the emails it claimed.
+type DispatchResult struct {
+	Sent   int
+	Failed int
+	Dead   int
+}
+
+// EmailDispatcher sends the emails of the outbox. Each email is claimed, sent and marked in its own database
+// transaction, so several dispatchers can run at once without sending an email twice. Failed emails are retried with
+// exponential backoff until MaxAttempts, then they are left as dead until retried by hand.
+type EmailDispatcher struct {
+	Database *sql.DB
+	Email    *EmailService
+
+	// MaxAttempts, RetryDelay and MaxRetryDelay default to DefaultEmailMaxAttempts, DefaultEmailRetryDelay and
+	// DefaultEmailMaxRetryDelay.
+	MaxAttempts   int
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+}
+
+// backoff returns how long to wait after the given number of failed attempts.
+func (d *EmailDispatcher) backoff(attempts int32) time.Duration {
+	delay, maxDelay := d.RetryDelay, d.MaxRetryDelay
+	if delay <= 0 {
+		delay = DefaultEmailRetryDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultEmailMaxRetryDelay
+	}
+
+	for i := int32(1); i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDelay)
+}
+
+func (d *EmailDispatcher) maxAttempts() int32 {
+	if d.MaxAttempts <= 0 {
+		return DefaultEmailMaxAttempts
+	}
+
+	return int32(d.MaxAttempts)
+}
+
+// DispatchPending sends every email due until there are none left or the context is done.
+func (d *EmailDispatcher) DispatchPending(ctx context.Context) (DispatchResult, error) {
+	var result DispatchResult
+	for ctx.Err() == nil {
+		claimed, err := d.dispatchNext(ctx, &result, func(queries *dao.Queries, now time.Time) (dao.EmailOutbox, error) {
+			return queries.ClaimEmail(ctx, now)
+		})
+		if err != nil || !claimed {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// DispatchKeys sends the emails due with the given idempotency keys, like the reports queued by an import, and leaves
+// the rest of the outbox to Run and the email-dispatcher command. Emails already sent, dead, waiting for their next
+// attempt or claimed by another dispatcher are skipped.
+func (d *EmailDispatcher) DispatchKeys(ctx context.Context, keys ...string) (DispatchResult, error) {
+	var result DispatchResult
+	for _, key := range keys {
+		_, err := d.dispatchNext(ctx, &result, func(queries *dao.Queries, now time.Time) (dao.EmailOutbox, error) {
+			return queries.ClaimEmailByKey(ctx, dao.ClaimEmailByKeyParams{IdempotencyKey: key, NextAttemptAt: now})
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// dispatchNext claims an email due with claim and sends it, reporting whether there was one.
+func (d *EmailDispatcher) dispatchNext(ctx context.Context, result *DispatchResult, claim func(queries *dao.Queries, now time.Time) (dao.EmailOutbox, error)) (bool, error) {
+	claimed := false
+	err := withTx(ctx, d.Database, func(tx *sql.Tx) error {
+		queries := dao.New(d.Database).WithTx(tx)
+		now := time.Now()
+		email, err := claim(queries, now)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error claiming email: %w", err)
+		}
+		claimed = true
+
+		sendErr := d.send(ctx, queries, email)
+		if sendErr == nil {
+			_, err = queries.MarkEmailSent(ctx, dao.MarkEmailSentParams{SentAt: sql.NullTime{Valid: true, Time: now}, EmailID: email.EmailID})
+			if err != nil {
+				return fmt.Errorf("error marking email %d as sent: %w", email.EmailID, err)
+			}
+			result.Sent += 1
+			return nil
+		}
+
+		attempts := email.Attempts + 1
+		params := dao.MarkEmailFailedParams{
+			Status:        dao.EmailStatusPending,
+			NextAttemptAt: now.Add(d.backoff(attempts)),
+			LastError:     sendErr.Error(),
+			UpdatedAt:     sql.NullTime{Valid: true, Time: now},
+			EmailID:       email.EmailID,
+		}
+		if attempts >= d.maxAttempts() || errors.Is(sendErr, errUndeliverable) {
+			params.Status = dao.EmailStatusDead
+			params.NextAttemptAt = now
+			result.Dead += 1
+			log.Printf("email %d (%s) dead after %d attempts: %s", email.EmailID, email.IdempotencyKey, attempts, sendErr)
+		} else {
+			result.Failed += 1
+			log.Printf("email %d (%s) failed, attempt %d of %d: %s", email.EmailID, email.IdempotencyKey, attempts, d.maxAttempts(), sendErr)
+		}
+
+		if _, err := queries.MarkEmailFailed(ctx, params); err != nil {
+			return fmt.Errorf("error marking email %d as failed: %w", email.EmailID, err)
+		}
+		return nil
+	})
+	return claimed, err
+}
+
+// send renders and sends an email of the outbox to its account.
+func (d *EmailDispatcher) send(ctx context.Context, queries *dao.Queries, email dao.EmailOutbox) error {
+	account, err := queries.GetAccount(ctx, email.AccountID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: unknown account %d", errUndeliverable, email.AccountID)
+	} else if err != nil {
+		return err
+	}
+
+	switch email.Kind {
+	case EmailKindBalanceReport:
+		var payload BalanceReportEmail
+		if err := json.Unmarshal(email.Payload, &payload); err != nil {
+			return fmt.Errorf("%w: %w", errUndeliverable, err)
+		}
+		statement, err := loadStatement(ctx, queries, account, payload.Balance, payload.Report, payload.ImportID)
+		if err != nil {
+			return err
+		}
+		return d.Email.sendReport(statement)
+	default:
+		return fmt.Errorf("%w: unknown kind %q", errUndeliverable, email.Kind)
+	}
+}
+
+// Run dispatches the emails due every interval until the context is done.
+func (d *EmailDispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		result, err := d.DispatchPending(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("error dispatching emails: %s", err)
+		}
+		if result != (DispatchResult{}) {
+			log.Printf("dispatched emails: %d sent, %d failed, %d dead", result.Sent, result.Failed, result.Dead)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Retry queues dead emails again with their attempts reset, every dead email when emailID is 0.
+func (d *EmailDispatcher) Retry(ctx context.Context, emailID int64) (int64, error) {
+	now := time.Now()
+	return dao.New(d.Database).RetryDeadEmails(ctx, dao.RetryDeadEmailsParams{
+		RetryAt:   now,
+		UpdatedAt: sql.NullTime{Valid: true, Time: now},
+		EmailID:   emailID,
+	})
+}
+
+// Status counts the emails of the outbox by status.
+func (d *EmailDispatcher) Status(ctx context.Context) (map[dao.EmailStatus]int64, error) {
+	rows, err := dao.New(d.Database).CountEmailsByStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[dao.EmailStatus]int64)
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
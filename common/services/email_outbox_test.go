@@ -0,0 +1,261 @@
+package services
+
+import (
+	"bytes"
+	"common/dao"
+	"context"
+	"database/sql/driver"
+	"encoding/csv"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var outboxColumns = []string{"email_id", "idempotency_key", "account_id", "kind", "payload", "status", "attempts", "next_attempt_at", "last_error", "sent_at", "created_at", "updated_at"}
+
+// failingSender fails every email with err, counting the attempts.
+type failingSender struct {
+	err      error
+	attempts int
+}
+
+func (s *failingSender) Send(EmailMessage) error {
+	s.attempts += 1
+	return s.err
+}
+
+func TestTransactionService_ImportFileNotify(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	service := TransactionService{Database: db, Workers: 1, BatchSize: 1, Source: "statement.csv", Notify: true}
+	account := dao.Account{AccountID: 1, Email: "john.doe@example.com"}
+
+	mock.ExpectQuery(`INSERT INTO transactions`).WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM transactions`).WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows(totalsColumns).AddRow("MXN", "10.00", 1, "0", 0))
+	mock.ExpectQuery(`SELECT to_char`).WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"year_month", "count"}))
+	mock.ExpectQuery(`UPDATE accounts`).
+		WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(1, "John", "Doe", "john.doe@example.com", "es-MX", "10.00", "0", "10.00", time.Now(), nil, nil, nil, nil, nil))
+	mock.ExpectQuery(`INSERT INTO balance_snapshots`).WillReturnRows(sqlmock.NewRows(snapshotColumns).AddRow(1, 1, "10.00", []byte("{}"), time.Now()))
+	mock.ExpectExec(`INSERT INTO email_outbox`).
+		WithArgs(sqlmock.AnyArg(), int64(1), EmailKindBalanceReport, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnError(errors.New("db_error"))
+	mock.ExpectRollback()
+
+	// failing to queue the email fails the balance update along with it
+	_, _, _, err = service.ImportFile(context.Background(), account, &CSVParser{Reader: csv.NewReader(bytes.NewBufferString("ID,DATE,AMOUNT\n1,2024-01-01,+10\n"))})
+	require.ErrorContains(t, err, "error queueing report email: db_error")
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTransactionService_ReportKey(t *testing.T) {
+	service := TransactionService{Source: "statement.csv"}
+	key, err := service.reportKey(1, 3, BalanceReport{AccountID: 1})
+	require.NoError(t, err)
+	require.Equal(t, "balance_report/import/3/account/1", key)
+
+	// without an import the same statement gets the same key
+	first, err := service.reportKey(1, 0, BalanceReport{AccountID: 1, CountCredit: 1})
+	require.NoError(t, err)
+	second, err := service.reportKey(1, 0, BalanceReport{AccountID: 1, CountCredit: 1})
+	require.NoError(t, err)
+	require.Equal(t, first, second)
+
+	other, err := service.reportKey(1, 0, BalanceReport{AccountID: 1, CountCredit: 2})
+	require.NoError(t, err)
+	require.NotEqual(t, first, other)
+}
+
+func TestEmailDispatcher_Backoff(t *testing.T) {
+	dispatcher := &EmailDispatcher{RetryDelay: time.Second, MaxRetryDelay: time.Minute}
+	require.Equal(t, time.Second, dispatcher.backoff(1))
+	require.Equal(t, 2*time.Second, dispatcher.backoff(2))
+	require.Equal(t, 16*time.Second, dispatcher.backoff(5))
+	require.Equal(t, time.Minute, dispatcher.backoff(7))
+	require.Equal(t, time.Minute, dispatcher.backoff(40))
+
+	defaults := &EmailDispatcher{}
+	require.Equal(t, DefaultEmailRetryDelay, defaults.backoff(1))
+	require.Equal(t, DefaultEmailMaxRetryDelay, defaults.backoff(DefaultEmailMaxAttempts*2))
+}
+
+func TestEmailDispatcher_DispatchPending(t *testing.T) {
+	payload := []byte(`{"balance":{"account_id":1,"currency":"MXN","total_balance":{"amount":"1250.5","currency":"MXN"}},"report":{"account_id":1,"count_credit":2},"import_id":3}`)
+	accountRow := func() *sqlmock.Rows {
+		return sqlmock.NewRows(accountColumns).AddRow(1, "John", "Doe", "john.doe@example.com", "en-US", "99.00", "0", "0", time.Now(), nil, nil, nil, nil, nil)
+	}
+	emailRow := func(kind string, attempts int) *sqlmock.Rows {
+		return sqlmock.NewRows(outboxColumns).AddRow(7, "balance_report/import/3/account/1", 1, kind, payload, "pending", attempts, time.Now(), "", nil, nil, nil)
+	}
+	expectTransactions := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`FROM transactions\s+WHERE import_id = \$1`).WithArgs(int64(3), int64(1)).
+			WillReturnRows(sqlmock.NewRows(transactionColumns).AddRow(1, 1, "credit", "1000.50", "MXN", time.Now(), nil, nil, 3, "tx-1", "statement.csv"))
+	}
+	expectEmpty := func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM email_outbox`).WillReturnRows(sqlmock.NewRows(outboxColumns))
+		mock.ExpectCommit()
+	}
+
+	t.Run("Sent", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		sender := &MemorySender{}
+		email := &EmailService{Sender: sender}
+		require.NoError(t, email.LoadMessages())
+		dispatcher := &EmailDispatcher{Database: db, Email: email}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM email_outbox\s+WHERE status = 'pending'`).WillReturnRows(emailRow(EmailKindBalanceReport, 0))
+		mock.ExpectQuery(`FROM accounts WHERE account_id = \$1`).WithArgs(int64(1)).WillReturnRows(accountRow())
+		expectTransactions(mock)
+		mock.ExpectQuery(`UPDATE email_outbox\s+SET status = 'sent'`).WithArgs(sqlmock.AnyArg(), int64(7)).
+			WillReturnRows(sqlmock.NewRows(outboxColumns).AddRow(7, "key", 1, EmailKindBalanceReport, payload, "sent", 1, time.Now(), "", time.Now(), nil, nil))
+		mock.ExpectCommit()
+		expectEmpty(mock)
+
+		result, err := dispatcher.DispatchPending(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, DispatchResult{Sent: 1}, result)
+
+		// the balance is the one queued with the import, not the current one of the account
+		sent := sender.Last()
+		require.Equal(t, "john.doe@example.com", sent.To)
+		require.Contains(t, sent.HTML, "<strong>Total balance</strong>: MX$1,250.50")
+
+		// the statement lists the transactions of the import
+		require.Len(t, sent.Attachments, 1)
+		require.Contains(t, string(sent.Attachments[0].Data), "(tx-1)")
+	})
+
+	t.Run("Retried", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		sender := &failingSender{err: errors.New("connection refused")}
+		email := &EmailService{Sender: sender}
+		require.NoError(t, email.LoadMessages())
+		dispatcher := &EmailDispatcher{Database: db, Email: email, RetryDelay: time.Hour}
+
+		before := time.Now()
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM email_outbox`).WillReturnRows(emailRow(EmailKindBalanceReport, 2))
+		mock.ExpectQuery(`FROM accounts WHERE account_id = \$1`).WithArgs(int64(1)).WillReturnRows(accountRow())
+		expectTransactions(mock)
+		mock.ExpectQuery(`UPDATE email_outbox\s+SET status = \$1`).
+			WithArgs(dao.EmailStatusPending, afterArg{before.Add(4 * time.Hour)}, "connection refused", sqlmock.AnyArg(), int64(7)).
+			WillReturnRows(sqlmock.NewRows(outboxColumns).AddRow(7, "key", 1, EmailKindBalanceReport, payload, "pending", 3, time.Now(), "connection refused", nil, nil, nil))
+		mock.ExpectCommit()
+		expectEmpty(mock)
+
+		result, err := dispatcher.DispatchPending(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, DispatchResult{Failed: 1}, result)
+		require.Equal(t, 1, sender.attempts)
+	})
+
+	t.Run("DeadLetter", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		sender := &failingSender{err: errors.New("connection refused")}
+		email := &EmailService{Sender: sender}
+		require.NoError(t, email.LoadMessages())
+		dispatcher := &EmailDispatcher{Database: db, Email: email, MaxAttempts: 3}
+
+		// the last attempt
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM email_outbox`).WillReturnRows(emailRow(EmailKindBalanceReport, 2))
+		mock.ExpectQuery(`FROM accounts WHERE account_id = \$1`).WithArgs(int64(1)).WillReturnRows(accountRow())
+		expectTransactions(mock)
+		mock.ExpectQuery(`UPDATE email_outbox\s+SET status = \$1`).WithArgs(dao.EmailStatusDead, sqlmock.AnyArg(), "connection refused", sqlmock.AnyArg(), int64(7)).
+			WillReturnRows(sqlmock.NewRows(outboxColumns).AddRow(7, "key", 1, EmailKindBalanceReport, payload, "dead", 3, time.Now(), "connection refused", nil, nil, nil))
+		mock.ExpectCommit()
+
+		// unknown kinds never send, they are dead-lettered on the first attempt
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM email_outbox`).WillReturnRows(emailRow("newsletter", 0))
+		mock.ExpectQuery(`FROM accounts WHERE account_id = \$1`).WithArgs(int64(1)).WillReturnRows(accountRow())
+		mock.ExpectQuery(`UPDATE email_outbox\s+SET status = \$1`).WithArgs(dao.EmailStatusDead, sqlmock.AnyArg(), `undeliverable email: unknown kind "newsletter"`, sqlmock.AnyArg(), int64(7)).
+			WillReturnRows(sqlmock.NewRows(outboxColumns).AddRow(7, "key", 1, "newsletter", payload, "dead", 1, time.Now(), "", nil, nil, nil))
+		mock.ExpectCommit()
+		expectEmpty(mock)
+
+		result, err := dispatcher.DispatchPending(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, DispatchResult{Dead: 2}, result)
+		require.Equal(t, 1, sender.attempts)
+	})
+
+	t.Run("ClaimError", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		dispatcher := &EmailDispatcher{Database: db, Email: &EmailService{Sender: &MemorySender{}}}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM email_outbox`).WillReturnError(errors.New("db_error"))
+		mock.ExpectRollback()
+
+		_, err = dispatcher.DispatchPending(context.Background())
+		require.ErrorContains(t, err, "error claiming email: db_error")
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestEmailDispatcher_DispatchKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	sender := &MemorySender{}
+	email := &EmailService{Sender: sender}
+	require.NoError(t, email.LoadMessages())
+	dispatcher := &EmailDispatcher{Database: db, Email: email}
+
+	// only the emails of the keys are claimed, the rest of the outbox is left alone
+	payload := []byte(`{"balance":{"account_id":1},"report":{"account_id":1},"import_id":3}`)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM email_outbox\s+WHERE idempotency_key = \$1`).WithArgs("balance_report/import/3/account/1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(outboxColumns).AddRow(7, "balance_report/import/3/account/1", 1, EmailKindBalanceReport, payload, "pending", 0, time.Now(), "", nil, nil, nil))
+	mock.ExpectQuery(`FROM accounts WHERE account_id = \$1`).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(1, "John", "Doe", "john.doe@example.com", "en-US", "0", "0", "0", time.Now(), nil, nil, nil, nil, nil))
+	mock.ExpectQuery(`FROM transactions\s+WHERE import_id = \$1`).WithArgs(int64(3), int64(1)).WillReturnRows(sqlmock.NewRows(transactionColumns))
+	mock.ExpectQuery(`UPDATE email_outbox\s+SET status = 'sent'`).WithArgs(sqlmock.AnyArg(), int64(7)).
+		WillReturnRows(sqlmock.NewRows(outboxColumns).AddRow(7, "balance_report/import/3/account/1", 1, EmailKindBalanceReport, payload, "sent", 1, time.Now(), "", time.Now(), nil, nil))
+	mock.ExpectCommit()
+
+	// emails already sent or claimed by another dispatcher are skipped
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM email_outbox\s+WHERE idempotency_key = \$1`).WithArgs("balance_report/import/3/account/2", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(outboxColumns))
+	mock.ExpectCommit()
+
+	result, err := dispatcher.DispatchKeys(context.Background(), ImportReportKey(3, 1), ImportReportKey(3, 2))
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Equal(t, DispatchResult{Sent: 1}, result)
+	require.Equal(t, "john.doe@example.com", sender.Last().To)
+}
+
+func TestEmailDispatcher_Retry(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	dispatcher := &EmailDispatcher{Database: db}
+
+	mock.ExpectExec(`UPDATE email_outbox\s+SET status = 'pending'`).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), int64(0)).WillReturnResult(sqlmock.NewResult(0, 2))
+	retried, err := dispatcher.Retry(context.Background(), 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), retried)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// afterArg matches times at or after a time.
+type afterArg struct {
+	time time.Time
+}
+
+func (a afterArg) Match(value driver.Value) bool {
+	t, ok := value.(time.Time)
+	return ok && !t.Before(a.time)
+}
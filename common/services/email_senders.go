@@ -0,0 +1,251 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EmailSenderKind selects the EmailSender that reports are given to.
+type EmailSenderKind int
+
+const (
+	// EmailSenderSMTP sends the emails through SMTP.
+	EmailSenderSMTP EmailSenderKind = iota
+
+	// EmailSenderDir writes each email to files of a directory instead.
+	EmailSenderDir
+
+	// EmailSenderLog only logs the emails.
+	EmailSenderLog
+)
+
+// ParseEmailSenderKind returns the sender by its name: smtp, dir or log.
+func ParseEmailSenderKind(name string) (EmailSenderKind, error) {
+	switch name {
+	case "", "smtp":
+		return EmailSenderSMTP, nil
+	case "dir":
+		return EmailSenderDir, nil
+	case "log":
+		return EmailSenderLog, nil
+	}
+
+	return EmailSenderSMTP, fmt.Errorf("unknown email sender: %s", name)
+}
+
+// String returns the name of the sender.
+func (k EmailSenderKind) String() string {
+	switch k {
+	case EmailSenderDir:
+		return "dir"
+	case EmailSenderLog:
+		return "log"
+	default:
+		return "smtp"
+	}
+}
+
+// EmailConfig configures the sender of report emails.
+type EmailConfig struct {
+	Sender    EmailSenderKind
+	Dir       string
+	FromEmail string
+	SMTPHost  string
+	SMTPPort  int
+	SMTPUser  string
+	SMTPPass  string
+}
+
+// EmailConfigFromEnv reads the sender from EMAIL_SENDER and EMAIL_DIR, and its SMTP parameters from SMTP_HOST,
+// SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM_EMAIL. SMTP_PORT may be left unset, but not set to something
+// that isn't a port.
+func EmailConfigFromEnv() (EmailConfig, error) {
+	kind, err := ParseEmailSenderKind(os.Getenv("EMAIL_SENDER"))
+	if err != nil {
+		return EmailConfig{}, err
+	}
+
+	var port int
+	if value := os.Getenv("SMTP_PORT"); value != "" {
+		if port, err = strconv.Atoi(value); err != nil || port < 1 || port > 65535 {
+			return EmailConfig{}, fmt.Errorf("invalid SMTP_PORT: %s", value)
+		}
+	}
+	return EmailConfig{
+		Sender:    kind,
+		Dir:       os.Getenv("EMAIL_DIR"),
+		FromEmail: os.Getenv("SMTP_FROM_EMAIL"),
+		SMTPHost:  os.Getenv("SMTP_HOST"),
+		SMTPPort:  port,
+		SMTPUser:  os.Getenv("SMTP_USERNAME"),
+		SMTPPass:  os.Getenv("SMTP_PASSWORD"),
+	}, nil
+}
+
+// NewSender returns the sender of the configuration, the directory sender needs a Dir.
+func (c EmailConfig) NewSender() (EmailSender, error) {
+	switch c.Sender {
+	case EmailSenderDir:
+		if c.Dir == "" {
+			return nil, fmt.Errorf("email directory not set")
+		}
+		return &DirectorySender{Dir: c.Dir, FromEmail: c.FromEmail}, nil
+	case EmailSenderLog:
+		return &LogSender{}, nil
+	default:
+		return &SMTPSender{FromEmail: c.FromEmail, SMTPHost: c.SMTPHost, SMTPPort: c.SMTPPort, SMTPUser: c.SMTPUser, SMTPPass: c.SMTPPass}, nil
+	}
+}
+
+// DirectorySender writes every message to Dir instead of sending it: the whole MIME message, headers and attachments
+// included, as a .eml file that mail clients open, and the HTML body as a .html file next to it. Files are named after
+// the time and the recipient so messages never overwrite each other.
+type DirectorySender struct {
+	Dir       string
+	FromEmail string
+}
+
+// Send writes the message to the directory.
+func (s *DirectorySender) Send(message EmailMessage) error {
+	return s.SendWithAttachments(message, nil)
+}
+
+// SendWithAttachments writes the message with the attachments to the directory.
+func (s *DirectorySender) SendWithAttachments(message EmailMessage, attachments []EmailAttachment) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	name := filepath.Join(s.Dir, now.UTC().Format("20060102T150405.000000000Z")+"-"+fileSafe(message.To))
+	m := newMessage(s.FromEmail, message, attachments)
+	m.SetDateHeader("Date", now)
+	if err := writeNew(name+".eml", func(file *os.File) error {
+		_, err := m.WriteTo(file)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if message.HTML != "" {
+		// the headers go in a comment, which can't hold "--"
+		headers := strings.ReplaceAll(fmt.Sprintf("From: %s\nTo: %s\nSubject: %s\nDate: %s", s.FromEmail, message.To, message.Subject, m.FormatDate(now)), "--", "- -")
+		if err := writeNew(name+".html", func(file *os.File) error {
+			_, err := fmt.Fprintf(file, "<!--\n%s\n-->\n%s", headers, message.HTML)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	log.Println("Wrote email to", message.To, "as", name+".eml")
+	return nil
+}
+
+// writeNew creates a file that must not exist yet and writes it with write.
+func writeNew(name string, write func(file *os.File) error) error {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err := write(file); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// fileSafe replaces the characters of an email that don't belong in file names.
+func fileSafe(email string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("@.+-_", r) {
+			return r
+		}
+		return '_'
+	}, email)
+}
+
+// SentEmail is a message kept by MemorySender, with its attachments.
+type SentEmail struct {
+	EmailMessage
+	Attachments []EmailAttachment
+}
+
+// MemorySender keeps the messages it is given instead of sending them, for tests. It is safe for concurrent use.
+type MemorySender struct {
+	lock     sync.Mutex
+	messages []SentEmail
+}
+
+// Send keeps the message.
+func (s *MemorySender) Send(message EmailMessage) error {
+	return s.SendWithAttachments(message, nil)
+}
+
+// SendWithAttachments keeps the message with the attachments.
+func (s *MemorySender) SendWithAttachments(message EmailMessage, attachments []EmailAttachment) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.messages = append(s.messages, SentEmail{EmailMessage: message, Attachments: attachments})
+	return nil
+}
+
+// Messages returns the messages kept so far, oldest first.
+func (s *MemorySender) Messages() []SentEmail {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]SentEmail(nil), s.messages...)
+}
+
+// Last returns the latest message kept, the zero message when there are none.
+func (s *MemorySender) Last() SentEmail {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.messages) == 0 {
+		return SentEmail{}
+	}
+
+	return s.messages[len(s.messages)-1]
+}
+
+// Reset forgets the messages kept so far.
+func (s *MemorySender) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.messages = nil
+}
+
+// LogSender only logs the recipient, subject and size of the messages it is given, for environments that must never
+// send email.
+type LogSender struct {
+	// Logger defaults to the standard logger.
+	Logger *log.Logger
+}
+
+// Send logs the message.
+func (s *LogSender) Send(message EmailMessage) error {
+	return s.SendWithAttachments(message, nil)
+}
+
+// SendWithAttachments logs the message and the name and size of the attachments.
+func (s *LogSender) SendWithAttachments(message EmailMessage, attachments []EmailAttachment) error {
+	logger := s.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	names := make([]string, len(attachments))
+	for i, attachment := range attachments {
+		names[i] = fmt.Sprintf("%s (%s, %d bytes)", attachment.Name, attachment.ContentType, len(attachment.Data))
+	}
+	logger.Printf("Email to %s: %q, %d bytes of text, %d bytes of HTML, attachments: [%s]",
+		message.To, message.Subject, len(message.Text), len(message.HTML), strings.Join(names, ", "))
+	return nil
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseEmailSenderKind(t *testing.T) {
+	for _, name := range []string{"smtp", "dir", "log"} {
+		kind, err := ParseEmailSenderKind(name)
+		require.NoError(t, err)
+		require.Equal(t, name, kind.String())
+	}
+
+	kind, err := ParseEmailSenderKind("")
+	require.NoError(t, err)
+	require.Equal(t, EmailSenderSMTP, kind)
+
+	_, err = ParseEmailSenderKind("fake")
+	require.ErrorContains(t, err, "unknown email sender: fake")
+}
+
+func TestEmailConfig_NewSender(t *testing.T) {
+	t.Setenv("EMAIL_SENDER", "dir")
+	t.Setenv("EMAIL_DIR", "support/files/emails")
+	t.Setenv("SMTP_FROM_EMAIL", "reports@example.com")
+	config, err := EmailConfigFromEnv()
+	require.NoError(t, err)
+
+	sender, err := config.NewSender()
+	require.NoError(t, err)
+	require.Equal(t, &DirectorySender{Dir: "support/files/emails", FromEmail: "reports@example.com"}, sender)
+
+	config.Dir = ""
+	_, err = config.NewSender()
+	require.ErrorContains(t, err, "email directory not set")
+
+	sender, err = EmailConfig{Sender: EmailSenderLog}.NewSender()
+	require.NoError(t, err)
+	require.IsType(t, &LogSender{}, sender)
+
+	sender, err = EmailConfig{SMTPHost: "localhost", SMTPPort: 25}.NewSender()
+	require.NoError(t, err)
+	require.Equal(t, &SMTPSender{SMTPHost: "localhost", SMTPPort: 25}, sender)
+
+	t.Setenv("SMTP_PORT", "2525")
+	config, err = EmailConfigFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, 2525, config.SMTPPort)
+
+	// a port that is set must be valid, rather than falling back to no port
+	for _, port := range []string{"smtp", "0", "70000"} {
+		t.Setenv("SMTP_PORT", port)
+		_, err = EmailConfigFromEnv()
+		require.EqualError(t, err, "invalid SMTP_PORT: "+port)
+	}
+	t.Setenv("SMTP_PORT", "")
+
+	t.Setenv("EMAIL_SENDER", "fake")
+	_, err = EmailConfigFromEnv()
+	require.Error(t, err)
+}
+
+func TestDirectorySender(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "emails")
+	sender := &DirectorySender{Dir: dir, FromEmail: "reports@example.com"}
+	message := EmailMessage{To: "john.doe@example.com", Subject: "Balance Report", Text: "Total balance: $10.00", HTML: "<p>Total balance: $10.00</p>"}
+
+	require.NoError(t, sender.SendWithAttachments(message, []EmailAttachment{{Name: "statement-1.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.4")}}))
+	require.NoError(t, sender.Send(EmailMessage{To: "jane/doe@example.com", Subject: "Balance Report", Text: "Total balance: $5.00"}))
+
+	emls, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, emls, 2)
+	htmls, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	require.NoError(t, err)
+	require.Len(t, htmls, 1)
+
+	// every message keeps its own files, named after the recipient
+	require.True(t, strings.HasSuffix(emls[0], "-john.doe@example.com.eml"))
+	require.True(t, strings.HasSuffix(emls[1], "-jane_doe@example.com.eml"))
+	require.Equal(t, strings.TrimSuffix(emls[0], ".eml")+".html", htmls[0])
+
+	eml, err := os.ReadFile(emls[0])
+	require.NoError(t, err)
+	for _, header := range []string{"From: reports@example.com", "To: john.doe@example.com", "Subject: Balance Report", "Date: ", "Content-Type: multipart/mixed;", `filename="statement-1.pdf"`} {
+		require.Contains(t, string(eml), header)
+	}
+
+	html, err := os.ReadFile(htmls[0])
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(html), "<!--\nFrom: reports@example.com\nTo: john.doe@example.com\nSubject: Balance Report\nDate: "))
+	require.True(t, strings.HasSuffix(string(html), "-->\n<p>Total balance: $10.00</p>"))
+}
+
+func TestMemorySender(t *testing.T) {
+	sender := &MemorySender{}
+	require.Equal(t, SentEmail{}, sender.Last())
+
+	require.NoError(t, sender.Send(EmailMessage{To: "john.doe@example.com"}))
+	require.NoError(t, sender.SendWithAttachments(EmailMessage{To: "jane.doe@example.com"}, []EmailAttachment{{Name: "statement-2.pdf"}}))
+	messages := sender.Messages()
+	require.Len(t, messages, 2)
+	require.Equal(t, "john.doe@example.com", messages[0].To)
+	require.Equal(t, "jane.doe@example.com", sender.Last().To)
+	require.Equal(t, "statement-2.pdf", sender.Last().Attachments[0].Name)
+
+	sender.Reset()
+	require.Empty(t, sender.Messages())
+}
+
+func TestLogSender(t *testing.T) {
+	var out bytes.Buffer
+	sender := &LogSender{Logger: log.New(&out, "", 0)}
+
+	require.NoError(t, sender.SendWithAttachments(EmailMessage{To: "john.doe@example.com", Subject: "Balance Report", Text: "text", HTML: "<p>html</p>"},
+		[]EmailAttachment{{Name: "statement-1.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.4")}}))
+	require.Equal(t, "Email to john.doe@example.com: \"Balance Report\", 4 bytes of text, 11 bytes of HTML, attachments: [statement-1.pdf (application/pdf, 8 bytes)]\n", out.String())
+}
@@ -3,50 +3,96 @@ package services
 import (
 	"bytes"
 	"common/dao"
+	"common/money"
 	"crypto/tls"
 	"embed"
 	"encoding/json"
 	"fmt"
-	"github.com/shopspring/decimal"
 	"gopkg.in/gomail.v2"
 	"html/template"
 	"io"
 	"log"
-	"os"
+	"net/url"
 	"strconv"
 	"strings"
+	texttemplate "text/template"
+	"time"
 )
 
 //go:embed all:static
 var content embed.FS
-var emailTemplate = template.Must(template.New("balance_report.html").Funcs(template.FuncMap{
-	"moneyFmt": func(decimal decimal.Decimal) string {
-		return "$" + decimal.StringFixedBank(2)
+
+// templateFuncs are the functions shared by the email and balance page templates.
+var templateFuncs = template.FuncMap{
+	"moneyFmt": func(locale string, amount money.Money) string {
+		if amount.Currency() == "" {
+			amount = money.New(amount.Amount(), DefaultCurrency)
+		}
+		return amount.Format(locale)
 	},
-	"monthToString": func(loc map[string]string, m int) string {
-		return loc["month."+strconv.Itoa(m)]
+	"yearMonthToString": func(loc map[string]string, yearMonth string) string {
+		date, err := time.Parse(yearMonthLayout, yearMonth)
+		if err != nil {
+			return yearMonth
+		}
+		return loc["month."+strconv.Itoa(int(date.Month()))] + " " + strconv.Itoa(date.Year())
 	},
-}).ParseFS(content, "static/email/balance_report.html"))
+}
+var emailTemplate = template.Must(template.New("balance_report.html").Funcs(templateFuncs).ParseFS(content, "static/email/balance_report.html"))
+
+// textEmailTemplate renders the plain-text alternative of the report, text/template leaves it unescaped.
+var textEmailTemplate = texttemplate.Must(texttemplate.New("balance_report.txt").Funcs(texttemplate.FuncMap(templateFuncs)).ParseFS(content, "static/email/balance_report.txt"))
 
-// EmailSender interface abstracts only the part to send actual HTML to an email
+// EmailMessage is an email with an HTML body and its plain-text alternative, either may be blank.
+type EmailMessage struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// EmailAttachment is a file attached to an email.
+type EmailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// EmailSender interface abstracts only the part to send actual emails, messages with both bodies are sent as
+// multipart/alternative.
 type EmailSender interface {
-	SendHTML(email string, subject string, html string) error
+	Send(message EmailMessage) error
+}
+
+// AttachmentSender is an EmailSender that can attach files to messages, reports are sent with their PDF statement
+// only through one.
+type AttachmentSender interface {
+	EmailSender
+	SendWithAttachments(message EmailMessage, attachments []EmailAttachment) error
 }
 
 // EmailData represents the data required to generate an email report for an account.
 type EmailData struct {
 	Account                dao.Account
+	Lang                   string
 	TitleMsg               string
 	SubtitleMsg            string
 	CheckBalanceMsg        string
 	CheckBalanceLink       string
+	CheckBalanceTextMsg    string
 	FooterMsg              string
 	TotalBalanceMsg        string
 	AvgCreditAmountMsg     string
 	AvgDebitAmountMsg      string
 	TransactionsInMonthMsg string
+	StatementMsg           string
+	CreditsMsg             string
+	DebitsMsg              string
 	Locale                 map[string]string
-	Report                 BalanceReport
+
+	// Balance holds the totals of the account, Report the statement of the file just imported.
+	Balance BalanceReport
+	Report  BalanceReport
 }
 
 // EmailService manages sending emails and loading localization messages for emails.
@@ -54,6 +100,9 @@ type EmailService struct {
 	PublicURL string
 	Sender    EmailSender
 	Messages  map[string]map[string]string
+
+	// Tokens signs the account of the check balance link, the link is left out without them or a PublicURL.
+	Tokens *BalanceTokens
 }
 
 // SMTPSender implements the EmailSender interface to send messages through SMTP
@@ -65,87 +114,158 @@ type SMTPSender struct {
 	SMTPPass  string
 }
 
-func (s *SMTPSender) SendHTML(email string, subject string, html string) error {
-	if s.SMTPHost != "" {
-		m := gomail.NewMessage()
-		m.SetHeader("From", s.FromEmail)
-		m.SetHeader("To", email)
-		m.SetHeader("Subject", subject)
-		m.SetBody("text/html", html)
+// Send sends the message through SMTP, failing when there is no SMTPHost.
+func (s *SMTPSender) Send(message EmailMessage) error {
+	return s.SendWithAttachments(message, nil)
+}
 
-		// Send email via SMTP
-		d := gomail.NewDialer(s.SMTPHost, s.SMTPPort, s.SMTPUser, s.SMTPPass)
-		d.TLSConfig = &tls.Config{InsecureSkipVerify: true}
-		if err := d.DialAndSend(m); err != nil {
-			return err
-		}
+// SendWithAttachments sends the message with the attachments through SMTP. Without SMTPHost nothing is sent and an
+// error is returned, so emails of the outbox stay pending instead of being marked as sent.
+func (s *SMTPSender) SendWithAttachments(message EmailMessage, attachments []EmailAttachment) error {
+	if s.SMTPHost == "" {
+		return fmt.Errorf("SMTP host not set")
+	}
 
-		log.Println("Sent email to", email)
-	} else if s.SMTPHost == "" {
-		log.Println("Skipping email send because SMTPHost is empty")
+	d := gomail.NewDialer(s.SMTPHost, s.SMTPPort, s.SMTPUser, s.SMTPPass)
+	d.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	if err := d.DialAndSend(newMessage(s.FromEmail, message, attachments)); err != nil {
+		return err
 	}
 
+	log.Println("Sent email to", message.To)
 	return nil
 }
 
+// newMessage builds the MIME message, the plain-text part goes first since clients show the last alternative they
+// support. Attachments make it multipart/mixed around the alternatives.
+func newMessage(from string, message EmailMessage, attachments []EmailAttachment) *gomail.Message {
+	m := gomail.NewMessage()
+	m.SetHeader("From", from)
+	m.SetHeader("To", message.To)
+	m.SetHeader("Subject", message.Subject)
+	switch {
+	case message.Text != "" && message.HTML != "":
+		m.SetBody("text/plain", message.Text)
+		m.AddAlternative("text/html", message.HTML)
+	case message.HTML != "":
+		m.SetBody("text/html", message.HTML)
+	default:
+		m.SetBody("text/plain", message.Text)
+	}
+	for _, attachment := range attachments {
+		m.Attach(attachment.Name,
+			gomail.SetHeader(map[string][]string{"Content-Type": {attachment.ContentType}}),
+			gomail.SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(attachment.Data)
+				return err
+			}),
+		)
+	}
+	return m
+}
+
 // LoadMessages loads localization messages
 func (s *EmailService) LoadMessages() error {
-	s.Messages = make(map[string]map[string]string)
+	messages, err := loadMessages()
+	s.Messages = messages
+	return err
+}
+
+// loadMessages loads the localization messages by locale, shared by emails and the balance page.
+func loadMessages() (map[string]map[string]string, error) {
+	messages := make(map[string]map[string]string)
 	jsonData, err := content.ReadFile("static/email/messages.json")
 	if err != nil {
-		return err
+		return messages, err
 	}
 
-	return json.Unmarshal(jsonData, &s.Messages)
+	return messages, json.Unmarshal(jsonData, &messages)
 }
 
-// SendReport sends a balance report to the specified account's email.
+// balanceLink returns the link to the balance page of the account, blank when links can't be signed.
+func (s *EmailService) balanceLink(accountID int64) (string, error) {
+	if s.Tokens == nil || s.PublicURL == "" {
+		return "", nil
+	}
+
+	token, err := s.Tokens.Sign(accountID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/balance?token=%s", strings.TrimSuffix(s.PublicURL, "/"), url.QueryEscape(token)), nil
+}
+
+// SendReport sends the balance of the account, as stored in it, to its email along with the statement of the file
+// just imported. The PDF statement, if the sender attaches it, lists no transactions.
 func (s *EmailService) SendReport(account dao.Account, report BalanceReport) error {
-	var output io.Writer
-	var buffer *bytes.Buffer
-	accountID := account.AccountID
-	email := account.Email
-	locale := account.Locale
+	return s.sendReport(Statement{Account: account, Balance: storedBalance(account), Report: report, GeneratedAt: time.Now()})
+}
 
-	if strings.HasPrefix(email, "fake+") {
-		log.Println("Sending fake email to", email, "rendering to files/fake_email.html")
-		writer, err := os.Create("support/files/fake_email.html")
-		if err != nil {
-			return err
-		}
-		defer func(writer *os.File) {
-			if err := writer.Close(); err != nil {
-				log.Println("Error closing fake email file", err)
-			}
-		}(writer)
-		output = writer
-	} else {
-		buffer = bytes.NewBufferString("")
-		output = buffer
+// storedBalance returns the balance stored in the account as a report.
+func storedBalance(account dao.Account) BalanceReport {
+	currency := reportingCurrency(account)
+	return BalanceReport{
+		AccountID:       account.AccountID,
+		Currency:        currency,
+		TotalBalance:    nullMoney(account.TotalBalance, currency),
+		AvgDebitAmount:  nullMoney(account.AvgDebitAmount, currency),
+		AvgCreditAmount: nullMoney(account.AvgCreditAmount, currency),
 	}
+}
 
+// sendReport sends the balance and the statement of the file to the email of the account, the outbox keeps the
+// balance as it was after the import since the account may change before the email is sent. Senders that attach
+// files get the statement as a PDF too.
+func (s *EmailService) sendReport(statement Statement) error {
+	account := statement.Account
+	link, err := s.balanceLink(account.AccountID)
+	if err != nil {
+		return err
+	}
+
+	locale := account.Locale
 	loc := s.Messages[locale]
-	err := emailTemplate.Execute(output, EmailData{
+	data := EmailData{
 		Account:                account,
+		Lang:                   locale,
 		Locale:                 loc,
 		TitleMsg:               loc["balance_email.title"],
 		SubtitleMsg:            loc["balance_email.subtitle"],
 		CheckBalanceMsg:        loc["balance_email.check"],
-		CheckBalanceLink:       fmt.Sprintf("%s/balance?id=%d", s.PublicURL, accountID),
+		CheckBalanceLink:       link,
+		CheckBalanceTextMsg:    loc["balance_email_text.check"],
 		FooterMsg:              loc["balance_email.footer"],
 		TotalBalanceMsg:        loc["balance_email.total_balance"],
 		AvgCreditAmountMsg:     loc["balance_email.avg_credit_amount"],
 		AvgDebitAmountMsg:      loc["balance_email.avg_debit_amount"],
 		TransactionsInMonthMsg: loc["balance_email.transactions_in_month"],
-		Report:                 report,
-	})
-	if err != nil {
+		StatementMsg:           loc["balance_email.statement"],
+		CreditsMsg:             loc["balance_email.credits"],
+		DebitsMsg:              loc["balance_email.debits"],
+		Balance:                statement.Balance,
+		Report:                 statement.Report,
+	}
+
+	var html, text bytes.Buffer
+	if err := emailTemplate.Execute(&html, data); err != nil {
 		return err
 	}
+	if err := textEmailTemplate.Execute(&text, data); err != nil {
+		return err
+	}
+	message := EmailMessage{To: account.Email, Subject: data.TitleMsg, Text: text.String(), HTML: html.String()}
 
-	// It is probably a good idea to pub/sub this process, since they are only limited email it is ok for now.
-	if buffer != nil {
-		return s.Sender.SendHTML(email, loc["balance_email.title"], buffer.String())
+	sender, ok := s.Sender.(AttachmentSender)
+	if !ok {
+		return s.Sender.Send(message)
 	}
-	return nil
+
+	statements := &StatementPDF{Messages: s.Messages}
+	var document bytes.Buffer
+	if err := statements.Render(&document, statement); err != nil {
+		return fmt.Errorf("error rendering statement: %w", err)
+	}
+	return sender.SendWithAttachments(message, []EmailAttachment{
+		{Name: statements.FileName(account), ContentType: "application/pdf", Data: document.Bytes()},
+	})
 }
@@ -1,46 +1,179 @@
 package services
 
 import (
+	"bytes"
 	"common/dao"
+	"common/mailcatcher"
+	"common/money"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/require"
+	"net"
+	"strings"
 	"testing"
 )
 
-type MockSender struct {
-	SentTo      string
-	SentSubject string
-	SentHTML    string
-}
-
-func (m *MockSender) SendHTML(email string, subject string, html string) error {
-	m.SentTo = email
-	m.SentSubject = subject
-	m.SentHTML = html
-	return nil
-}
-
 func TestEmailService_SendReport(t *testing.T) {
-	mockSender := &MockSender{}
-	service := &EmailService{Sender: mockSender, PublicURL: "http://localhost:3000"}
+	sender := &MemorySender{}
+	service := &EmailService{Sender: sender, PublicURL: "http://localhost:3000"}
 	require.NoError(t, service.LoadMessages())
 
 	account := dao.Account{
-		AccountID: 1,
-		Email:     "test@example.com",
-		Locale:    "en-US",
+		AccountID:    1,
+		Email:        "test@example.com",
+		Locale:       "en-US",
+		TotalBalance: decimal.NullDecimal{Valid: true, Decimal: decimal.RequireFromString("1250.50")},
 	}
 
 	report := BalanceReport{
 		AccountID:   1,
-		TotalCredit: decimal.NewFromInt(100),
+		TotalCredit: money.New(decimal.NewFromInt(100), "MXN"),
 		CountCredit: 10,
-		TotalDebit:  decimal.NewFromInt(50),
+		TotalDebit:  money.New(decimal.NewFromInt(50), "MXN"),
 		CountDebit:  5,
+		TransactionCount: map[string]int{
+			"2024-12": 7,
+			"2025-01": 8,
+		},
 	}
 
 	err := service.SendReport(account, report)
 	require.NoError(t, err)
-	require.Equal(t, account.Email, mockSender.SentTo)
-	require.Equal(t, "Balance Report", mockSender.SentSubject)
+	require.Equal(t, account.Email, sender.Last().To)
+	require.Equal(t, "Balance Report", sender.Last().Subject)
+	require.Contains(t, sender.Last().HTML, "Number of transactions in December 2024: 7")
+	require.Contains(t, sender.Last().HTML, "Number of transactions in January 2025: 8")
+
+	// totals come from the account, the report is the statement of the file
+	require.Contains(t, sender.Last().HTML, "<strong>Total balance</strong>: MX$1,250.50")
+	require.Contains(t, sender.Last().HTML, "<strong>In this statement</strong>: 10 credits (MX$100.00), 5 debits (-MX$50.00)")
+
+	// the plain-text alternative has the same content
+	require.Equal(t, `Balance Report
+
+Hi, 
+
+Total balance: MX$1,250.50
+Average credit amount: MX$0.00
+Average debit amount: MX$0.00
+
+In this statement: 10 credits (MX$100.00), 5 debits (-MX$50.00)
+Number of transactions in December 2024: 7
+Number of transactions in January 2025: 8
+
+-- 
+This is not a real email it is just a test. Please ignore it.
+`, sender.Last().Text)
+}
+
+func TestEmailService_SendReportBalanceLink(t *testing.T) {
+	sender := &MemorySender{}
+	service := &EmailService{Sender: sender, PublicURL: "http://localhost:3000/"}
+	require.NoError(t, service.LoadMessages())
+	account := dao.Account{AccountID: 42, Email: "test@example.com", Locale: "en-US"}
+
+	// without tokens the link is left out rather than exposing the account id
+	require.NoError(t, service.SendReport(account, BalanceReport{AccountID: 42}))
+	require.NotContains(t, sender.Last().HTML, "/balance")
+	require.NotContains(t, sender.Last().HTML, "Check full balance")
+
+	tokens := &BalanceTokens{Secret: []byte("secret")}
+	service.Tokens = tokens
+	require.NoError(t, service.SendReport(account, BalanceReport{AccountID: 42}))
+	require.Contains(t, sender.Last().HTML, "Check full balance")
+	require.Contains(t, sender.Last().HTML, `href="http://localhost:3000/balance?token=`)
+	require.Contains(t, sender.Last().Text, "Check your full balance at: http://localhost:3000/balance?token=")
+
+	_, link, _ := strings.Cut(sender.Last().HTML, "/balance?token=")
+	token, _, _ := strings.Cut(link, `"`)
+	accountID, err := tokens.Verify(token)
+	require.NoError(t, err)
+	require.Equal(t, int64(42), accountID)
+}
+
+func TestEmailService_SendReportStatement(t *testing.T) {
+	sender := &MemorySender{}
+	service := &EmailService{Sender: sender}
+	require.NoError(t, service.LoadMessages())
+	account := dao.Account{AccountID: 42, Email: "test@example.com", Locale: "es-MX"}
+
+	require.NoError(t, service.SendReport(account, BalanceReport{AccountID: 42}))
+	require.Equal(t, "test@example.com", sender.Last().To)
+	attachments := sender.Last().Attachments
+	require.Len(t, attachments, 1)
+	require.Equal(t, "estado-de-cuenta-42.pdf", attachments[0].Name)
+	require.Equal(t, "application/pdf", attachments[0].ContentType)
+	require.True(t, bytes.HasPrefix(attachments[0].Data, []byte("%PDF-1.4")))
+}
+
+func TestNewMessage(t *testing.T) {
+	var multipart bytes.Buffer
+	_, err := newMessage("reports@example.com", EmailMessage{To: "test@example.com", Subject: "Balance Report", Text: "Total balance: $10.00", HTML: "<p>Total balance: $10.00</p>"}, nil).WriteTo(&multipart)
+	require.NoError(t, err)
+	mime := multipart.String()
+	require.Contains(t, mime, "Content-Type: multipart/alternative;")
+	require.Contains(t, mime, "Content-Type: text/plain; charset=UTF-8")
+	require.Contains(t, mime, "Content-Type: text/html; charset=UTF-8")
+	require.Less(t, strings.Index(mime, "text/plain"), strings.Index(mime, "text/html"))
+
+	var html bytes.Buffer
+	_, err = newMessage("reports@example.com", EmailMessage{To: "test@example.com", Subject: "Balance Report", HTML: "<p>Total balance</p>"}, nil).WriteTo(&html)
+	require.NoError(t, err)
+	require.NotContains(t, html.String(), "multipart")
+	require.Contains(t, html.String(), "Content-Type: text/html; charset=UTF-8")
+
+	var mixed bytes.Buffer
+	_, err = newMessage("reports@example.com", EmailMessage{To: "test@example.com", Subject: "Balance Report", Text: "Total balance", HTML: "<p>Total balance</p>"},
+		[]EmailAttachment{{Name: "statement-1.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.4")}}).WriteTo(&mixed)
+	require.NoError(t, err)
+	mime = mixed.String()
+	require.Contains(t, mime, "Content-Type: multipart/mixed;")
+	require.Contains(t, mime, "Content-Type: multipart/alternative;")
+	require.Contains(t, mime, "Content-Type: application/pdf")
+	require.Contains(t, mime, `Content-Disposition: attachment; filename="statement-1.pdf"`)
+	require.Contains(t, mime, "JVBERi0xLjQ=") // base64 of the data
+}
+
+func TestSMTPSender(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	store := &mailcatcher.Store{}
+	server := &mailcatcher.Server{Store: store, Username: "user", Password: "secret"}
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+
+	sender := &SMTPSender{FromEmail: "reports@example.com", SMTPHost: "127.0.0.1", SMTPPort: listener.Addr().(*net.TCPAddr).Port, SMTPUser: "user", SMTPPass: "secret"}
+	require.NoError(t, sender.SendWithAttachments(EmailMessage{To: "john.doe@example.com", Subject: "Balance Report", Text: "Total balance: $10.00", HTML: "<p>Total balance: $10.00</p>"},
+		[]EmailAttachment{{Name: "statement-1.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.4")}}))
+
+	messages := store.Messages()
+	require.Len(t, messages, 1)
+	message := messages[0]
+	require.Equal(t, "reports@example.com", message.From)
+	require.Equal(t, []string{"john.doe@example.com"}, message.To)
+	require.Equal(t, "Balance Report", message.Subject)
+	require.True(t, message.TLS)
+	require.Equal(t, "user", message.Username)
+
+	header, err := message.Header()
+	require.NoError(t, err)
+	require.Equal(t, "1.0", header.Get("Mime-Version"))
+	require.True(t, strings.HasPrefix(header.Get("Content-Type"), "multipart/mixed;"))
+
+	parts, err := message.Parts()
+	require.NoError(t, err)
+	require.Equal(t, []mailcatcher.Part{
+		{ContentType: "text/plain", Size: 21, Body: []byte("Total balance: $10.00")},
+		{ContentType: "text/html", Size: 28, Body: []byte("<p>Total balance: $10.00</p>")},
+		{ContentType: "application/pdf", Filename: "statement-1.pdf", Size: 8, Body: []byte("%PDF-1.4")},
+	}, parts)
+
+	// the server refuses other credentials, which the sender reports
+	sender.SMTPPass = "wrong"
+	require.ErrorContains(t, sender.Send(EmailMessage{To: "john.doe@example.com", Subject: "Balance Report", Text: "Total balance: $10.00"}), "535")
+	require.Len(t, store.Messages(), 1)
+
+	// without a host nothing is sent, and the outbox keeps the email for a later attempt
+	sender.SMTPHost = ""
+	require.EqualError(t, sender.Send(EmailMessage{To: "john.doe@example.com", Subject: "Balance Report"}), "SMTP host not set")
+	require.Len(t, store.Messages(), 1)
 }
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrThresholdExceeded is returned when an import is aborted for rejecting too many rows.
+var ErrThresholdExceeded = errors.New("error threshold exceeded")
+
+// ErrorThreshold sets when an import must be aborted because of rejected rows, zero values disable each limit.
+// Rows stored before aborting are kept unless the import is atomic.
+type ErrorThreshold struct {
+	// FailFast aborts on the first rejected row.
+	FailFast bool
+
+	// MaxErrors aborts as soon as more than MaxErrors rows are rejected.
+	MaxErrors int64
+
+	// MaxErrorRatio aborts when the rejected rows over all read rows is above it, checked once the file is read.
+	MaxErrorRatio float64
+}
+
+// Check returns ErrThresholdExceeded when the rejected rows out of total pass any limit.
+func (t ErrorThreshold) Check(rejected, total int64) error {
+	if rejected == 0 {
+		return nil
+	}
+
+	if t.FailFast {
+		return fmt.Errorf("%w: fail fast on first rejected row", ErrThresholdExceeded)
+	}
+	if t.MaxErrors > 0 && rejected > t.MaxErrors {
+		return fmt.Errorf("%w: %d rejected rows, max %d", ErrThresholdExceeded, rejected, t.MaxErrors)
+	}
+	if t.MaxErrorRatio > 0 && total > 0 && float64(rejected)/float64(total) > t.MaxErrorRatio {
+		return fmt.Errorf("%w: %d of %d rows rejected, max ratio %.2f", ErrThresholdExceeded, rejected, total, t.MaxErrorRatio)
+	}
+	return nil
+}
+
+// rejectionCounter is shared by the workers of an import to cancel it as soon as a limit is passed.
+type rejectionCounter struct {
+	threshold ErrorThreshold
+	cancel    context.CancelFunc
+	rejected  atomic.Int64
+}
+
+// add counts rejected rows, the ratio is left out since it needs the whole file.
+func (c *rejectionCounter) add(n int) {
+	if c == nil || n == 0 {
+		return
+	}
+
+	rejected := c.rejected.Add(int64(n))
+	limits := ErrorThreshold{FailFast: c.threshold.FailFast, MaxErrors: c.threshold.MaxErrors}
+	if limits.Check(rejected, rejected) != nil {
+		c.cancel()
+	}
+}
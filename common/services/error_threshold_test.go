@@ -0,0 +1,35 @@
+package services
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestErrorThreshold_Check(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold ErrorThreshold
+		rejected  int64
+		total     int64
+		expectErr bool
+	}{
+		{"Disabled", ErrorThreshold{}, 10, 10, false},
+		{"No rejections", ErrorThreshold{FailFast: true}, 0, 10, false},
+		{"Fail fast", ErrorThreshold{FailFast: true}, 1, 10, true},
+		{"Below max errors", ErrorThreshold{MaxErrors: 2}, 2, 10, false},
+		{"Above max errors", ErrorThreshold{MaxErrors: 2}, 3, 10, true},
+		{"Below max ratio", ErrorThreshold{MaxErrorRatio: 0.5}, 5, 10, false},
+		{"Above max ratio", ErrorThreshold{MaxErrorRatio: 0.5}, 6, 10, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.threshold.Check(tc.rejected, tc.total)
+			if tc.expectErr {
+				require.ErrorIs(t, err, ErrThresholdExceeded)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"common/dao"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultCurrency is the currency of transactions whose statement doesn't tell one, and of the totals of accounts
+// without a reporting currency.
+const DefaultCurrency = "MXN"
+
+// ErrNoRate is returned by FX providers without a rate recorded for a pair of currencies.
+var ErrNoRate = errors.New("no exchange rate recorded")
+
+var rCurrency = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ParseCurrency validates an ISO 4217 currency code, returned in upper case.
+func ParseCurrency(code string) (string, error) {
+	currency := strings.ToUpper(strings.TrimSpace(code))
+	if !rCurrency.MatchString(currency) {
+		return "", fmt.Errorf("invalid currency: %q", code)
+	}
+
+	return currency, nil
+}
+
+// reportingCurrency returns the currency the totals of the account are reported in.
+func reportingCurrency(account dao.Account) string {
+	if account.ReportingCurrency.Valid && account.ReportingCurrency.String != "" {
+		return account.ReportingCurrency.String
+	}
+
+	return DefaultCurrency
+}
+
+// FXProvider gives the exchange rates used to convert totals into the reporting currency of an account.
+type FXProvider interface {
+	// Rate returns how many units of quote one unit of base is worth, as recorded on or before at. ErrNoRate is
+	// returned when there is none.
+	Rate(ctx context.Context, base, quote string, at time.Time) (decimal.Decimal, error)
+}
+
+// DatabaseRates reads the rates recorded in the fx_rates table.
+type DatabaseRates struct {
+	Database *sql.DB
+}
+
+func (r *DatabaseRates) Rate(ctx context.Context, base, quote string, at time.Time) (decimal.Decimal, error) {
+	queries := dao.New(r.Database)
+	return rateOf(base, quote, func(base, quote string) (decimal.Decimal, error) {
+		rate, err := queries.GetFXRate(ctx, dao.GetFXRateParams{Base: base, Quote: quote, EffectiveAt: at})
+		if errors.Is(err, sql.ErrNoRows) {
+			return decimal.Zero, ErrNoRate
+		}
+		return rate, err
+	})
+}
+
+// FXRate is the rate of a pair of currencies from a day on.
+type FXRate struct {
+	Base        string
+	Quote       string
+	Rate        decimal.Decimal
+	EffectiveAt time.Time
+}
+
+// FileRates holds rates read from a local file with LoadFileRates.
+type FileRates struct {
+	Rates []FXRate
+}
+
+// LoadFileRates reads rates from a CSV file with the base,quote,rate,effective_at header, dates are YYYY-MM-DD.
+func LoadFileRates(reader io.Reader) (*FileRates, error) {
+	records, err := csv.NewReader(reader).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error reading rates: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, errors.New("error reading rates: empty file")
+	}
+
+	rates := &FileRates{}
+	for i, record := range records[1:] {
+		line := i + 2
+		if len(record) != 4 {
+			return nil, fmt.Errorf("line %d: expected base,quote,rate,effective_at", line)
+		}
+
+		base, err := ParseCurrency(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		quote, err := ParseCurrency(record[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rate, err := decimal.NewFromString(strings.TrimSpace(record[2]))
+		if err != nil || !rate.IsPositive() {
+			return nil, fmt.Errorf("line %d: invalid rate: %q", line, record[2])
+		}
+		effectiveAt, err := time.Parse(time.DateOnly, strings.TrimSpace(record[3]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date: %q", line, record[3])
+		}
+
+		rates.Rates = append(rates.Rates, FXRate{Base: base, Quote: quote, Rate: rate, EffectiveAt: effectiveAt})
+	}
+
+	// the latest rate first, so the first match is the one in effect
+	sort.SliceStable(rates.Rates, func(i, j int) bool {
+		return rates.Rates[i].EffectiveAt.After(rates.Rates[j].EffectiveAt)
+	})
+	return rates, nil
+}
+
+func (r *FileRates) Rate(_ context.Context, base, quote string, at time.Time) (decimal.Decimal, error) {
+	return rateOf(base, quote, func(base, quote string) (decimal.Decimal, error) {
+		for _, rate := range r.Rates {
+			if rate.Base == base && rate.Quote == quote && !rate.EffectiveAt.After(at) {
+				return rate.Rate, nil
+			}
+		}
+		return decimal.Zero, ErrNoRate
+	})
+}
+
+// rateOf looks up the rate of a pair, falling back to the inverse of the opposite pair.
+func rateOf(base, quote string, lookup func(base, quote string) (decimal.Decimal, error)) (decimal.Decimal, error) {
+	if base == quote {
+		return decimal.NewFromInt(1), nil
+	}
+
+	rate, err := lookup(base, quote)
+	if !errors.Is(err, ErrNoRate) {
+		return rate, err
+	}
+
+	inverse, err := lookup(quote, base)
+	if errors.Is(err, ErrNoRate) {
+		return decimal.Zero, fmt.Errorf("%w: %s to %s", ErrNoRate, base, quote)
+	} else if err != nil {
+		return decimal.Zero, err
+	}
+	return decimal.NewFromInt(1).DivRound(inverse, 10), nil
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"bytes"
+	"common/money"
+	"context"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestParseCurrency(t *testing.T) {
+	currency, err := ParseCurrency(" usd ")
+	require.NoError(t, err)
+	require.Equal(t, "USD", currency)
+
+	for _, code := range []string{"", "US", "US$", "USDT"} {
+		_, err := ParseCurrency(code)
+		require.Error(t, err, code)
+	}
+}
+
+func TestFileRates_Rate(t *testing.T) {
+	content := "base,quote,rate,effective_at\n" +
+		"USD,MXN,17.00,2024-01-01\n" +
+		"USD,MXN,17.50,2024-02-01\n" +
+		"MXN,EUR,0.05,2024-01-01\n"
+	rates, err := LoadFileRates(bytes.NewBufferString(content))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		base  string
+		quote string
+		at    time.Time
+		rate  string
+	}{
+		{"Same currency", "EUR", "EUR", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), "1"},
+		{"First rate", "USD", "MXN", time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC), "17"},
+		{"Latest rate", "USD", "MXN", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), "17.5"},
+		{"Inverse rate", "EUR", "MXN", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), "20"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rate, err := rates.Rate(ctx, tc.base, tc.quote, tc.at)
+			require.NoError(t, err)
+			require.Equal(t, tc.rate, rate.String())
+		})
+	}
+
+	_, err = rates.Rate(ctx, "USD", "MXN", time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC))
+	require.ErrorIs(t, err, ErrNoRate)
+	_, err = rates.Rate(ctx, "USD", "EUR", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	require.ErrorIs(t, err, ErrNoRate)
+}
+
+func TestLoadFileRates_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"Empty", "", "empty file"},
+		{"Invalid currency", "base,quote,rate,effective_at\nUS,MXN,17,2024-01-01\n", `line 2: invalid currency: "US"`},
+		{"Invalid rate", "base,quote,rate,effective_at\nUSD,MXN,-1,2024-01-01\n", `line 2: invalid rate: "-1"`},
+		{"Invalid date", "base,quote,rate,effective_at\nUSD,MXN,17,01/01/2024\n", `line 2: invalid date: "01/01/2024"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadFileRates(bytes.NewBufferString(tc.content))
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestBalanceReport_Summarize(t *testing.T) {
+	rates := &FileRates{Rates: []FXRate{
+		{Base: "USD", Quote: "MXN", Rate: decimal.RequireFromString("17.5"), EffectiveAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}}
+	report := BalanceReport{
+		Currency: "MXN",
+		Currencies: map[string]CurrencyTotals{
+			"MXN": {TotalCredit: money.New(decimal.NewFromInt(100), "MXN"), CountCredit: 1},
+			"USD": {TotalCredit: money.New(decimal.NewFromInt(10), "USD"), CountCredit: 1, TotalDebit: money.New(decimal.NewFromInt(2), "USD"), CountDebit: 1},
+			"EUR": {TotalDebit: money.New(decimal.NewFromInt(5), "EUR"), CountDebit: 1},
+		},
+	}
+
+	report.summarize(context.Background(), rates, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))
+	require.Equal(t, "275.00 MXN", report.TotalCredit.String())
+	require.Equal(t, "35.00 MXN", report.TotalDebit.String())
+	require.Equal(t, "240.00 MXN", report.TotalBalance.String())
+	require.Equal(t, int64(2), report.CountCredit)
+	require.Equal(t, int64(1), report.CountDebit)
+	require.Equal(t, "8.00 USD", report.Currencies["USD"].TotalBalance.String())
+	require.Equal(t, []string{"EUR"}, report.Unconverted)
+	require.Equal(t, map[string]AppliedRate{
+		"USD": {Rate: decimal.RequireFromString("17.5"), At: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}, report.Rates)
+
+	// without rates only the transactions in the currency of the report are totaled
+	report.summarize(context.Background(), nil, time.Now())
+	require.Equal(t, "100.00 MXN", report.TotalBalance.String())
+	require.Equal(t, []string{"EUR", "USD"}, report.Unconverted)
+	require.Nil(t, report.Rates)
+}
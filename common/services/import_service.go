@@ -0,0 +1,198 @@
+package services
+
+import (
+	"common/dao"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// finishTimeout bounds recording the outcome of an import, which happens even when the import was interrupted.
+const finishTimeout = 5 * time.Second
+
+// ImportService records every import of a statement in the imports table: which file, for which account, when and
+// with what result.
+type ImportService struct {
+	Database *sql.DB
+}
+
+// Checksum returns the SHA-256 of a statement, hex encoded, and rewinds it so it can be read again.
+func Checksum(reader io.ReadSeeker) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", err
+	}
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ImportFile imports a statement with TransactionService.ImportFile, recording it as running before and with its
+// outcome after. Stored transactions are linked to the import. The results of TransactionService.ImportFile are
+// returned as they are, along with the recorded import.
+func (s *ImportService) ImportFile(ctx context.Context, transactions TransactionService, account dao.Account, parser StatementParser, format StatementFormat, checksum string) (dao.Import, dao.Account, BalanceReport, []Rejection, error) {
+	imp, err := s.Start(ctx, account, transactions.Source, format, checksum)
+	if err != nil {
+		return imp, account, BalanceReport{}, nil, err
+	}
+
+	transactions.ImportID = imp.ImportID
+	account, report, rejections, importErr := transactions.ImportFile(ctx, account, parser)
+
+	// interrupted imports are recorded too, so the context may already be done
+	finishCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), finishTimeout)
+	defer cancel()
+	finished, err := s.Finish(finishCtx, imp, report, rejections, importErr)
+	if err != nil && importErr == nil {
+		return imp, account, report, rejections, fmt.Errorf("error recording import %d: %w", imp.ImportID, err)
+	} else if err != nil {
+		log.Printf("error recording import %d: %s", imp.ImportID, err)
+		return imp, account, report, rejections, importErr
+	}
+	return finished, account, report, rejections, importErr
+}
+
+// ImportBatch imports a multi-account statement with TransactionService.ImportBatch, recording an import of the file
+// for every account as its first row is routed, and finishing each one with the report of its account. Stored
+// transactions are linked to the import of their account. Duplicates and rejections are only counted in the summary,
+// rejected rows may not belong to any account. The results of TransactionService.ImportBatch are returned as they
+// are, with the import of each account in its report.
+func (s *ImportService) ImportBatch(ctx context.Context, transactions TransactionService, resolver AccountResolver, parser StatementParser, format StatementFormat, checksum string) (BatchReport, []Rejection, error) {
+	imports := &batchImports{service: s, source: transactions.Source, format: format, checksum: checksum, imports: make(map[int64]dao.Import)}
+	transactions.imports = imports
+	batch, rejections, importErr := transactions.ImportBatch(ctx, resolver, parser)
+
+	reports := make(map[int64]BalanceReport, len(batch.Accounts))
+	for _, account := range batch.Accounts {
+		reports[account.Report.AccountID] = account.Report
+	}
+
+	// interrupted imports are recorded too, so the context may already be done
+	finishCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), finishTimeout)
+	defer cancel()
+	var finishErr error
+	for _, imp := range imports.ordered {
+		if _, err := s.Finish(finishCtx, imp, reports[imp.AccountID], nil, importErr); err != nil {
+			log.Printf("error recording import %d: %s", imp.ImportID, err)
+			if finishErr == nil {
+				finishErr = fmt.Errorf("error recording import %d: %w", imp.ImportID, err)
+			}
+		}
+	}
+	if finishErr != nil && importErr == nil {
+		return batch, rejections, finishErr
+	}
+	return batch, rejections, importErr
+}
+
+// batchImports records the imports of a multi-account statement, one per account.
+type batchImports struct {
+	service  *ImportService
+	source   string
+	format   StatementFormat
+	checksum string
+	imports  map[int64]dao.Import
+	ordered  []dao.Import
+}
+
+// start records a running import for the account, once per account. Batches imported without ImportService record
+// nothing.
+func (b *batchImports) start(ctx context.Context, account dao.Account) error {
+	if b == nil {
+		return nil
+	}
+	if _, ok := b.imports[account.AccountID]; ok {
+		return nil
+	}
+
+	imp, err := b.service.Start(ctx, account, b.source, b.format, b.checksum)
+	if err != nil {
+		return err
+	}
+	b.imports[account.AccountID] = imp
+	b.ordered = append(b.ordered, imp)
+	return nil
+}
+
+// importID returns the import recorded for the account, 0 without one.
+func (b *batchImports) importID(accountID int64) int64 {
+	if b == nil {
+		return 0
+	}
+
+	return b.imports[accountID].ImportID
+}
+
+// Start records a running import of a statement for the account.
+func (s *ImportService) Start(ctx context.Context, account dao.Account, source string, format StatementFormat, checksum string) (dao.Import, error) {
+	now := time.Now()
+	return dao.New(s.Database).CreateImport(ctx, dao.CreateImportParams{
+		AccountID: account.AccountID,
+		Source:    source,
+		Checksum:  checksum,
+		Format:    string(format),
+		Status:    dao.ImportStatusRunning,
+		StartedAt: now,
+		CreatedAt: sql.NullTime{Valid: true, Time: now},
+		UpdatedAt: sql.NullTime{Valid: true, Time: now},
+	})
+}
+
+// Finish records the outcome of an import given the results of TransactionService.ImportFile. The report is only
+// stored for completed and incomplete imports, failed ones keep the error and the count of rejected rows.
+func (s *ImportService) Finish(ctx context.Context, imp dao.Import, report BalanceReport, rejections []Rejection, importErr error) (dao.Import, error) {
+	params := dao.FinishImportParams{
+		Status:        dao.ImportStatusCompleted,
+		CountRejected: int64(len(rejections)),
+		Report:        json.RawMessage("{}"),
+		ImportID:      imp.ImportID,
+	}
+	switch {
+	case importErr == nil:
+	case errors.Is(importErr, ErrImportIncomplete):
+		params.Status = dao.ImportStatusIncomplete
+		params.Error = importErr.Error()
+	default:
+		params.Status = dao.ImportStatusFailed
+		params.Error = importErr.Error()
+	}
+
+	if params.Status != dao.ImportStatusFailed {
+		jsonData, err := json.Marshal(report)
+		if err != nil {
+			return imp, err
+		}
+		params.Report = jsonData
+		params.CountAccepted = report.CountCredit + report.CountDebit
+		params.CountDuplicate = report.CountDuplicate
+	}
+
+	now := time.Now()
+	params.FinishedAt = sql.NullTime{Valid: true, Time: now}
+	params.UpdatedAt = sql.NullTime{Valid: true, Time: now}
+	return dao.New(s.Database).FinishImport(ctx, params)
+}
+
+// GetImport returns an import by id, sql.ErrNoRows is returned for unknown imports.
+func (s *ImportService) GetImport(ctx context.Context, importID int64) (dao.Import, error) {
+	return dao.New(s.Database).GetImport(ctx, importID)
+}
+
+// ListImports returns the latest imports of an account, most recent first.
+func (s *ImportService) ListImports(ctx context.Context, accountID int64, limit int32) ([]dao.Import, error) {
+	return dao.New(s.Database).ListImportsByAccount(ctx, dao.ListImportsByAccountParams{AccountID: accountID, Limit: limit})
+}
+
+// PreviousImports returns the imports of the same file for an account, by checksum, most recent first.
+func (s *ImportService) PreviousImports(ctx context.Context, accountID int64, checksum string) ([]dao.Import, error) {
+	return dao.New(s.Database).ListImportsByChecksum(ctx, dao.ListImportsByChecksumParams{AccountID: accountID, Checksum: checksum})
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"bytes"
+	"common/dao"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+var accountColumns = []string{"account_id", "first_name", "last_name", "email", "locale", "total_balance", "avg_debit_amount", "avg_credit_amount", "last_balance_at", "created_at", "updated_at", "reporting_currency", "deactivated_at", "merged_into"}
+var totalsColumns = []string{"currency", "total_credit", "count_credit", "total_debit", "count_debit"}
+var snapshotColumns = []string{"snapshot_id", "account_id", "total_balance", "report", "created_at"}
+var importColumns = []string{"import_id", "account_id", "source", "checksum", "format", "status", "count_accepted", "count_rejected", "count_duplicate", "error", "report", "started_at", "finished_at", "created_at", "updated_at"}
+
+func TestChecksum(t *testing.T) {
+	reader := strings.NewReader("ID,DATE,AMOUNT\n")
+	checksum, err := Checksum(reader)
+	require.NoError(t, err)
+	require.Equal(t, "f0153569adce045da646d548325f598f93b1c43e166cd6bd9796ca834597d86d", checksum)
+
+	// the reader is rewound
+	content, err := csv.NewReader(reader).Read()
+	require.NoError(t, err)
+	require.Equal(t, []string{"ID", "DATE", "AMOUNT"}, content)
+}
+
+func TestImportService_ImportFile(t *testing.T) {
+	account := dao.Account{AccountID: 1}
+	startedAt := time.Now()
+	csvContent := "ID,DATE,AMOUNT\n1,2024-01-01,+1.5\n2,someday,+1"
+
+	t.Run("Completed", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		service := &ImportService{Database: db}
+		transactions := TransactionService{Database: db, Workers: 1, BatchSize: 1, Source: "statement.csv"}
+
+		mock.ExpectQuery(`INSERT INTO imports`).WithArgs(int64(1), "statement.csv", "abc", "csv", dao.ImportStatusRunning, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(importColumns).AddRow(7, 1, "statement.csv", "abc", "csv", "running", 0, 0, 0, "", []byte("{}"), startedAt, nil, startedAt, startedAt))
+		mock.ExpectQuery(`INSERT INTO transactions`).WithArgs(1, "1", "statement.csv", "credit", sqlmock.AnyArg(), "MXN", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), int64(7)).
+			WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(1))
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM transactions`).WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows(totalsColumns).AddRow("MXN", "1.5", 1, "0", 0))
+		mock.ExpectQuery(`SELECT to_char`).WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"year_month", "count"}).AddRow("2024-01", 1))
+		mock.ExpectQuery(`UPDATE accounts`).WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(1, "John", "Doe", "john.doe@example.com", "es-MX", "1.5", "0", "1.5", nil, nil, nil, nil, nil, nil))
+		mock.ExpectQuery(`INSERT INTO balance_snapshots`).WillReturnRows(sqlmock.NewRows(snapshotColumns).AddRow(1, 1, "1.5", []byte("{}"), startedAt))
+		mock.ExpectCommit()
+		mock.ExpectQuery(`UPDATE imports`).WithArgs(dao.ImportStatusCompleted, int64(1), int64(1), int64(0), "", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), int64(7)).
+			WillReturnRows(sqlmock.NewRows(importColumns).AddRow(7, 1, "statement.csv", "abc", "csv", "completed", 1, 1, 0, "", []byte(`{"count_credit":1}`), startedAt, time.Now(), startedAt, time.Now()))
+
+		parser := &CSVParser{Reader: csv.NewReader(bytes.NewBufferString(csvContent))}
+		imp, updated, report, rejections, err := service.ImportFile(context.Background(), transactions, account, parser, FormatCSV, "abc")
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, int64(7), imp.ImportID)
+		require.Equal(t, dao.ImportStatusCompleted, imp.Status)
+		require.Equal(t, "1.5", updated.TotalBalance.Decimal.String())
+		require.Equal(t, int64(1), report.CountCredit)
+		require.Len(t, rejections, 1)
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		service := &ImportService{Database: db}
+		transactions := TransactionService{Database: db, Workers: 1, BatchSize: 1, Threshold: ErrorThreshold{FailFast: true}}
+
+		mock.ExpectQuery(`INSERT INTO imports`).
+			WillReturnRows(sqlmock.NewRows(importColumns).AddRow(8, 1, "", "abc", "csv", "running", 0, 0, 0, "", []byte("{}"), startedAt, nil, startedAt, startedAt))
+		mock.MatchExpectationsInOrder(false)
+		mock.ExpectQuery(`INSERT INTO transactions`).WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(1))
+		mock.ExpectQuery(`UPDATE imports`).WithArgs(dao.ImportStatusFailed, int64(0), int64(1), int64(0), sqlmock.AnyArg(), json.RawMessage("{}"), sqlmock.AnyArg(), sqlmock.AnyArg(), int64(8)).
+			WillReturnRows(sqlmock.NewRows(importColumns).AddRow(8, 1, "", "abc", "csv", "failed", 0, 1, 0, "too many rejected rows", []byte("{}"), startedAt, time.Now(), startedAt, time.Now()))
+
+		parser := &CSVParser{Reader: csv.NewReader(bytes.NewBufferString(csvContent))}
+		imp, _, _, rejections, err := service.ImportFile(context.Background(), transactions, account, parser, FormatCSV, "abc")
+		require.ErrorIs(t, err, ErrThresholdExceeded)
+		require.Equal(t, dao.ImportStatusFailed, imp.Status)
+		require.Len(t, rejections, 1)
+	})
+
+	t.Run("Interrupted", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		service := &ImportService{Database: db}
+		transactions := TransactionService{Database: db, Workers: 1, BatchSize: 1}
+		ctx, cancel := context.WithCancel(context.Background())
+
+		mock.ExpectQuery(`INSERT INTO imports`).
+			WillReturnRows(sqlmock.NewRows(importColumns).AddRow(9, 1, "", "abc", "csv", "running", 0, 0, 0, "", []byte("{}"), startedAt, nil, startedAt, startedAt))
+		mock.ExpectQuery(`UPDATE imports`).WithArgs(dao.ImportStatusIncomplete, int64(0), int64(0), int64(0), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), int64(9)).
+			WillReturnRows(sqlmock.NewRows(importColumns).AddRow(9, 1, "", "abc", "csv", "incomplete", 0, 0, 0, "import incomplete", []byte(`{"incomplete":true}`), startedAt, time.Now(), startedAt, time.Now()))
+
+		// the import is recorded as incomplete even though its context is done
+		parser := &cancellingParser{count: 1, cancelAt: 0, cancel: cancel}
+		imp, _, report, _, err := service.ImportFile(ctx, transactions, account, parser, FormatCSV, "abc")
+		require.ErrorIs(t, err, ErrImportIncomplete)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.True(t, report.Incomplete)
+		require.Equal(t, dao.ImportStatusIncomplete, imp.Status)
+	})
+
+	t.Run("StartError", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		service := &ImportService{Database: db}
+
+		mock.ExpectQuery(`INSERT INTO imports`).WillReturnError(errors.New("db_error"))
+
+		_, _, _, _, err = service.ImportFile(context.Background(), TransactionService{Database: db}, account, &CSVParser{}, FormatCSV, "abc")
+		require.ErrorContains(t, err, "db_error")
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestImportService_ImportBatch(t *testing.T) {
+	profile, err := LoadCSVProfile("aggregator")
+	require.NoError(t, err)
+	content := "Reference,Date,Amount,Currency,Description,Account\n" +
+		"1,2024-01-10,100.00,MXN,Payroll,ana@example.com\n" +
+		"2,2024-01-11,-10.00,MXN,Coffee,2\n"
+	startedAt := time.Now()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	service := &ImportService{Database: db}
+	transactions := TransactionService{Database: db, Workers: 1, BatchSize: 1, Source: "feed.csv"}
+	resolver := &mapResolver{
+		accounts: map[string]dao.Account{
+			"ana@example.com": {AccountID: 1, Email: "ana@example.com"},
+			"2":               {AccountID: 2, Email: "bob@example.com"},
+		},
+		resolved: make(map[string]int),
+	}
+
+	// rows are stored while the next accounts are resolved
+	mock.MatchExpectationsInOrder(false)
+	for _, row := range [][]any{{int64(1), int64(7), "1", "credit"}, {int64(2), int64(8), "2", "debit"}} {
+		accountID, importID := row[0].(int64), row[1].(int64)
+		mock.ExpectQuery(`INSERT INTO imports`).WithArgs(accountID, "feed.csv", "abc", "csv", dao.ImportStatusRunning, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(importColumns).AddRow(importID, accountID, "feed.csv", "abc", "csv", "running", 0, 0, 0, "", []byte("{}"), startedAt, nil, startedAt, startedAt))
+		mock.ExpectQuery(`INSERT INTO transactions`).WithArgs(accountID, row[2], "feed.csv", row[3], sqlmock.AnyArg(), "MXN", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), importID).
+			WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(importID))
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM transactions`).WithArgs(accountID).WillReturnRows(sqlmock.NewRows(totalsColumns))
+		mock.ExpectQuery(`SELECT to_char`).WithArgs(accountID).WillReturnRows(sqlmock.NewRows([]string{"year_month", "count"}))
+		mock.ExpectQuery(`UPDATE accounts`).WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(accountID, "", "", "", "es-MX", "0", "0", "0", time.Now(), nil, nil, nil, nil, nil))
+		mock.ExpectQuery(`INSERT INTO balance_snapshots`).WillReturnRows(sqlmock.NewRows(snapshotColumns).AddRow(accountID, accountID, "0", []byte("{}"), time.Now()))
+		mock.ExpectCommit()
+		mock.ExpectQuery(`UPDATE imports`).WithArgs(dao.ImportStatusCompleted, int64(1), int64(0), int64(0), "", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), importID).
+			WillReturnRows(sqlmock.NewRows(importColumns).AddRow(importID, accountID, "feed.csv", "abc", "csv", "completed", 1, 0, 0, "", []byte("{}"), startedAt, time.Now(), startedAt, time.Now()))
+	}
+
+	parser := &CSVParser{Reader: csv.NewReader(bytes.NewBufferString(content)), Profile: &profile}
+	batch, rejections, err := service.ImportBatch(context.Background(), transactions, resolver, parser, FormatCSV, "abc")
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Empty(t, rejections)
+	require.Len(t, batch.Accounts, 2)
+	require.Equal(t, int64(7), batch.Accounts[0].ImportID)
+	require.Equal(t, int64(8), batch.Accounts[1].ImportID)
+	require.Equal(t, []string{"balance_report/import/7/account/1", "balance_report/import/8/account/2"}, batch.ReportKeys())
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"bufio"
+	"common/dao"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	rMT940Tag       = regexp.MustCompile(`^:(\d{2}[A-Z]?):(.*)$`)
+	rMT940Statement = regexp.MustCompile(`^(\d{6})(\d{4})?(R?[CD])([A-Z])?(\d+,\d*)([A-Z][A-Z0-9]{3})(.*?)(?://(.*))?$`)
+	rMT940Balance   = regexp.MustCompile(`^[CD]\d{6}([A-Z]{3})`)
+)
+
+// mt940Tag is a field of an MT940 message, along with the line it starts at.
+type mt940Tag struct {
+	line  int
+	name  string
+	value string
+}
+
+// MT940Parser reads the statement lines (:61:) of SWIFT MT940 messages, along with the information to the account
+// owner (:86:) that follows each of them. Amounts are in the currency of the opening balance (:60F: or :60M:) of
+// their statement.
+type MT940Parser struct {
+	Reader io.Reader
+
+	tags     []mt940Tag
+	read     bool
+	ids      syntheticIDs
+	currency string
+}
+
+// Next returns the next statement line of the messages.
+func (p *MT940Parser) Next() (StatementTransaction, error) {
+	if !p.read {
+		if err := p.readTags(); err != nil {
+			return StatementTransaction{}, err
+		}
+		p.ids = make(syntheticIDs)
+		p.read = true
+	}
+
+	for len(p.tags) > 0 {
+		tag := p.tags[0]
+		p.tags = p.tags[1:]
+		if tag.name == "60F" || tag.name == "60M" {
+			if match := rMT940Balance.FindStringSubmatch(tag.value); match != nil {
+				p.currency = match[1]
+			}
+		}
+		if tag.name != "61" {
+			continue
+		}
+
+		var description string
+		if len(p.tags) > 0 && p.tags[0].name == "86" {
+			description = strings.ReplaceAll(p.tags[0].value, "\n", " ")
+			p.tags = p.tags[1:]
+		}
+
+		// the first line holds the fields, a second one may hold supplementary details
+		statementLine, _, _ := strings.Cut(tag.value, "\n")
+		record := CSVRecord{statementLine}
+		transaction, err := p.parseStatementLine(statementLine, description)
+		transaction.Line = tag.line
+		transaction.Record = record
+		var recordErr *RecordError
+		if errors.As(err, &recordErr) {
+			recordErr.Line = tag.line
+			recordErr.Record = record
+		}
+		return transaction, err
+	}
+
+	return StatementTransaction{}, io.EOF
+}
+
+// readTags splits the messages into tags, lines that don't start a tag continue the previous one.
+func (p *MT940Parser) readTags() error {
+	scanner := bufio.NewScanner(p.Reader)
+	number := 0
+	for scanner.Scan() {
+		number += 1
+		line := strings.TrimRight(scanner.Text(), "\r ")
+		if match := rMT940Tag.FindStringSubmatch(line); match != nil {
+			p.tags = append(p.tags, mt940Tag{line: number, name: match[1], value: match[2]})
+		} else if line == "-" || line == "-}" || strings.HasPrefix(line, "{") {
+			// end of message or block headers
+			p.tags = append(p.tags, mt940Tag{line: number})
+		} else if len(p.tags) > 0 && line != "" {
+			p.tags[len(p.tags)-1].value += "\n" + line
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading statement: %w", err)
+	}
+	return nil
+}
+
+// parseStatementLine parses a :61: field like 2401310131D100,00NTRFREF123//BANKREF, RC and RD mark reversals of
+// credits and debits.
+func (p *MT940Parser) parseStatementLine(statementLine, description string) (StatementTransaction, error) {
+	match := rMT940Statement.FindStringSubmatch(statementLine)
+	if match == nil {
+		return StatementTransaction{}, &RecordError{Reason: fmt.Sprintf("invalid statement line: %s", statementLine)}
+	}
+
+	performedAt, err := time.Parse("060102", match[1])
+	if err != nil {
+		return StatementTransaction{}, &RecordError{Field: "date", Reason: fmt.Sprintf("invalid date: %q", match[1])}
+	}
+
+	amount, err := decimal.NewFromString(strings.Replace(match[5], ",", ".", 1))
+	if err != nil {
+		return StatementTransaction{}, &RecordError{Field: "amount", Reason: fmt.Sprintf("invalid transaction amount: %s", match[5])}
+	}
+
+	operation := dao.TxOperationTypeCredit
+	if match[3] == "D" || match[3] == "RC" {
+		operation = dao.TxOperationTypeDebit
+	}
+
+	// the bank reference identifies an entry, the customer one may be NONREF or repeat
+	id := strings.TrimSpace(match[8])
+	if id == "" {
+		id = p.ids.next(statementLine, description)
+	}
+
+	currency := p.currency
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+
+	return StatementTransaction{
+		ExternalID:  id,
+		PerformedAt: performedAt,
+		Operation:   operation,
+		Amount:      amount,
+		Currency:    currency,
+		Description: description,
+	}, nil
+}
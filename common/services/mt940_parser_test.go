@@ -0,0 +1,60 @@
+package services
+
+import (
+	"bytes"
+	"common/dao"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestMT940Parser_Next(t *testing.T) {
+	content := `:20:STATEMENT
+:25:12345678/0001234567
+:28C:00001/001
+:60F:C240130EUR1000,00
+:61:2401310131C1500,25NTRFNONREF//BANK1
+:86:Payroll
+January
+:61:240201D20,50NMSCNONREF
+:86:Coffee
+:61:240202RD5,NTRFREF3//BANK3
+:61:2402XXD5,NTRF
+:62F:C240202EUR2484,75
+-
+`
+	parser := MT940Parser{Reader: bytes.NewBufferString(content)}
+
+	transaction, err := parser.Next()
+	require.NoError(t, err)
+	require.Equal(t, 5, transaction.Line)
+	require.Equal(t, "BANK1", transaction.ExternalID)
+	require.Equal(t, time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC), transaction.PerformedAt)
+	require.Equal(t, dao.TxOperationTypeCredit, transaction.Operation)
+	require.Equal(t, "1500.25", transaction.Amount.String())
+	require.Equal(t, "EUR", transaction.Currency)
+	require.Equal(t, "Payroll January", transaction.Description)
+
+	// no bank reference, the id is synthetic
+	transaction, err = parser.Next()
+	require.NoError(t, err)
+	require.NotEmpty(t, transaction.ExternalID)
+	require.Equal(t, dao.TxOperationTypeDebit, transaction.Operation)
+	require.Equal(t, "20.5", transaction.Amount.String())
+	require.Equal(t, "Coffee", transaction.Description)
+
+	// reversal of a debit
+	transaction, err = parser.Next()
+	require.NoError(t, err)
+	require.Equal(t, "BANK3", transaction.ExternalID)
+	require.Equal(t, dao.TxOperationTypeCredit, transaction.Operation)
+
+	_, err = parser.Next()
+	var recordErr *RecordError
+	require.ErrorAs(t, err, &recordErr)
+	require.Equal(t, Rejection{Line: 11, Record: CSVRecord{"2402XXD5,NTRF"}, Reason: "invalid statement line: 2402XXD5,NTRF"}, recordErr.Rejection())
+
+	_, err = parser.Next()
+	require.ErrorIs(t, err, io.EOF)
+}
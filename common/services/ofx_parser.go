@@ -0,0 +1,126 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	rOFXTransaction = regexp.MustCompile(`(?is)<STMTTRN>(.*?)</STMTTRN>`)
+	rOFXElement     = regexp.MustCompile(`(?i)<([A-Z0-9.]+)>([^<\r\n]*)`)
+	rOFXCurrency    = regexp.MustCompile(`(?i)<CURDEF>\s*([A-Z]{3})`)
+)
+
+// OFXParser reads the transactions of OFX and QFX statements, both the SGML (1.x) and XML (2.x) flavours, where
+// aggregates are closed but elements may not be. Amounts are signed: positive for credit and negative for debit, in the
+// CURDEF of their statement unless a CURRENCY aggregate tells otherwise.
+type OFXParser struct {
+	Reader io.Reader
+
+	content []byte
+	matches [][]int
+	read    bool
+}
+
+// Next returns the next STMTTRN aggregate of the statement.
+func (p *OFXParser) Next() (StatementTransaction, error) {
+	if !p.read {
+		content, err := io.ReadAll(p.Reader)
+		if err != nil {
+			return StatementTransaction{}, fmt.Errorf("error reading statement: %w", err)
+		}
+
+		p.content = content
+		p.matches = rOFXTransaction.FindAllSubmatchIndex(content, -1)
+		p.read = true
+	}
+
+	if len(p.matches) == 0 {
+		return StatementTransaction{}, io.EOF
+	}
+	match := p.matches[0]
+	p.matches = p.matches[1:]
+
+	elements := make(map[string]string)
+	for _, element := range rOFXElement.FindAllSubmatch(p.content[match[2]:match[3]], -1) {
+		elements[strings.ToUpper(string(element[1]))] = strings.TrimSpace(string(element[2]))
+	}
+
+	// the default currency of the statement comes before its transactions
+	if _, ok := elements["CURSYM"]; !ok {
+		elements["CURSYM"] = DefaultCurrency
+		if currencies := rOFXCurrency.FindAllSubmatch(p.content[:match[0]], -1); len(currencies) > 0 {
+			elements["CURSYM"] = string(currencies[len(currencies)-1][1])
+		}
+	}
+
+	transaction, err := parseOFXTransaction(elements)
+	transaction.Line = lineAt(p.content, match[0])
+	transaction.Record = CSVRecord{elements["FITID"], elements["DTPOSTED"], elements["TRNAMT"]}
+	var recordErr *RecordError
+	if errors.As(err, &recordErr) {
+		recordErr.Line = transaction.Line
+		recordErr.Record = transaction.Record
+	}
+	return transaction, err
+}
+
+// parseOFXTransaction extracts a transaction from the elements of a STMTTRN aggregate.
+func parseOFXTransaction(elements map[string]string) (StatementTransaction, error) {
+	id := elements["FITID"]
+	if id == "" {
+		return StatementTransaction{}, &RecordError{Field: "id", Reason: "missing FITID"}
+	}
+
+	performedAt, err := parseOFXDate(elements["DTPOSTED"])
+	if err != nil {
+		return StatementTransaction{}, &RecordError{Field: "date", Reason: err.Error()}
+	}
+
+	// some banks use a decimal comma
+	amount, err := decimal.NewFromString(strings.Replace(elements["TRNAMT"], ",", ".", 1))
+	if err != nil {
+		return StatementTransaction{}, &RecordError{Field: "amount", Reason: fmt.Sprintf("invalid transaction amount: %s", elements["TRNAMT"])}
+	}
+	operation, amount := operationOf(amount)
+
+	currency, err := ParseCurrency(elements["CURSYM"])
+	if err != nil {
+		return StatementTransaction{}, &RecordError{Field: "currency", Reason: err.Error()}
+	}
+
+	description := elements["NAME"]
+	if memo := elements["MEMO"]; memo != "" && description != "" {
+		description += " - " + memo
+	} else if memo != "" {
+		description = memo
+	}
+
+	return StatementTransaction{
+		ExternalID:  id,
+		PerformedAt: performedAt,
+		Operation:   operation,
+		Amount:      amount,
+		Currency:    currency,
+		Description: description,
+	}, nil
+}
+
+// parseOFXDate parses the date part of an OFX datetime like 20240131120000.000[-6:CST], the time and zone are
+// ignored like they are for every other format.
+func parseOFXDate(value string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("invalid date: %q", value)
+	}
+
+	date, err := time.Parse("20060102", value[:8])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date: %q", value)
+	}
+	return date, nil
+}
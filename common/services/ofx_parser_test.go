@@ -0,0 +1,69 @@
+package services
+
+import (
+	"bytes"
+	"common/dao"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestOFXParser_Next(t *testing.T) {
+	content := `OFXHEADER:100
+DATA:OFXSGML
+
+<OFX>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<CURDEF>USD
+<BANKTRANLIST>
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20240131120000.000[-6:CST]
+<TRNAMT>1500.25
+<FITID>A1
+<NAME>Payroll
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20240201
+<TRNAMT>-20,50
+<FITID>A2
+<MEMO>Coffee
+</STMTTRN>
+<STMTTRN>
+<DTPOSTED>2024
+<TRNAMT>-1
+<FITID>A3
+</STMTTRN>
+</BANKTRANLIST>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`
+	parser := OFXParser{Reader: bytes.NewBufferString(content)}
+
+	transaction, err := parser.Next()
+	require.NoError(t, err)
+	require.Equal(t, 8, transaction.Line)
+	require.Equal(t, "A1", transaction.ExternalID)
+	require.Equal(t, time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC), transaction.PerformedAt)
+	require.Equal(t, dao.TxOperationTypeCredit, transaction.Operation)
+	require.Equal(t, "1500.25", transaction.Amount.String())
+	require.Equal(t, "USD", transaction.Currency)
+	require.Equal(t, "Payroll", transaction.Description)
+
+	transaction, err = parser.Next()
+	require.NoError(t, err)
+	require.Equal(t, "A2", transaction.ExternalID)
+	require.Equal(t, dao.TxOperationTypeDebit, transaction.Operation)
+	require.Equal(t, "20.5", transaction.Amount.String())
+	require.Equal(t, "Coffee", transaction.Description)
+
+	_, err = parser.Next()
+	var recordErr *RecordError
+	require.ErrorAs(t, err, &recordErr)
+	require.Equal(t, Rejection{Line: 22, Record: CSVRecord{"A3", "2024", "-1"}, Field: "date", Reason: `invalid date: "2024"`}, recordErr.Rejection())
+
+	_, err = parser.Next()
+	require.ErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"io"
+	"strings"
+	"time"
+)
+
+// qifDateLayouts are the date layouts written by Quicken and friends, once ' is replaced by /.
+var qifDateLayouts = []string{"1/2/2006", "1/2/06", "2006-01-02"}
+
+// QIFParser reads the transactions of QIF statements, entries are a list of lines starting with a field code and
+// ended by ^. QIF has no transaction ids, so the check number is used when there is one and a synthetic id otherwise.
+type QIFParser struct {
+	Reader io.Reader
+
+	scanner *bufio.Scanner
+	line    int
+	ids     syntheticIDs
+}
+
+// Next returns the next entry of the statement, skipping headers like !Type:Bank.
+func (p *QIFParser) Next() (StatementTransaction, error) {
+	if p.scanner == nil {
+		p.scanner = bufio.NewScanner(p.Reader)
+		p.ids = make(syntheticIDs)
+	}
+
+	start := 0
+	fields := make(map[byte]string)
+	for p.scanner.Scan() {
+		p.line += 1
+		line := strings.TrimSpace(p.scanner.Text())
+		if line == "" || line[0] == '!' {
+			continue
+		}
+		if start == 0 {
+			start = p.line
+		}
+
+		if line[0] == '^' {
+			return p.parseEntry(start, fields)
+		}
+		// split transactions repeat S, E and $, only the first value of each field is kept
+		if _, ok := fields[line[0]]; !ok {
+			fields[line[0]] = strings.TrimSpace(line[1:])
+		}
+	}
+
+	if err := p.scanner.Err(); err != nil {
+		return StatementTransaction{}, fmt.Errorf("error reading statement: %w", err)
+	}
+	// the last entry may lack its ^
+	if start != 0 {
+		return p.parseEntry(start, fields)
+	}
+	return StatementTransaction{}, io.EOF
+}
+
+// parseEntry builds the transaction of the entry starting at line.
+func (p *QIFParser) parseEntry(line int, fields map[byte]string) (StatementTransaction, error) {
+	amountField, ok := fields['T']
+	if !ok {
+		amountField = fields['U']
+	}
+	record := CSVRecord{fields['N'], fields['D'], amountField}
+
+	transaction, err := p.parseFields(fields, amountField)
+	transaction.Line = line
+	transaction.Record = record
+	var recordErr *RecordError
+	if errors.As(err, &recordErr) {
+		recordErr.Line = line
+		recordErr.Record = record
+	}
+	return transaction, err
+}
+
+func (p *QIFParser) parseFields(fields map[byte]string, amountField string) (StatementTransaction, error) {
+	performedAt, err := parseQIFDate(fields['D'])
+	if err != nil {
+		return StatementTransaction{}, &RecordError{Field: "date", Reason: err.Error()}
+	}
+
+	amount, err := decimal.NewFromString(strings.ReplaceAll(amountField, ",", ""))
+	if err != nil {
+		return StatementTransaction{}, &RecordError{Field: "amount", Reason: fmt.Sprintf("invalid transaction amount: %s", amountField)}
+	}
+	operation, amount := operationOf(amount)
+
+	// N also holds things like ATM or DEP, only check numbers identify an entry
+	id := fields['N']
+	if !rTxID.MatchString(id) {
+		id = p.ids.next(fields['D'], amountField, fields['P'], fields['M'])
+	}
+
+	description := fields['P']
+	if description == "" {
+		description = fields['M']
+	}
+
+	return StatementTransaction{
+		ExternalID:  id,
+		PerformedAt: performedAt,
+		Operation:   operation,
+		Amount:      amount,
+		Description: description,
+	}, nil
+}
+
+// parseQIFDate parses dates like 01/31/2024, 1/31/24 and 1/31'24.
+func parseQIFDate(value string) (time.Time, error) {
+	normalized := strings.ReplaceAll(strings.ReplaceAll(value, "'", "/"), " ", "")
+	for _, layout := range qifDateLayouts {
+		if date, err := time.Parse(layout, normalized); err == nil {
+			return date, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date: %q", value)
+}
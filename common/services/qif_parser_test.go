@@ -0,0 +1,58 @@
+package services
+
+import (
+	"bytes"
+	"common/dao"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestQIFParser_Next(t *testing.T) {
+	content := "!Type:Bank\n" +
+		"D01/31/2024\nT1,500.25\nN1001\nPPayroll\n^\n" +
+		"D2/1'24\nT-20.50\nNATM\nPCoffee\n^\n" +
+		"D2/1'24\nT-20.50\nNATM\nPCoffee\n^\n" +
+		"D13/45/2024\nT-1\n^\n" +
+		"D2/2/24\nU-3.00\nMNo caret at the end\n"
+	parser := QIFParser{Reader: bytes.NewBufferString(content)}
+
+	transaction, err := parser.Next()
+	require.NoError(t, err)
+	require.Equal(t, 2, transaction.Line)
+	require.Equal(t, "1001", transaction.ExternalID)
+	require.Equal(t, time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC), transaction.PerformedAt)
+	require.Equal(t, dao.TxOperationTypeCredit, transaction.Operation)
+	require.Equal(t, "1500.25", transaction.Amount.String())
+	require.Equal(t, "Payroll", transaction.Description)
+
+	// identical entries without a check number get different but stable ids
+	first, err := parser.Next()
+	require.NoError(t, err)
+	second, err := parser.Next()
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), first.PerformedAt)
+	require.Equal(t, dao.TxOperationTypeDebit, first.Operation)
+	require.NotEqual(t, first.ExternalID, second.ExternalID)
+	again := QIFParser{Reader: bytes.NewBufferString(content)}
+	_, err = again.Next()
+	require.NoError(t, err)
+	transaction, err = again.Next()
+	require.NoError(t, err)
+	require.Equal(t, first.ExternalID, transaction.ExternalID)
+
+	_, err = parser.Next()
+	var recordErr *RecordError
+	require.ErrorAs(t, err, &recordErr)
+	require.Equal(t, 17, recordErr.Line)
+	require.Equal(t, "date", recordErr.Field)
+
+	transaction, err = parser.Next()
+	require.NoError(t, err)
+	require.Equal(t, "3", transaction.Amount.String())
+	require.Equal(t, "No caret at the end", transaction.Description)
+
+	_, err = parser.Next()
+	require.ErrorIs(t, err, io.EOF)
+}
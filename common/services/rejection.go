@@ -0,0 +1,72 @@
+package services
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Rejection describes a row of the file that could not be imported.
+type Rejection struct {
+	Line   int       `json:"line"`
+	Record CSVRecord `json:"record"`
+	Field  string    `json:"field"`
+	Reason string    `json:"reason"`
+}
+
+// RecordError is returned when an entry of a statement (or one of its fields) is invalid.
+type RecordError struct {
+	Line   int
+	Record CSVRecord
+	Field  string
+	Reason string
+}
+
+func (e *RecordError) Error() string {
+	return e.Reason
+}
+
+// newRejection builds the rejection of a transaction from a database error.
+func newRejection(transaction StatementTransaction, err error) Rejection {
+	return Rejection{
+		Line:   transaction.Line,
+		Record: transaction.Record,
+		Reason: err.Error(),
+	}
+}
+
+// Rejection builds the rejection of the invalid entry.
+func (e *RecordError) Rejection() Rejection {
+	return Rejection{
+		Line:   e.Line,
+		Record: e.Record,
+		Field:  e.Field,
+		Reason: e.Reason,
+	}
+}
+
+// sortRejections orders rejections by line, since workers report them in any order.
+func sortRejections(rejections []Rejection) {
+	sort.SliceStable(rejections, func(i, j int) bool {
+		return rejections[i].Line < rejections[j].Line
+	})
+}
+
+// WriteRejections writes rejections as CSV: line, field, reason and then the raw record.
+func WriteRejections(writer io.Writer, rejections []Rejection) error {
+	csvWriter := csv.NewWriter(writer)
+	if err := csvWriter.Write([]string{"line", "field", "reason", "record"}); err != nil {
+		return err
+	}
+
+	for _, rejection := range rejections {
+		row := append([]string{strconv.Itoa(rejection.Line), rejection.Field, rejection.Reason}, rejection.Record...)
+		if err := csvWriter.Write(row); err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
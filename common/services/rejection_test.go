@@ -0,0 +1,20 @@
+package services
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestWriteRejections(t *testing.T) {
+	buffer := bytes.NewBufferString("")
+	err := WriteRejections(buffer, []Rejection{
+		{Line: 3, Record: CSVRecord{"2", "2024-13-01", "+1.5"}, Field: "date", Reason: "invalid transaction date: 2024-13-01"},
+		{Line: 7, Record: CSVRecord{"6", "2024-01-03", "+1,5"}, Field: "amount", Reason: "invalid transaction operation and amount: +1,5"},
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, "line,field,reason,record\n"+
+		"3,date,invalid transaction date: 2024-13-01,2,2024-13-01,+1.5\n"+
+		"7,amount,\"invalid transaction operation and amount: +1,5\",6,2024-01-03,\"+1,5\"\n", buffer.String())
+}
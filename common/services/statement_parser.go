@@ -0,0 +1,180 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"common/dao"
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sniffSize is how much of a file is looked at to detect its format.
+const sniffSize = 4096
+
+// StatementFormat names a supported statement file format.
+type StatementFormat string
+
+const (
+	FormatAuto    StatementFormat = "auto"
+	FormatCSV     StatementFormat = "csv"
+	FormatOFX     StatementFormat = "ofx"
+	FormatQIF     StatementFormat = "qif"
+	FormatCAMT053 StatementFormat = "camt053"
+	FormatMT940   StatementFormat = "mt940"
+)
+
+// StatementFormats lists every supported format, besides auto detection.
+var StatementFormats = []StatementFormat{FormatCSV, FormatOFX, FormatQIF, FormatCAMT053, FormatMT940}
+
+// StatementTransaction is a transaction normalized from any statement format.
+type StatementTransaction struct {
+	Line        int
+	Record      CSVRecord
+	ExternalID  string
+	PerformedAt time.Time
+	Operation   dao.TxOperationType
+	Amount      decimal.Decimal
+	Description string
+
+	// Currency is the ISO 4217 code of the amount, the DefaultCurrency when the statement doesn't tell it.
+	Currency string
+
+	// Account is the email or account number the transaction belongs to in multi-account statements, blank when the
+	// whole statement belongs to one account. AccountID is the account it was resolved to when importing, ImportID
+	// the import recorded for that account, if any.
+	Account   string
+	AccountID int64
+	ImportID  int64
+}
+
+// StatementParser reads the transactions of a statement one by one.
+type StatementParser interface {
+	// Next returns the next transaction or io.EOF at the end of the statement. Entries that can't be parsed
+	// return a *RecordError, and reading can go on after them.
+	Next() (StatementTransaction, error)
+}
+
+// ParserOptions configures the parsers built by NewStatementParser.
+type ParserOptions struct {
+	// Dates parses CSV dates, other formats have their own fixed layouts.
+	Dates DateParser
+
+	// Tolerant accepts sloppy CSV rows, see CSVParser.
+	Tolerant bool
+
+	// Profile maps the columns of bank specific CSV layouts, statements with a profile are always read as CSV.
+	Profile *CSVProfile
+}
+
+// ParseFormat returns the format by its name, an empty name means auto detection.
+func ParseFormat(name string) (StatementFormat, error) {
+	format := StatementFormat(strings.ToLower(name))
+	switch format {
+	case "":
+		return FormatAuto, nil
+	case "qfx":
+		return FormatOFX, nil
+	case "camt.053", "camt":
+		return FormatCAMT053, nil
+	case "mt":
+		return FormatMT940, nil
+	case FormatAuto, FormatCSV, FormatOFX, FormatQIF, FormatCAMT053, FormatMT940:
+		return format, nil
+	}
+
+	return FormatAuto, fmt.Errorf("unknown statement format: %s", name)
+}
+
+// DetectFormat guesses the format of a statement from the beginning of its content, falling back to the file
+// name extension and then to CSV.
+func DetectFormat(name string, head []byte) StatementFormat {
+	content := strings.ToUpper(string(head))
+	switch {
+	case strings.Contains(content, "OFXHEADER") || strings.Contains(content, "<OFX>"):
+		return FormatOFX
+	case strings.HasPrefix(strings.TrimSpace(content), "!TYPE:") || strings.HasPrefix(strings.TrimSpace(content), "!ACCOUNT"):
+		return FormatQIF
+	case strings.Contains(content, "CAMT.053") || strings.Contains(content, "<BKTOCSTMRSTMT>"):
+		return FormatCAMT053
+	case strings.Contains(content, ":20:") && strings.Contains(content, ":61:"):
+		return FormatMT940
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".ofx", ".qfx":
+		return FormatOFX
+	case ".qif":
+		return FormatQIF
+	case ".xml":
+		return FormatCAMT053
+	case ".sta", ".mt940", ".940":
+		return FormatMT940
+	}
+
+	return FormatCSV
+}
+
+// NewStatementParser builds the parser of a format, detecting it from the file name and content when auto.
+// Returns the format used.
+func NewStatementParser(format StatementFormat, name string, reader io.Reader, options ParserOptions) (StatementParser, StatementFormat, error) {
+	if (format == "" || format == FormatAuto) && options.Profile != nil {
+		format = FormatCSV
+	} else if format == "" || format == FormatAuto {
+		buffered := bufio.NewReaderSize(reader, sniffSize)
+		head, err := buffered.Peek(sniffSize)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return nil, format, err
+		}
+
+		format = DetectFormat(name, head)
+		reader = buffered
+	}
+
+	switch format {
+	case FormatCSV:
+		return &CSVParser{Reader: csv.NewReader(reader), Dates: options.Dates, Tolerant: options.Tolerant, Profile: options.Profile}, format, nil
+	case FormatOFX:
+		return &OFXParser{Reader: reader}, format, nil
+	case FormatQIF:
+		return &QIFParser{Reader: reader}, format, nil
+	case FormatCAMT053:
+		return &CAMT053Parser{Reader: reader}, format, nil
+	case FormatMT940:
+		return &MT940Parser{Reader: reader}, format, nil
+	}
+
+	return nil, format, fmt.Errorf("unknown statement format: %s", format)
+}
+
+// operationOf returns the operation of a signed amount along with its absolute value.
+func operationOf(amount decimal.Decimal) (dao.TxOperationType, decimal.Decimal) {
+	if amount.IsNegative() {
+		return dao.TxOperationTypeDebit, amount.Neg()
+	}
+
+	return dao.TxOperationTypeCredit, amount
+}
+
+// syntheticIDs makes stable ids for formats whose entries may lack one, from the content of the entry and how
+// many identical entries came before it, so importing the same statement twice yields the same ids.
+type syntheticIDs map[string]int
+
+func (s syntheticIDs) next(fields ...string) string {
+	hash := sha1.Sum([]byte(strings.Join(fields, "\x00")))
+	key := hex.EncodeToString(hash[:8])
+	s[key] += 1
+	return key + "-" + strconv.Itoa(s[key])
+}
+
+// lineAt returns the line number of an offset within content.
+func lineAt(content []byte, offset int) int {
+	return bytes.Count(content[:offset], []byte{'\n'}) + 1
+}
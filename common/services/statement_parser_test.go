@@ -0,0 +1,67 @@
+package services
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseFormat(t *testing.T) {
+	for _, format := range StatementFormats {
+		parsed, err := ParseFormat(string(format))
+		require.NoError(t, err)
+		require.Equal(t, format, parsed)
+	}
+
+	parsed, err := ParseFormat("QFX")
+	require.NoError(t, err)
+	require.Equal(t, FormatOFX, parsed)
+
+	parsed, err = ParseFormat("")
+	require.NoError(t, err)
+	require.Equal(t, FormatAuto, parsed)
+
+	_, err = ParseFormat("xlsx")
+	require.Error(t, err)
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		head     string
+		format   StatementFormat
+	}{
+		{"OFX header", "statement", "OFXHEADER:100\nDATA:OFXSGML\n", FormatOFX},
+		{"OFX XML", "statement", "<?xml version=\"1.0\"?>\n<?OFX OFXHEADER=\"200\"?>\n<OFX>", FormatOFX},
+		{"QIF", "statement.txt", "!Type:Bank\nD01/31/2024\n", FormatQIF},
+		{"camt.053", "statement.xml", "<Document xmlns=\"urn:iso:std:iso:20022:tech:xsd:camt.053.001.02\">", FormatCAMT053},
+		{"MT940", "statement.txt", ":20:STATEMENT\n:25:123\n:61:240131C1,00NTRF\n", FormatMT940},
+		{"CSV", "statement.csv", "id,date,amount\n1,2024-01-31,+1\n", FormatCSV},
+		{"Extension", "statement.qfx", "", FormatOFX},
+		{"Unknown", "statement", "", FormatCSV},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.format, DetectFormat(tc.fileName, []byte(tc.head)))
+		})
+	}
+}
+
+func TestNewStatementParser(t *testing.T) {
+	content := "!Type:Bank\nD01/31/2024\nT-1.00\n^\n"
+
+	// detection must not consume the content
+	parser, format, err := NewStatementParser(FormatAuto, "statement", bytes.NewBufferString(content), ParserOptions{})
+	require.NoError(t, err)
+	require.Equal(t, FormatQIF, format)
+	transaction, err := parser.Next()
+	require.NoError(t, err)
+	require.Equal(t, "1", transaction.Amount.String())
+
+	parser, format, err = NewStatementParser(FormatCSV, "statement.qif", bytes.NewBufferString(content), ParserOptions{Tolerant: true})
+	require.NoError(t, err)
+	require.Equal(t, FormatCSV, format)
+	require.True(t, parser.(*CSVParser).Tolerant)
+}
@@ -0,0 +1,290 @@
+package services
+
+import (
+	"common/dao"
+	"common/money"
+	"common/pdf"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	statementMargin = 50.0
+	statementRight  = pdf.PageWidth - statementMargin
+	statementBottom = pdf.PageHeight - 60
+	statementRow    = 16.0
+)
+
+// Statement is what the PDF statement of an account shows: the balance of the account, the report of an import and
+// its transactions.
+type Statement struct {
+	Account      dao.Account
+	Balance      BalanceReport
+	Report       BalanceReport
+	Transactions []BalanceTransaction
+
+	// Recent is set when the import isn't known and Transactions are the latest of the account instead.
+	Recent      bool
+	GeneratedAt time.Time
+}
+
+// Statement loads the statement of an import into the account, with the balance as stored in the account. Without
+// an import the statement lists the recent transactions of the account.
+func (s *AccountService) Statement(ctx context.Context, account dao.Account, importID int64, report BalanceReport) (Statement, error) {
+	return loadStatement(ctx, dao.New(s.Database), account, storedBalance(account), report, importID)
+}
+
+// loadStatement loads the transactions of the statement of an import into the account.
+func loadStatement(ctx context.Context, queries *dao.Queries, account dao.Account, balance, report BalanceReport, importID int64) (Statement, error) {
+	statement := Statement{Account: account, Balance: balance, Report: report, GeneratedAt: time.Now()}
+
+	var transactions []dao.Transaction
+	var err error
+	if importID != 0 {
+		transactions, err = queries.ListTransactionsByImport(ctx, dao.ListTransactionsByImportParams{
+			ImportID:  sql.NullInt64{Valid: true, Int64: importID},
+			AccountID: account.AccountID,
+		})
+	} else {
+		statement.Recent = true
+		transactions, err = queries.ListRecentTransactions(ctx, dao.ListRecentTransactionsParams{AccountID: account.AccountID, Limit: RecentTransactionsLimit})
+	}
+	if err != nil {
+		return Statement{}, fmt.Errorf("error loading statement transactions: %w", err)
+	}
+
+	statement.Transactions = balanceTransactions(transactions)
+	return statement, nil
+}
+
+// StatementPDF renders statements as PDF documents with the same messages as the balance email.
+type StatementPDF struct {
+	Messages map[string]map[string]string
+}
+
+// LoadMessages loads localization messages
+func (p *StatementPDF) LoadMessages() error {
+	messages, err := loadMessages()
+	p.Messages = messages
+	return err
+}
+
+// FileName returns the name of the PDF statement of an account, in the locale of the account.
+func (p *StatementPDF) FileName(account dao.Account) string {
+	_, loc := p.locale(account.Locale)
+	return fmt.Sprintf("%s-%d.pdf", loc["statement_pdf.file_name"], account.AccountID)
+}
+
+// Render writes the statement as a PDF, in the locale of the account.
+func (p *StatementPDF) Render(w io.Writer, statement Statement) error {
+	lang, loc := p.locale(statement.Account.Locale)
+	r := &statementRenderer{doc: pdf.New(loc["statement_pdf.title"]), lang: lang, loc: loc}
+	r.newPage()
+
+	r.header(statement)
+	r.balance(statement.Balance)
+	r.report(statement.Report)
+	r.monthly(statement.Report.TransactionCount)
+	r.transactions(statement)
+	r.footers(statement.Account)
+
+	_, err := r.doc.WriteTo(w)
+	return err
+}
+
+func (p *StatementPDF) locale(locale string) (string, map[string]string) {
+	loc, ok := p.Messages[locale]
+	if !ok {
+		locale = DefaultLocale
+		loc = p.Messages[locale]
+	}
+
+	return locale, loc
+}
+
+// statementRenderer lays out a statement top to bottom, adding pages as they fill up.
+type statementRenderer struct {
+	doc  *pdf.Document
+	page *pdf.Page
+	y    float64
+	lang string
+	loc  map[string]string
+}
+
+func (r *statementRenderer) newPage() {
+	r.page = r.doc.AddPage()
+	r.y = statementMargin
+}
+
+// space moves to a new page unless height fits in the current one, reporting whether it did.
+func (r *statementRenderer) space(height float64) bool {
+	if r.y+height <= statementBottom {
+		return false
+	}
+
+	r.newPage()
+	return true
+}
+
+func (r *statementRenderer) money(amount money.Money) string {
+	if amount.Currency() == "" {
+		amount = money.New(amount.Amount(), DefaultCurrency)
+	}
+	return amount.Format(r.lang)
+}
+
+func (r *statementRenderer) heading(text string) {
+	r.space(3 * statementRow)
+	r.y += statementRow
+	r.page.Text(statementMargin, r.y, pdf.HelveticaBold, 13, text)
+	r.page.Line(statementMargin, r.y+5, statementRight, r.y+5, 0.5)
+	r.y += statementRow
+}
+
+// row writes a label with its value aligned to the right margin.
+func (r *statementRenderer) row(label, value string) {
+	r.space(statementRow)
+	r.page.Text(statementMargin, r.y, pdf.Helvetica, 10, label)
+	r.page.TextRight(statementRight, r.y, pdf.Helvetica, 10, value)
+	r.y += statementRow
+}
+
+func (r *statementRenderer) header(statement Statement) {
+	account := statement.Account
+	r.page.Text(statementMargin, r.y+10, pdf.HelveticaBold, 20, r.loc["statement_pdf.title"])
+	r.page.TextRight(statementRight, r.y+10, pdf.Helvetica, 10, r.loc["statement_pdf.generated_at"]+" "+statement.GeneratedAt.Format(time.DateOnly))
+	r.y += 40
+
+	name := strings.TrimSpace(account.FirstName + " " + account.LastName)
+	if name != "" {
+		r.page.Text(statementMargin, r.y, pdf.HelveticaBold, 12, name)
+		r.y += statementRow
+	}
+	r.row(r.loc["statement_pdf.account"], strconv.FormatInt(account.AccountID, 10))
+	r.row(r.loc["statement_pdf.email"], account.Email)
+	r.row(r.loc["statement_pdf.currency"], statement.Balance.Currency)
+}
+
+func (r *statementRenderer) balance(balance BalanceReport) {
+	r.heading(r.loc["balance_page.title"])
+	r.row(r.loc["balance_email.total_balance"], r.money(balance.TotalBalance))
+	r.row(r.loc["balance_email.avg_credit_amount"], r.money(balance.AvgCreditAmount))
+	r.row(r.loc["balance_email.avg_debit_amount"], r.money(balance.AvgDebitAmount.Neg()))
+}
+
+func (r *statementRenderer) report(report BalanceReport) {
+	r.heading(r.loc["balance_email.statement"])
+	r.row(fmt.Sprintf("%d %s", report.CountCredit, r.loc["balance_email.credits"]), r.money(report.TotalCredit))
+	r.row(fmt.Sprintf("%d %s", report.CountDebit, r.loc["balance_email.debits"]), r.money(report.TotalDebit.Neg()))
+	if len(report.Currencies) <= 1 {
+		return
+	}
+
+	currencies := make([]string, 0, len(report.Currencies))
+	for currency := range report.Currencies {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	for _, currency := range currencies {
+		totals := report.Currencies[currency]
+		r.row(fmt.Sprintf("%s: %d %s", currency, totals.CountCredit, r.loc["balance_email.credits"]), r.money(totals.TotalCredit))
+		r.row(fmt.Sprintf("%s: %d %s", currency, totals.CountDebit, r.loc["balance_email.debits"]), r.money(totals.TotalDebit.Neg()))
+	}
+}
+
+func (r *statementRenderer) monthly(counts map[string]int) {
+	if len(counts) == 0 {
+		return
+	}
+
+	yearMonths := make([]string, 0, len(counts))
+	for yearMonth := range counts {
+		yearMonths = append(yearMonths, yearMonth)
+	}
+	sort.Strings(yearMonths)
+
+	r.heading(r.loc["statement_pdf.monthly"])
+	for _, yearMonth := range yearMonths {
+		label := yearMonth
+		if date, err := time.Parse(yearMonthLayout, yearMonth); err == nil {
+			label = r.loc["month."+strconv.Itoa(int(date.Month()))] + " " + strconv.Itoa(date.Year())
+		}
+		r.row(label, strconv.Itoa(counts[yearMonth]))
+	}
+}
+
+// statementColumns are the left edges of the date, reference and operation columns, the amount is aligned to the
+// right margin.
+var statementColumns = [3]float64{statementMargin, statementMargin + 80, statementMargin + 320}
+
+func (r *statementRenderer) transactionsHeader() {
+	r.page.Box(statementMargin, r.y-12, statementRight-statementMargin, statementRow, 0.85)
+	r.page.Text(statementColumns[0]+4, r.y, pdf.HelveticaBold, 10, r.loc["balance_page.date"])
+	r.page.Text(statementColumns[1], r.y, pdf.HelveticaBold, 10, r.loc["statement_pdf.reference"])
+	r.page.Text(statementColumns[2], r.y, pdf.HelveticaBold, 10, r.loc["balance_page.operation"])
+	r.page.TextRight(statementRight-4, r.y, pdf.HelveticaBold, 10, r.loc["balance_page.amount"])
+	r.y += statementRow
+}
+
+func (r *statementRenderer) transactions(statement Statement) {
+	if statement.Recent {
+		r.heading(r.loc["balance_page.recent_transactions"])
+	} else {
+		r.heading(r.loc["statement_pdf.transactions"])
+	}
+	if len(statement.Transactions) == 0 {
+		r.page.Text(statementMargin, r.y, pdf.Helvetica, 10, r.loc["balance_page.no_transactions"])
+		return
+	}
+
+	r.transactionsHeader()
+	for i, transaction := range statement.Transactions {
+		if r.space(statementRow) {
+			r.transactionsHeader()
+		}
+		if i%2 == 1 {
+			r.page.Box(statementMargin, r.y-12, statementRight-statementMargin, statementRow, 0.95)
+		}
+
+		operation, amount := r.loc["balance_page.credit"], transaction.Money()
+		if transaction.Operation == dao.TxOperationTypeDebit {
+			operation, amount = r.loc["balance_page.debit"], amount.Neg()
+		}
+		reference := fit(transaction.ExternalID, pdf.Helvetica, 10, statementColumns[2]-statementColumns[1]-10)
+		r.page.Text(statementColumns[0]+4, r.y, pdf.Helvetica, 10, transaction.PerformedAt)
+		r.page.Text(statementColumns[1], r.y, pdf.Helvetica, 10, reference)
+		r.page.Text(statementColumns[2], r.y, pdf.Helvetica, 10, operation)
+		r.page.TextRight(statementRight-4, r.y, pdf.Helvetica, 10, r.money(amount))
+		r.y += statementRow
+	}
+}
+
+// footers numbers the pages once the statement is laid out.
+func (r *statementRenderer) footers(account dao.Account) {
+	pages := r.doc.Pages()
+	for i, page := range pages {
+		y := pdf.PageHeight - 30
+		page.Line(statementMargin, y-12, statementRight, y-12, 0.25)
+		page.Text(statementMargin, y, pdf.Helvetica, 8, fmt.Sprintf("%s %d", r.loc["statement_pdf.account"], account.AccountID))
+		page.TextRight(statementRight, y, pdf.Helvetica, 8, fmt.Sprintf(r.loc["statement_pdf.page"], i+1, len(pages)))
+	}
+}
+
+// fit shortens text with an ellipsis until it is at most width wide.
+func fit(text string, font pdf.Font, size, width float64) string {
+	if pdf.TextWidth(font, size, text) <= width {
+		return text
+	}
+
+	runes := []rune(text)
+	for len(runes) > 0 && pdf.TextWidth(font, size, string(runes)+"…") > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"bytes"
+	"common/dao"
+	"common/money"
+	"common/pdf"
+	"context"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var transactionColumns = []string{"transaction_id", "account_id", "operation", "amount", "currency", "performed_at", "created_at", "updated_at", "import_id", "external_id", "source"}
+
+func TestStatementPDF_Render(t *testing.T) {
+	statements := &StatementPDF{}
+	require.NoError(t, statements.LoadMessages())
+
+	statement := Statement{
+		Account: dao.Account{AccountID: 7, FirstName: "Juan", LastName: "Pérez", Email: "juan@example.com", Locale: "es-MX"},
+		Balance: BalanceReport{
+			Currency:        "MXN",
+			TotalBalance:    money.New(decimal.RequireFromString("1250.5"), "MXN"),
+			AvgCreditAmount: money.New(decimal.RequireFromString("300"), "MXN"),
+			AvgDebitAmount:  money.New(decimal.RequireFromString("49.5"), "MXN"),
+		},
+		Report: BalanceReport{
+			TotalCredit:      money.New(decimal.NewFromInt(300), "MXN"),
+			CountCredit:      1,
+			TotalDebit:       money.New(decimal.RequireFromString("49.5"), "MXN"),
+			CountDebit:       1,
+			TransactionCount: map[string]int{"2025-01": 1, "2024-12": 1},
+		},
+		Transactions: []BalanceTransaction{
+			{ExternalID: "tx-1", Operation: dao.TxOperationTypeCredit, Amount: decimal.NewFromInt(300), Currency: "MXN", PerformedAt: "2024-12-31"},
+			{ExternalID: "tx-2", Operation: dao.TxOperationTypeDebit, Amount: decimal.RequireFromString("49.5"), Currency: "MXN", PerformedAt: "2025-01-02"},
+		},
+		GeneratedAt: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, statements.Render(&buf, statement))
+	out := buf.String()
+	require.Regexp(t, `^%PDF-1\.4`, out)
+	require.Contains(t, out, "/Count 1")
+	for _, text := range []string{
+		"(Estado de cuenta)", "(Generado el 2025-02-01)", "(Juan P\\351rez)", "(juan@example.com)",
+		"(Balance total)", "($1,250.50)", "(Promedio de dep\\363sitos)", "($300.00)", "(-$49.50)",
+		"(1 dep\\363sitos)", "(Diciembre 2024)", "(Enero 2025)",
+		"(Transacciones de este estado de cuenta)", "(2024-12-31)", "(tx-1)", "(Dep\\363sito)", "(Retiro)",
+		"(P\\341gina 1 de 1)",
+	} {
+		require.Contains(t, out, text)
+	}
+	require.Less(t, bytes.Index(buf.Bytes(), []byte("(Diciembre 2024)")), bytes.Index(buf.Bytes(), []byte("(Enero 2025)")))
+	require.Equal(t, "estado-de-cuenta-7.pdf", statements.FileName(statement.Account))
+
+	// long statements continue on more pages, unknown locales fall back to the default one
+	statement.Account.Locale = "fr-FR"
+	statement.Recent = true
+	statement.Transactions = nil
+	for i := range 100 {
+		statement.Transactions = append(statement.Transactions, BalanceTransaction{
+			ExternalID: fmt.Sprintf("tx-%d", i), Operation: dao.TxOperationTypeCredit, Amount: decimal.NewFromInt(1), Currency: "MXN", PerformedAt: "2025-01-01",
+		})
+	}
+	buf.Reset()
+	require.NoError(t, statements.Render(&buf, statement))
+	out = buf.String()
+	require.Contains(t, out, "/Count 3")
+	require.Contains(t, out, "(Transacciones recientes)")
+	require.Contains(t, out, "(tx-99)")
+	require.Contains(t, out, "(P\\341gina 3 de 3)")
+}
+
+func TestStatementPDF_RenderNoTransactions(t *testing.T) {
+	statements := &StatementPDF{}
+	require.NoError(t, statements.LoadMessages())
+
+	var buf bytes.Buffer
+	require.NoError(t, statements.Render(&buf, Statement{Account: dao.Account{AccountID: 1, Locale: "en-US"}}))
+	require.Contains(t, buf.String(), "(Account statement)")
+	require.Contains(t, buf.String(), "(There are no transactions yet.)")
+	require.Contains(t, buf.String(), "(Page 1 of 1)")
+}
+
+func TestAccountService_Statement(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	service := &AccountService{Database: db}
+	account := dao.Account{
+		AccountID:    1,
+		TotalBalance: decimal.NullDecimal{Valid: true, Decimal: decimal.RequireFromString("10.00")},
+	}
+	performedAt := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`FROM transactions\s+WHERE import_id = \$1 AND account_id = \$2`).WithArgs(int64(3), int64(1)).
+		WillReturnRows(sqlmock.NewRows(transactionColumns).AddRow(1, 1, "credit", "10.00", "MXN", performedAt, nil, nil, 3, "tx-1", "statement.csv"))
+	statement, err := service.Statement(context.Background(), account, 3, BalanceReport{CountCredit: 1})
+	require.NoError(t, err)
+	require.False(t, statement.Recent)
+	require.Equal(t, "10", statement.Balance.TotalBalance.Amount().String())
+	require.Equal(t, int64(1), statement.Report.CountCredit)
+	require.Equal(t, []BalanceTransaction{
+		{ExternalID: "tx-1", Source: "statement.csv", Operation: dao.TxOperationTypeCredit, Amount: decimal.RequireFromString("10.00"), Currency: "MXN", PerformedAt: "2025-01-02"},
+	}, statement.Transactions)
+
+	// without an import the recent transactions of the account are listed
+	mock.ExpectQuery(`FROM transactions\s+WHERE account_id = \$1`).WithArgs(int64(1), RecentTransactionsLimit).
+		WillReturnRows(sqlmock.NewRows(transactionColumns))
+	statement, err = service.Statement(context.Background(), account, 0, BalanceReport{})
+	require.NoError(t, err)
+	require.True(t, statement.Recent)
+	require.Empty(t, statement.Transactions)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFit(t *testing.T) {
+	require.Equal(t, "tx-1", fit("tx-1", pdf.Helvetica, 10, 100))
+
+	long := fit("a-very-long-transaction-reference-from-the-bank", pdf.Helvetica, 10, 100)
+	require.Less(t, pdf.TextWidth(pdf.Helvetica, 10, long), 100.0)
+	require.Regexp(t, `^a-very-long.*…$`, long)
+}
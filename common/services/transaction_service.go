@@ -1,50 +1,244 @@
 package services
 
 import (
+	"common/dao"
+	"common/money"
 	"context"
 	"database/sql"
-	"encoding/csv"
 	"errors"
 	"fmt"
 	"github.com/shopspring/decimal"
 	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
 )
 
 // CSVRecord should contain transaction data from CSV
 type CSVRecord []string
 
+// ErrImportIncomplete is returned when the context of an import is done before reading the whole file. Rows stored
+// until then are kept (unless the import is atomic) and skipped as duplicates when importing the file again.
+var ErrImportIncomplete = errors.New("import incomplete")
+
 // TransactionService manages transactions and balances
 type TransactionService struct {
 	Database  *sql.DB
 	Workers   int
 	BatchSize int
+
+	// Source identifies where the file comes from (institution, file name, etc.), transaction ids are
+	// unique per account and source, so importing the same file twice skips rows already stored.
+	Source string
+
+	// ImportID links stored transactions to their row in the imports table, left empty without it. ImportService
+	// sets it when recording an import.
+	ImportID int64
+
+	// Mode selects between best effort (default) and atomic imports.
+	Mode ImportMode
+
+	// Strategy selects how workers insert transactions, in batches of BatchSize unless inserting row by row.
+	Strategy InsertStrategy
+
+	// Threshold aborts imports with too many rejected rows, by default imports never abort because of them.
+	Threshold ErrorThreshold
+
+	// Rates converts the totals of other currencies into the reporting currency of the account, without it only
+	// the transactions in that currency are totaled.
+	Rates FXProvider
+
+	// Notify queues the report email of each imported account in the email outbox, in the same database transaction
+	// as its balance update. EmailDispatcher sends them.
+	Notify bool
+
+	// imports records an import for every account of multi-account statements, ImportService.ImportBatch sets it.
+	imports *batchImports
 }
 
-// BalanceReport general info about the account
+// BalanceReport general info about the account, either the statement of a single file or, when recalculated, the
+// whole history of the account. Totals, counts and averages are in Currency, converting the totals of every other
+// currency with the FX provider, currencies without a rate are left out of them and listed in Unconverted.
 type BalanceReport struct {
-	AccountID        int64           `json:"account_id"`
-	TotalCredit      decimal.Decimal `json:"total_credit"`
-	CountCredit      int64           `json:"count_credit"`
-	TotalDebit       decimal.Decimal `json:"total_debit"`
-	CountDebit       int64           `json:"count_debit"`
-	TotalBalance     decimal.Decimal `json:"total_balance"`
-	AvgDebitAmount   decimal.Decimal `json:"avg_debit_amount"`
-	AvgCreditAmount  decimal.Decimal `json:"avg_credit_amount"`
-	TransactionCount map[int]int     `json:"transaction_count"`
+	AccountID        int64                     `json:"account_id"`
+	Currency         string                    `json:"currency"`
+	Currencies       map[string]CurrencyTotals `json:"currencies"`
+	Unconverted      []string                  `json:"unconverted,omitempty"`
+	Rates            map[string]AppliedRate    `json:"rates,omitempty"`
+	TotalCredit      money.Money               `json:"total_credit"`
+	CountCredit      int64                     `json:"count_credit"`
+	TotalDebit       money.Money               `json:"total_debit"`
+	CountDebit       int64                     `json:"count_debit"`
+	TotalBalance     money.Money               `json:"total_balance"`
+	AvgDebitAmount   money.Money               `json:"avg_debit_amount"`
+	AvgCreditAmount  money.Money               `json:"avg_credit_amount"`
+	TransactionCount map[string]int            `json:"transaction_count"`
+	CountDuplicate   int64                     `json:"count_duplicate"`
+	CountRejected    int64                     `json:"count_rejected"`
+
+	// Incomplete reports are returned along with ErrImportIncomplete, they only count the rows stored before the
+	// import was interrupted.
+	Incomplete bool `json:"incomplete"`
+
+	// accounts are the totals of each account of multi-account statements, ImportBatch splits them into a report per
+	// account.
+	accounts map[int64]AccountTotals
+}
+
+// CurrencyTotals are the totals of the transactions in a single currency, as they are in the statements.
+type CurrencyTotals struct {
+	TotalCredit  money.Money `json:"total_credit"`
+	CountCredit  int64       `json:"count_credit"`
+	TotalDebit   money.Money `json:"total_debit"`
+	CountDebit   int64       `json:"count_debit"`
+	TotalBalance money.Money `json:"total_balance"`
+}
+
+// AppliedRate is the rate a currency of the report was converted with, the latest one recorded on or before At.
+type AppliedRate struct {
+	Rate decimal.Decimal `json:"rate"`
+	At   time.Time       `json:"at"`
+}
+
+// newCurrencyTotals returns empty totals of a currency.
+func newCurrencyTotals(currency string) CurrencyTotals {
+	return CurrencyTotals{TotalCredit: money.Zero(currency), TotalDebit: money.Zero(currency)}
+}
+
+// merge returns the sum of both totals.
+func (t CurrencyTotals) merge(other CurrencyTotals) CurrencyTotals {
+	return CurrencyTotals{
+		TotalCredit: t.TotalCredit.Add(other.TotalCredit),
+		CountCredit: t.CountCredit + other.CountCredit,
+		TotalDebit:  t.TotalDebit.Add(other.TotalDebit),
+		CountDebit:  t.CountDebit + other.CountDebit,
+	}
+}
+
+// add counts a transaction in the totals.
+func (t *CurrencyTotals) add(operation dao.TxOperationType, amount money.Money) {
+	if operation == dao.TxOperationTypeDebit {
+		t.TotalDebit = t.TotalDebit.Add(amount)
+		t.CountDebit += 1
+	} else {
+		t.TotalCredit = t.TotalCredit.Add(amount)
+		t.CountCredit += 1
+	}
+}
+
+// ImportMode selects how the rows of a file are stored.
+type ImportMode int
+
+const (
+	// ImportModeBestEffort stores every row on its own, rows that fail are logged and skipped.
+	ImportModeBestEffort ImportMode = iota
+
+	// ImportModeAtomic stores all rows of a file (and the account balance when using ImportFile) in a single
+	// database transaction, any database failure rolls back the whole file.
+	ImportModeAtomic
+)
+
+// ImportFile processes a file and recalculates the balance of the account from all its stored transactions. The
+// report returned is the statement of the file alone. In atomic mode both the rows and the balance are committed
+// together, or not at all.
+func (s *TransactionService) ImportFile(ctx context.Context, account dao.Account, parser StatementParser) (dao.Account, BalanceReport, []Rejection, error) {
+	if s.Mode != ImportModeAtomic {
+		report, rejections, err := s.processFile(ctx, s.Database, nil, account.AccountID, reportingCurrency(account), parser)
+		if err != nil {
+			return account, report, rejections, err
+		}
+
+		err = withTx(ctx, s.Database, func(tx *sql.Tx) error {
+			queries := dao.New(s.Database).WithTx(tx)
+			var err error
+			if account, _, err = recalculateBalance(ctx, queries, s.Rates, account); err != nil {
+				return err
+			}
+			return s.notify(ctx, queries, account, s.ImportID, report)
+		})
+		return account, report, rejections, err
+	}
+
+	var report BalanceReport
+	var rejections []Rejection
+	err := withTx(ctx, s.Database, func(tx *sql.Tx) error {
+		var err error
+		report, rejections, err = s.processFile(ctx, tx, &sync.Mutex{}, account.AccountID, reportingCurrency(account), parser)
+		if err != nil {
+			return err
+		}
+
+		queries := dao.New(s.Database).WithTx(tx)
+		if account, _, err = recalculateBalance(ctx, queries, s.Rates, account); err != nil {
+			return err
+		}
+		return s.notify(ctx, queries, account, s.ImportID, report)
+	})
+	return account, report, rejections, err
+}
+
+// ProcessFile start a work group and divides the calculation of transactions, in atomic mode all rows are
+// committed together. Totals are reported in the DefaultCurrency. Rows that could not be imported are returned as
+// rejections, ordered by line.
+func (s *TransactionService) ProcessFile(ctx context.Context, accountID int64, parser StatementParser) (BalanceReport, []Rejection, error) {
+	if s.Mode != ImportModeAtomic {
+		return s.processFile(ctx, s.Database, nil, accountID, DefaultCurrency, parser)
+	}
+
+	var report BalanceReport
+	var rejections []Rejection
+	err := withTx(ctx, s.Database, func(tx *sql.Tx) error {
+		var err error
+		report, rejections, err = s.processFile(ctx, tx, &sync.Mutex{}, accountID, DefaultCurrency, parser)
+		return err
+	})
+	return report, rejections, err
 }
 
-// ProcessFile start a work group and divides the calculation of transactions
-func (s *TransactionService) ProcessFile(ctx context.Context, accountID int64, reader *csv.Reader) (BalanceReport, error) {
+// withTx runs fn inside a database transaction, it is committed only when fn succeeds.
+func withTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			log.Printf("error rolling back transaction: %s", rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// processFile reads every transaction of the statement into the workers, which store them through db, and reports
+// the totals in currency. A shared transaction can only run one statement at a time, so lock must be given in that
+// case.
+func (s *TransactionService) processFile(ctx context.Context, db dao.DBTX, lock *sync.Mutex, accountID int64, currency string, parser StatementParser) (BalanceReport, []Rejection, error) {
 	reports := make(chan WorkerReport)
-	transactions := make(chan CSVRecord, s.BatchSize)
+	transactions := make(chan StatementTransaction, s.BatchSize)
+
+	// Passing the error threshold cancels the reading and the workers
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rejected := &rejectionCounter{threshold: s.Threshold, cancel: cancel}
 
-	// Spin up the workers
-	for i := 0; i < s.Workers; i++ {
+	// Spin up the workers, at least one so the statement is read by someone
+	workers := max(s.Workers, 1)
+	for i := 0; i < workers; i++ {
 		worker := TransactionWorker{
-			db:           s.Database,
+			db:           db,
+			lock:         lock,
+			strategy:     s.Strategy,
+			batchSize:    s.BatchSize,
 			ctx:          ctx,
 			workerID:     i,
 			accountID:    accountID,
+			source:       s.Source,
+			importID:     s.ImportID,
+			rejected:     rejected,
 			transactions: transactions,
 			reports:      reports,
 		}
@@ -52,19 +246,32 @@ func (s *TransactionService) ProcessFile(ctx context.Context, accountID int64, r
 		go worker.PullTransactions()
 	}
 
-	// Read all transactions from file
-	_, err := reader.Read()
-	if err != nil {
-		return BalanceReport{}, fmt.Errorf("error reading header: %w", err)
-	}
-	for {
-		line, err := reader.Read()
-		if errors.Is(err, io.EOF) || errors.Is(err, csv.ErrFieldCount) {
+	// Read all transactions from the statement, entries that can't be parsed are rejected and reading goes on
+	var readErr error
+	var total int64
+	var rejections []Rejection
+reading:
+	for ctx.Err() == nil {
+		transaction, err := parser.Next()
+		var recordErr *RecordError
+		if errors.Is(err, io.EOF) {
 			break
+		} else if errors.As(err, &recordErr) {
+			total += 1
+			rejections = append(rejections, recordErr.Rejection())
+			rejected.add(1)
+			continue
 		} else if err != nil {
-			return BalanceReport{}, err
+			readErr = err
+			break
+		}
+
+		select {
+		case transactions <- transaction:
+			total += 1
+		case <-ctx.Done():
+			break reading
 		}
-		transactions <- line
 	}
 
 	// Terminate processing
@@ -74,40 +281,121 @@ func (s *TransactionService) ProcessFile(ctx context.Context, accountID int64, r
 	receivedReports := 0
 	balanceReport := &BalanceReport{
 		AccountID:        accountID,
-		TotalCredit:      decimal.Zero,
-		TotalDebit:       decimal.Zero,
-		TotalBalance:     decimal.Zero,
-		AvgCreditAmount:  decimal.Zero,
-		AvgDebitAmount:   decimal.Zero,
-		TransactionCount: make(map[int]int),
+		Currency:         currency,
+		Currencies:       make(map[string]CurrencyTotals),
+		TransactionCount: make(map[string]int),
+		accounts:         make(map[int64]AccountTotals),
 	}
+	balanceReport.CountRejected = int64(len(rejections))
+	var insertErr error
 	for workerReport := range reports {
 		receivedReports += 1
+		if insertErr == nil {
+			insertErr = workerReport.Err
+		}
 
 		// add each result
-		balanceReport.TotalDebit = balanceReport.TotalDebit.Add(workerReport.TotalDebit)
-		balanceReport.TotalCredit = balanceReport.TotalCredit.Add(workerReport.TotalCredit)
-		balanceReport.CountDebit += workerReport.CountDebit
-		balanceReport.CountCredit += workerReport.CountCredit
-
-		// add transaction count for each month
-		for month, count := range workerReport.TransactionCount {
-			balanceReport.TransactionCount[month] += count
+		for currency, totals := range workerReport.Currencies {
+			balanceReport.Currencies[currency] = balanceReport.Currencies[currency].merge(totals)
+		}
+		balanceReport.CountDuplicate += int64(workerReport.Duplicates)
+		balanceReport.CountRejected += int64(workerReport.Errors)
+		rejections = append(rejections, workerReport.Rejections...)
+
+		// add transaction count for each year and month
+		for yearMonth, count := range workerReport.TransactionCount {
+			balanceReport.TransactionCount[yearMonth] += count
+		}
+
+		// and the totals of each account of multi-account statements
+		for accountID, totals := range workerReport.Accounts {
+			if _, ok := balanceReport.accounts[accountID]; !ok {
+				balanceReport.accounts[accountID] = newAccountTotals()
+			}
+			balanceReport.accounts[accountID].merge(totals)
 		}
 
 		// interrupt after all workers have reported
-		if receivedReports == s.Workers {
+		if receivedReports == workers {
 			close(reports)
 			break
 		}
 	}
 
-	balanceReport.TotalBalance = balanceReport.TotalCredit.Sub(balanceReport.TotalDebit)
-	if balanceReport.CountCredit != 0 {
-		balanceReport.AvgCreditAmount = balanceReport.TotalCredit.Div(decimal.NewFromInt(balanceReport.CountCredit))
+	sortRejections(rejections)
+	if readErr != nil {
+		return BalanceReport{}, rejections, readErr
+	}
+	if err := s.Threshold.Check(rejected.rejected.Load(), total); err != nil {
+		return BalanceReport{}, rejections, err
+	}
+	if err := ctx.Err(); err != nil {
+		// The caller gave up (a signal or a deadline), report what was stored until then
+		balanceReport.Incomplete = true
+		balanceReport.summarize(context.WithoutCancel(ctx), s.Rates, time.Now())
+		return *balanceReport, rejections, fmt.Errorf("%w: %w", ErrImportIncomplete, err)
+	}
+	if insertErr != nil && s.Mode == ImportModeAtomic {
+		return BalanceReport{}, rejections, fmt.Errorf("error storing transactions: %w", insertErr)
+	}
+
+	balanceReport.summarize(ctx, s.Rates, time.Now())
+	return *balanceReport, rejections, nil
+}
+
+// summarize computes the totals in the currency of the report from the totals of each currency, converted with the
+// rates as of at, along with the balance and averages rounded to the minor units of the currency. The rates used are
+// kept in Rates, so stored reports tell how their totals were converted.
+func (r *BalanceReport) summarize(ctx context.Context, rates FXProvider, at time.Time) {
+	r.TotalCredit, r.TotalDebit = money.Zero(r.Currency), money.Zero(r.Currency)
+	r.AvgCreditAmount, r.AvgDebitAmount = money.Zero(r.Currency), money.Zero(r.Currency)
+	r.CountCredit, r.CountDebit = 0, 0
+	r.Unconverted = nil
+	r.Rates = nil
+
+	currencies := make([]string, 0, len(r.Currencies))
+	for currency := range r.Currencies {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	for _, currency := range currencies {
+		totals := r.Currencies[currency]
+		totals.TotalBalance = totals.TotalCredit.Sub(totals.TotalDebit)
+		r.Currencies[currency] = totals
+
+		rate := decimal.NewFromInt(1)
+		if currency != r.Currency {
+			var err error
+			if rates == nil {
+				err = fmt.Errorf("%w: %s to %s", ErrNoRate, currency, r.Currency)
+			} else {
+				rate, err = rates.Rate(ctx, currency, r.Currency, at)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrNoRate) {
+					log.Printf("error converting %s to %s: %s", currency, r.Currency, err)
+				}
+				r.Unconverted = append(r.Unconverted, currency)
+				continue
+			}
+			if r.Rates == nil {
+				r.Rates = make(map[string]AppliedRate)
+			}
+			r.Rates[currency] = AppliedRate{Rate: rate, At: at}
+		}
+
+		r.TotalCredit = r.TotalCredit.Add(totals.TotalCredit.Convert(rate, r.Currency))
+		r.TotalDebit = r.TotalDebit.Add(totals.TotalDebit.Convert(rate, r.Currency))
+		r.CountCredit += totals.CountCredit
+		r.CountDebit += totals.CountDebit
+	}
+
+	r.TotalBalance = r.TotalCredit.Sub(r.TotalDebit)
+	if r.CountCredit != 0 {
+		r.AvgCreditAmount = r.TotalCredit.Div(r.CountCredit)
 	}
-	if balanceReport.CountDebit != 0 {
-		balanceReport.AvgDebitAmount = balanceReport.TotalDebit.Div(decimal.NewFromInt(balanceReport.CountDebit))
+	if r.CountDebit != 0 {
+		r.AvgDebitAmount = r.TotalDebit.Div(r.CountDebit)
 	}
-	return *balanceReport, nil
 }
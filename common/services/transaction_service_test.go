@@ -2,13 +2,20 @@ package services
 
 import (
 	"bytes"
+	"common/dao"
+	"common/money"
 	"context"
 	"database/sql/driver"
 	"encoding/csv"
+	"errors"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/require"
+	"io"
+	"runtime"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestTransactionService_ProcessFile(t *testing.T) {
@@ -23,58 +30,76 @@ func TestTransactionService_ProcessFile(t *testing.T) {
 		{"Invalid CSV", "", true, 0, [][]driver.Value{}, BalanceReport{}},
 		{"Invalid row", "ID,DATE,AMOUNT\nA,B,C", false, 1, [][]driver.Value{}, BalanceReport{
 			AccountID:        1,
-			TotalCredit:      decimal.Zero,
+			TotalCredit:      money.Zero("MXN"),
 			CountCredit:      0,
-			TotalDebit:       decimal.Zero,
+			TotalDebit:       money.Zero("MXN"),
 			CountDebit:       0,
-			TotalBalance:     decimal.Zero,
-			AvgDebitAmount:   decimal.Zero,
-			AvgCreditAmount:  decimal.Zero,
-			TransactionCount: make(map[int]int),
+			TotalBalance:     money.Zero("MXN"),
+			AvgDebitAmount:   money.Zero("MXN"),
+			AvgCreditAmount:  money.Zero("MXN"),
+			TransactionCount: make(map[string]int),
+			CountRejected:    1,
 		}},
 		{"Single debit", "ID,DATE,AMOUNT\n1,01/01,+1.5", false, 1,
-			[][]driver.Value{{1, "credit", decimal.NewFromFloat(1.5), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()}},
+			[][]driver.Value{{1, "1", "", "credit", decimal.NewFromFloat(1.5), "MXN", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()}},
 			BalanceReport{
 				AccountID:        1,
-				TotalCredit:      decimal.NewFromFloat(1.5),
+				TotalCredit:      money.New(decimal.NewFromFloat(1.5), "MXN"),
 				CountCredit:      1,
-				TotalDebit:       decimal.Zero,
+				TotalDebit:       money.Zero("MXN"),
 				CountDebit:       0,
-				TotalBalance:     decimal.NewFromFloat(1.5),
-				AvgDebitAmount:   decimal.Zero,
-				AvgCreditAmount:  decimal.NewFromFloat(1.5),
-				TransactionCount: map[int]int{1: 1},
+				TotalBalance:     money.New(decimal.NewFromFloat(1.5), "MXN"),
+				AvgDebitAmount:   money.Zero("MXN"),
+				AvgCreditAmount:  money.New(decimal.NewFromFloat(1.5), "MXN"),
+				TransactionCount: map[string]int{"2024-01": 1},
 			},
 		},
 		{"Single credit", "ID,DATE,AMOUNT\n1,01/01,-1.5", false, 1,
-			[][]driver.Value{{1, "debit", decimal.NewFromFloat(1.5), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()}},
+			[][]driver.Value{{1, "1", "", "debit", decimal.NewFromFloat(1.5), "MXN", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()}},
 			BalanceReport{
 				AccountID:        1,
-				TotalCredit:      decimal.Zero,
+				TotalCredit:      money.Zero("MXN"),
 				CountCredit:      0,
-				TotalDebit:       decimal.NewFromFloat(1.5),
+				TotalDebit:       money.New(decimal.NewFromFloat(1.5), "MXN"),
 				CountDebit:       1,
-				TotalBalance:     decimal.NewFromFloat(-1.5),
-				AvgDebitAmount:   decimal.NewFromFloat(1.5),
-				AvgCreditAmount:  decimal.Zero,
-				TransactionCount: map[int]int{1: 1},
+				TotalBalance:     money.New(decimal.NewFromFloat(-1.5), "MXN"),
+				AvgDebitAmount:   money.New(decimal.NewFromFloat(1.5), "MXN"),
+				AvgCreditAmount:  money.Zero("MXN"),
+				TransactionCount: map[string]int{"2024-01": 1},
+			},
+		},
+		{"Full year dates", "ID,DATE,AMOUNT\n1,2023-12-31,-1.5\n2,2024-01-01,+1.5", false, 1,
+			[][]driver.Value{
+				{1, "1", "", "debit", decimal.NewFromFloat(1.5), "MXN", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()},
+				{1, "2", "", "credit", decimal.NewFromFloat(1.5), "MXN", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()},
+			},
+			BalanceReport{
+				AccountID:        1,
+				TotalCredit:      money.New(decimal.NewFromFloat(1.5), "MXN"),
+				CountCredit:      1,
+				TotalDebit:       money.New(decimal.NewFromFloat(1.5), "MXN"),
+				CountDebit:       1,
+				TotalBalance:     money.New(decimal.NewFromFloat(0), "MXN"),
+				AvgDebitAmount:   money.New(decimal.NewFromFloat(1.5), "MXN"),
+				AvgCreditAmount:  money.New(decimal.NewFromFloat(1.5), "MXN"),
+				TransactionCount: map[string]int{"2023-12": 1, "2024-01": 1},
 			},
 		},
 		{"Cancelling debit and credit", "ID,DATE,AMOUNT\n1,01/01,-1.5\n2,01/02,+1.5", false, 1,
 			[][]driver.Value{
-				{1, "debit", decimal.NewFromFloat(1.5), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()},
-				{1, "credit", decimal.NewFromFloat(1.5), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()},
+				{1, "1", "", "debit", decimal.NewFromFloat(1.5), "MXN", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()},
+				{1, "2", "", "credit", decimal.NewFromFloat(1.5), "MXN", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()},
 			},
 			BalanceReport{
 				AccountID:        1,
-				TotalCredit:      decimal.NewFromFloat(1.5),
+				TotalCredit:      money.New(decimal.NewFromFloat(1.5), "MXN"),
 				CountCredit:      1,
-				TotalDebit:       decimal.NewFromFloat(1.5),
+				TotalDebit:       money.New(decimal.NewFromFloat(1.5), "MXN"),
 				CountDebit:       1,
-				TotalBalance:     decimal.NewFromFloat(0),
-				AvgDebitAmount:   decimal.NewFromFloat(1.5),
-				AvgCreditAmount:  decimal.NewFromFloat(1.5),
-				TransactionCount: map[int]int{1: 2},
+				TotalBalance:     money.New(decimal.NewFromFloat(0), "MXN"),
+				AvgDebitAmount:   money.New(decimal.NewFromFloat(1.5), "MXN"),
+				AvgCreditAmount:  money.New(decimal.NewFromFloat(1.5), "MXN"),
+				TransactionCount: map[string]int{"2024-01": 2},
 			},
 		},
 	}
@@ -93,8 +118,11 @@ func TestTransactionService_ProcessFile(t *testing.T) {
 					WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(1))
 			}
 
-			reader := csv.NewReader(bytes.NewBufferString(tc.csvContent))
-			report, err := service.ProcessFile(context.Background(), tc.accountID, reader)
+			parser := &CSVParser{
+				Reader: csv.NewReader(bytes.NewBufferString(tc.csvContent)),
+				Dates:  DateParser{ReferenceDate: time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)},
+			}
+			report, rejections, err := service.ProcessFile(context.Background(), tc.accountID, parser)
 			if tc.expectError {
 				require.Error(t, err)
 				return
@@ -105,12 +133,328 @@ func TestTransactionService_ProcessFile(t *testing.T) {
 			require.Equal(t, tc.expectedReport.AccountID, report.AccountID)
 			require.Equal(t, tc.expectedReport.CountDebit, report.CountDebit)
 			require.Equal(t, tc.expectedReport.CountCredit, report.CountCredit)
+			require.Equal(t, tc.expectedReport.CountRejected, report.CountRejected)
+			require.Len(t, rejections, int(tc.expectedReport.CountRejected))
 			require.Equal(t, tc.expectedReport.TransactionCount, report.TransactionCount)
-			require.Equal(t, tc.expectedReport.TotalBalance.StringFixed(2), report.TotalBalance.StringFixed(2))
-			require.Equal(t, tc.expectedReport.TotalDebit.StringFixed(2), report.TotalDebit.StringFixed(2))
-			require.Equal(t, tc.expectedReport.TotalCredit.StringFixed(2), report.TotalCredit.StringFixed(2))
-			require.Equal(t, tc.expectedReport.AvgDebitAmount.StringFixed(2), report.AvgDebitAmount.StringFixed(2))
-			require.Equal(t, tc.expectedReport.AvgCreditAmount.StringFixed(2), report.AvgCreditAmount.StringFixed(2))
+			require.Equal(t, tc.expectedReport.TotalBalance.String(), report.TotalBalance.String())
+			require.Equal(t, tc.expectedReport.TotalDebit.String(), report.TotalDebit.String())
+			require.Equal(t, tc.expectedReport.TotalCredit.String(), report.TotalCredit.String())
+			require.Equal(t, tc.expectedReport.AvgDebitAmount.String(), report.AvgDebitAmount.String())
+			require.Equal(t, tc.expectedReport.AvgCreditAmount.String(), report.AvgCreditAmount.String())
 		})
 	}
 }
+
+func TestTransactionService_ImportFile(t *testing.T) {
+	accountColumns := []string{
+		"account_id",
+		"first_name",
+		"last_name",
+		"email",
+		"locale",
+		"total_balance",
+		"avg_debit_amount",
+		"avg_credit_amount",
+		"last_balance_at",
+		"created_at",
+		"updated_at",
+		"reporting_currency",
+		"deactivated_at",
+		"merged_into",
+	}
+	accountRow := []driver.Value{int64(1), "John", "Doe", "john.doe@example.com", "es-MX", "1.5", "0", "1.5", nil, nil, nil, nil, nil, nil}
+	account := dao.Account{AccountID: 1}
+	csvContent := "ID,DATE,AMOUNT\n1,2024-01-01,+1.5"
+	insertArgs := []driver.Value{1, "1", "", "credit", decimal.NewFromFloat(1.5), "MXN", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()}
+
+	t.Run("BestEffort", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		service := TransactionService{Database: db, Workers: 1, BatchSize: 1}
+
+		mock.ExpectQuery(`INSERT INTO transactions`).WithArgs(insertArgs...).
+			WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(1))
+		// the account already had transactions from a previous statement
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM transactions`).WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows(totalsColumns).AddRow("MXN", "11.5", 2, "4", 1))
+		mock.ExpectQuery(`SELECT to_char`).WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"year_month", "count"}).AddRow("2023-12", 2).AddRow("2024-01", 1))
+		mock.ExpectQuery(`UPDATE accounts`).WithArgs(sqlmock.AnyArg(), "7.5", "4", "5.75", int64(1)).
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(int64(1), "John", "Doe", "john.doe@example.com", "es-MX", "7.5", "4", "5.75", nil, nil, nil, nil, nil, nil))
+		mock.ExpectQuery(`INSERT INTO balance_snapshots`).WithArgs(int64(1), decimal.RequireFromString("7.5"), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(snapshotColumns).AddRow(1, 1, "7.5", []byte("{}"), time.Now()))
+		mock.ExpectCommit()
+
+		updated, report, _, err := service.ImportFile(context.Background(), account, &CSVParser{Reader: csv.NewReader(bytes.NewBufferString(csvContent))})
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, "7.5", updated.TotalBalance.Decimal.String())
+
+		// the report is the statement of the file alone
+		require.Equal(t, int64(1), report.CountCredit)
+		require.Equal(t, "1.50 MXN", report.TotalBalance.String())
+	})
+
+	t.Run("AtomicCommit", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		service := TransactionService{Database: db, Workers: 2, BatchSize: 1, Mode: ImportModeAtomic}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO transactions`).WithArgs(insertArgs...).
+			WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(1))
+		mock.ExpectQuery(`FROM transactions`).WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows(totalsColumns).AddRow("MXN", "1.5", 1, "0", 0))
+		mock.ExpectQuery(`SELECT to_char`).WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"year_month", "count"}).AddRow("2024-01", 1))
+		mock.ExpectQuery(`UPDATE accounts`).WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(accountRow...))
+		mock.ExpectQuery(`INSERT INTO balance_snapshots`).WillReturnRows(sqlmock.NewRows(snapshotColumns).AddRow(1, 1, "1.5", []byte("{}"), time.Now()))
+		mock.ExpectCommit()
+
+		_, report, _, err := service.ImportFile(context.Background(), account, &CSVParser{Reader: csv.NewReader(bytes.NewBufferString(csvContent))})
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, int64(1), report.CountCredit)
+	})
+
+	t.Run("AtomicRollback", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		service := TransactionService{Database: db, Workers: 1, BatchSize: 1, Mode: ImportModeAtomic}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO transactions`).WithArgs(insertArgs...).WillReturnError(errors.New("db_error"))
+		mock.ExpectRollback()
+
+		_, _, rejections, err := service.ImportFile(context.Background(), account, &CSVParser{Reader: csv.NewReader(bytes.NewBufferString(csvContent))})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "db_error")
+		require.Len(t, rejections, 1)
+		require.Equal(t, 2, rejections[0].Line)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestTransactionService_ProcessFileRejections(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	service := TransactionService{Database: db, Workers: 3, BatchSize: 1}
+
+	mock.MatchExpectationsInOrder(false)
+	for _, id := range []string{"1", "4"} {
+		mock.ExpectQuery(`INSERT INTO transactions`).WithArgs(1, id, "", sqlmock.AnyArg(), sqlmock.AnyArg(), "MXN", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(1))
+	}
+
+	content := "ID,DATE,AMOUNT\n1,2024-01-01,+1.5\n2,2024-13-01,+1.5\nX,2024-01-03,+1.5\n4,2024-01-04,-1.5"
+	report, rejections, err := service.ProcessFile(context.Background(), 1, &CSVParser{Reader: csv.NewReader(bytes.NewBufferString(content))})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Equal(t, int64(2), report.CountRejected)
+	require.Equal(t, []Rejection{
+		{Line: 3, Record: CSVRecord{"2", "2024-13-01", "+1.5"}, Field: "date", Reason: "invalid transaction date: 2024-13-01"},
+		{Line: 4, Record: CSVRecord{"X", "2024-01-03", "+1.5"}, Field: "id", Reason: "invalid transaction id: X"},
+	}, rejections)
+}
+
+func TestTransactionService_ProcessFileThreshold(t *testing.T) {
+	t.Run("FailFast", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		service := TransactionService{Database: db, Workers: 2, BatchSize: 1, Threshold: ErrorThreshold{FailFast: true}}
+
+		content := bytes.NewBufferString("ID,DATE,AMOUNT\n")
+		for i := 0; i < 1000; i++ {
+			content.WriteString("A,B,C\n")
+		}
+
+		_, rejections, err := service.ProcessFile(context.Background(), 1, &CSVParser{Reader: csv.NewReader(content)})
+		require.ErrorIs(t, err, ErrThresholdExceeded)
+		require.NotEmpty(t, rejections)
+		require.Less(t, len(rejections), 1000) // reading stopped early
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("MaxErrorRatio", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		service := TransactionService{Database: db, Workers: 1, BatchSize: 1, Threshold: ErrorThreshold{MaxErrorRatio: 0.5}}
+
+		mock.ExpectQuery(`INSERT INTO transactions`).WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(1))
+
+		content := "ID,DATE,AMOUNT\n1,2024-01-01,+1.5\nA,B,C\nA,B,C"
+		_, rejections, err := service.ProcessFile(context.Background(), 1, &CSVParser{Reader: csv.NewReader(bytes.NewBufferString(content))})
+		require.ErrorIs(t, err, ErrThresholdExceeded)
+		require.Len(t, rejections, 2)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("SkipsBalanceUpdate", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		service := TransactionService{Database: db, Workers: 1, BatchSize: 1, Threshold: ErrorThreshold{MaxErrors: 1}}
+
+		content := "ID,DATE,AMOUNT\nA,B,C\nA,B,C"
+		_, _, _, err = service.ImportFile(context.Background(), dao.Account{AccountID: 1}, &CSVParser{Reader: csv.NewReader(bytes.NewBufferString(content))})
+		require.ErrorIs(t, err, ErrThresholdExceeded)
+		require.NoError(t, mock.ExpectationsWereMet()) // no UPDATE accounts
+	})
+}
+
+func TestTransactionService_ProcessFileBadRowsInTheMiddle(t *testing.T) {
+	content := "ID,DATE,AMOUNT\n" +
+		"1,2024-01-01,+1.5\n" +
+		"2,2024-01-02,+1.5,extra\n" +
+		"3,2024-01-03\n" +
+		"4,2024-01-04,\"-1,000.25\"\n" +
+		"5,2024-01-05,+2.5,\n" +
+		"6,2024-01-06,-0.5\n"
+
+	tests := []struct {
+		name       string
+		tolerant   bool
+		insertIDs  []string
+		rejections []Rejection
+	}{
+		{"Strict", false, []string{"1", "6"}, []Rejection{
+			{Line: 3, Record: CSVRecord{"2", "2024-01-02", "+1.5", "extra"}, Reason: "wrong number of fields"},
+			{Line: 4, Record: CSVRecord{"3", "2024-01-03"}, Reason: "wrong number of fields"},
+			{Line: 5, Record: CSVRecord{"4", "2024-01-04", "-1,000.25"}, Field: "amount", Reason: "invalid transaction operation and amount: -1,000.25"},
+			{Line: 6, Record: CSVRecord{"5", "2024-01-05", "+2.5", ""}, Reason: "wrong number of fields"},
+		}},
+		{"Tolerant", true, []string{"1", "4", "5", "6"}, []Rejection{
+			{Line: 3, Record: CSVRecord{"2", "2024-01-02", "+1.5", "extra"}, Field: "currency", Reason: `invalid currency: "extra"`},
+			{Line: 4, Record: CSVRecord{"3", "2024-01-03"}, Reason: "wrong number of fields: expected 3 or 4, got 2"},
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			service := TransactionService{Database: db, Workers: 1, BatchSize: 1}
+
+			for _, id := range tc.insertIDs {
+				mock.ExpectQuery(`INSERT INTO transactions`).WithArgs(1, id, "", sqlmock.AnyArg(), sqlmock.AnyArg(), "MXN", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(1))
+			}
+
+			report, rejections, err := service.ProcessFile(context.Background(), 1, &CSVParser{Reader: csv.NewReader(bytes.NewBufferString(content)), Tolerant: tc.tolerant})
+			require.NoError(t, err)
+			require.NoError(t, mock.ExpectationsWereMet())
+			require.Equal(t, tc.rejections, rejections)
+			require.Equal(t, int64(len(tc.rejections)), report.CountRejected)
+			require.Equal(t, int64(len(tc.insertIDs)), report.CountCredit+report.CountDebit)
+		})
+	}
+}
+
+// cancellingParser yields credits of 1 and cancels the import once cancelAt of them were read.
+type cancellingParser struct {
+	count    int
+	cancelAt int
+	cancel   context.CancelFunc
+	read     int
+}
+
+func (p *cancellingParser) Next() (StatementTransaction, error) {
+	if p.read == p.cancelAt {
+		p.cancel()
+	}
+	if p.read == p.count {
+		return StatementTransaction{}, io.EOF
+	}
+
+	p.read += 1
+	return StatementTransaction{
+		Line:        p.read + 1,
+		ExternalID:  strconv.Itoa(p.read),
+		PerformedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Operation:   dao.TxOperationTypeCredit,
+		Amount:      decimal.NewFromInt(1),
+	}, nil
+}
+
+// requireNoGoroutineLeaks fails when goroutines started by fn are still running after it returns.
+func requireNoGoroutineLeaks(t *testing.T, fn func()) {
+	before := runtime.NumGoroutine()
+	fn()
+
+	// polled by hand, require.Eventually runs its condition in a goroutine of its own
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.LessOrEqual(t, runtime.NumGoroutine(), before, "goroutines leaked")
+}
+
+func TestTransactionService_ProcessFileCancelled(t *testing.T) {
+	for _, mode := range []ImportMode{ImportModeBestEffort, ImportModeAtomic} {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		mock.MatchExpectationsInOrder(false)
+		if mode == ImportModeAtomic {
+			mock.ExpectBegin()
+		}
+		for i := 0; i < 5; i++ {
+			mock.ExpectQuery(`INSERT INTO transactions`).WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(i))
+		}
+		mock.ExpectRollback()
+
+		requireNoGoroutineLeaks(t, func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			service := TransactionService{Database: db, Workers: 3, BatchSize: 1, Mode: mode}
+			parser := &cancellingParser{count: 1000, cancelAt: 5, cancel: cancel}
+			report, rejections, err := service.ProcessFile(ctx, 1, parser)
+			require.ErrorIs(t, err, ErrImportIncomplete)
+			require.ErrorIs(t, err, context.Canceled)
+			require.Empty(t, rejections)
+			require.True(t, report.Incomplete)
+			require.LessOrEqual(t, report.CountCredit, int64(5))
+			require.True(t, money.New(decimal.NewFromInt(report.CountCredit), DefaultCurrency).Equal(report.TotalBalance))
+
+			// reading stops soon after the cancellation
+			require.Less(t, parser.read, 1000)
+		})
+	}
+}
+
+func TestTransactionService_ProcessFileDeadline(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	// the database hangs, the deadline must interrupt the worker instead of rejecting its rows
+	mock.ExpectQuery(`INSERT INTO transactions`).WillDelayFor(time.Minute).
+		WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(1))
+
+	requireNoGoroutineLeaks(t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		service := TransactionService{Database: db, Workers: 1, BatchSize: 1}
+		content := "id,date,amount\n1,2024-01-01,+1\n2,2024-01-02,+1\n3,2024-01-03,+1\n"
+		report, rejections, err := service.ProcessFile(ctx, 1, &CSVParser{Reader: csv.NewReader(bytes.NewBufferString(content))})
+		require.ErrorIs(t, err, ErrImportIncomplete)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Empty(t, rejections)
+		require.True(t, report.Incomplete)
+		require.Equal(t, int64(0), report.CountCredit)
+	})
+}
+
+func TestTransactionService_ProcessFileWithoutWorkers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	mock.ExpectQuery(`INSERT INTO transactions`).WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(1))
+
+	requireNoGoroutineLeaks(t, func() {
+		service := TransactionService{Database: db, Workers: 0, BatchSize: 0}
+		content := "id,date,amount\n1,2024-01-01,+1\n"
+		report, _, err := service.ProcessFile(context.Background(), 1, &CSVParser{Reader: csv.NewReader(bytes.NewBufferString(content))})
+		require.NoError(t, err)
+		require.False(t, report.Incomplete)
+		require.Equal(t, int64(1), report.CountCredit)
+	})
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -2,125 +2,216 @@ package services
 
 import (
 	"common/dao"
+	"common/money"
 	"context"
 	"database/sql"
-	"fmt"
-	"github.com/shopspring/decimal"
 	"log"
-	"regexp"
+	"sync"
 	"time"
 )
 
-const (
-	dayMonthLayout = "01/02"
-)
-
-var (
-	rTxID                 = regexp.MustCompile(`^\d+$`)
-	rTxDate               = regexp.MustCompile(`^\d{2}/\d{2}$`)
-	rTxOperationAndAmount = regexp.MustCompile(`^[+|-]\d+(\.\d+)?$`)
-)
-
 // WorkerReport holds sums for each worker
 type WorkerReport struct {
-	TotalDebit       decimal.Decimal
-	CountDebit       int64
-	TotalCredit      decimal.Decimal
-	CountCredit      int64
-	TransactionCount map[int]int
+	Currencies       map[string]CurrencyTotals
+	TransactionCount map[string]int
 	Errors           int
+	Duplicates       int
+	Rejections       []Rejection
+
+	// Accounts holds the totals of each account of multi-account statements, by account id, along with the totals of
+	// the whole statement above.
+	Accounts map[int64]AccountTotals
+
+	// Err is the first database error, in atomic mode it aborts the whole import
+	Err error
+}
+
+// AccountTotals are the totals of the transactions of one account within a multi-account statement.
+type AccountTotals struct {
+	Currencies       map[string]CurrencyTotals
+	TransactionCount map[string]int
+}
+
+// newAccountTotals returns empty totals.
+func newAccountTotals() AccountTotals {
+	return AccountTotals{Currencies: make(map[string]CurrencyTotals), TransactionCount: make(map[string]int)}
+}
+
+// add counts a transaction in the totals.
+func (t AccountTotals) add(transaction StatementTransaction) {
+	t.TransactionCount[transaction.PerformedAt.Format(yearMonthLayout)] += 1
+	totals, ok := t.Currencies[transaction.Currency]
+	if !ok {
+		totals = newCurrencyTotals(transaction.Currency)
+	}
+	totals.add(transaction.Operation, money.New(transaction.Amount, transaction.Currency))
+	t.Currencies[transaction.Currency] = totals
+}
+
+// merge adds other totals to these.
+func (t AccountTotals) merge(other AccountTotals) {
+	for currency, totals := range other.Currencies {
+		merged, ok := t.Currencies[currency]
+		if !ok {
+			merged = newCurrencyTotals(currency)
+		}
+		t.Currencies[currency] = merged.merge(totals)
+	}
+	for yearMonth, count := range other.TransactionCount {
+		t.TransactionCount[yearMonth] += count
+	}
 }
 
-// TransactionWorker processes CSV records as a work group.
+// TransactionWorker stores statement transactions as a work group.
 type TransactionWorker struct {
-	db           *sql.DB
+	db           dao.DBTX
+	lock         *sync.Mutex
+	strategy     InsertStrategy
+	batchSize    int
 	ctx          context.Context
 	workerID     int
 	accountID    int64
-	transactions chan CSVRecord
+	source       string
+	importID     int64
+	rejected     *rejectionCounter
+	transactions chan StatementTransaction
 	reports      chan WorkerReport
 }
 
-// ValidateRecord validates a CSV record and extracts transaction date, operation type, and amount.
-// Returns an error if any of the fields are invalid.
-func (w *TransactionWorker) ValidateRecord(record CSVRecord) (txDate time.Time, txOperation dao.TxOperationType, txAmount decimal.Decimal, err error) {
-	if !rTxID.MatchString(record[0]) {
-		err = fmt.Errorf("invalid transaction id: %s", record[0])
-		return
+// PullTransactions processes transactions from the worker's transaction channel and inserts them into the database.
+func (w *TransactionWorker) PullTransactions() {
+	writer := newTransactionWriter(w.strategy, w.db)
+	batchSize := w.batchSize
+	if w.strategy == InsertStrategyRow || batchSize < 1 {
+		batchSize = 1
 	}
 
-	if !rTxDate.MatchString(record[1]) {
-		err = fmt.Errorf("invalid transaction date: %s", record[1])
-		return
+	now := time.Now()
+	inserted := 0
+	batch := make([]dao.InsertTransactionParams, 0, batchSize)
+	transactions := make([]StatementTransaction, 0, batchSize)
+	report := WorkerReport{
+		Currencies:       make(map[string]CurrencyTotals),
+		TransactionCount: make(map[string]int),
+		Accounts:         make(map[int64]AccountTotals),
 	}
+pulling:
+	for {
+		var transaction StatementTransaction
+		var ok bool
+		select {
+		case <-w.ctx.Done():
+			// The import was aborted, transactions not stored yet are abandoned
+			break pulling
+		case transaction, ok = <-w.transactions:
+			if !ok {
+				break pulling
+			}
+		}
 
-	txDate, err = time.Parse(dayMonthLayout, record[1])
-	if err != nil {
-		return
+		if transaction.Currency == "" {
+			transaction.Currency = DefaultCurrency
+		}
+		if transaction.AccountID == 0 {
+			transaction.AccountID = w.accountID
+		}
+		if transaction.ImportID == 0 {
+			transaction.ImportID = w.importID
+		}
+		// batches are written for a single account and import, rows of multi-account statements start a new one
+		if len(batch) > 0 && (batch[0].AccountID != transaction.AccountID || batch[0].ImportID.Int64 != transaction.ImportID) {
+			inserted += w.flush(writer, batch, transactions, &report)
+			batch = batch[:0]
+			transactions = transactions[:0]
+		}
+		batch = append(batch, dao.InsertTransactionParams{
+			AccountID:   transaction.AccountID,
+			ExternalID:  transaction.ExternalID,
+			Source:      w.source,
+			Operation:   transaction.Operation,
+			Amount:      transaction.Amount,
+			Currency:    transaction.Currency,
+			PerformedAt: transaction.PerformedAt,
+			CreatedAt:   sql.NullTime{Valid: true, Time: now},
+			UpdatedAt:   sql.NullTime{Valid: true, Time: now},
+			ImportID:    sql.NullInt64{Valid: transaction.ImportID != 0, Int64: transaction.ImportID},
+		})
+		transactions = append(transactions, transaction)
+		if len(batch) >= batchSize {
+			inserted += w.flush(writer, batch, transactions, &report)
+			batch = batch[:0]
+			transactions = transactions[:0]
+		}
 	}
-	txDate = txDate.AddDate(time.Now().Year(), 0, 0)
+	inserted += w.flush(writer, batch, transactions, &report)
 
-	if !rTxOperationAndAmount.MatchString(record[2]) {
-		err = fmt.Errorf("invalid transaction operation and amount: %s", record[2])
-		return
-	}
+	log.Printf("worker %d: inserted %d transactions in %s with %d duplicates and %d rejections", w.workerID, inserted, time.Since(now), report.Duplicates, report.Errors)
+	w.reports <- report
+}
 
-	operationAndAmount := record[2]
-	txOperation = dao.TxOperationTypeCredit
-	if operationAndAmount[0:1] == "-" {
-		txOperation = dao.TxOperationTypeDebit
+// flush stores a batch into the database, rows already imported from the same source are counted as duplicates.
+// Access to the database is serialized when sharing a transaction.
+func (w *TransactionWorker) flush(writer transactionWriter, batch []dao.InsertTransactionParams, transactions []StatementTransaction, report *WorkerReport) int {
+	if len(batch) == 0 {
+		return 0
 	}
 
-	txAmount, err = decimal.NewFromString(operationAndAmount[1:])
-	return
-}
+	// A failed database transaction rejects every statement afterward, and an aborted import stores nothing else
+	if w.lock != nil && report.Err != nil {
+		w.reject(report, report.Err, transactions...)
+		return 0
+	}
+	if w.ctx.Err() != nil {
+		return 0
+	}
 
-// PullTransactions processes transaction records from the worker's transaction channel and inserts them into the database.
-func (w *TransactionWorker) PullTransactions() {
-	queries := dao.New(w.db)
-	now := time.Now()
-	inserted := 0
-	report := WorkerReport{
-		TotalDebit:       decimal.Zero,
-		TotalCredit:      decimal.Zero,
-		TransactionCount: make(map[int]int),
+	if w.lock != nil {
+		w.lock.Lock()
+		defer w.lock.Unlock()
 	}
-	for transaction := range w.transactions {
-		performedAt, operation, amount, err := w.ValidateRecord(transaction)
-		if err != nil {
-			log.Printf("worker %d: error validating transaction: %s", w.workerID, err)
-			report.Errors += 1
-			continue
+
+	inserted, written, err := writer.Write(w.ctx, batch)
+	w.count(report, transactions[:written])
+	report.Duplicates += written - inserted
+	if err != nil {
+		// Interrupted by the cancellation, the rest of the batch is abandoned rather than rejected
+		if w.ctx.Err() != nil {
+			return inserted
 		}
 
-		// Perform basic calculations
-		month := performedAt.Month()
-		report.TransactionCount[int(month)] += 1
-		if operation == dao.TxOperationTypeDebit {
-			report.TotalDebit = report.TotalDebit.Add(amount)
-			report.CountDebit += 1
-		} else {
-			report.TotalCredit = report.TotalCredit.Add(amount)
-			report.CountCredit += 1
+		log.Printf("worker %d: error inserting %d transactions: %s", w.workerID, len(batch)-written, err)
+		w.reject(report, err, transactions[written:]...)
+		if report.Err == nil {
+			report.Err = err
 		}
+	}
+	return inserted
+}
 
-		// Insert transaction into database
-		_, err = queries.InsertTransaction(w.ctx, dao.InsertTransactionParams{
-			AccountID:   w.accountID,
-			Operation:   operation,
-			Amount:      amount,
-			PerformedAt: performedAt,
-			CreatedAt:   sql.NullTime{Valid: true, Time: now},
-			UpdatedAt:   sql.NullTime{Valid: true, Time: now},
-		})
-		if err != nil {
-			log.Printf("worker %d: error inserting transaction: %s", w.workerID, err)
-			report.Errors += 1
+// count adds transactions stored (or already stored before) to the totals of the report, and to the ones of their
+// account in multi-account statements.
+func (w *TransactionWorker) count(report *WorkerReport, transactions []StatementTransaction) {
+	statement := AccountTotals{Currencies: report.Currencies, TransactionCount: report.TransactionCount}
+	for _, transaction := range transactions {
+		statement.add(transaction)
+		if transaction.Account == "" {
+			continue
 		}
 
-		inserted += 1
+		totals, ok := report.Accounts[transaction.AccountID]
+		if !ok {
+			totals = newAccountTotals()
+			report.Accounts[transaction.AccountID] = totals
+		}
+		totals.add(transaction)
 	}
+}
 
-	log.Printf("worker %d: inserted %d transactions in %s with %d errors", w.workerID, inserted, time.Since(now), report.Errors)
-	w.reports <- report
+// reject adds the transactions to the rejections of the report, counting them towards the error threshold.
+func (w *TransactionWorker) reject(report *WorkerReport, err error, transactions ...StatementTransaction) {
+	for _, transaction := range transactions {
+		report.Rejections = append(report.Rejections, newRejection(transaction, err))
+	}
+	report.Errors += len(transactions)
+	w.rejected.add(len(transactions))
 }
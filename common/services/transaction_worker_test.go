@@ -2,77 +2,36 @@ package services
 
 import (
 	"common/dao"
+	"common/money"
 	"context"
 	"database/sql/driver"
+	"errors"
+	"fmt"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/shopspring/decimal"
-	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"testing"
 	"time"
 )
 
-func TestTransactionWorker_ValidateRecord(t *testing.T) {
-	tests := []struct {
-		name      string
-		record    CSVRecord
-		expectErr bool
-	}{
-		{
-			name:      "Valid Record",
-			record:    []string{"422202", "01/31", "+10.50"},
-			expectErr: false,
-		},
-		{
-			name:      "Invalid ID",
-			record:    []string{"422AA2", "29/10", "+10.50"},
-			expectErr: true,
-		},
-		{
-			name:      "Invalid Date",
-			record:    []string{"422202", "13-20", "+10.50"},
-			expectErr: true,
-		},
-		{
-			name:      "Invalid Operation type",
-			record:    []string{"422202", "29/10", "~10.50"},
-			expectErr: true,
-		},
-		{
-			name:      "Invalid Amount",
-			record:    []string{"422202", "29/10", "+1A.50"},
-			expectErr: true,
-		},
-	}
-
-	for _, tc := range tests {
-		t.Run(tc.name, func(t *testing.T) {
-			worker := TransactionWorker{}
-			date, op, amount, err := worker.ValidateRecord(tc.record)
-
-			if tc.expectErr {
-				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
-				assert.IsType(t, time.Time{}, date)
-				assert.IsType(t, dao.TxOperationType(""), op)
-				assert.IsType(t, decimal.Decimal{}, amount)
-			}
-		})
-	}
-}
-
-func TestTransactionWorker_ParseRecord(t *testing.T) {
+func TestTransactionWorker_PullTransactions(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
 
 	accountID := int64(20)
-	csvRow := []string{"10", "01/31", "+10.50"}
-	args := []driver.Value{accountID, "credit", decimal.NewFromFloat(10.50), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()}
+	transaction := StatementTransaction{
+		Line:        2,
+		Record:      []string{"10", "01/31", "+10.50"},
+		ExternalID:  "10",
+		PerformedAt: time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+		Operation:   dao.TxOperationTypeCredit,
+		Amount:      decimal.NewFromFloat(10.50),
+	}
+	args := []driver.Value{accountID, "10", "statement.csv", "credit", decimal.NewFromFloat(10.50), "MXN", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()}
 	mock.ExpectQuery(`INSERT INTO transactions`).WithArgs(args...).
 		WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(1))
 
-	transactions := make(chan CSVRecord)
+	transactions := make(chan StatementTransaction)
 	reports := make(chan WorkerReport)
 
 	worker := TransactionWorker{
@@ -80,31 +39,180 @@ func TestTransactionWorker_ParseRecord(t *testing.T) {
 		ctx:          context.Background(),
 		workerID:     0,
 		accountID:    accountID,
+		source:       "statement.csv",
 		transactions: transactions,
 		reports:      reports,
 	}
 
 	go worker.PullTransactions()
-	transactions <- csvRow
+	transactions <- transaction
 	close(transactions)
 
 	report := <-reports
 	close(reports)
 
 	expectedReport := WorkerReport{
-		TotalDebit:       decimal.Zero,
-		CountDebit:       0,
-		TotalCredit:      decimal.NewFromFloat(10.50),
-		CountCredit:      1,
-		TransactionCount: map[int]int{1: 1},
+		Currencies: map[string]CurrencyTotals{
+			"MXN": {TotalDebit: money.Zero("MXN"), TotalCredit: money.New(decimal.NewFromFloat(10.50), "MXN"), CountCredit: 1},
+		},
+		TransactionCount: map[string]int{"2024-01": 1},
 		Errors:           0,
 	}
 
 	require.NoError(t, mock.ExpectationsWereMet())
-	require.Equal(t, expectedReport.TotalDebit.StringFixed(2), report.TotalDebit.StringFixed(2))
-	require.Equal(t, expectedReport.TotalCredit.StringFixed(2), report.TotalCredit.StringFixed(2))
-	require.Equal(t, expectedReport.CountDebit, report.CountDebit)
-	require.Equal(t, expectedReport.CountCredit, report.CountCredit)
+	require.Len(t, report.Currencies, 1)
+	require.Equal(t, expectedReport.Currencies["MXN"].TotalDebit.String(), report.Currencies["MXN"].TotalDebit.String())
+	require.Equal(t, expectedReport.Currencies["MXN"].TotalCredit.String(), report.Currencies["MXN"].TotalCredit.String())
+	require.Equal(t, expectedReport.Currencies["MXN"].CountDebit, report.Currencies["MXN"].CountDebit)
+	require.Equal(t, expectedReport.Currencies["MXN"].CountCredit, report.Currencies["MXN"].CountCredit)
 	require.Equal(t, expectedReport.TransactionCount, report.TransactionCount)
 	require.Equal(t, expectedReport.Errors, report.Errors)
 }
+
+func TestTransactionWorker_DuplicateRecord(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	accountID := int64(20)
+	transaction := StatementTransaction{
+		Line:        2,
+		Record:      []string{"10", "01/31", "-10.50"},
+		ExternalID:  "10",
+		PerformedAt: time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+		Operation:   dao.TxOperationTypeDebit,
+		Amount:      decimal.NewFromFloat(10.50),
+	}
+	args := []driver.Value{accountID, "10", "statement.csv", "debit", decimal.NewFromFloat(10.50), "MXN", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()}
+	mock.ExpectQuery(`INSERT INTO transactions`).WithArgs(args...).
+		WillReturnRows(sqlmock.NewRows([]string{"transaction_id"})) // ON CONFLICT DO NOTHING returns no rows
+
+	transactions := make(chan StatementTransaction)
+	reports := make(chan WorkerReport)
+
+	worker := TransactionWorker{
+		db:           db,
+		ctx:          context.Background(),
+		workerID:     0,
+		accountID:    accountID,
+		source:       "statement.csv",
+		transactions: transactions,
+		reports:      reports,
+	}
+
+	go worker.PullTransactions()
+	transactions <- transaction
+	close(transactions)
+
+	report := <-reports
+	close(reports)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Equal(t, "10.50 MXN", report.Currencies["MXN"].TotalDebit.String())
+	require.Equal(t, int64(1), report.Currencies["MXN"].CountDebit)
+	require.Equal(t, 1, report.Duplicates)
+	require.Equal(t, 0, report.Errors)
+}
+
+func TestTransactionWorker_PullTransactionsByAccount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	// rows of other accounts and imports start a new batch
+	mock.ExpectExec(`INSERT INTO transactions`).
+		WithArgs(int64(1), `{"1","2"}`, "feed.csv", `{"credit","credit"}`, `{"1","2"}`, `{"MXN","MXN"}`, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO transactions`).
+		WithArgs(int64(2), `{"3"}`, "feed.csv", `{"credit"}`, `{"3"}`, `{"MXN"}`, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), int64(8)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	transactions := make(chan StatementTransaction)
+	reports := make(chan WorkerReport)
+	worker := TransactionWorker{
+		db:           db,
+		batchSize:    10,
+		strategy:     InsertStrategyBatch,
+		ctx:          context.Background(),
+		source:       "feed.csv",
+		transactions: transactions,
+		reports:      reports,
+	}
+
+	go worker.PullTransactions()
+	for i, accountID := range []int64{1, 1, 2} {
+		transactions <- StatementTransaction{
+			Line:        i + 2,
+			ExternalID:  fmt.Sprint(i + 1),
+			PerformedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			Operation:   dao.TxOperationTypeCredit,
+			Amount:      decimal.NewFromInt(int64(i + 1)),
+			Account:     fmt.Sprint(accountID),
+			AccountID:   accountID,
+			ImportID:    accountID + 6,
+		}
+	}
+	close(transactions)
+
+	report := <-reports
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Equal(t, int64(2), report.Accounts[1].Currencies["MXN"].CountCredit)
+	require.Equal(t, int64(1), report.Accounts[2].Currencies["MXN"].CountCredit)
+}
+
+// partialWriter writes the rows of a batch up to a failing one, finding the second row already stored.
+type partialWriter struct {
+	written int
+}
+
+func (w *partialWriter) Write(ctx context.Context, batch []dao.InsertTransactionParams) (int, int, error) {
+	return 1, w.written, errors.New("connection reset")
+}
+
+func TestTransactionWorker_FlushPartialBatch(t *testing.T) {
+	worker := TransactionWorker{ctx: context.Background()}
+	transactions := []StatementTransaction{
+		{Line: 2, ExternalID: "1", PerformedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Operation: dao.TxOperationTypeCredit, Amount: decimal.NewFromInt(1), Currency: "MXN"},
+		{Line: 3, ExternalID: "2", PerformedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Operation: dao.TxOperationTypeCredit, Amount: decimal.NewFromInt(2), Currency: "MXN"},
+		{Line: 4, ExternalID: "3", PerformedAt: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), Operation: dao.TxOperationTypeCredit, Amount: decimal.NewFromInt(3), Currency: "MXN"},
+	}
+	batch := make([]dao.InsertTransactionParams, len(transactions))
+	report := WorkerReport{Currencies: make(map[string]CurrencyTotals), TransactionCount: make(map[string]int), Accounts: make(map[int64]AccountTotals)}
+
+	// the duplicate among the written rows is counted as such, only the rows after them are rejected
+	inserted := worker.flush(&partialWriter{written: 2}, batch, transactions, &report)
+	require.Equal(t, 1, inserted)
+	require.Equal(t, int64(2), report.Currencies["MXN"].CountCredit)
+	require.Equal(t, 1, report.Duplicates)
+	require.Equal(t, 1, report.Errors)
+	require.Equal(t, []Rejection{{Line: 4, Reason: "connection reset"}}, report.Rejections)
+	require.EqualError(t, report.Err, "connection reset")
+}
+
+func TestTransactionWorker_PullTransactionsCancelled(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	transactions := make(chan StatementTransaction)
+	reports := make(chan WorkerReport)
+
+	worker := TransactionWorker{
+		db:           db,
+		batchSize:    10,
+		strategy:     InsertStrategyBatch,
+		ctx:          ctx,
+		accountID:    20,
+		transactions: transactions,
+		reports:      reports,
+	}
+
+	requireNoGoroutineLeaks(t, func() {
+		go worker.PullTransactions()
+		transactions <- StatementTransaction{Line: 2, ExternalID: "10", Operation: dao.TxOperationTypeCredit, Amount: decimal.NewFromInt(1)}
+
+		// the worker reports without waiting for the channel to be closed, the pending batch is abandoned
+		cancel()
+		report := <-reports
+		require.Empty(t, report.Currencies)
+		require.Empty(t, report.Rejections)
+	})
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"common/dao"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"log"
+	"time"
+)
+
+// InsertStrategy selects how workers store validated transactions.
+type InsertStrategy int
+
+const (
+	// InsertStrategyRow runs one INSERT per transaction.
+	InsertStrategyRow InsertStrategy = iota
+
+	// InsertStrategyBatch runs one multi-row INSERT per BatchSize transactions.
+	InsertStrategyBatch
+
+	// InsertStrategyCopy streams BatchSize transactions with COPY FROM STDIN into a staging table, then moves them
+	// into transactions skipping duplicates.
+	InsertStrategyCopy
+)
+
+const (
+	createStagingTable = `CREATE TEMP TABLE IF NOT EXISTS transactions_staging (
+    account_id BIGINT NOT NULL,
+    external_id TEXT NOT NULL,
+    source TEXT NOT NULL,
+    operation TX_OPERATION_TYPE NOT NULL,
+    amount DECIMAL(16, 2) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    performed_at DATE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE,
+    import_id BIGINT
+)`
+	moveStagedTransactions = `INSERT INTO transactions
+    (account_id, external_id, source, operation, amount, currency, performed_at, created_at, updated_at, import_id)
+SELECT account_id, external_id, source, operation, amount, currency, performed_at, created_at, updated_at, import_id
+FROM transactions_staging
+ON CONFLICT (account_id, source, external_id) DO NOTHING`
+	truncateStagingTable = `TRUNCATE transactions_staging`
+)
+
+var stagingColumns = []string{"account_id", "external_id", "source", "operation", "amount", "currency", "performed_at", "created_at", "updated_at", "import_id"}
+
+// ParseInsertStrategy returns the strategy by its name: row, batch or copy.
+func ParseInsertStrategy(name string) (InsertStrategy, error) {
+	switch name {
+	case "", "row":
+		return InsertStrategyRow, nil
+	case "batch":
+		return InsertStrategyBatch, nil
+	case "copy":
+		return InsertStrategyCopy, nil
+	}
+
+	return InsertStrategyRow, fmt.Errorf("unknown insert strategy: %s", name)
+}
+
+// String returns the name of the strategy.
+func (s InsertStrategy) String() string {
+	switch s {
+	case InsertStrategyBatch:
+		return "batch"
+	case InsertStrategyCopy:
+		return "copy"
+	default:
+		return "row"
+	}
+}
+
+// transactionWriter stores a batch of transactions of the same account, source and import. It returns how many rows
+// were new, the rest were already stored, and how many rows at the start of the batch were written (stored or found
+// already stored) before an error: the rest of the batch is not stored. Writers that store a batch with a single
+// statement are all or nothing, they write no rows when failing.
+type transactionWriter interface {
+	Write(ctx context.Context, batch []dao.InsertTransactionParams) (inserted int, written int, err error)
+}
+
+// newTransactionWriter builds the writer for a strategy on top of a database or a transaction.
+func newTransactionWriter(strategy InsertStrategy, db dao.DBTX) transactionWriter {
+	switch strategy {
+	case InsertStrategyBatch:
+		return &batchWriter{queries: dao.New(db)}
+	case InsertStrategyCopy:
+		return &copyWriter{db: db}
+	default:
+		return &rowWriter{queries: dao.New(db)}
+	}
+}
+
+// rowWriter inserts each transaction with its own statement.
+type rowWriter struct {
+	queries *dao.Queries
+}
+
+func (w *rowWriter) Write(ctx context.Context, batch []dao.InsertTransactionParams) (int, int, error) {
+	inserted := 0
+	for i, params := range batch {
+		_, err := w.queries.InsertTransaction(ctx, params)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return inserted, i, err
+		}
+
+		inserted += 1
+	}
+
+	return inserted, len(batch), nil
+}
+
+// batchWriter inserts a whole batch with a single statement, its rows must share the account, source and import.
+type batchWriter struct {
+	queries *dao.Queries
+}
+
+func (w *batchWriter) Write(ctx context.Context, batch []dao.InsertTransactionParams) (int, int, error) {
+	if len(batch) == 0 {
+		return 0, 0, nil
+	}
+
+	params := dao.InsertTransactionsParams{
+		AccountID:    batch[0].AccountID,
+		Source:       batch[0].Source,
+		ExternalIds:  make([]string, len(batch)),
+		Operations:   make([]dao.TxOperationType, len(batch)),
+		Amounts:      make([]decimal.Decimal, len(batch)),
+		Currencies:   make([]string, len(batch)),
+		PerformedAts: make([]time.Time, len(batch)),
+		CreatedAt:    batch[0].CreatedAt.Time,
+		UpdatedAt:    batch[0].UpdatedAt.Time,
+		ImportID:     batch[0].ImportID,
+	}
+	for i, row := range batch {
+		params.ExternalIds[i] = row.ExternalID
+		params.Operations[i] = row.Operation
+		params.Amounts[i] = row.Amount
+		params.Currencies[i] = row.Currency
+		params.PerformedAts[i] = row.PerformedAt
+	}
+
+	inserted, err := w.queries.InsertTransactions(ctx, params)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(inserted), len(batch), nil
+}
+
+// copyWriter streams a batch through COPY, which requires a transaction: the one given or a new one per batch.
+type copyWriter struct {
+	db dao.DBTX
+}
+
+func (w *copyWriter) Write(ctx context.Context, batch []dao.InsertTransactionParams) (int, int, error) {
+	if len(batch) == 0 {
+		return 0, 0, nil
+	}
+
+	switch db := w.db.(type) {
+	case *sql.Tx:
+		inserted, err := copyTransactions(ctx, db, batch)
+		if err != nil {
+			return 0, 0, err
+		}
+		return inserted, len(batch), nil
+	case *sql.DB:
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		inserted, err := copyTransactions(ctx, tx, batch)
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("error rolling back copy: %s", rbErr)
+			}
+			return 0, 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, 0, err
+		}
+		return inserted, len(batch), nil
+	}
+
+	return 0, 0, fmt.Errorf("copy requires *sql.DB or *sql.Tx, got %T", w.db)
+}
+
+// copyTransactions copies a batch into the staging table and moves the new rows into transactions.
+func copyTransactions(ctx context.Context, tx *sql.Tx, batch []dao.InsertTransactionParams) (int, error) {
+	if _, err := tx.ExecContext(ctx, createStagingTable); err != nil {
+		return 0, fmt.Errorf("error creating staging table: %w", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("transactions_staging", stagingColumns...))
+	if err != nil {
+		return 0, fmt.Errorf("error starting copy: %w", err)
+	}
+	for _, row := range batch {
+		_, err = stmt.ExecContext(ctx, row.AccountID, row.ExternalID, row.Source, string(row.Operation), row.Amount, row.Currency, row.PerformedAt, row.CreatedAt, row.UpdatedAt, row.ImportID)
+		if err != nil {
+			_ = stmt.Close()
+			return 0, fmt.Errorf("error copying transaction %s: %w", row.ExternalID, err)
+		}
+	}
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		_ = stmt.Close()
+		return 0, fmt.Errorf("error flushing copy: %w", err)
+	}
+	if err = stmt.Close(); err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, moveStagedTransactions)
+	if err != nil {
+		return 0, fmt.Errorf("error moving staged transactions: %w", err)
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err = tx.ExecContext(ctx, truncateStagingTable); err != nil {
+		return 0, fmt.Errorf("error truncating staging table: %w", err)
+	}
+	return int(inserted), nil
+}
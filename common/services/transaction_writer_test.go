@@ -0,0 +1,164 @@
+package services
+
+import (
+	"bytes"
+	"common/dao"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+func testBatch() []dao.InsertTransactionParams {
+	now := time.Now()
+	return []dao.InsertTransactionParams{
+		{
+			AccountID:   1,
+			ExternalID:  "1",
+			Source:      "statement.csv",
+			Operation:   dao.TxOperationTypeCredit,
+			Amount:      decimal.NewFromFloat(1.5),
+			Currency:    "MXN",
+			PerformedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			CreatedAt:   sql.NullTime{Valid: true, Time: now},
+			UpdatedAt:   sql.NullTime{Valid: true, Time: now},
+		},
+		{
+			AccountID:   1,
+			ExternalID:  "2",
+			Source:      "statement.csv",
+			Operation:   dao.TxOperationTypeDebit,
+			Amount:      decimal.NewFromFloat(2.5),
+			Currency:    "MXN",
+			PerformedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+			CreatedAt:   sql.NullTime{Valid: true, Time: now},
+			UpdatedAt:   sql.NullTime{Valid: true, Time: now},
+		},
+	}
+}
+
+func TestParseInsertStrategy(t *testing.T) {
+	for _, strategy := range []InsertStrategy{InsertStrategyRow, InsertStrategyBatch, InsertStrategyCopy} {
+		parsed, err := ParseInsertStrategy(strategy.String())
+		require.NoError(t, err)
+		require.Equal(t, strategy, parsed)
+	}
+
+	_, err := ParseInsertStrategy("bulk")
+	require.Error(t, err)
+}
+
+func TestBatchWriter_Write(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	mock.ExpectExec(`INSERT INTO transactions`).
+		WithArgs(int64(1), `{"1","2"}`, "statement.csv", `{"credit","debit"}`, `{"1.5","2.5"}`, `{"MXN","MXN"}`, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1)) // one of them was already stored
+
+	writer := newTransactionWriter(InsertStrategyBatch, db)
+	inserted, written, err := writer.Write(context.Background(), testBatch())
+	require.NoError(t, err)
+	require.Equal(t, 1, inserted)
+	require.Equal(t, 2, written)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCopyWriter_Write(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE TEMP TABLE IF NOT EXISTS transactions_staging`).WillReturnResult(sqlmock.NewResult(0, 0))
+	prepare := mock.ExpectPrepare(`COPY "transactions_staging"`)
+	prepare.ExpectExec().WithArgs(int64(1), "1", "statement.csv", "credit", sqlmock.AnyArg(), "MXN", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	prepare.ExpectExec().WithArgs(int64(1), "2", "statement.csv", "debit", sqlmock.AnyArg(), "MXN", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	prepare.ExpectExec().WithoutArgs().WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO transactions`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`TRUNCATE transactions_staging`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	writer := newTransactionWriter(InsertStrategyCopy, db)
+	inserted, written, err := writer.Write(context.Background(), testBatch())
+	require.NoError(t, err)
+	require.Equal(t, 2, inserted)
+	require.Equal(t, 2, written)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTransactionService_ProcessFileInBatches(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	service := TransactionService{
+		Database:  db,
+		Workers:   1,
+		BatchSize: 2,
+		Strategy:  InsertStrategyBatch,
+	}
+
+	// three rows make a full batch and a partial one
+	mock.ExpectExec(`INSERT INTO transactions`).
+		WithArgs(int64(1), `{"1","2"}`, "", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO transactions`).
+		WithArgs(int64(1), `{"3"}`, "", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	parser := &CSVParser{Reader: csv.NewReader(bytes.NewBufferString("ID,DATE,AMOUNT\n1,2024-01-01,+1.5\n2,2024-01-02,-1.5\n3,2024-01-03,+2"))}
+	report, _, err := service.ProcessFile(context.Background(), 1, parser)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Equal(t, int64(2), report.CountCredit)
+	require.Equal(t, int64(1), report.CountDebit)
+	require.Equal(t, int64(1), report.CountDuplicate)
+}
+
+// BenchmarkTransactionService_ProcessFile compares insert strategies against a real database, set
+// BENCH_DATABASE_URL to a PostgreSQL database with the schema loaded to run it.
+func BenchmarkTransactionService_ProcessFile(b *testing.B) {
+	databaseURL := os.Getenv("BENCH_DATABASE_URL")
+	if databaseURL == "" {
+		b.Skip("BENCH_DATABASE_URL not set")
+	}
+
+	db, err := sql.Open("postgres", databaseURL)
+	require.NoError(b, err)
+	defer func() { require.NoError(b, db.Close()) }()
+
+	accountService := AccountService{Database: db}
+	account, err := accountService.FetchOrCreateAccount(context.Background(), "fake+bench@example.com", "Bench", "Mark")
+	require.NoError(b, err)
+
+	const rows = 10000
+	content := bytes.NewBufferString("id,date,amount\n")
+	for i := 0; i < rows; i++ {
+		_, _ = fmt.Fprintf(content, "%d,2024-%02d-%02d,%+.2f\n", i, i%12+1, i%28+1, float64(i%2000-1000)/3)
+	}
+
+	for _, strategy := range []InsertStrategy{InsertStrategyRow, InsertStrategyBatch, InsertStrategyCopy} {
+		b.Run(strategy.String(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				service := TransactionService{
+					Database:  db,
+					Workers:   5,
+					BatchSize: 1000,
+					Strategy:  strategy,
+					Source:    fmt.Sprintf("bench-%s-%d-%d", strategy, time.Now().UnixNano(), i),
+				}
+
+				_, _, err := service.ProcessFile(context.Background(), account.AccountID, &CSVParser{Reader: csv.NewReader(bytes.NewReader(content.Bytes()))})
+				require.NoError(b, err)
+			}
+			b.ReportMetric(float64(rows*b.N)/b.Elapsed().Seconds(), "rows/s")
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package web
+
+import (
+	"bytes"
+	"common/services"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// BalanceHandler serves the balance page and its JSON API, accounts are identified by signed tokens given as the
+// token query parameter or, for the API, as a Bearer token.
+type BalanceHandler struct {
+	Accounts *services.AccountService
+	Tokens   *services.BalanceTokens
+	Page     *services.BalancePage
+}
+
+// errorResponse is the body of API responses that failed.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Routes returns the handler of the balance page (GET /balance), the balance API (GET /api/balance) and a health
+// check (GET /health).
+func (h *BalanceHandler) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /balance", h.balancePage)
+	mux.HandleFunc("GET /api/balance", h.balanceAPI)
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+func (h *BalanceHandler) balancePage(w http.ResponseWriter, r *http.Request) {
+	balance, status, err := h.balance(r, r.URL.Query().Get("token"))
+	if status == http.StatusInternalServerError {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	var buffer bytes.Buffer
+	if err != nil {
+		// unknown accounts get the same page as invalid links
+		err = h.Page.RenderInvalidLink(&buffer)
+	} else {
+		err = h.Page.Render(&buffer, balance)
+	}
+	if err != nil {
+		log.Printf("Could not render balance page: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(status)
+	_, _ = w.Write(buffer.Bytes())
+}
+
+func (h *BalanceHandler) balanceAPI(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	}
+
+	balance, status, err := h.balance(r, token)
+	if err != nil {
+		writeJSON(w, status, errorResponse{Error: http.StatusText(status)})
+		return
+	}
+
+	writeJSON(w, status, balance)
+}
+
+// balance loads the balance of the account of the token, along with the status code to respond with.
+func (h *BalanceHandler) balance(r *http.Request, token string) (services.AccountBalance, int, error) {
+	accountID, err := h.Tokens.Verify(token)
+	if err != nil {
+		return services.AccountBalance{}, http.StatusUnauthorized, err
+	}
+
+	balance, err := h.Accounts.GetBalance(r.Context(), accountID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return services.AccountBalance{}, http.StatusNotFound, err
+	} else if err != nil {
+		log.Printf("Could not load balance of account %d: %v", accountID, err)
+		return services.AccountBalance{}, http.StatusInternalServerError, err
+	}
+
+	return balance, http.StatusOK, nil
+}
@@ -0,0 +1,149 @@
+package web
+
+import (
+	"common/services"
+	"encoding/json"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var (
+	accountColumns     = []string{"account_id", "first_name", "last_name", "email", "locale", "total_balance", "avg_debit_amount", "avg_credit_amount", "last_balance_at", "created_at", "updated_at", "reporting_currency", "deactivated_at", "merged_into"}
+	transactionColumns = []string{"transaction_id", "account_id", "operation", "amount", "currency", "performed_at", "created_at", "updated_at", "import_id", "external_id", "source"}
+)
+
+func newTestHandler(t *testing.T) (*BalanceHandler, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	page := &services.BalancePage{}
+	require.NoError(t, page.LoadMessages())
+	return &BalanceHandler{
+		Accounts: &services.AccountService{Database: db},
+		Tokens:   &services.BalanceTokens{Secret: []byte("secret"), TTL: time.Hour},
+		Page:     page,
+	}, mock
+}
+
+func expectBalance(mock sqlmock.Sqlmock, accountID int64) {
+	mock.ExpectQuery(`FROM accounts WHERE account_id = \$1`).WithArgs(accountID).
+		WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(accountID, "John", "Doe", "john.doe@example.com", "en-US", "10.50", "2.00", "12.50", time.Now(), nil, nil, nil, nil, nil))
+	mock.ExpectQuery(`SELECT to_char`).WithArgs(accountID).
+		WillReturnRows(sqlmock.NewRows([]string{"year_month", "count"}).AddRow("2025-01", 2))
+	mock.ExpectQuery(`FROM transactions`).WithArgs(accountID, services.RecentTransactionsLimit).
+		WillReturnRows(sqlmock.NewRows(transactionColumns).
+			AddRow(2, accountID, "debit", "2.00", "MXN", time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), nil, nil, nil, "2", "statement.csv").
+			AddRow(1, accountID, "credit", "12.50", "MXN", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), nil, nil, nil, "1", "statement.csv"))
+}
+
+func TestBalanceHandler_Page(t *testing.T) {
+	handler, mock := newTestHandler(t)
+	server := httptest.NewServer(handler.Routes())
+	defer server.Close()
+
+	token, err := handler.Tokens.Sign(1)
+	require.NoError(t, err)
+	expired, err := (&services.BalanceTokens{Secret: handler.Tokens.Secret, Now: func() time.Time { return time.Now().Add(-24 * time.Hour) }, TTL: time.Hour}).Sign(1)
+	require.NoError(t, err)
+
+	t.Run("Balance", func(t *testing.T) {
+		expectBalance(mock, 1)
+		recorder := httptest.NewRecorder()
+		handler.Routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/balance?token="+token, nil))
+
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "text/html; charset=utf-8", recorder.Header().Get("Content-Type"))
+		require.Contains(t, recorder.Body.String(), "John Doe")
+		require.Contains(t, recorder.Body.String(), "MX$10.50")
+		require.Contains(t, recorder.Body.String(), "Number of transactions in January 2025: 2")
+	})
+
+	for name, token := range map[string]string{"Missing": "", "Invalid": "MQ.abc", "Expired": expired, "RawAccountID": "1"} {
+		t.Run(name, func(t *testing.T) {
+			res, err := http.Get(server.URL + "/balance?token=" + token)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		})
+	}
+
+	t.Run("UnknownAccount", func(t *testing.T) {
+		mock.ExpectQuery(`FROM accounts WHERE account_id = \$1`).WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows(accountColumns))
+		recorder := httptest.NewRecorder()
+		handler.Routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/balance?token="+token, nil))
+		require.Equal(t, http.StatusNotFound, recorder.Code)
+		require.Contains(t, recorder.Body.String(), "Este enlace no es válido")
+	})
+
+	t.Run("DatabaseError", func(t *testing.T) {
+		mock.ExpectQuery(`FROM accounts WHERE account_id = \$1`).WithArgs(int64(1)).WillReturnError(errors.New("connection refused"))
+		recorder := httptest.NewRecorder()
+		handler.Routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/balance?token="+token, nil))
+		require.Equal(t, http.StatusInternalServerError, recorder.Code)
+		require.NotContains(t, recorder.Body.String(), "connection refused")
+	})
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		res, err := http.Post(server.URL+"/balance?token="+token, "text/plain", nil)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+	})
+}
+
+func TestBalanceHandler_API(t *testing.T) {
+	handler, mock := newTestHandler(t)
+	token, err := handler.Tokens.Sign(1)
+	require.NoError(t, err)
+
+	t.Run("BearerToken", func(t *testing.T) {
+		expectBalance(mock, 1)
+		req := httptest.NewRequest(http.MethodGet, "/api/balance", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		handler.Routes().ServeHTTP(recorder, req)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+		var balance services.AccountBalance
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &balance))
+		require.Equal(t, int64(1), balance.AccountID)
+		require.Equal(t, "10.50 MXN", balance.TotalBalance.String())
+		require.Equal(t, map[string]int{"2025-01": 2}, balance.TransactionCount)
+		require.Len(t, balance.Transactions, 2)
+		require.Equal(t, "2025-01-02", balance.Transactions[0].PerformedAt)
+	})
+
+	t.Run("QueryToken", func(t *testing.T) {
+		expectBalance(mock, 1)
+		recorder := httptest.NewRecorder()
+		handler.Routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/balance?token="+token, nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/balance", nil)
+		req.Header.Set("Authorization", "Bearer 1")
+		recorder := httptest.NewRecorder()
+		handler.Routes().ServeHTTP(recorder, req)
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+		require.JSONEq(t, `{"error":"Unauthorized"}`, recorder.Body.String())
+	})
+
+	t.Run("UnknownAccount", func(t *testing.T) {
+		mock.ExpectQuery(`FROM accounts WHERE account_id = \$1`).WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows(accountColumns))
+		recorder := httptest.NewRecorder()
+		handler.Routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/balance?token="+token, nil))
+		require.Equal(t, http.StatusNotFound, recorder.Code)
+		require.JSONEq(t, `{"error":"Not Found"}`, recorder.Body.String())
+	})
+}
@@ -0,0 +1,368 @@
+package web
+
+import (
+	"bytes"
+	"common/services"
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultMaxUploadSize is the largest statement accepted when ImportHandler has no MaxUploadSize.
+const DefaultMaxUploadSize = 32 << 20
+
+//go:embed openapi.yaml
+var openAPI []byte
+
+// ImportHandler accepts statements as multipart uploads and imports them in the background, the status and report
+// of each import are polled by id.
+type ImportHandler struct {
+	Accounts *services.AccountService
+	Jobs     *ImportJobs
+
+	// Imports records each upload in the imports table once it starts running, jobs link to it by ImportID.
+	Imports *services.ImportService
+
+	// Transactions is the configuration of the imports (database, workers, insert strategy), the source, mode
+	// and error threshold are taken from each upload.
+	Transactions services.TransactionService
+
+	// Dispatcher sends the reports of completed imports, which are queued in the email outbox along with the balance
+	// update, right after each import. Reports are not queued without it, failed ones are left to Dispatcher.Run.
+	Dispatcher *services.EmailDispatcher
+
+	// MaxUploadSize limits the size of uploads, DefaultMaxUploadSize by default.
+	MaxUploadSize int64
+
+	// Concurrency is how many imports run at the same time, one by default.
+	Concurrency int
+
+	// Context stops running imports when done, they finish as incomplete. Background by default.
+	Context context.Context
+
+	once  sync.Once
+	slots chan struct{}
+	wait  sync.WaitGroup
+}
+
+// importRequest is the account and options of an upload. Statements with an account column are routed to the
+// account of each row, without an account of their own.
+type importRequest struct {
+	email, firstName, lastName string
+	multiAccount               bool
+	service                    services.TransactionService
+	format                     services.StatementFormat
+	checksum                   string
+}
+
+// Routes returns the handler of uploads (POST /imports), their status (GET /imports/{id}), the OpenAPI
+// description (GET /openapi.yaml) and a health check (GET /health).
+func (h *ImportHandler) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /imports", h.createImport)
+	mux.HandleFunc("GET /imports/{id}", h.getImport)
+	mux.HandleFunc("GET /openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		_, _ = w.Write(openAPI)
+	})
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// Wait blocks until every import started by the handler is done.
+func (h *ImportHandler) Wait() {
+	h.wait.Wait()
+}
+
+func (h *ImportHandler) createImport(w http.ResponseWriter, r *http.Request) {
+	maxUploadSize := h.MaxUploadSize
+	if maxUploadSize <= 0 {
+		maxUploadSize = DefaultMaxUploadSize
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, errorResponse{Error: fmt.Sprintf("file larger than %d bytes", maxUploadSize)})
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "expected a multipart/form-data upload: " + err.Error()})
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "missing file"})
+		return
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Println("Could not close upload:", err)
+		}
+	}()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "could not read file: " + err.Error()})
+		return
+	}
+
+	req, format, options, err := h.parseImportRequest(r, header.Filename)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	parser, format, err := services.NewStatementParser(format, header.Filename, bytes.NewReader(content), options)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "could not read statement: " + err.Error()})
+		return
+	}
+	req.format = format
+	if req.checksum, err = services.Checksum(bytes.NewReader(content)); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "could not read file: " + err.Error()})
+		return
+	}
+
+	job, err := h.Jobs.Create(ImportJob{
+		AccountEmail: req.email,
+		FileName:     header.Filename,
+		Source:       req.service.Source,
+		Format:       format,
+		Checksum:     req.checksum,
+	})
+	if err != nil {
+		log.Printf("Could not create import job: %v", err)
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+	h.start(job, req, parser)
+
+	w.Header().Set("Location", "/imports/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// getImport returns the job by id, or the import recorded in the imports table by import id, which outlives jobs:
+// they are lost on restarts and other servers don't have them.
+func (h *ImportHandler) getImport(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if job, ok := h.Jobs.Get(id); ok {
+		writeJSON(w, http.StatusOK, job)
+		return
+	}
+
+	importID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || h.Imports == nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: http.StatusText(http.StatusNotFound)})
+		return
+	}
+
+	record, err := h.Imports.GetImport(r.Context(), importID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: http.StatusText(http.StatusNotFound)})
+		return
+	}
+	var job ImportJob
+	if err == nil {
+		job, err = recordedJob(record)
+	}
+	if err != nil {
+		log.Printf("could not get import %d: %s", importID, err)
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+// parseImportRequest reads the account and import options of an upload, the same as proc-txns-csv flags.
+func (h *ImportHandler) parseImportRequest(r *http.Request, fileName string) (importRequest, services.StatementFormat, services.ParserOptions, error) {
+	req := importRequest{
+		email:     r.FormValue("account_email"),
+		firstName: r.FormValue("account_first_name"),
+		lastName:  r.FormValue("account_last_name"),
+		service:   h.Transactions,
+	}
+	req.service.Notify = h.Dispatcher != nil
+
+	req.service.Source = r.FormValue("source")
+	if req.service.Source == "" {
+		req.service.Source = fileName
+	}
+
+	var err error
+	var atomic bool
+	if atomic, err = formBool(r, "atomic"); err != nil {
+		return req, "", services.ParserOptions{}, err
+	}
+	if atomic {
+		req.service.Mode = services.ImportModeAtomic
+	}
+	if req.service.Threshold.FailFast, err = formBool(r, "fail_fast"); err != nil {
+		return req, "", services.ParserOptions{}, err
+	}
+	if value := r.FormValue("max_errors"); value != "" {
+		if req.service.Threshold.MaxErrors, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return req, "", services.ParserOptions{}, fmt.Errorf("invalid max_errors: %s", value)
+		}
+	}
+	if value := r.FormValue("max_error_ratio"); value != "" {
+		if req.service.Threshold.MaxErrorRatio, err = strconv.ParseFloat(value, 64); err != nil {
+			return req, "", services.ParserOptions{}, fmt.Errorf("invalid max_error_ratio: %s", value)
+		}
+	}
+
+	format, err := services.ParseFormat(r.FormValue("format"))
+	if err != nil {
+		return req, "", services.ParserOptions{}, err
+	}
+
+	options := services.ParserOptions{}
+	if options.Tolerant, err = formBool(r, "tolerant"); err != nil {
+		return req, "", services.ParserOptions{}, err
+	}
+	if value := r.FormValue("statement_date"); value != "" {
+		if options.Dates.ReferenceDate, err = time.Parse(time.DateOnly, value); err != nil {
+			return req, "", services.ParserOptions{}, fmt.Errorf("invalid statement_date: %s", value)
+		}
+	}
+	if name := r.FormValue("profile"); name != "" {
+		profile, err := services.LoadCSVProfile(name)
+		if err != nil {
+			return req, "", services.ParserOptions{}, err
+		}
+		options.Profile = &profile
+		req.multiAccount = profile.MultiAccount()
+	}
+	if req.email == "" && !req.multiAccount {
+		return req, "", services.ParserOptions{}, errors.New("missing account_email")
+	}
+
+	return req, format, options, nil
+}
+
+// start imports the statement of a job in the background, once one of the Concurrency slots is free.
+func (h *ImportHandler) start(job ImportJob, req importRequest, parser services.StatementParser) {
+	h.once.Do(func() {
+		h.slots = make(chan struct{}, max(h.Concurrency, 1))
+	})
+
+	ctx := h.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	h.wait.Add(1)
+	go func() {
+		defer h.wait.Done()
+
+		select {
+		case h.slots <- struct{}{}:
+			defer func() { <-h.slots }()
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			h.finish(job, nil, nil, fmt.Errorf("%w: %w", services.ErrImportIncomplete, ctx.Err()))
+			return
+		}
+
+		startedAt := time.Now()
+		job.Status = ImportRunning
+		job.StartedAt = &startedAt
+		h.Jobs.Save(job)
+
+		if req.multiAccount {
+			batch, rejections, err := h.Imports.ImportBatch(ctx, req.service, h.Accounts, parser, req.format, req.checksum)
+			job.Accounts = batch.Accounts
+			if err == nil {
+				h.dispatch(ctx, job, batch.ReportKeys()...)
+			}
+			h.finish(job, &batch.Summary, rejections, err)
+			return
+		}
+
+		account, err := h.Accounts.FetchOrCreateAccount(ctx, req.email, req.firstName, req.lastName)
+		if err != nil {
+			h.finish(job, nil, nil, fmt.Errorf("could not fetch or create account: %w", err))
+			return
+		}
+
+		imp, account, report, rejections, err := h.Imports.ImportFile(ctx, req.service, account, parser, req.format, req.checksum)
+		job.ImportID = imp.ImportID
+		if err == nil {
+			h.dispatch(ctx, job, services.ImportReportKey(imp.ImportID, account.AccountID))
+		}
+		h.finish(job, &report, rejections, err)
+	}()
+}
+
+// dispatch sends the reports queued by a completed job, by key, they are already in the outbox so failing to send
+// them now doesn't fail the import.
+func (h *ImportHandler) dispatch(ctx context.Context, job ImportJob, keys ...string) {
+	if h.Dispatcher == nil {
+		return
+	}
+
+	if _, err := h.Dispatcher.DispatchKeys(ctx, keys...); err != nil {
+		log.Printf("could not send reports of job %s: %s", job.ID, err)
+	}
+}
+
+// finish stores the outcome of a job, reports of failed imports are kept since rows may have been stored anyway.
+func (h *ImportHandler) finish(job ImportJob, report *services.BalanceReport, rejections []services.Rejection, err error) {
+	finishedAt := time.Now()
+	job.FinishedAt = &finishedAt
+	job.Report = report
+	if rejections != nil {
+		job.Rejections = rejections
+	}
+
+	switch {
+	case err == nil:
+		job.Status = ImportCompleted
+	case errors.Is(err, services.ErrImportIncomplete):
+		job.Status = ImportIncomplete
+		job.Error = err.Error()
+	default:
+		job.Status = ImportFailed
+		job.Error = err.Error()
+	}
+	if err != nil {
+		log.Printf("Import %s %s: %v", job.ID, job.Status, err)
+	}
+	h.Jobs.Save(job)
+}
+
+// formBool parses an optional boolean form value, false when absent.
+func formBool(r *http.Request, name string) (bool, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %s", name, value)
+	}
+	return b, nil
+}
+
+// writeJSON responds with body as JSON.
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Could not write response: %v", err)
+	}
+}
@@ -0,0 +1,357 @@
+package web
+
+import (
+	"bytes"
+	"common/services"
+	"context"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var outboxColumns = []string{"email_id", "idempotency_key", "account_id", "kind", "payload", "status", "attempts", "next_attempt_at", "last_error", "sent_at", "created_at", "updated_at"}
+
+var importColumns = []string{"import_id", "account_id", "source", "checksum", "format", "status", "count_accepted", "count_rejected", "count_duplicate", "error", "report", "started_at", "finished_at", "created_at", "updated_at"}
+
+// expectImport expects an import to be recorded as running and then finished with status.
+func expectImport(mock sqlmock.Sqlmock, importID int64, status string, transactions func()) {
+	now := time.Now()
+	mock.ExpectQuery(`INSERT INTO imports`).
+		WillReturnRows(sqlmock.NewRows(importColumns).AddRow(importID, 1, "statement.csv", "checksum", "csv", "running", 0, 0, 0, "", []byte("{}"), now, nil, now, now))
+	transactions()
+	mock.ExpectQuery(`UPDATE imports`).WithArgs(status, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), importID).
+		WillReturnRows(sqlmock.NewRows(importColumns).AddRow(importID, 1, "statement.csv", "checksum", "csv", status, 0, 0, 0, "", []byte("{}"), now, now, now, now))
+}
+
+func newTestImportHandler(t *testing.T) (*ImportHandler, sqlmock.Sqlmock, *services.MemorySender) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	sender := &services.MemorySender{}
+	email := &services.EmailService{Sender: sender}
+	require.NoError(t, email.LoadMessages())
+	return &ImportHandler{
+		Accounts:     &services.AccountService{Database: db},
+		Imports:      &services.ImportService{Database: db},
+		Jobs:         &ImportJobs{},
+		Transactions: services.TransactionService{Database: db, Workers: 1, BatchSize: 1},
+		Dispatcher:   &services.EmailDispatcher{Database: db, Email: email},
+	}, mock, sender
+}
+
+// upload builds a multipart request with the file and form values.
+func upload(t *testing.T, fileName, content string, values map[string]string) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range values {
+		require.NoError(t, writer.WriteField(name, value))
+	}
+	if fileName != "" {
+		part, err := writer.CreateFormFile("file", fileName)
+		require.NoError(t, err)
+		_, err = part.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/imports", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// awaitImport polls the import until it is no longer queued or running.
+func awaitImport(t *testing.T, routes http.Handler, location string) ImportJob {
+	var job ImportJob
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		recorder := httptest.NewRecorder()
+		routes.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, location, nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &job))
+		if job.Status != ImportQueued && job.Status != ImportRunning {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("import %s did not finish, status %s", location, job.Status)
+	return job
+}
+
+func TestImportHandler_Upload(t *testing.T) {
+	handler, mock, sender := newTestImportHandler(t)
+	routes := handler.Routes()
+
+	mock.ExpectQuery(`FROM accounts WHERE email = \$1`).WithArgs("john.doe@example.com").
+		WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(1, "John", "Doe", "john.doe@example.com", "en-US", nil, nil, nil, nil, nil, nil, nil, nil, nil))
+	expectImport(mock, 3, "completed", func() {
+		mock.ExpectQuery(`INSERT INTO transactions`).WithArgs(1, "1", "statement.csv", "credit", sqlmock.AnyArg(), "MXN", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), int64(3)).
+			WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(1))
+		mock.ExpectQuery(`INSERT INTO transactions`).WithArgs(1, "2", "statement.csv", "debit", sqlmock.AnyArg(), "MXN", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), int64(3)).
+			WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(2))
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM transactions`).WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"currency", "total_credit", "count_credit", "total_debit", "count_debit"}).AddRow("MXN", "10.00", 1, "2.00", 1))
+		mock.ExpectQuery(`SELECT to_char`).WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"year_month", "count"}).AddRow("2024-01", 2))
+		mock.ExpectQuery(`UPDATE accounts`).
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(1, "John", "Doe", "john.doe@example.com", "en-US", "8.00", "2.00", "10.00", time.Now(), nil, nil, nil, nil, nil))
+		mock.ExpectQuery(`INSERT INTO balance_snapshots`).
+			WillReturnRows(sqlmock.NewRows([]string{"snapshot_id", "account_id", "total_balance", "report", "created_at"}).AddRow(1, 1, "8.00", []byte("{}"), time.Now()))
+		mock.ExpectExec(`INSERT INTO email_outbox`).WithArgs("balance_report/import/3/account/1", int64(1), "balance_report", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	})
+
+	// the report of the import is sent right after it, the rest of the outbox is left to the dispatcher
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM email_outbox`).WithArgs("balance_report/import/3/account/1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(outboxColumns).AddRow(1, "balance_report/import/3/account/1", 1, "balance_report", []byte(`{"balance":{},"report":{},"import_id":3}`), "pending", 0, time.Now(), "", nil, nil, nil))
+	mock.ExpectQuery(`FROM accounts WHERE account_id = \$1`).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(1, "John", "Doe", "john.doe@example.com", "en-US", "8.00", "2.00", "10.00", time.Now(), nil, nil, nil, nil, nil))
+	mock.ExpectQuery(`FROM transactions\s+WHERE import_id = \$1`).WithArgs(int64(3), int64(1)).
+		WillReturnRows(sqlmock.NewRows(transactionColumns))
+	mock.ExpectQuery(`UPDATE email_outbox`).WithArgs(sqlmock.AnyArg(), int64(1)).
+		WillReturnRows(sqlmock.NewRows(outboxColumns).AddRow(1, "balance_report/import/3/account/1", 1, "balance_report", []byte("{}"), "sent", 1, time.Now(), "", time.Now(), nil, nil))
+	mock.ExpectCommit()
+
+	recorder := httptest.NewRecorder()
+	routes.ServeHTTP(recorder, upload(t, "statement.csv", "ID,DATE,AMOUNT\n1,2024-01-01,+10\n2,2024-01-02,-2\n", map[string]string{
+		"account_email": "john.doe@example.com",
+	}))
+	require.Equal(t, http.StatusAccepted, recorder.Code)
+
+	var created ImportJob
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &created))
+	require.Len(t, created.ID, 32)
+	require.Equal(t, "/imports/"+created.ID, recorder.Header().Get("Location"))
+	require.Equal(t, services.FormatCSV, created.Format)
+	require.Equal(t, "statement.csv", created.Source)
+	require.Len(t, created.Checksum, 64)
+
+	job := awaitImport(t, routes, recorder.Header().Get("Location"))
+	handler.Wait()
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Equal(t, ImportCompleted, job.Status, job.Error)
+	require.Equal(t, int64(3), job.ImportID)
+	require.NotNil(t, job.StartedAt)
+	require.NotNil(t, job.FinishedAt)
+	require.Equal(t, int64(1), job.Report.CountCredit)
+	require.Equal(t, int64(1), job.Report.CountDebit)
+	require.Equal(t, "8.00 MXN", job.Report.TotalBalance.String())
+	require.Empty(t, job.Rejections)
+	require.Len(t, sender.Messages(), 1)
+	require.Equal(t, "john.doe@example.com", sender.Last().To)
+}
+
+func TestImportHandler_UploadMultiAccount(t *testing.T) {
+	handler, mock, _ := newTestImportHandler(t)
+	handler.Dispatcher = nil
+	routes := handler.Routes()
+
+	// each row goes to the account of its column, which gets its own import
+	mock.ExpectQuery(`FROM accounts WHERE email = \$1`).WithArgs("ana@example.com").
+		WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(2, "Ana", "", "ana@example.com", "es-MX", nil, nil, nil, nil, nil, nil, nil, nil, nil))
+	expectImport(mock, 5, "completed", func() {
+		mock.ExpectQuery(`INSERT INTO transactions`).WithArgs(2, "1", "feed.csv", "credit", sqlmock.AnyArg(), "MXN", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), int64(5)).
+			WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(1))
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM transactions`).WithArgs(int64(2)).
+			WillReturnRows(sqlmock.NewRows([]string{"currency", "total_credit", "count_credit", "total_debit", "count_debit"}).AddRow("MXN", "10.00", 1, "0", 0))
+		mock.ExpectQuery(`SELECT to_char`).WithArgs(int64(2)).
+			WillReturnRows(sqlmock.NewRows([]string{"year_month", "count"}).AddRow("2024-01", 1))
+		mock.ExpectQuery(`UPDATE accounts`).
+			WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(2, "Ana", "", "ana@example.com", "es-MX", "10.00", "0", "10.00", time.Now(), nil, nil, nil, nil, nil))
+		mock.ExpectQuery(`INSERT INTO balance_snapshots`).
+			WillReturnRows(sqlmock.NewRows([]string{"snapshot_id", "account_id", "total_balance", "report", "created_at"}).AddRow(1, 2, "10.00", []byte("{}"), time.Now()))
+		mock.ExpectCommit()
+	})
+
+	recorder := httptest.NewRecorder()
+	routes.ServeHTTP(recorder, upload(t, "feed.csv", "Reference,Date,Amount,Currency,Description,Account\n1,2024-01-10,10.00,MXN,Payroll,ana@example.com\n", map[string]string{
+		"profile": "aggregator",
+	}))
+	require.Equal(t, http.StatusAccepted, recorder.Code)
+
+	job := awaitImport(t, routes, recorder.Header().Get("Location"))
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Equal(t, ImportCompleted, job.Status)
+	require.Zero(t, job.ImportID)
+	require.Equal(t, int64(1), job.Report.CountCredit)
+	require.Len(t, job.Accounts, 1)
+	require.Equal(t, "ana@example.com", job.Accounts[0].Key)
+	require.Equal(t, int64(5), job.Accounts[0].ImportID)
+	require.Equal(t, int64(2), job.Accounts[0].Report.AccountID)
+}
+
+func TestImportHandler_UploadFailed(t *testing.T) {
+	handler, mock, sender := newTestImportHandler(t)
+	routes := handler.Routes()
+
+	mock.ExpectQuery(`FROM accounts WHERE email = \$1`).WithArgs("john.doe@example.com").
+		WillReturnRows(sqlmock.NewRows(accountColumns).AddRow(1, "John", "Doe", "john.doe@example.com", "en-US", nil, nil, nil, nil, nil, nil, nil, nil, nil))
+	expectImport(mock, 4, "failed", func() {})
+
+	recorder := httptest.NewRecorder()
+	routes.ServeHTTP(recorder, upload(t, "statement.csv", "ID,DATE,AMOUNT\n1,yesterday,+10\n", map[string]string{
+		"account_email": "john.doe@example.com",
+		"format":        "csv",
+		"fail_fast":     "true",
+	}))
+	require.Equal(t, http.StatusAccepted, recorder.Code)
+
+	job := awaitImport(t, routes, recorder.Header().Get("Location"))
+	handler.Wait()
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Equal(t, ImportFailed, job.Status)
+	require.Equal(t, int64(4), job.ImportID)
+	require.NotEmpty(t, job.Error)
+	require.Len(t, job.Rejections, 1)
+	require.Equal(t, 2, job.Rejections[0].Line)
+	require.Empty(t, sender.Messages())
+}
+
+func TestImportHandler_UploadInterrupted(t *testing.T) {
+	handler, _, sender := newTestImportHandler(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	handler.Context = ctx
+	routes := handler.Routes()
+
+	recorder := httptest.NewRecorder()
+	routes.ServeHTTP(recorder, upload(t, "statement.csv", "ID,DATE,AMOUNT\n1,2024-01-01,+10\n", map[string]string{
+		"account_email": "john.doe@example.com",
+	}))
+	require.Equal(t, http.StatusAccepted, recorder.Code)
+
+	job := awaitImport(t, routes, recorder.Header().Get("Location"))
+	handler.Wait()
+	require.Equal(t, ImportIncomplete, job.Status)
+	require.Contains(t, job.Error, services.ErrImportIncomplete.Error())
+	require.Empty(t, sender.Messages())
+}
+
+func TestImportHandler_BadRequests(t *testing.T) {
+	handler, mock, _ := newTestImportHandler(t)
+	handler.MaxUploadSize = 1024
+	routes := handler.Routes()
+
+	csvContent := "ID,DATE,AMOUNT\n1,2024-01-01,+10\n"
+	account := map[string]string{"account_email": "john.doe@example.com"}
+	with := func(name, value string) map[string]string {
+		return map[string]string{"account_email": "john.doe@example.com", name: value}
+	}
+
+	tests := map[string]struct {
+		req        *http.Request
+		statusCode int
+		error      string
+	}{
+		"NotMultipart":     {httptest.NewRequest(http.MethodPost, "/imports", strings.NewReader(csvContent)), http.StatusBadRequest, "expected a multipart/form-data upload"},
+		"MissingFile":      {upload(t, "", "", account), http.StatusBadRequest, "missing file"},
+		"MissingAccount":   {upload(t, "statement.csv", csvContent, nil), http.StatusBadRequest, "missing account_email"},
+		"UnknownFormat":    {upload(t, "statement.csv", csvContent, with("format", "xls")), http.StatusBadRequest, "unknown statement format: xls"},
+		"UnknownProfile":   {upload(t, "statement.csv", csvContent, with("profile", "nope")), http.StatusBadRequest, "unknown profile nope"},
+		"InvalidBool":      {upload(t, "statement.csv", csvContent, with("atomic", "maybe")), http.StatusBadRequest, "invalid atomic: maybe"},
+		"InvalidMaxErrors": {upload(t, "statement.csv", csvContent, with("max_errors", "many")), http.StatusBadRequest, "invalid max_errors: many"},
+		"InvalidDate":      {upload(t, "statement.csv", csvContent, with("statement_date", "31/01/2025")), http.StatusBadRequest, "invalid statement_date: 31/01/2025"},
+		"TooLarge":         {upload(t, "statement.csv", strings.Repeat(csvContent, 100), account), http.StatusRequestEntityTooLarge, "file larger than 1024 bytes"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			routes.ServeHTTP(recorder, test.req)
+			require.Equal(t, test.statusCode, recorder.Code)
+
+			var body errorResponse
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+			require.Contains(t, body.Error, test.error)
+		})
+	}
+
+	// nothing is imported from bad requests
+	handler.Wait()
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestImportHandler_Get(t *testing.T) {
+	handler, _, _ := newTestImportHandler(t)
+	server := httptest.NewServer(handler.Routes())
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/imports/unknown")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	job, err := handler.Jobs.Create(ImportJob{FileName: "statement.csv"})
+	require.NoError(t, err)
+	res, err = http.Get(server.URL + "/imports/" + job.ID)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var got ImportJob
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+	require.Equal(t, ImportQueued, got.Status)
+	require.Equal(t, "statement.csv", got.FileName)
+	require.Nil(t, got.Report)
+}
+
+func TestImportHandler_GetRecorded(t *testing.T) {
+	handler, mock, _ := newTestImportHandler(t)
+	routes := handler.Routes()
+	now := time.Now()
+
+	// jobs of a previous run or another server are read from the imports table by import id
+	mock.ExpectQuery(`FROM imports WHERE import_id = \$1`).WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows(importColumns).AddRow(7, 1, "upload", "checksum", "csv", "completed", 2, 0, 0, "", []byte(`{"account_id":1,"count_credit":2}`), now, now, now, now))
+	recorder := httptest.NewRecorder()
+	routes.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/imports/7", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var job ImportJob
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &job))
+	require.Equal(t, "7", job.ID)
+	require.Equal(t, int64(7), job.ImportID)
+	require.Equal(t, ImportCompleted, job.Status)
+	require.Equal(t, "checksum", job.Checksum)
+	require.NotNil(t, job.FinishedAt)
+	require.NotNil(t, job.Report)
+	require.Equal(t, int64(2), job.Report.CountCredit)
+
+	// running imports have no report yet
+	mock.ExpectQuery(`FROM imports WHERE import_id = \$1`).WithArgs(int64(8)).
+		WillReturnRows(sqlmock.NewRows(importColumns).AddRow(8, 1, "upload", "checksum", "csv", "running", 0, 0, 0, "", []byte("{}"), now, nil, now, now))
+	recorder = httptest.NewRecorder()
+	routes.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/imports/8", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	job = ImportJob{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &job))
+	require.Equal(t, ImportRunning, job.Status)
+	require.Nil(t, job.FinishedAt)
+	require.Nil(t, job.Report)
+
+	mock.ExpectQuery(`FROM imports WHERE import_id = \$1`).WithArgs(int64(9)).WillReturnRows(sqlmock.NewRows(importColumns))
+	recorder = httptest.NewRecorder()
+	routes.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/imports/9", nil))
+	require.Equal(t, http.StatusNotFound, recorder.Code)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestImportHandler_OpenAPI(t *testing.T) {
+	handler, _, _ := newTestImportHandler(t)
+
+	recorder := httptest.NewRecorder()
+	handler.Routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/openapi.yaml", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Contains(t, recorder.Body.String(), "/imports/{id}:")
+	require.Contains(t, recorder.Body.String(), "operationId: createImport")
+}
@@ -0,0 +1,126 @@
+package web
+
+import (
+	"common/dao"
+	"common/services"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ImportStatus is the state of an import job.
+type ImportStatus string
+
+const (
+	// ImportQueued jobs wait for a free slot to run.
+	ImportQueued ImportStatus = "queued"
+
+	// ImportRunning jobs are importing their file.
+	ImportRunning ImportStatus = "running"
+
+	// ImportCompleted jobs imported the whole file, updated the balance and queued the report email. Sending it is
+	// attempted right away, failures stay in the email outbox to be retried and do not change the status of the job.
+	ImportCompleted ImportStatus = "completed"
+
+	// ImportIncomplete jobs were interrupted, their report only counts the rows stored until then.
+	ImportIncomplete ImportStatus = "incomplete"
+
+	// ImportFailed jobs stopped on an error, like too many rejected rows or a database failure.
+	ImportFailed ImportStatus = "failed"
+)
+
+// ImportJob is an uploaded statement and the result of importing it.
+type ImportJob struct {
+	ID           string                   `json:"id"`
+	ImportID     int64                    `json:"import_id,omitempty"`
+	Status       ImportStatus             `json:"status"`
+	AccountEmail string                   `json:"account_email"`
+	FileName     string                   `json:"file_name"`
+	Source       string                   `json:"source"`
+	Format       services.StatementFormat `json:"format"`
+	Checksum     string                   `json:"checksum"`
+	CreatedAt    time.Time                `json:"created_at"`
+	StartedAt    *time.Time               `json:"started_at"`
+	FinishedAt   *time.Time               `json:"finished_at"`
+	Error        string                   `json:"error,omitempty"`
+	Report       *services.BalanceReport  `json:"report"`
+	Rejections   []services.Rejection     `json:"rejections"`
+
+	// Accounts are the import and report of each account of statements with an account column, Report is the
+	// summary of the file then.
+	Accounts []services.AccountReport `json:"accounts,omitempty"`
+}
+
+// recordedJob returns the import recorded in the imports table as a job, its id is the import id. The table doesn't
+// keep the file name, the email of the account nor the rejected rows, and imports of a server that stopped while
+// running them stay running.
+func recordedJob(record dao.Import) (ImportJob, error) {
+	startedAt := record.StartedAt
+	job := ImportJob{
+		ID:         strconv.FormatInt(record.ImportID, 10),
+		ImportID:   record.ImportID,
+		Status:     ImportStatus(record.Status),
+		Source:     record.Source,
+		Format:     services.StatementFormat(record.Format),
+		Checksum:   record.Checksum,
+		CreatedAt:  record.CreatedAt.Time,
+		StartedAt:  &startedAt,
+		Error:      record.Error,
+		Rejections: []services.Rejection{},
+	}
+	if record.FinishedAt.Valid {
+		job.FinishedAt = &record.FinishedAt.Time
+
+		var report services.BalanceReport
+		if err := json.Unmarshal(record.Report, &report); err != nil {
+			return job, err
+		}
+		job.Report = &report
+	}
+	return job, nil
+}
+
+// ImportJobs keeps import jobs in memory, by id. They are lost when the server stops and other servers don't see
+// them, the import each job records is read from the imports table instead then.
+type ImportJobs struct {
+	lock sync.RWMutex
+	jobs map[string]ImportJob
+}
+
+// Create stores a new queued job with a random id, so ids can't be guessed from other uploads.
+func (j *ImportJobs) Create(job ImportJob) (ImportJob, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return job, err
+	}
+
+	job.ID = hex.EncodeToString(id)
+	job.Status = ImportQueued
+	job.CreatedAt = time.Now()
+	job.Rejections = []services.Rejection{}
+	j.Save(job)
+	return job, nil
+}
+
+// Save stores a job, replacing the job with the same id.
+func (j *ImportJobs) Save(job ImportJob) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.jobs == nil {
+		j.jobs = make(map[string]ImportJob)
+	}
+	j.jobs[job.ID] = job
+}
+
+// Get returns a job by id, false when there is no such job.
+func (j *ImportJobs) Get(id string) (ImportJob, bool) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	job, ok := j.jobs[id]
+	return job, ok
+}
@@ -0,0 +1,203 @@
+package web
+
+import (
+	"bytes"
+	"common/mailcatcher"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// mailTemplate is the page of the mail catcher: the list of messages, or a message with its parts and source.
+var mailTemplate = template.Must(template.New("mail").Funcs(template.FuncMap{
+	"join": strings.Join,
+	"text": func(part mailcatcher.Part) bool { return strings.HasPrefix(part.ContentType, "text/") },
+	"body": func(part mailcatcher.Part) string { return string(part.Body) },
+	"raw":  func(message mailcatcher.Message) string { return string(message.Data) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Mail catcher</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: .4em; text-align: left; }
+pre { background: #f6f6f6; padding: 1em; overflow: auto; white-space: pre-wrap; }
+iframe { border: 1px solid #ddd; width: 100%; height: 30em; }
+</style>
+</head>
+<body>
+{{- with .Message}}
+<p><a href="/">All messages</a></p>
+<h1>{{.Subject}}</h1>
+<table>
+<tr><th>From</th><td>{{.From}}</td></tr>
+<tr><th>To</th><td>{{join .To ", "}}</td></tr>
+<tr><th>Received</th><td>{{.ReceivedAt.Format "2006-01-02 15:04:05"}}</td></tr>
+<tr><th>TLS</th><td>{{.TLS}}</td></tr>
+<tr><th>Username</th><td>{{.Username}}</td></tr>
+</table>
+{{- range $i, $part := $.Parts}}
+<h2>{{$part.ContentType}}{{with $part.Filename}} ({{.}}){{end}}, {{$part.Size}} bytes</h2>
+{{- if eq $part.ContentType "text/html"}}
+<iframe sandbox srcdoc="{{body $part}}"></iframe>
+{{- else if text $part}}
+<pre>{{body $part}}</pre>
+{{- else}}
+<p><a href="/api/messages/{{$.Message.ID}}/parts/{{$i}}">Download</a></p>
+{{- end}}
+{{- end}}
+<h2>Source</h2>
+<pre>{{raw .}}</pre>
+{{- else}}
+<h1>Mail catcher</h1>
+{{- if .Messages}}
+<table>
+<tr><th>Received</th><th>From</th><th>To</th><th>Subject</th><th>Size</th></tr>
+{{- range .Messages}}
+<tr><td>{{.ReceivedAt.Format "2006-01-02 15:04:05"}}</td><td>{{.From}}</td><td>{{join .To ", "}}</td><td><a href="/messages/{{.ID}}">{{.Subject}}</a></td><td>{{.Size}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>No messages yet.</p>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// MailHandler serves the messages caught by the mail catcher as web pages and as a JSON API.
+type MailHandler struct {
+	Store *mailcatcher.Store
+}
+
+// mailMessage is a message of the API, with its parts when it is requested by id.
+type mailMessage struct {
+	mailcatcher.Message
+	Parts []mailcatcher.Part `json:"parts,omitempty"`
+}
+
+// Routes returns the handler of the message list (GET /) and pages (GET /messages/{id}), the API to list
+// (GET /api/messages), get (GET /api/messages/{id}) and delete (DELETE /api/messages) messages, the raw messages
+// (GET /api/messages/{id}/raw) and their parts (GET /api/messages/{id}/parts/{index}), and a health check
+// (GET /health).
+func (h *MailHandler) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", h.listPage)
+	mux.HandleFunc("GET /messages/{id}", h.messagePage)
+	mux.HandleFunc("GET /api/messages", func(w http.ResponseWriter, r *http.Request) {
+		messages := h.Store.Messages()
+		if messages == nil {
+			messages = []mailcatcher.Message{}
+		}
+		writeJSON(w, http.StatusOK, messages)
+	})
+	mux.HandleFunc("DELETE /api/messages", func(w http.ResponseWriter, r *http.Request) {
+		h.Store.Clear()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /api/messages/{id}", h.getMessage)
+	mux.HandleFunc("GET /api/messages/{id}/raw", h.rawMessage)
+	mux.HandleFunc("GET /api/messages/{id}/parts/{index}", h.messagePart)
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+func (h *MailHandler) listPage(w http.ResponseWriter, r *http.Request) {
+	h.render(w, map[string]any{"Messages": h.Store.Messages()})
+}
+
+func (h *MailHandler) messagePage(w http.ResponseWriter, r *http.Request) {
+	message, ok := h.message(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	parts, err := message.Parts()
+	if err != nil {
+		log.Printf("Could not parse message %d: %v", message.ID, err)
+	}
+	h.render(w, map[string]any{"Message": message, "Parts": parts})
+}
+
+func (h *MailHandler) render(w http.ResponseWriter, data map[string]any) {
+	var buffer bytes.Buffer
+	if err := mailTemplate.Execute(&buffer, data); err != nil {
+		log.Printf("Could not render mail page: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(buffer.Bytes())
+}
+
+func (h *MailHandler) getMessage(w http.ResponseWriter, r *http.Request) {
+	message, ok := h.message(r)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "message not found"})
+		return
+	}
+
+	parts, err := message.Parts()
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, mailMessage{Message: message, Parts: parts})
+}
+
+func (h *MailHandler) rawMessage(w http.ResponseWriter, r *http.Request) {
+	message, ok := h.message(r)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "message not found"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "message/rfc822")
+	_, _ = w.Write(message.Data)
+}
+
+func (h *MailHandler) messagePart(w http.ResponseWriter, r *http.Request) {
+	message, ok := h.message(r)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "message not found"})
+		return
+	}
+
+	parts, err := message.Parts()
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: err.Error()})
+		return
+	}
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil || index < 0 || index >= len(parts) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "part not found"})
+		return
+	}
+
+	part := parts[index]
+	w.Header().Set("Content-Type", part.ContentType)
+	if part.Filename != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": part.Filename}))
+	}
+	_, _ = w.Write(part.Body)
+}
+
+// message returns the message of the id in the path, reporting whether there is one.
+func (h *MailHandler) message(r *http.Request) (mailcatcher.Message, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		return mailcatcher.Message{}, false
+	}
+
+	return h.Store.Get(id)
+}
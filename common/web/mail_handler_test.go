@@ -0,0 +1,123 @@
+package web
+
+import (
+	"common/mailcatcher"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const caughtMessage = "From: reports@example.com\n" +
+	"To: john.doe@example.com\n" +
+	"Subject: Balance Report\n" +
+	"Content-Type: multipart/mixed; boundary=outer\n" +
+	"\n" +
+	"--outer\n" +
+	"Content-Type: text/html; charset=UTF-8\n" +
+	"\n" +
+	"<p>Total balance: $10.00</p>\n" +
+	"--outer\n" +
+	"Content-Type: application/pdf\n" +
+	"Content-Disposition: attachment; filename=\"statement-1.pdf\"\n" +
+	"Content-Transfer-Encoding: base64\n" +
+	"\n" +
+	"JVBERi0xLjQ=\n" +
+	"--outer--\n"
+
+func newTestMailHandler() *MailHandler {
+	store := &mailcatcher.Store{}
+	store.Add(mailcatcher.Message{From: "reports@example.com", To: []string{"john.doe@example.com"}, Subject: "Balance Report", TLS: true, ReceivedAt: time.Now(), Data: []byte(caughtMessage)})
+	return &MailHandler{Store: store}
+}
+
+func TestMailHandler_Pages(t *testing.T) {
+	handler := newTestMailHandler()
+
+	recorder := httptest.NewRecorder()
+	handler.Routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, "text/html; charset=utf-8", recorder.Header().Get("Content-Type"))
+	require.Contains(t, recorder.Body.String(), `<a href="/messages/1">Balance Report</a>`)
+
+	recorder = httptest.NewRecorder()
+	handler.Routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/messages/1", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Contains(t, recorder.Body.String(), "application/pdf (statement-1.pdf), 8 bytes")
+	require.Contains(t, recorder.Body.String(), `<a href="/api/messages/1/parts/1">Download</a>`)
+
+	for _, path := range []string{"/messages/2", "/messages/x", "/missing"} {
+		recorder = httptest.NewRecorder()
+		handler.Routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusNotFound, recorder.Code, path)
+	}
+}
+
+func TestMailHandler_API(t *testing.T) {
+	handler := newTestMailHandler()
+
+	t.Run("List", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		handler.Routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/messages", nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		var messages []map[string]any
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &messages))
+		require.Len(t, messages, 1)
+		require.Equal(t, "Balance Report", messages[0]["subject"])
+		require.Equal(t, true, messages[0]["tls"])
+		require.NotContains(t, messages[0], "parts")
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		handler.Routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/messages/1", nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		var message struct {
+			ID    int64              `json:"id"`
+			Parts []mailcatcher.Part `json:"parts"`
+		}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &message))
+		require.Equal(t, int64(1), message.ID)
+		require.Equal(t, []mailcatcher.Part{{ContentType: "text/html", Size: 28}, {ContentType: "application/pdf", Filename: "statement-1.pdf", Size: 8}}, message.Parts)
+	})
+
+	t.Run("Raw", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		handler.Routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/messages/1/raw", nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "message/rfc822", recorder.Header().Get("Content-Type"))
+		require.Equal(t, caughtMessage, recorder.Body.String())
+	})
+
+	t.Run("Part", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		handler.Routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/messages/1/parts/1", nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "application/pdf", recorder.Header().Get("Content-Type"))
+		require.Equal(t, `attachment; filename=statement-1.pdf`, recorder.Header().Get("Content-Disposition"))
+		require.Equal(t, "%PDF-1.4", recorder.Body.String())
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		for _, path := range []string{"/api/messages/2", "/api/messages/2/raw", "/api/messages/1/parts/2"} {
+			recorder := httptest.NewRecorder()
+			handler.Routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+			require.Equal(t, http.StatusNotFound, recorder.Code, path)
+			require.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		handler.Routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/api/messages", nil))
+		require.Equal(t, http.StatusNoContent, recorder.Code)
+
+		recorder = httptest.NewRecorder()
+		handler.Routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/messages", nil))
+		require.JSONEq(t, "[]", recorder.Body.String())
+	})
+}
@@ -2,18 +2,21 @@ package main
 
 import (
 	"bytes"
+	"common/migrations"
 	"common/services"
 	"context"
 	"database/sql"
-	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/rds/auth"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"log"
+	"net/http"
 	"os"
+	"time"
 
 	_ "github.com/lib/pq"
 )
@@ -21,16 +24,38 @@ import (
 const (
 	WorkerCount = 5
 	BatchSize   = 100
-	PublicURL   = ""
+
+	// ShutdownMargin is the time left before the lambda deadline to stop importing and respond with a partial report.
+	ShutdownMargin = 5 * time.Second
 )
 
-// CSVProcessRequest request a process of a CSV file within a S3 disk.
+// CSVProcessRequest request a process of a statement file within a S3 disk, the format is detected from the
+// object key and content unless given.
 type CSVProcessRequest struct {
-	ObjectKey        string `json:"object_key"`
-	Bucket           string `json:"bucket"`
-	AccountEmail     string `json:"account_email"`
-	AccountFirstName string `json:"account_first_name"`
-	AccountLastName  string `json:"account_last_name"`
+	ObjectKey        string  `json:"object_key"`
+	Bucket           string  `json:"bucket"`
+	Source           string  `json:"source"`
+	Format           string  `json:"format"`
+	Profile          string  `json:"profile"`
+	StatementDate    string  `json:"statement_date"`
+	Atomic           bool    `json:"atomic"`
+	InsertStrategy   string  `json:"insert_strategy"`
+	FailFast         bool    `json:"fail_fast"`
+	MaxErrors        int64   `json:"max_errors"`
+	MaxErrorRatio    float64 `json:"max_error_ratio"`
+	Tolerant         bool    `json:"tolerant"`
+	AccountEmail     string  `json:"account_email"`
+	AccountFirstName string  `json:"account_first_name"`
+	AccountLastName  string  `json:"account_last_name"`
+}
+
+// CSVProcessResponse is the body of a successful response. Multi-account statements have no single import, their
+// report is the summary of the file and each account lists its own import and report.
+type CSVProcessResponse struct {
+	ImportID   int64                    `json:"import_id"`
+	Report     services.BalanceReport   `json:"report"`
+	Accounts   []services.AccountReport `json:"accounts,omitempty"`
+	Rejections []services.Rejection     `json:"rejections"`
 }
 
 var (
@@ -98,22 +123,77 @@ func handleRequest(ctx context.Context, event json.RawMessage) (map[string]any,
 		}
 	}(db)
 
+	// refuse to import into a schema missing migrations, run migrate up first
+	migrator := migrations.Migrator{Database: db}
+	if err := migrator.Check(ctx); err != nil {
+		log.Printf("Database schema check failed: %v", err)
+		return nil, err
+	}
+
 	var req CSVProcessRequest
 	if err := json.Unmarshal(event, &req); err != nil {
 		log.Printf("Failed to unmarshal event: %v", err)
 		return nil, err
 	}
 
+	rates := &services.DatabaseRates{Database: db}
 	accountService := services.AccountService{
 		Database: db,
+		Rates:    rates,
+	}
+	importService := services.ImportService{
+		Database: db,
+	}
+	source := req.Source
+	if source == "" {
+		source = req.Bucket + "/" + req.ObjectKey
+	}
+	strategy, err := services.ParseInsertStrategy(req.InsertStrategy)
+	if err != nil {
+		log.Printf("Invalid insert strategy: %v", err)
+		return nil, err
+	}
+	dates := services.DateParser{}
+	if req.StatementDate != "" {
+		dates.ReferenceDate, err = time.Parse(time.DateOnly, req.StatementDate)
+		if err != nil {
+			log.Printf("Failed to parse statement date: %v", err)
+			return nil, err
+		}
 	}
 	transactionService := services.TransactionService{
 		Database:  db,
+		Rates:     rates,
 		Workers:   WorkerCount,
 		BatchSize: BatchSize,
+		Source:    source,
+		Strategy:  strategy,
+		Threshold: services.ErrorThreshold{
+			FailFast:      req.FailFast,
+			MaxErrors:     req.MaxErrors,
+			MaxErrorRatio: req.MaxErrorRatio,
+		},
+		Notify: true,
+	}
+	if req.Atomic {
+		transactionService.Mode = services.ImportModeAtomic
+	}
+	emailConfig, err := services.EmailConfigFromEnv()
+	if err != nil {
+		log.Printf("Invalid email configuration: %v", err)
+		return nil, err
+	}
+	sender, err := emailConfig.NewSender()
+	if err != nil {
+		log.Printf("Failed to configure email sender: %v", err)
+		return nil, err
 	}
 	emailService := services.EmailService{
-		PublicURL: PublicURL,
+		PublicURL: os.Getenv("PUBLIC_URL"),
+		Sender:    sender,
+	}
+	if secret := os.Getenv("BALANCE_TOKEN_SECRET"); secret != "" {
+		emailService.Tokens = &services.BalanceTokens{Secret: []byte(secret)}
 	}
 
 	err = emailService.LoadMessages()
@@ -122,45 +202,109 @@ func handleRequest(ctx context.Context, event json.RawMessage) (map[string]any,
 		return nil, err
 	}
 
-	account, err := accountService.FetchOrCreateAccount(ctx, req.AccountEmail, req.AccountFirstName, req.AccountLastName)
+	content, err := getFile(ctx, req.Bucket, req.ObjectKey)
 	if err != nil {
-		log.Printf("Failed to fetch or create account: %v", err)
+		log.Printf("Failed to get file from S3: %v", err)
 		return nil, err
 	}
 
-	csvContent, err := getFile(ctx, req.Bucket, req.ObjectKey)
+	checksum, err := services.Checksum(bytes.NewReader(content))
 	if err != nil {
-		log.Printf("Failed to get file from S3: %v", err)
+		log.Printf("Failed to checksum statement: %v", err)
 		return nil, err
 	}
 
-	reader := csv.NewReader(bytes.NewReader(csvContent))
-	_, err = reader.Read()
+	format, err := services.ParseFormat(req.Format)
 	if err != nil {
-		log.Printf("Failed to read CSV header: %v", err)
+		log.Printf("Invalid statement format: %v", err)
 		return nil, err
 	}
-
-	report, err := transactionService.ProcessFile(ctx, account.AccountID, reader)
+	var profile *services.CSVProfile
+	if req.Profile != "" {
+		loaded, err := services.LoadCSVProfile(req.Profile)
+		if err != nil {
+			log.Printf("Invalid profile: %v", err)
+			return nil, err
+		}
+		profile = &loaded
+	}
+	parser, format, err := services.NewStatementParser(format, req.ObjectKey, bytes.NewReader(content), services.ParserOptions{
+		Dates:    dates,
+		Tolerant: req.Tolerant,
+		Profile:  profile,
+	})
 	if err != nil {
-		log.Printf("Failed to parse CSV file: %v", err)
+		log.Printf("Failed to read statement: %v", err)
 		return nil, err
 	}
+	log.Printf("Reading %s statement", format)
+
+	importCtx := ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		importCtx, cancel = context.WithDeadline(ctx, deadline.Add(-ShutdownMargin))
+		defer cancel()
+	}
+
+	// the reports are queued along with the balance, only the ones of this import are sent before the deadline,
+	// failed emails are retried by the email-dispatcher command
+	dispatcher := &services.EmailDispatcher{Database: db, Email: &emailService}
+
+	// statements with an account column are routed to the account of each row, the account of the request is unused
+	if profile != nil && profile.MultiAccount() {
+		batch, rejections, err := importService.ImportBatch(importCtx, transactionService, &accountService, parser, format, checksum)
+		if errors.Is(err, services.ErrImportIncomplete) {
+			log.Printf("Import of %d accounts interrupted by the deadline: %v", len(batch.Accounts), err)
+			return response(http.StatusServiceUnavailable, CSVProcessResponse{Report: batch.Summary, Accounts: batch.Accounts, Rejections: rejections})
+		} else if err != nil {
+			log.Printf("Failed to import statement: %v", err)
+			return nil, err
+		}
+
+		log.Printf("Imported %d accounts", len(batch.Accounts))
+		if _, err := dispatcher.DispatchKeys(importCtx, batch.ReportKeys()...); err != nil {
+			log.Printf("Failed to send report emails: %v", err)
+		}
+		return response(http.StatusOK, CSVProcessResponse{Report: batch.Summary, Accounts: batch.Accounts, Rejections: rejections})
+	}
 
-	err = emailService.SendReport(account, report)
+	account, err := accountService.FetchOrCreateAccount(ctx, req.AccountEmail, req.AccountFirstName, req.AccountLastName)
 	if err != nil {
-		log.Printf("Failed to send report email: %v", err)
+		log.Printf("Failed to fetch or create account: %v", err)
+		return nil, err
+	}
+
+	imp, account, report, rejections, err := importService.ImportFile(importCtx, transactionService, account, parser, format, checksum)
+	if errors.Is(err, services.ErrImportIncomplete) {
+		log.Printf("Import %d interrupted by the deadline: %v", imp.ImportID, err)
+		return response(http.StatusServiceUnavailable, CSVProcessResponse{ImportID: imp.ImportID, Report: report, Rejections: rejections})
+	} else if err != nil {
+		log.Printf("Failed to import statement: %v", err)
 		return nil, err
 	}
 
-	reportStr, err := json.Marshal(report)
+	log.Printf("Recorded import %d", imp.ImportID)
+	if _, err := dispatcher.DispatchKeys(importCtx, services.ImportReportKey(imp.ImportID, account.AccountID)); err != nil {
+		log.Printf("Failed to send report email: %v", err)
+	}
+
+	return response(http.StatusOK, CSVProcessResponse{ImportID: imp.ImportID, Report: report, Rejections: rejections})
+}
+
+// response builds the lambda response with the import, report and rejections as body, incomplete reports are sent
+// with a 503 status so callers retry, rows already stored are skipped then.
+func response(statusCode int, body CSVProcessResponse) (map[string]any, error) {
+	if body.Rejections == nil {
+		body.Rejections = []services.Rejection{}
+	}
+	reportStr, err := json.Marshal(body)
 	if err != nil {
 		log.Printf("Failed to generate report response: %v", err)
 		return nil, err
 	}
 
 	return map[string]any{
-		"statusCode": 200,
+		"statusCode": statusCode,
 		"headers":    map[string]string{"Content-Type": "application/json"},
 		"body":       string(reportStr),
 	}, nil
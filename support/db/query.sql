@@ -1,11 +1,32 @@
 -- name: GetAccountByEmail :one
 SELECT * FROM accounts WHERE email = $1 LIMIT 1;
 
+-- name: GetAccount :one
+SELECT * FROM accounts WHERE account_id = $1 LIMIT 1;
+
 -- name: CreateAccount :one
 INSERT INTO accounts
-    (first_name, last_name, email, created_at, updated_at)
+    (first_name, last_name, email, locale, created_at, updated_at)
 VALUES
-    ($1, $2, $3, $4, $5)
+    ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: ListAccounts :many
+SELECT * FROM accounts
+WHERE account_id > @after_id AND (@include_deactivated::BOOLEAN OR deactivated_at IS NULL)
+ORDER BY account_id
+LIMIT @row_limit;
+
+-- name: UpdateAccount :one
+UPDATE accounts
+    SET first_name = $1, last_name = $2, email = $3, locale = $4, updated_at = $5
+    WHERE account_id = $6
+RETURNING *;
+
+-- name: DeactivateAccount :one
+UPDATE accounts
+    SET deactivated_at = @deactivated_at, merged_into = @merged_into, updated_at = @deactivated_at
+    WHERE account_id = @account_id
 RETURNING *;
 
 -- name: UpdateAccountBalance :one
@@ -14,10 +35,164 @@ UPDATE accounts
     WHERE account_id = $5
 RETURNING *;
 
+-- name: UpdateAccountReportingCurrency :one
+UPDATE accounts
+    SET reporting_currency = $1, updated_at = $2
+    WHERE account_id = $3
+RETURNING *;
+
 -- name: InsertTransaction :one
 INSERT INTO transactions
-    (account_id, operation, amount, performed_at, created_at, updated_at)
+    (account_id, external_id, source, operation, amount, currency, performed_at, created_at, updated_at, import_id)
 VALUES
-    ($1, $2, $3, $4, $5, $6)
+    ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+ON CONFLICT (account_id, source, external_id) DO NOTHING
 RETURNING transaction_id;
 
+
+-- name: InsertTransactions :execrows
+INSERT INTO transactions
+    (account_id, external_id, source, operation, amount, currency, performed_at, created_at, updated_at, import_id)
+SELECT
+    @account_id::BIGINT,
+    unnest(@external_ids::TEXT[]),
+    @source::TEXT,
+    unnest(@operations::TX_OPERATION_TYPE[]),
+    unnest(@amounts::DECIMAL[]),
+    unnest(@currencies::TEXT[]),
+    unnest(@performed_ats::DATE[]),
+    @created_at::TIMESTAMPTZ,
+    @updated_at::TIMESTAMPTZ,
+    sqlc.narg(import_id)::BIGINT
+ON CONFLICT (account_id, source, external_id) DO NOTHING;
+
+-- name: MoveTransactions :execrows
+UPDATE transactions
+    SET account_id = @target_id, updated_at = @updated_at
+    WHERE account_id = @source_id AND NOT EXISTS (
+        SELECT 1 FROM transactions t
+        WHERE t.account_id = @target_id AND t.source = transactions.source AND t.external_id = transactions.external_id
+    );
+
+-- name: DeleteTransactionsByAccount :execrows
+DELETE FROM transactions WHERE account_id = $1;
+
+-- name: CountTransactionsByMonth :many
+SELECT to_char(performed_at, 'YYYY-MM')::TEXT AS year_month, count(*) AS count
+FROM transactions
+WHERE account_id = $1
+GROUP BY year_month
+ORDER BY year_month;
+
+-- name: GetAccountTotals :many
+SELECT
+    currency::TEXT AS currency,
+    COALESCE(SUM(CASE WHEN operation = 'credit' THEN amount ELSE 0 END), 0)::DECIMAL AS total_credit,
+    COUNT(*) FILTER (WHERE operation = 'credit') AS count_credit,
+    COALESCE(SUM(CASE WHEN operation = 'debit' THEN amount ELSE 0 END), 0)::DECIMAL AS total_debit,
+    COUNT(*) FILTER (WHERE operation = 'debit') AS count_debit
+FROM transactions
+WHERE account_id = $1
+GROUP BY currency
+ORDER BY currency;
+
+-- name: ListRecentTransactions :many
+SELECT * FROM transactions
+WHERE account_id = $1
+ORDER BY performed_at DESC, transaction_id DESC
+LIMIT $2;
+
+-- name: ListTransactionsByImport :many
+SELECT * FROM transactions
+WHERE import_id = $1 AND account_id = $2
+ORDER BY performed_at, transaction_id;
+
+-- name: CreateImport :one
+INSERT INTO imports
+    (account_id, source, checksum, format, status, started_at, created_at, updated_at)
+VALUES
+    ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING *;
+
+-- name: FinishImport :one
+UPDATE imports
+    SET status = $1, count_accepted = $2, count_rejected = $3, count_duplicate = $4, error = $5, report = $6,
+        finished_at = $7, updated_at = $8
+    WHERE import_id = $9
+RETURNING *;
+
+-- name: GetImport :one
+SELECT * FROM imports WHERE import_id = $1 LIMIT 1;
+
+-- name: ListImportsByAccount :many
+SELECT * FROM imports
+WHERE account_id = $1
+ORDER BY started_at DESC, import_id DESC
+LIMIT $2;
+
+-- name: ListImportsByChecksum :many
+SELECT * FROM imports
+WHERE account_id = $1 AND checksum = $2
+ORDER BY started_at DESC, import_id DESC;
+
+-- name: MoveImports :execrows
+UPDATE imports
+    SET account_id = @target_id, updated_at = @updated_at
+    WHERE account_id = @source_id;
+
+-- name: CreateBalanceSnapshot :one
+INSERT INTO balance_snapshots
+    (account_id, total_balance, report, created_at)
+VALUES
+    ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: ListBalanceSnapshots :many
+SELECT * FROM balance_snapshots
+WHERE account_id = @account_id AND created_at >= @created_from AND created_at < @created_until
+ORDER BY created_at, snapshot_id;
+
+-- name: GetFXRate :one
+SELECT rate FROM fx_rates
+WHERE base = $1 AND quote = $2 AND effective_at <= $3
+ORDER BY effective_at DESC
+LIMIT 1;
+
+-- name: EnqueueEmail :execrows
+INSERT INTO email_outbox
+    (idempotency_key, account_id, kind, payload, status, next_attempt_at, created_at, updated_at)
+VALUES
+    ($1, $2, $3, $4, 'pending', $5, $6, $6)
+ON CONFLICT (idempotency_key) DO NOTHING;
+
+-- name: ClaimEmail :one
+SELECT * FROM email_outbox
+WHERE status = 'pending' AND next_attempt_at <= $1
+ORDER BY next_attempt_at, email_id
+LIMIT 1
+FOR UPDATE SKIP LOCKED;
+
+-- name: ClaimEmailByKey :one
+SELECT * FROM email_outbox
+WHERE idempotency_key = $1 AND status = 'pending' AND next_attempt_at <= $2
+FOR UPDATE SKIP LOCKED;
+
+-- name: MarkEmailSent :one
+UPDATE email_outbox
+    SET status = 'sent', attempts = attempts + 1, last_error = '', sent_at = $1, updated_at = $1
+    WHERE email_id = $2
+RETURNING *;
+
+-- name: MarkEmailFailed :one
+UPDATE email_outbox
+    SET status = $1, attempts = attempts + 1, next_attempt_at = $2, last_error = $3, updated_at = $4
+    WHERE email_id = $5
+RETURNING *;
+
+-- name: RetryDeadEmails :execrows
+UPDATE email_outbox
+    SET status = 'pending', attempts = 0, next_attempt_at = @retry_at, updated_at = @updated_at
+    WHERE status = 'dead' AND (@email_id::BIGINT = 0 OR email_id = @email_id);
+
+-- name: CountEmailsByStatus :many
+SELECT status, COUNT(*) AS count FROM email_outbox GROUP BY status ORDER BY status;